@@ -66,21 +66,148 @@ func (da *DexAccountsType) Unmarshal(data []byte) error {
 	return nil
 }
 
+// entries returns marshaled dex accounts keyed by token address, used for computing state diffs
+func (da DexAccountsType) entries() map[[common.AddressLength]byte][]byte {
+	entries := make(map[[common.AddressLength]byte][]byte, len(da.AllDexAccounts))
+	for address, acc := range da.AllDexAccounts {
+		entries[address] = acc.Marshal()
+	}
+	return entries
+}
+
+func dexAccountsFromEntries(entries map[[common.AddressLength]byte][]byte) (DexAccountsType, error) {
+	da := DexAccountsType{AllDexAccounts: make(map[[common.AddressLength]byte]DexAccount, len(entries))}
+	for address, b := range entries {
+		acc := DexAccount{}
+		if err := acc.Unmarshal(b); err != nil {
+			return DexAccountsType{}, fmt.Errorf("failed to unmarshal dex account: %w", err)
+		}
+		da.AllDexAccounts[address] = acc
+	}
+	return da, nil
+}
+
+var (
+	dexAccountsCache = stateCache{height: -1}
+	dexChanged       changedAddresses
+)
+
+// ChangedDexAccounts returns dex accounts changed since dex accounts were stored
+func ChangedDexAccounts() (map[[common.AddressLength]byte]bool, bool) {
+	return dexChanged.peek()
+}
+
+func dexAccountsCheckpointKey(height int64) []byte {
+	return stateKey(common.DexAccountsDBPrefix, height)
+}
+
+// StoreDexAccounts stores only changes since the previous height,
+// except every common.StateCheckpointInterval blocks when full snapshot is stored
 func StoreDexAccounts(height int64) error {
+	if height < 0 {
+		height = common.GetHeight()
+	}
 	DexRWMutex.Lock()
 	defer DexRWMutex.Unlock()
 
-	k := DexAccounts.Marshal()
-	hb := common.GetByteInt64(height)
-	prefix := append(common.DexAccountsDBPrefix[:], hb...)
-	err := database.MainDB.Put(prefix, k[:])
+	changed, all := dexChanged.take()
+	if !IsStateCheckpointHeight(height) {
+		var diff StateDiff
+		var entries map[[common.AddressLength]byte][]byte
+		var err error
+		if !all && dexAccountsCache.height == height-1 && dexAccountsCache.entries != nil {
+			// only dex accounts changed by block are marshaled
+			entries = dexAccountsCache.entries
+			diff = diffChanged(entries, changed, func(addr [common.AddressLength]byte) ([]byte, bool) {
+				acc, ok := DexAccounts.AllDexAccounts[addr]
+				if !ok {
+					return nil, false
+				}
+				return acc.Marshal(), true
+			})
+			dexAccountsCache.set(-1, nil)
+		} else {
+			entries = DexAccounts.entries()
+			var prev map[[common.AddressLength]byte][]byte
+			prev, err = loadDexAccountsEntries(height - 1)
+			diff = NewStateDiff(prev, entries)
+		}
+		if err == nil {
+			err = database.MainDB.Put(stateKey(common.DexAccountsDiffDBPrefix, height), diff.Marshal())
+			if err != nil {
+				logger.GetLogger().Println("cannot store dex accounts", err)
+				return err
+			}
+			err = deleteStateKeys(dexAccountsCheckpointKey(height))
+			if err != nil {
+				logger.GetLogger().Println("cannot remove stale dex accounts checkpoint", err)
+				return err
+			}
+			dexAccountsCache.set(height, entries)
+			return nil
+		}
+		logger.GetLogger().Println("no previous dex accounts state, checkpoint will be stored at height", height, err)
+	}
+	entries := DexAccounts.entries()
+	err := database.MainDB.Put(dexAccountsCheckpointKey(height), DexAccounts.Marshal())
 	if err != nil {
 		logger.GetLogger().Println("cannot store dex accounts", err)
+		return err
 	}
-
+	err = deleteStateKeys(stateKey(common.DexAccountsDiffDBPrefix, height))
+	if err != nil {
+		logger.GetLogger().Println("cannot remove stale dex accounts diff", err)
+		return err
+	}
+	dexAccountsCache.set(height, entries)
 	return nil
 }
 
+// loadDexAccountsEntries rebuilds dex state from the nearest checkpoint and following diffs
+func loadDexAccountsEntries(height int64) (map[[common.AddressLength]byte][]byte, error) {
+	c, err := nearestCheckpoint(dexAccountsCheckpointKey, height)
+	if err != nil {
+		return nil, err
+	}
+	b, err := database.MainDB.Get(dexAccountsCheckpointKey(c))
+	if err != nil {
+		return nil, err
+	}
+	da := DexAccountsType{}
+	err = da.Unmarshal(b)
+	if err != nil {
+		return nil, err
+	}
+	entries := da.entries()
+	for h := c + 1; h <= height; h++ {
+		b, err = database.MainDB.Get(stateKey(common.DexAccountsDiffDBPrefix, h))
+		if err != nil {
+			return nil, fmt.Errorf("missing dex accounts diff at height %v: %w", h, err)
+		}
+		sd := StateDiff{}
+		err = sd.Unmarshal(b)
+		if err != nil {
+			return nil, err
+		}
+		sd.Apply(entries)
+	}
+	return entries, nil
+}
+
+// GetDexAccountsAtHeight rebuilds dex accounts as they were after block at height, without touching current state
+func GetDexAccountsAtHeight(height int64) (DexAccountsType, error) {
+	entries, err := loadDexAccountsEntries(height)
+	if err != nil {
+		return DexAccountsType{}, err
+	}
+	return dexAccountsFromEntries(entries)
+}
+
+// DexAccountsDiffAt returns changes of dex accounts made by block at height
+func DexAccountsDiffAt(height int64) (StateDiff, error) {
+	return loadStateDiff(common.DexAccountsDiffDBPrefix, height)
+}
+
 func LoadDexAccounts(height int64) error {
 	var err error
 	DexRWMutex.Lock()
@@ -92,19 +219,19 @@ func LoadDexAccounts(height int64) error {
 		}
 	}
 
-	hb := common.GetByteInt64(height)
-	prefix := append(common.DexAccountsDBPrefix[:], hb...)
-	b, err := database.MainDB.Get(prefix)
+	entries, err := loadDexAccountsEntries(height)
 	if err != nil {
 		logger.GetLogger().Println("cannot load accounts", err)
 		return err
 	}
-	err = (&DexAccounts).Unmarshal(b)
+	da, err := dexAccountsFromEntries(entries)
 	if err != nil {
 		logger.GetLogger().Println("cannot unmarshal accounts", err)
 		return err
 	}
-
+	DexAccounts = da
+	dexAccountsCache.set(height, entries)
+	dexChanged.take()
 	return nil
 }
 
@@ -122,6 +249,7 @@ func SetDexAccountByAddressBytes(address []byte, acc DexAccount) {
 	addrb := [common.AddressLength]byte{}
 	copy(addrb[:], address[:common.AddressLength])
 	DexAccounts.AllDexAccounts[addrb] = acc
+	dexChanged.add(addrb)
 }
 
 func GetCoinLiquidityInDex() int64 {
@@ -132,10 +260,39 @@ func GetCoinLiquidityInDex() int64 {
 	return sum
 }
 
+// TokensWithOrders returns sorted addresses of tokens with open limit orders
+func TokensWithOrders() [][common.AddressLength]byte {
+	DexRWMutex.RLock()
+	defer DexRWMutex.RUnlock()
+	tokens := [][common.AddressLength]byte{}
+	for addr, acc := range DexAccounts.AllDexAccounts {
+		if len(acc.Orders) > 0 {
+			tokens = append(tokens, addr)
+		}
+	}
+	sortAddresses(tokens)
+	return tokens
+}
+
+// MigrateDexAccountsToShares converts balances of providers of all pools to shares, called once at DexForkHeight
+func MigrateDexAccountsToShares() {
+	DexRWMutex.Lock()
+	defer DexRWMutex.Unlock()
+	for addr, acc := range DexAccounts.AllDexAccounts {
+		if acc.Balances == nil {
+			continue
+		}
+		acc.MigrateToShares()
+		DexAccounts.AllDexAccounts[addr] = acc
+		dexChanged.add(addr)
+	}
+}
+
 func RemoveDexAccountsFromDB(height int64) error {
-	hb := common.GetByteInt64(height)
-	prefix := append(common.DexAccountsDBPrefix[:], hb...)
-	err := database.MainDB.Delete(prefix)
+	DexRWMutex.Lock()
+	dexAccountsCache.invalidateFrom(height)
+	DexRWMutex.Unlock()
+	err := deleteStateKeys(dexAccountsCheckpointKey(height), stateKey(common.DexAccountsDiffDBPrefix, height))
 	if err != nil {
 		logger.GetLogger().Println("cannot remove account", err)
 		return err
@@ -144,18 +301,5 @@ func RemoveDexAccountsFromDB(height int64) error {
 }
 
 func LastHeightStoredInDexAccounts() (int64, error) {
-	i := int64(0)
-	for {
-		ib := common.GetByteInt64(i)
-		prefix := append(common.DexAccountsDBPrefix[:], ib...)
-		isKey, err := database.MainDB.IsKey(prefix)
-		if err != nil {
-			return i - 1, err
-		}
-		if isKey == false {
-			break
-		}
-		i++
-	}
-	return i - 1, nil
+	return lastHeightStoredInState(dexAccountsCheckpointKey, common.DexAccountsDiffDBPrefix)
 }
@@ -65,22 +65,248 @@ func (at *StakingAccountsType) Unmarshal(data []byte) error {
 	return nil
 }
 
+// entries returns marshaled staking accounts keyed by address, used for computing state diffs
+func (at StakingAccountsType) entries() map[[common.AddressLength]byte][]byte {
+	entries := make(map[[common.AddressLength]byte][]byte, len(at.AllStakingAccounts))
+	for address, acc := range at.AllStakingAccounts {
+		entries[address] = acc.Marshal()
+	}
+	return entries
+}
+
+func stakingAccountsFromEntries(entries map[[common.AddressLength]byte][]byte) (StakingAccountsType, error) {
+	at := StakingAccountsType{AllStakingAccounts: make(map[[common.AddressLength]byte]StakingAccount, len(entries))}
+	for address, b := range entries {
+		acc := StakingAccount{}
+		if err := acc.Unmarshal(b); err != nil {
+			return StakingAccountsType{}, fmt.Errorf("failed to unmarshal staking account: %w", err)
+		}
+		at.AllStakingAccounts[address] = acc
+	}
+	return at, nil
+}
+
+// stakingStateCache is stateCache for all 256 delegated accounts
+type stakingStateCache struct {
+	height  int64
+	entries [256]map[[common.AddressLength]byte][]byte
+}
+
+var (
+	stakingAccountsCache = stakingStateCache{height: -1}
+	stakingChanged       changedAddresses
+)
+
+// StakingAccountChanged marks staking accounts of address to be stored in the next diff, code changing
+// StakingAccounts outside of this package has to call it
+func StakingAccountChanged(address [common.AddressLength]byte) {
+	stakingChanged.add(address)
+}
+
+// ChangedStakingAccounts returns addresses of staking accounts changed since staking accounts were stored
+func ChangedStakingAccounts() (map[[common.AddressLength]byte]bool, bool) {
+	return stakingChanged.peek()
+}
+
+func stakingAccountsCheckpointKey(height int64, delegatedAccount int) []byte {
+	return append(stateKey(common.StakingAccountsDBPrefix, height), byte(delegatedAccount))
+}
+
+func stakingAccountsCheckpointKeys(height int64) [][]byte {
+	keys := make([][]byte, 256)
+	for i := 0; i < 256; i++ {
+		keys[i] = stakingAccountsCheckpointKey(height, i)
+	}
+	return keys
+}
+
+// presence of checkpoint is checked by delegated account 1 which always exists
+func stakingAccountsCheckpointMarker(height int64) []byte {
+	return stakingAccountsCheckpointKey(height, 1)
+}
+
+// marshalStakingDiffs writes only diffs of delegated accounts which were changed
+func marshalStakingDiffs(diffs [256]StateDiff) []byte {
+	var buffer bytes.Buffer
+	changed := []int{}
+	for i := 0; i < 256; i++ {
+		if !diffs[i].IsEmpty() {
+			changed = append(changed, i)
+		}
+	}
+	buffer.Write(common.GetByteInt64(int64(len(changed))))
+	for _, i := range changed {
+		buffer.WriteByte(byte(i))
+		buffer.Write(common.BytesToLenAndBytes(diffs[i].Marshal()))
+	}
+	return buffer.Bytes()
+}
+
+func unmarshalStakingDiffs(data []byte) ([256]StateDiff, error) {
+	diffs := [256]StateDiff{}
+	if len(data) < 8 {
+		return diffs, fmt.Errorf("not enough data to unmarshal staking diffs")
+	}
+	n := common.GetInt64FromByte(data[:8])
+	data = data[8:]
+	for j := int64(0); j < n; j++ {
+		if len(data) < 1 {
+			return diffs, fmt.Errorf("not enough data for staking diff %d", j)
+		}
+		i := int(data[0])
+		bs, left, err := common.BytesWithLenToBytes(data[1:])
+		if err != nil {
+			return diffs, err
+		}
+		err = (&diffs[i]).Unmarshal(bs)
+		if err != nil {
+			return diffs, err
+		}
+		data = left
+	}
+	return diffs, nil
+}
+
+// StoreStakingAccounts stores only changes since the previous height,
+// except every common.StateCheckpointInterval blocks when full snapshot is stored
 func StoreStakingAccounts(height int64) error {
+	if height < 0 {
+		height = common.GetHeight()
+	}
 	StakingRWMutex.Lock()
 	defer StakingRWMutex.Unlock()
+	changed, all := stakingChanged.take()
+	if !IsStateCheckpointHeight(height) {
+		diffs := [256]StateDiff{}
+		entries := [256]map[[common.AddressLength]byte][]byte{}
+		var err error
+		if !all && stakingAccountsCache.height == height-1 {
+			// only staking accounts changed by block are marshaled
+			entries = stakingAccountsCache.entries
+			for i := 0; i < 256; i++ {
+				if entries[i] == nil {
+					entries[i] = map[[common.AddressLength]byte][]byte{}
+				}
+				diffs[i] = diffChanged(entries[i], changed, func(addr [common.AddressLength]byte) ([]byte, bool) {
+					acc, ok := StakingAccounts[i].AllStakingAccounts[addr]
+					if !ok {
+						return nil, false
+					}
+					return acc.Marshal(), true
+				})
+			}
+			stakingAccountsCache.height = -1
+		} else {
+			entries = stakingEntries()
+			var prev [256]map[[common.AddressLength]byte][]byte
+			prev, err = loadStakingAccountsEntries(height - 1)
+			for i := 0; i < 256; i++ {
+				diffs[i] = NewStateDiff(prev[i], entries[i])
+			}
+		}
+		if err == nil {
+			err = database.MainDB.Put(stateKey(common.StakingAccountsDiffDBPrefix, height), marshalStakingDiffs(diffs))
+			if err != nil {
+				logger.GetLogger().Println("cannot store accounts", err)
+				return err
+			}
+			err = deleteStateKeys(stakingAccountsCheckpointKeys(height)...)
+			if err != nil {
+				logger.GetLogger().Println("cannot remove stale staking accounts checkpoint", err)
+				return err
+			}
+			stakingAccountsCache.height = height
+			stakingAccountsCache.entries = entries
+			return nil
+		}
+		logger.GetLogger().Println("no previous staking accounts state, checkpoint will be stored at height", height, err)
+	}
+	entries := stakingEntries()
 	for i := 0; i < 256; i++ {
 		k := StakingAccounts[i].Marshal()
-		hb := common.GetByteInt64(height)
-		prefix := append(common.StakingAccountsDBPrefix[:], hb...)
-		prefix = append(prefix, byte(i))
-		err := database.MainDB.Put(prefix, k[:])
+		err := database.MainDB.Put(stakingAccountsCheckpointKey(height, i), k[:])
 		if err != nil {
 			logger.GetLogger().Println("cannot store accounts", err)
+			return err
 		}
 	}
+	err := deleteStateKeys(stateKey(common.StakingAccountsDiffDBPrefix, height))
+	if err != nil {
+		logger.GetLogger().Println("cannot remove stale staking accounts diff", err)
+		return err
+	}
+	stakingAccountsCache.height = height
+	stakingAccountsCache.entries = entries
 	return nil
 }
 
+func stakingEntries() [256]map[[common.AddressLength]byte][]byte {
+	entries := [256]map[[common.AddressLength]byte][]byte{}
+	for i := 0; i < 256; i++ {
+		entries[i] = StakingAccounts[i].entries()
+	}
+	return entries
+}
+
+// loadStakingAccountsEntries rebuilds staking state from the nearest checkpoint and following diffs
+func loadStakingAccountsEntries(height int64) ([256]map[[common.AddressLength]byte][]byte, error) {
+	entries := [256]map[[common.AddressLength]byte][]byte{}
+	c, err := nearestCheckpoint(stakingAccountsCheckpointMarker, height)
+	if err != nil {
+		return entries, err
+	}
+	for i := 0; i < 256; i++ {
+		at := StakingAccountsType{}
+		b, err := database.MainDB.Get(stakingAccountsCheckpointKey(c, i))
+		if err == nil && b != nil {
+			err = at.Unmarshal(b)
+			if err != nil {
+				return entries, err
+			}
+		}
+		entries[i] = at.entries()
+	}
+	for h := c + 1; h <= height; h++ {
+		b, err := database.MainDB.Get(stateKey(common.StakingAccountsDiffDBPrefix, h))
+		if err != nil {
+			return entries, fmt.Errorf("missing staking accounts diff at height %v: %w", h, err)
+		}
+		diffs, err := unmarshalStakingDiffs(b)
+		if err != nil {
+			return entries, err
+		}
+		for i := 0; i < 256; i++ {
+			diffs[i].Apply(entries[i])
+		}
+	}
+	return entries, nil
+}
+
+// GetStakingAccountsAtHeight rebuilds staking accounts as they were after block at height, without touching current state
+func GetStakingAccountsAtHeight(height int64) ([256]StakingAccountsType, error) {
+	sas := [256]StakingAccountsType{}
+	entries, err := loadStakingAccountsEntries(height)
+	if err != nil {
+		return sas, err
+	}
+	for i := 0; i < 256; i++ {
+		sas[i], err = stakingAccountsFromEntries(entries[i])
+		if err != nil {
+			return sas, err
+		}
+	}
+	return sas, nil
+}
+
+// StakingAccountsDiffsAt returns changes of staking accounts made by block at height, by delegated account
+func StakingAccountsDiffsAt(height int64) ([256]StateDiff, error) {
+	b, err := database.MainDB.Get(stateKey(common.StakingAccountsDiffDBPrefix, height))
+	if err != nil {
+		return [256]StateDiff{}, err
+	}
+	return unmarshalStakingDiffs(b)
+}
+
 func LoadStakingAccounts(height int64) error {
 	var err error
 	StakingRWMutex.Lock()
@@ -92,21 +318,23 @@ func LoadStakingAccounts(height int64) error {
 		}
 	}
 
+	entries, err := loadStakingAccountsEntries(height)
+	if err != nil {
+		logger.GetLogger().Println("cannot load accounts", err)
+		return err
+	}
+	sas := [256]StakingAccountsType{}
 	for i := 0; i < 256; i++ {
-		hb := common.GetByteInt64(height)
-		prefix := append(common.StakingAccountsDBPrefix[:], hb...)
-		prefix = append(prefix, byte(i))
-		b, err := database.MainDB.Get(prefix)
-		if err != nil || b == nil {
-			logger.GetLogger().Println("cannot load accounts", err)
-			continue
-		}
-		err = (&StakingAccounts[i]).Unmarshal(b)
+		sas[i], err = stakingAccountsFromEntries(entries[i])
 		if err != nil {
 			logger.GetLogger().Println("cannot unmarshal accounts", err)
 			return err
 		}
 	}
+	StakingAccounts = sas
+	stakingAccountsCache.height = height
+	stakingAccountsCache.entries = entries
+	stakingChanged.take()
 	return nil
 }
 
@@ -119,35 +347,22 @@ func GetStakingAccountByAddressBytes(address []byte, delegatedAccount int) Staki
 }
 
 func RemoveStakingAccountsFromDB(height int64) error {
-	hb := common.GetByteInt64(height)
-	prefix := append(common.StakingAccountsDBPrefix[:], hb...)
-	for i := 0; i < 256; i++ {
-		prefix = append(prefix, byte(i))
-		err := database.MainDB.Delete(prefix)
-		if err != nil {
-			logger.GetLogger().Println("cannot remove account", err)
-			return err
-		}
+	StakingRWMutex.Lock()
+	if stakingAccountsCache.height >= height {
+		stakingAccountsCache.height = -1
+	}
+	StakingRWMutex.Unlock()
+	keys := append(stakingAccountsCheckpointKeys(height), stateKey(common.StakingAccountsDiffDBPrefix, height))
+	err := deleteStateKeys(keys...)
+	if err != nil {
+		logger.GetLogger().Println("cannot remove account", err)
+		return err
 	}
 	return nil
 }
 
 func LastHeightStoredInStakingAccounts() (int64, error) {
-	i := int64(0)
-	for {
-		ib := common.GetByteInt64(i)
-		prefix := append(common.StakingAccountsDBPrefix[:], ib...)
-		prefix = append(prefix, byte(1))
-		isKey, err := database.MainDB.IsKey(prefix)
-		if err != nil {
-			return i - 1, err
-		}
-		if !isKey {
-			break
-		}
-		i++
-	}
-	return i - 1, nil
+	return lastHeightStoredInState(stakingAccountsCheckpointMarker, common.StakingAccountsDiffDBPrefix)
 }
 
 func GetStakedInAllDelegatedAccounts() int64 {
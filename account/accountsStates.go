@@ -36,6 +36,7 @@ func AddTransactionsSender(address [common.AddressLength]byte, hashTxn common.Ha
 	}
 	acc.TransactionsSender = append(acc.TransactionsSender, hashTxn)
 	Accounts.AllAccounts[address] = acc
+	accountsChanged.add(address)
 }
 
 func AddTransactionsRecipient(address [common.AddressLength]byte, hashTxn common.Hash) {
@@ -56,6 +57,7 @@ func AddTransactionsRecipient(address [common.AddressLength]byte, hashTxn common
 	}
 	acc.TransactionsRecipient = append(acc.TransactionsRecipient, hashTxn)
 	Accounts.AllAccounts[address] = acc
+	accountsChanged.add(address)
 }
 
 // error is not checked one should do the checking before
@@ -65,6 +67,7 @@ func SetBalance(address [common.AddressLength]byte, balance int64) {
 	acc := Accounts.AllAccounts[address]
 	acc.Balance = balance
 	Accounts.AllAccounts[address] = acc
+	accountsChanged.add(address)
 }
 
 // error is not checked one should do the checking before
@@ -126,27 +129,171 @@ func (at *AccountsType) Unmarshal(data []byte) error {
 	return nil
 }
 
+// entries returns marshaled accounts keyed by address, used for computing state diffs
+func (at AccountsType) entries() map[[common.AddressLength]byte][]byte {
+	entries := make(map[[common.AddressLength]byte][]byte, len(at.AllAccounts))
+	for address, acc := range at.AllAccounts {
+		entries[address] = acc.Marshal()
+	}
+	return entries
+}
+
+func accountsFromEntries(entries map[[common.AddressLength]byte][]byte, height int64) (AccountsType, error) {
+	at := AccountsType{
+		AllAccounts: make(map[[common.AddressLength]byte]Account, len(entries)),
+		Height:      height,
+	}
+	for address, b := range entries {
+		acc := Account{}
+		if err := acc.Unmarshal(b); err != nil {
+			return AccountsType{}, fmt.Errorf("failed to unmarshal account: %w", err)
+		}
+		at.AllAccounts[address] = acc
+	}
+	return at, nil
+}
+
+var (
+	accountsCache   = stateCache{height: -1}
+	accountsChanged changedAddresses
+)
+
+// AccountChanged marks account to be stored in the next diff, code changing Accounts outside
+// of this package has to call it
+func AccountChanged(address [common.AddressLength]byte) {
+	accountsChanged.add(address)
+}
+
+// ChangedAccounts returns accounts changed since accounts were stored, all tells that every account has to be compared
+func ChangedAccounts() (map[[common.AddressLength]byte]bool, bool) {
+	return accountsChanged.peek()
+}
+
+// StateReplaced marks all accounts, staking and dex accounts to be compared when the next diff is stored,
+// it is called when whole state is replaced outside of this package
+func StateReplaced() {
+	accountsChanged.addAll()
+	stakingChanged.addAll()
+	dexChanged.addAll()
+}
+
+func accountsCheckpointKey(height int64) []byte {
+	return stateKey(common.AccountsDBPrefix, height)
+}
+
+// StoreAccounts stores only changes since the previous height,
+// except every common.StateCheckpointInterval blocks when full snapshot is stored
 func StoreAccounts(height int64) error {
 	if height < 0 {
 		height = common.GetHeight()
 	}
 	AccountsRWMutex.Lock()
 	defer AccountsRWMutex.Unlock()
-	k := Accounts.Marshal()
-	hb := common.GetByteInt64(height)
-	prefix := append(common.AccountsDBPrefix[:], hb...)
-	err := database.MainDB.Put(prefix, k[:])
+	changed, all := accountsChanged.take()
+	if !IsStateCheckpointHeight(height) {
+		var diff StateDiff
+		var entries map[[common.AddressLength]byte][]byte
+		var err error
+		if !all && accountsCache.height == height-1 && accountsCache.entries != nil {
+			// only accounts changed by block are marshaled
+			entries = accountsCache.entries
+			diff = diffChanged(entries, changed, func(addr [common.AddressLength]byte) ([]byte, bool) {
+				acc, ok := Accounts.AllAccounts[addr]
+				if !ok {
+					return nil, false
+				}
+				return acc.Marshal(), true
+			})
+			accountsCache.set(-1, nil)
+		} else {
+			entries = Accounts.entries()
+			var prev map[[common.AddressLength]byte][]byte
+			prev, err = loadAccountsEntries(height - 1)
+			diff = NewStateDiff(prev, entries)
+		}
+		if err == nil {
+			err = database.MainDB.Put(stateKey(common.AccountsDiffDBPrefix, height), diff.Marshal())
+			if err != nil {
+				logger.GetLogger().Println("cannot store accounts", err)
+				return err
+			}
+			err = deleteStateKeys(accountsCheckpointKey(height))
+			if err != nil {
+				logger.GetLogger().Println("cannot remove stale accounts checkpoint", err)
+				return err
+			}
+			accountsCache.set(height, entries)
+			return nil
+		}
+		logger.GetLogger().Println("no previous accounts state, checkpoint will be stored at height", height, err)
+	}
+	entries := Accounts.entries()
+	at := Accounts
+	at.Height = height
+	err := database.MainDB.Put(accountsCheckpointKey(height), at.Marshal())
 	if err != nil {
 		logger.GetLogger().Println("cannot store accounts", err)
 		return err
 	}
+	err = deleteStateKeys(stateKey(common.AccountsDiffDBPrefix, height))
+	if err != nil {
+		logger.GetLogger().Println("cannot remove stale accounts diff", err)
+		return err
+	}
+	accountsCache.set(height, entries)
 	return nil
 }
 
+// loadAccountsEntries rebuilds state from the nearest checkpoint and following diffs
+func loadAccountsEntries(height int64) (map[[common.AddressLength]byte][]byte, error) {
+	c, err := nearestCheckpoint(accountsCheckpointKey, height)
+	if err != nil {
+		return nil, err
+	}
+	b, err := database.MainDB.Get(accountsCheckpointKey(c))
+	if err != nil {
+		return nil, err
+	}
+	at := AccountsType{}
+	err = at.Unmarshal(b)
+	if err != nil {
+		return nil, err
+	}
+	entries := at.entries()
+	for h := c + 1; h <= height; h++ {
+		b, err = database.MainDB.Get(stateKey(common.AccountsDiffDBPrefix, h))
+		if err != nil {
+			return nil, fmt.Errorf("missing accounts diff at height %v: %w", h, err)
+		}
+		sd := StateDiff{}
+		err = sd.Unmarshal(b)
+		if err != nil {
+			return nil, err
+		}
+		sd.Apply(entries)
+	}
+	return entries, nil
+}
+
+// GetAccountsAtHeight rebuilds accounts as they were after block at height, without touching current state
+func GetAccountsAtHeight(height int64) (AccountsType, error) {
+	entries, err := loadAccountsEntries(height)
+	if err != nil {
+		return AccountsType{}, err
+	}
+	return accountsFromEntries(entries, height)
+}
+
+// AccountsDiffAt returns changes of accounts made by block at height
+func AccountsDiffAt(height int64) (StateDiff, error) {
+	return loadStateDiff(common.AccountsDiffDBPrefix, height)
+}
+
 func RemoveAccountsFromDB(height int64) error {
-	hb := common.GetByteInt64(height)
-	prefix := append(common.AccountsDBPrefix[:], hb...)
-	err := database.MainDB.Delete(prefix)
+	AccountsRWMutex.Lock()
+	accountsCache.invalidateFrom(height)
+	AccountsRWMutex.Unlock()
+	err := deleteStateKeys(accountsCheckpointKey(height), stateKey(common.AccountsDiffDBPrefix, height))
 	if err != nil {
 		logger.GetLogger().Println("cannot remove account", err)
 		return err
@@ -165,34 +312,22 @@ func LoadAccounts(height int64) error {
 		}
 	}
 
-	hb := common.GetByteInt64(height)
-	prefix := append(common.AccountsDBPrefix[:], hb...)
-	b, err := database.MainDB.Get(prefix)
-	if err != nil || b == nil {
+	entries, err := loadAccountsEntries(height)
+	if err != nil {
 		logger.GetLogger().Println("cannot load accounts", err)
 		return err
 	}
-	err = (&Accounts).Unmarshal(b)
+	at, err := accountsFromEntries(entries, height)
 	if err != nil {
 		logger.GetLogger().Println("cannot unmarshal accounts")
 		return err
 	}
+	Accounts = at
+	accountsCache.set(height, entries)
+	accountsChanged.take()
 	return nil
 }
 
 func LastHeightStoredInAccounts() (int64, error) {
-	i := int64(0)
-	for {
-		ib := common.GetByteInt64(i)
-		prefix := append(common.AccountsDBPrefix[:], ib...)
-		isKey, err := database.MainDB.IsKey(prefix)
-		if err != nil {
-			return i - 1, err
-		}
-		if !isKey {
-			break
-		}
-		i++
-	}
-	return i - 1, nil
+	return lastHeightStoredInState(accountsCheckpointKey, common.AccountsDiffDBPrefix)
 }
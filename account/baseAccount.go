@@ -54,6 +54,7 @@ func (a *Account) ModifyAccountToEscrow(transactionDelay int64) error {
 	a.TransactionDelay = transactionDelay
 	AccountsRWMutex.Lock()
 	Accounts.AllAccounts[a.Address] = *a
+	accountsChanged.add(a.Address)
 	AccountsRWMutex.Unlock()
 	return nil
 }
@@ -80,6 +81,7 @@ func (a *Account) ModifyAccountToMultiSign(numApprovals uint8, addresses []commo
 	a.MultiSignAddresses = addrs
 	AccountsRWMutex.Lock()
 	Accounts.AllAccounts[a.Address] = *a
+	accountsChanged.add(a.Address)
 	AccountsRWMutex.Unlock()
 	return nil
 }
@@ -100,6 +102,7 @@ func SetAccountByAddressBytes(address []byte) Account {
 		}
 		AccountsRWMutex.Lock()
 		Accounts.AllAccounts[addrb] = account
+		accountsChanged.add(addrb)
 		AccountsRWMutex.Unlock()
 	}
 	if !bytes.Equal(account.Address[:], address) {
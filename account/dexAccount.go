@@ -3,23 +3,36 @@ package account
 import (
 	"bytes"
 	"fmt"
+	"math/big"
+
 	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/dex"
 )
 
 type DexAccount struct {
-	Balances   map[[common.AddressLength]byte]CoinTokenDetails `json:"balances"`
-	CoinPool   int64                                           `json:"coin_pool"`
-	TokenPool  int64                                           `json:"token_pool"`
-	TokenPrice int64                                           `json:"token_price"`
+	// balances of providers in old format, kept until migration to shares at DexForkHeight
+	Balances    map[[common.AddressLength]byte]CoinTokenDetails `json:"balances,omitempty"`
+	Shares      map[[common.AddressLength]byte]int64            `json:"shares"`
+	TotalShares int64                                           `json:"total_shares"`
+	CoinPool    int64                                           `json:"coin_pool"`
+	TokenPool   int64                                           `json:"token_pool"`
+	TokenPrice  int64                                           `json:"token_price"`
 	//TokenDetails stateDB.TokenInfo                               `json:"token_details"`
 	TokenAddress common.Address `json:"token_address"`
+	// limit orders resting in book of token
+	Orders      []dex.LimitOrder `json:"orders"`
+	LastOrderID int64            `json:"last_order_id"`
 }
 
+// CoinTokenDetails are balances of liquidity provider in old format of dex account
 type CoinTokenDetails struct {
 	CoinBalance  int64 `json:"coin_balance"`
 	TokenBalance int64 `json:"token_balance"`
 }
 
+// sharesFormatMarker starts dex account with shares, old format starts with coin pool which is never negative
+const sharesFormatMarker int64 = -1
+
 func (ctb CoinTokenDetails) Marshal() []byte {
 	b := common.GetByteInt64(ctb.CoinBalance)
 	b = append(b, common.GetByteInt64(ctb.TokenBalance)...)
@@ -32,35 +45,129 @@ func (ctb CoinTokenDetails) Unmarshal(b []byte) CoinTokenDetails {
 	return ctb
 }
 
-// Marshal converts DexAccount to a binary format.
+// Pool returns amounts and shares of pool for computing of trades
+func (da DexAccount) Pool() dex.Pool {
+	return dex.Pool{Coin: da.CoinPool, Token: da.TokenPool, TotalShares: da.TotalShares}
+}
+
+// ProviderValue is coin and token which shares of provider are worth, or its balances when not migrated yet
+func (da DexAccount) ProviderValue(addr [common.AddressLength]byte) (int64, int64) {
+	if da.Balances != nil {
+		return da.Balances[addr].CoinBalance, da.Balances[addr].TokenBalance
+	}
+	return dex.ShareValue(da.Pool(), da.Shares[addr])
+}
+
+// ApplyLegacyQuote updates balances of providers for operation of addr, as blocks below DexForkHeight did.
+// Liquidity operations change balances of addr, trades change balances of all providers by their part of pool.
+func (da *DexAccount) ApplyLegacyQuote(addr [common.AddressLength]byte, operation int, q dex.Quote, tokenDecimals uint8) {
+	if da.Balances == nil {
+		da.Balances = make(map[[common.AddressLength]byte]CoinTokenDetails)
+	}
+	if operation == dex.OpAddLiquidity || operation > dex.OpSell {
+		da.Balances[addr] = CoinTokenDetails{
+			CoinBalance:  da.Balances[addr].CoinBalance - q.Coin,
+			TokenBalance: da.Balances[addr].TokenBalance - q.Token,
+		}
+		return
+	}
+	coinPercentTmp := float64(-q.Coin) / float64(da.CoinPool)
+	tokenPercentTmp := float64(-q.Token) / float64(da.TokenPool)
+	for a, acc := range da.Balances {
+		acc.TokenBalance += int64(common.RoundToken(tokenPercentTmp*float64(acc.TokenBalance), int(tokenDecimals)))
+		acc.CoinBalance += int64(common.RoundToken(coinPercentTmp*float64(acc.CoinBalance), int(common.Decimals)))
+		da.Balances[a] = acc
+	}
+}
+
+// MigrateToShares converts balances of providers to shares, it is done once at DexForkHeight
+func (da *DexAccount) MigrateToShares() {
+	if da.Balances == nil {
+		return
+	}
+	da.Shares, da.TotalShares = MigrateDexBalances(da.Balances, da.CoinPool, da.TokenPool)
+	da.Balances = nil
+}
+
+// AddShares mints (or burns when negative) shares of provider
+func (da *DexAccount) AddShares(addr [common.AddressLength]byte, shares int64) error {
+	if da.Shares[addr]+shares < 0 {
+		return fmt.Errorf("not enough shares of liquidity provider")
+	}
+	if da.Shares == nil {
+		da.Shares = map[[common.AddressLength]byte]int64{}
+	}
+	da.Shares[addr] += shares
+	da.TotalShares += shares
+	if da.Shares[addr] == 0 {
+		delete(da.Shares, addr)
+	}
+	return nil
+}
+
+// Book returns order book of token
+func (da DexAccount) Book() dex.Book {
+	return dex.Book{Orders: da.Orders, LastID: da.LastOrderID}
+}
+
+func (da *DexAccount) SetBook(b dex.Book) {
+	da.Orders = b.Orders
+	da.LastOrderID = b.LastID
+}
+
+// Marshal converts DexAccount to a binary format. Accounts not migrated to shares keep old format.
 func (da DexAccount) Marshal() []byte {
 
 	var buffer bytes.Buffer
 
-	buffer.Write(common.GetByteInt64(da.CoinPool))
-	buffer.Write(common.GetByteInt64(da.TokenPool))
-	buffer.Write(common.GetByteInt64(da.TokenPrice))
-	//bytesTd, err := common.Marshal(da.TokenDetails, common.TokenDetailsDBPrefix)
-	//if err != nil {
-	//	return nil
-	//}
-	//// Address length and Address
-	//buffer.Write(common.BytesToLenAndBytes(bytesTd))
-	// Address length and Address
-	buffer.Write(da.TokenAddress.GetBytes())
-	// StakingDetails count
-	buffer.Write(common.GetByteInt64(int64(len(da.Balances))))
+	if da.Balances != nil {
+		da.marshalBalances(&buffer)
+	} else {
+		buffer.Write(common.GetByteInt64(sharesFormatMarker))
+		buffer.Write(common.GetByteInt64(da.CoinPool))
+		buffer.Write(common.GetByteInt64(da.TokenPool))
+		buffer.Write(common.GetByteInt64(da.TokenPrice))
+		// Address length and Address
+		buffer.Write(da.TokenAddress.GetBytes())
+		buffer.Write(common.GetByteInt64(da.TotalShares))
+		// Shares count
+		buffer.Write(common.GetByteInt64(int64(len(da.Shares))))
 
-	// StakingDetails
-	for addr, details := range da.Balances {
-		buffer.Write(addr[:])
-		buffer.Write(details.Marshal())
+		// Shares, sorted by address so the same account always gives the same bytes
+		addrs := make([][common.AddressLength]byte, 0, len(da.Shares))
+		for addr := range da.Shares {
+			addrs = append(addrs, addr)
+		}
+		sortAddresses(addrs)
+		for _, addr := range addrs {
+			buffer.Write(addr[:])
+			buffer.Write(common.GetByteInt64(da.Shares[addr]))
+		}
+	}
+
+	// order book is written only when any order was placed, so accounts without it keep their bytes
+	if da.LastOrderID > 0 {
+		buffer.Write(common.GetByteInt64(da.LastOrderID))
+		buffer.Write(common.GetByteInt64(int64(len(da.Orders))))
+		for _, o := range da.Orders {
+			buffer.Write(common.GetByteInt64(o.ID))
+			buffer.Write(o.Owner[:])
+			if o.Sell {
+				buffer.WriteByte(1)
+			} else {
+				buffer.WriteByte(0)
+			}
+			buffer.Write(common.GetByteInt64(o.Amount))
+			buffer.Write(common.GetByteInt64(o.Price))
+			buffer.Write(common.GetByteInt64(o.Escrow))
+			buffer.Write(common.GetByteInt64(o.Expiry))
+		}
 	}
 
 	return buffer.Bytes()
 }
 
-// Unmarshal decodes StakingAccount from a binary format.
+// Unmarshal decodes DexAccount from a binary format, in old format with balances of providers or with shares
 func (da *DexAccount) Unmarshal(data []byte) error {
 
 	buffer := bytes.NewBuffer(data)
@@ -68,23 +175,97 @@ func (da *DexAccount) Unmarshal(data []byte) error {
 	if buffer.Len() < 8*3+common.AddressLength {
 		return fmt.Errorf("insufficient data for dex accounts unmarshaling")
 	}
+	if common.GetInt64FromByte(data[:8]) != sharesFormatMarker {
+		err := da.unmarshalBalances(buffer)
+		if err != nil || buffer.Len() == 0 {
+			return err
+		}
+		return da.unmarshalOrders(buffer)
+	}
+	buffer.Next(8)
+	if buffer.Len() < 8*5+common.AddressLength {
+		return fmt.Errorf("insufficient data for dex accounts unmarshaling")
+	}
+
+	da.CoinPool = common.GetInt64FromByte(buffer.Next(8))
+	da.TokenPool = common.GetInt64FromByte(buffer.Next(8))
+	da.TokenPrice = common.GetInt64FromByte(buffer.Next(8))
+	// Address
+	copy(da.TokenAddress.ByteValue[:], buffer.Next(common.AddressLength))
+	da.TotalShares = common.GetInt64FromByte(buffer.Next(8))
+
+	count := common.GetInt64FromByte(buffer.Next(8))
+	if count < 0 || count > int64(buffer.Len()/(8+common.AddressLength)) {
+		return fmt.Errorf("wrong number of liquidity providers %d", count)
+	}
+	da.Shares = make(map[[common.AddressLength]byte]int64, count)
+	addrb20 := [20]byte{}
+	for i := int64(0); i < count; i++ {
+		copy(addrb20[:], buffer.Next(common.AddressLength))
+		da.Shares[addrb20] = common.GetInt64FromByte(buffer.Next(8))
+	}
+	if buffer.Len() == 0 {
+		return nil
+	}
+	return da.unmarshalOrders(buffer)
+}
+
+// orderLength is length of limit order in binary format
+const orderLength = 8*5 + common.AddressLength + 1
+
+func (da *DexAccount) unmarshalOrders(buffer *bytes.Buffer) error {
+	if buffer.Len() < 16 {
+		return fmt.Errorf("insufficient data for dex orders unmarshaling")
+	}
+	da.LastOrderID = common.GetInt64FromByte(buffer.Next(8))
+	count := common.GetInt64FromByte(buffer.Next(8))
+	if count < 0 || count != int64(buffer.Len()/orderLength) || buffer.Len()%orderLength != 0 {
+		return fmt.Errorf("wrong number of dex orders %d", count)
+	}
+	da.Orders = make([]dex.LimitOrder, count)
+	for i := range da.Orders {
+		o := &da.Orders[i]
+		o.ID = common.GetInt64FromByte(buffer.Next(8))
+		copy(o.Owner[:], buffer.Next(common.AddressLength))
+		o.Sell = buffer.Next(1)[0] == 1
+		o.Amount = common.GetInt64FromByte(buffer.Next(8))
+		o.Price = common.GetInt64FromByte(buffer.Next(8))
+		o.Escrow = common.GetInt64FromByte(buffer.Next(8))
+		o.Expiry = common.GetInt64FromByte(buffer.Next(8))
+	}
+	return nil
+}
+
+// marshalBalances writes old format of dex account with balances of providers
+func (da DexAccount) marshalBalances(buffer *bytes.Buffer) {
+	buffer.Write(common.GetByteInt64(da.CoinPool))
+	buffer.Write(common.GetByteInt64(da.TokenPool))
+	buffer.Write(common.GetByteInt64(da.TokenPrice))
+	buffer.Write(da.TokenAddress.GetBytes())
+	buffer.Write(common.GetByteInt64(int64(len(da.Balances))))
+	addrs := make([][common.AddressLength]byte, 0, len(da.Balances))
+	for addr := range da.Balances {
+		addrs = append(addrs, addr)
+	}
+	sortAddresses(addrs)
+	for _, addr := range addrs {
+		buffer.Write(addr[:])
+		buffer.Write(da.Balances[addr].Marshal())
+	}
+}
 
+// unmarshalBalances decodes old format of dex account with balances of providers
+func (da *DexAccount) unmarshalBalances(buffer *bytes.Buffer) error {
 	da.CoinPool = common.GetInt64FromByte(buffer.Next(8))
 	da.TokenPool = common.GetInt64FromByte(buffer.Next(8))
 	da.TokenPrice = common.GetInt64FromByte(buffer.Next(8))
-	//toBytes, leftb, err := common.BytesWithLenToBytes(buffer.Bytes())
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//err = common.Unmarshal(toBytes, common.TokenDetailsDBPrefix, &(da.TokenDetails))
-	//if err != nil {
-	//	return err
-	//}
 	// Address
 	copy(da.TokenAddress.ByteValue[:], buffer.Next(common.AddressLength))
 
 	detailsCount := common.GetInt64FromByte(buffer.Next(8))
+	if detailsCount < 0 || detailsCount > int64(buffer.Len()/(16+common.AddressLength)) {
+		return fmt.Errorf("wrong number of liquidity providers %d", detailsCount)
+	}
 	da.Balances = make(map[[common.AddressLength]byte]CoinTokenDetails, detailsCount)
 	addrb20 := [20]byte{}
 	for i := int64(0); i < detailsCount; i++ {
@@ -99,3 +280,36 @@ func (da *DexAccount) Unmarshal(data []byte) error {
 	}
 	return nil
 }
+
+// UnallocatedSharesOwner gets shares of pool value not owned by any provider after migration.
+// Nobody can sign for zero address, so these shares are never withdrawn.
+var UnallocatedSharesOwner = [common.AddressLength]byte{}
+
+// MigrateDexBalances converts balances of providers to shares. Every provider gets shares equal
+// to value of balances in coins at current pool price, negative values give no shares.
+// Value of pool not covered by balances is given to UnallocatedSharesOwner, so shares cover whole pool.
+func MigrateDexBalances(balances map[[common.AddressLength]byte]CoinTokenDetails, coinPool, tokenPool int64) (map[[common.AddressLength]byte]int64, int64) {
+	shares := make(map[[common.AddressLength]byte]int64, len(balances))
+	total := int64(0)
+	for addr, b := range balances {
+		value := big.NewInt(b.CoinBalance)
+		if tokenPool > 0 {
+			t := new(big.Int).Mul(big.NewInt(b.TokenBalance), big.NewInt(coinPool))
+			value.Add(value, t.Div(t, big.NewInt(tokenPool)))
+		}
+		if value.Sign() <= 0 || !value.IsInt64() || total+value.Int64() < total {
+			continue
+		}
+		shares[addr] = value.Int64()
+		total += value.Int64()
+	}
+	poolValue := big.NewInt(coinPool)
+	if tokenPool > 0 {
+		poolValue.Add(poolValue, big.NewInt(coinPool))
+	}
+	if unallocated := poolValue.Sub(poolValue, big.NewInt(total)); unallocated.Sign() > 0 && unallocated.IsInt64() && total+unallocated.Int64() > total {
+		shares[UnallocatedSharesOwner] += unallocated.Int64()
+		total += unallocated.Int64()
+	}
+	return shares, total
+}
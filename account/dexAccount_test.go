@@ -1,11 +1,13 @@
 package account
 
 import (
+	"bytes"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/dex"
 	"github.com/wonabru/qwid-node/logger"
-	"github.com/stretchr/testify/assert"
 )
 
 func TestCoinTokenDetailsMarshalUnmarshal(t *testing.T) {
@@ -57,7 +59,7 @@ func TestDexAccountMarshalUnmarshal(t *testing.T) {
 
 	t.Run("marshal and unmarshal empty dex account", func(t *testing.T) {
 		original := DexAccount{
-			Balances:     make(map[[common.AddressLength]byte]CoinTokenDetails),
+			Shares:       make(map[[common.AddressLength]byte]int64),
 			CoinPool:     1000000,
 			TokenPool:    2000000,
 			TokenPrice:   100,
@@ -75,15 +77,16 @@ func TestDexAccountMarshalUnmarshal(t *testing.T) {
 		assert.Equal(t, original.TokenPrice, restored.TokenPrice)
 	})
 
-	t.Run("marshal and unmarshal with balances", func(t *testing.T) {
+	t.Run("marshal and unmarshal with shares", func(t *testing.T) {
 		addr1 := [common.AddressLength]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
 		addr2 := [common.AddressLength]byte{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21}
 
 		original := DexAccount{
-			Balances: map[[common.AddressLength]byte]CoinTokenDetails{
-				addr1: {CoinBalance: 500000, TokenBalance: 600000},
-				addr2: {CoinBalance: 700000, TokenBalance: 800000},
+			Shares: map[[common.AddressLength]byte]int64{
+				addr1: 500000,
+				addr2: 700000,
 			},
+			TotalShares:  1200000,
 			CoinPool:     5000000,
 			TokenPool:    10000000,
 			TokenPrice:   200,
@@ -94,7 +97,8 @@ func TestDexAccountMarshalUnmarshal(t *testing.T) {
 		var restored DexAccount
 		err := restored.Unmarshal(data)
 		assert.NoError(t, err)
-		assert.Equal(t, 2, len(restored.Balances))
+		assert.Equal(t, original.Shares, restored.Shares)
+		assert.Equal(t, original.TotalShares, restored.TotalShares)
 		assert.Equal(t, original.CoinPool, restored.CoinPool)
 		assert.Equal(t, original.TokenPool, restored.TokenPool)
 	})
@@ -111,7 +115,7 @@ func TestDexAccountMarshalUnmarshal(t *testing.T) {
 		tokenAddr.ByteValue[1] = 0xCD
 
 		original := DexAccount{
-			Balances:     make(map[[common.AddressLength]byte]CoinTokenDetails),
+			Shares:       make(map[[common.AddressLength]byte]int64),
 			CoinPool:     1000000,
 			TokenPool:    2000000,
 			TokenPrice:   100,
@@ -132,7 +136,7 @@ func TestDexAccountPools(t *testing.T) {
 
 	t.Run("coin and token pools are independent", func(t *testing.T) {
 		da := DexAccount{
-			Balances:   make(map[[common.AddressLength]byte]CoinTokenDetails),
+			Shares:     make(map[[common.AddressLength]byte]int64),
 			CoinPool:   1000000,
 			TokenPool:  5000000,
 			TokenPrice: 500,
@@ -145,7 +149,7 @@ func TestDexAccountPools(t *testing.T) {
 
 	t.Run("pool values can be zero", func(t *testing.T) {
 		da := DexAccount{
-			Balances:   make(map[[common.AddressLength]byte]CoinTokenDetails),
+			Shares:     make(map[[common.AddressLength]byte]int64),
 			CoinPool:   0,
 			TokenPool:  0,
 			TokenPrice: 0,
@@ -160,52 +164,89 @@ func TestDexAccountPools(t *testing.T) {
 	})
 }
 
-func TestDexAccountBalances(t *testing.T) {
+func TestDexAccountShares(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	t.Run("add balance for address", func(t *testing.T) {
-		da := DexAccount{
-			Balances: make(map[[common.AddressLength]byte]CoinTokenDetails),
-		}
+	addr := [common.AddressLength]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
 
-		addr := [common.AddressLength]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
-		da.Balances[addr] = CoinTokenDetails{
-			CoinBalance:  100000,
-			TokenBalance: 200000,
-		}
+	t.Run("mint and burn shares", func(t *testing.T) {
+		da := DexAccount{CoinPool: 1000, TokenPool: 4000}
+		assert.NoError(t, da.AddShares(addr, 2000))
+		assert.NoError(t, da.AddShares([common.AddressLength]byte{1}, 2000))
+		assert.Equal(t, int64(4000), da.TotalShares)
 
-		assert.Equal(t, 1, len(da.Balances))
-		assert.Equal(t, int64(100000), da.Balances[addr].CoinBalance)
-		assert.Equal(t, int64(200000), da.Balances[addr].TokenBalance)
+		coin, token := da.ProviderValue(addr)
+		assert.Equal(t, int64(500), coin)
+		assert.Equal(t, int64(2000), token)
+
+		assert.Error(t, da.AddShares(addr, -2001))
+		assert.NoError(t, da.AddShares(addr, -2000))
+		assert.Equal(t, int64(2000), da.TotalShares)
+		_, ok := da.Shares[addr]
+		assert.False(t, ok)
 	})
 
-	t.Run("multiple addresses", func(t *testing.T) {
-		da := DexAccount{
-			Balances: make(map[[common.AddressLength]byte]CoinTokenDetails),
-		}
+	t.Run("old format is kept until migration to shares", func(t *testing.T) {
+		var buffer bytes.Buffer
+		buffer.Write(common.GetByteInt64(1000))
+		buffer.Write(common.GetByteInt64(4000))
+		buffer.Write(common.GetByteInt64(25))
+		buffer.Write(make([]byte, common.AddressLength))
+		buffer.Write(common.GetByteInt64(2))
+		buffer.Write([]byte{1})
+		buffer.Write(make([]byte, common.AddressLength-1))
+		buffer.Write(CoinTokenDetails{CoinBalance: 100, TokenBalance: 400}.Marshal())
+		buffer.Write(addr[:])
+		buffer.Write(CoinTokenDetails{CoinBalance: -300, TokenBalance: 100}.Marshal())
 
-		for i := 0; i < 10; i++ {
-			addr := [common.AddressLength]byte{byte(i)}
-			da.Balances[addr] = CoinTokenDetails{
-				CoinBalance:  int64(i * 1000),
-				TokenBalance: int64(i * 2000),
-			}
-		}
+		var da DexAccount
+		assert.NoError(t, da.Unmarshal(buffer.Bytes()))
+		assert.Equal(t, int64(1000), da.CoinPool)
+		assert.Equal(t, int64(4000), da.TokenPool)
+		assert.Equal(t, CoinTokenDetails{CoinBalance: -300, TokenBalance: 100}, da.Balances[addr])
+		assert.Nil(t, da.Shares)
+		assert.Equal(t, buffer.Bytes(), da.Marshal())
+		coin, token := da.ProviderValue([common.AddressLength]byte{1})
+		assert.Equal(t, int64(100), coin)
+		assert.Equal(t, int64(400), token)
+
+		da.MigrateToShares()
+		assert.Nil(t, da.Balances)
+		// 100 coins and 400 tokens are worth 200 coins, negative value gives no shares,
+		// the rest of pool worth 2000 coins is not owned by any provider
+		assert.Equal(t, map[[common.AddressLength]byte]int64{{1}: 200, UnallocatedSharesOwner: 1800}, da.Shares)
+		assert.Equal(t, int64(2000), da.TotalShares)
 
-		assert.Equal(t, 10, len(da.Balances))
+		var restored DexAccount
+		assert.NoError(t, restored.Unmarshal(da.Marshal()))
+		assert.Equal(t, da, restored)
 	})
 
-	t.Run("update existing balance", func(t *testing.T) {
-		da := DexAccount{
-			Balances: make(map[[common.AddressLength]byte]CoinTokenDetails),
-		}
+	t.Run("legacy balances", func(t *testing.T) {
+		da := DexAccount{CoinPool: 1000, TokenPool: 4000}
+		da.ApplyLegacyQuote(addr, dex.OpAddLiquidity, dex.Quote{Coin: -1000, Token: -4000}, 8)
+		assert.Equal(t, CoinTokenDetails{CoinBalance: 1000, TokenBalance: 4000}, da.Balances[addr])
+		// buy of 400 tokens for 200 coins adds 20% to coin balances and takes 10% of token balances
+		da.ApplyLegacyQuote(addr, dex.OpBuy, dex.Quote{Coin: -200, Token: 400}, 8)
+		assert.Equal(t, CoinTokenDetails{CoinBalance: 1200, TokenBalance: 3600}, da.Balances[addr])
+		da.ApplyLegacyQuote(addr, dex.OpWithdrawToken, dex.Quote{Coin: 600, Token: 1800}, 8)
+		assert.Equal(t, CoinTokenDetails{CoinBalance: 600, TokenBalance: 1800}, da.Balances[addr])
 
-		addr := [common.AddressLength]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
-		da.Balances[addr] = CoinTokenDetails{CoinBalance: 100, TokenBalance: 200}
-		da.Balances[addr] = CoinTokenDetails{CoinBalance: 300, TokenBalance: 400}
+		var restored DexAccount
+		assert.NoError(t, restored.Unmarshal(da.Marshal()))
+		assert.Equal(t, da, restored)
+	})
 
-		assert.Equal(t, int64(300), da.Balances[addr].CoinBalance)
-		assert.Equal(t, int64(400), da.Balances[addr].TokenBalance)
+	t.Run("order book", func(t *testing.T) {
+		da := DexAccount{CoinPool: 1000, TokenPool: 4000, Shares: map[[common.AddressLength]byte]int64{addr: 2000}, TotalShares: 2000}
+		withoutBook := da.Marshal()
+		da.SetBook(dex.Book{LastID: 2, Orders: []dex.LimitOrder{{ID: 2, Owner: addr, Sell: true, Amount: 5, Price: 7, Expiry: 9}}})
+
+		var restored DexAccount
+		assert.NoError(t, restored.Unmarshal(da.Marshal()))
+		assert.Equal(t, da, restored)
+		assert.Equal(t, withoutBook, da.Marshal()[:len(withoutBook)])
+		assert.Error(t, restored.Unmarshal(da.Marshal()[:len(da.Marshal())-1]))
 	})
 }
@@ -0,0 +1,159 @@
+package account
+
+import (
+	"fmt"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/database"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+// MigrateStateSnapshots replaces full snapshots of accounts, staking and dex accounts, which older nodes
+// stored at every height, with diffs, so full state stays only at checkpoints. It runs once for database.
+func MigrateStateSnapshots() error {
+	done, err := database.MainDB.IsKey(common.StateMigratedDBPrefix[:])
+	if err != nil {
+		return err
+	}
+	if done {
+		return nil
+	}
+	err = migrateSnapshots(accountsCheckpointKey, common.AccountsDiffDBPrefix, func(b []byte) (map[[common.AddressLength]byte][]byte, error) {
+		at := AccountsType{}
+		err := at.Unmarshal(b)
+		return at.entries(), err
+	})
+	if err != nil {
+		return err
+	}
+	err = migrateSnapshots(dexAccountsCheckpointKey, common.DexAccountsDiffDBPrefix, func(b []byte) (map[[common.AddressLength]byte][]byte, error) {
+		da := DexAccountsType{}
+		err := da.Unmarshal(b)
+		return da.entries(), err
+	})
+	if err != nil {
+		return err
+	}
+	err = migrateStakingSnapshots()
+	if err != nil {
+		return err
+	}
+	return database.MainDB.Put(common.StateMigratedDBPrefix[:], []byte{1})
+}
+
+// migrateSnapshots goes from base height up and converts full snapshots at heights which are not checkpoints to diffs
+func migrateSnapshots(checkpointKey func(height int64) []byte, diffPrefix [2]byte,
+	decode func(b []byte) (map[[common.AddressLength]byte][]byte, error)) error {
+	last, err := lastHeightStoredInState(checkpointKey, diffPrefix)
+	if err != nil {
+		return err
+	}
+	var prev map[[common.AddressLength]byte][]byte
+	migrated := 0
+	for h := common.GetBaseHeight(); h <= last; h++ {
+		isKey, err := database.MainDB.IsKey(checkpointKey(h))
+		if err != nil {
+			return err
+		}
+		if !isKey {
+			if prev == nil {
+				return fmt.Errorf("no state snapshot below height %v", h)
+			}
+			sd, err := loadStateDiff(diffPrefix, h)
+			if err != nil {
+				return err
+			}
+			sd.Apply(prev)
+			continue
+		}
+		b, err := database.MainDB.Get(checkpointKey(h))
+		if err != nil {
+			return err
+		}
+		curr, err := decode(b)
+		if err != nil {
+			return err
+		}
+		if prev != nil && !IsStateCheckpointHeight(h) {
+			err = database.MainDB.Put(stateKey(diffPrefix, h), NewStateDiff(prev, curr).Marshal())
+			if err != nil {
+				return err
+			}
+			err = deleteStateKeys(checkpointKey(h))
+			if err != nil {
+				return err
+			}
+			migrated++
+		}
+		prev = curr
+	}
+	if migrated > 0 {
+		logger.GetLogger().Println("state snapshots converted to diffs:", migrated, string(diffPrefix[:]))
+	}
+	return nil
+}
+
+// migrateStakingSnapshots is migrateSnapshots for staking accounts stored by delegated accounts
+func migrateStakingSnapshots() error {
+	last, err := lastHeightStoredInState(stakingAccountsCheckpointMarker, common.StakingAccountsDiffDBPrefix)
+	if err != nil {
+		return err
+	}
+	var prev *[256]map[[common.AddressLength]byte][]byte
+	migrated := 0
+	for h := common.GetBaseHeight(); h <= last; h++ {
+		isKey, err := database.MainDB.IsKey(stakingAccountsCheckpointMarker(h))
+		if err != nil {
+			return err
+		}
+		if !isKey {
+			if prev == nil {
+				return fmt.Errorf("no staking state snapshot below height %v", h)
+			}
+			b, err := database.MainDB.Get(stateKey(common.StakingAccountsDiffDBPrefix, h))
+			if err != nil {
+				return err
+			}
+			diffs, err := unmarshalStakingDiffs(b)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 256; i++ {
+				diffs[i].Apply(prev[i])
+			}
+			continue
+		}
+		curr := [256]map[[common.AddressLength]byte][]byte{}
+		for i := 0; i < 256; i++ {
+			at := StakingAccountsType{}
+			b, err := database.MainDB.Get(stakingAccountsCheckpointKey(h, i))
+			if err == nil && b != nil {
+				err = at.Unmarshal(b)
+				if err != nil {
+					return err
+				}
+			}
+			curr[i] = at.entries()
+		}
+		if prev != nil && !IsStateCheckpointHeight(h) {
+			diffs := [256]StateDiff{}
+			for i := 0; i < 256; i++ {
+				diffs[i] = NewStateDiff(prev[i], curr[i])
+			}
+			err = database.MainDB.Put(stateKey(common.StakingAccountsDiffDBPrefix, h), marshalStakingDiffs(diffs))
+			if err != nil {
+				return err
+			}
+			err = deleteStateKeys(stakingAccountsCheckpointKeys(h)...)
+			if err != nil {
+				return err
+			}
+			migrated++
+		}
+		prev = &curr
+	}
+	if migrated > 0 {
+		logger.GetLogger().Println("staking state snapshots converted to diffs:", migrated)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package account
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/wonabru/qwid-node/common"
+)
+
+// Slash takes part of stake of operator which signed two blocks at evidenceHeight and jails it.
+// Returns slashed amount, which has to be moved elsewhere by caller.
+func Slash(accb []byte, delegatedAccount int, evidenceHeight int64, height int64) (int64, error) {
+	if len(accb) != common.AddressLength {
+		return 0, fmt.Errorf("wrong address length, must be %v", common.AddressLength)
+	}
+	if delegatedAccount <= 0 || delegatedAccount >= 256 {
+		return 0, fmt.Errorf("wrong delegated account %v of slashed operator", delegatedAccount)
+	}
+	acc := GetStakingAccountByAddressBytes(accb, delegatedAccount)
+	if !bytes.Equal(acc.Address[:], accb) {
+		return 0, fmt.Errorf("no staking account of slashed operator")
+	}
+	StakingRWMutex.Lock()
+	defer StakingRWMutex.Unlock()
+	for _, h := range acc.SlashedHeights {
+		if h == evidenceHeight {
+			return 0, fmt.Errorf("operator was already slashed for height %v", evidenceHeight)
+		}
+	}
+	// divided first, staked balance times permille could overflow
+	slashed := acc.StakedBalance / 1000 * common.SlashingPermille
+	acc.StakedBalance -= slashed
+	acc.SlashedHeights = append(acc.SlashedHeights, evidenceHeight)
+	if acc.JailedUntil < height+common.JailBlocks {
+		acc.JailedUntil = height + common.JailBlocks
+	}
+	sd := StakingDetail{
+		Amount:      -slashed,
+		LastUpdated: time.Now().Unix(),
+	}
+	if _, ok := acc.StakingDetails[height]; !ok {
+		acc.StakingDetails = map[int64][]StakingDetail{}
+		acc.StakingDetails[height] = []StakingDetail{}
+	}
+	acc.StakingDetails[height] = append(acc.StakingDetails[height], sd)
+	StakingAccounts[delegatedAccount].AllStakingAccounts[acc.Address] = acc
+	stakingChanged.add(acc.Address)
+	return slashed, nil
+}
+
+// IsSlashed tells if operator was already slashed for equivocation at evidenceHeight
+func IsSlashed(accb []byte, delegatedAccount int, evidenceHeight int64) bool {
+	acc := GetStakingAccountByAddressBytes(accb, delegatedAccount)
+	for _, h := range acc.SlashedHeights {
+		if h == evidenceHeight {
+			return true
+		}
+	}
+	return false
+}
+
+// IsJailed tells if delegated account cannot produce blocks at height, because one of its operators was slashed
+func IsJailed(delegatedAccount int, height int64) bool {
+	if delegatedAccount <= 0 || delegatedAccount >= 256 {
+		return false
+	}
+	StakingRWMutex.RLock()
+	defer StakingRWMutex.RUnlock()
+	for _, sa := range StakingAccounts[delegatedAccount].AllStakingAccounts {
+		if sa.JailedUntil > height {
+			return true
+		}
+	}
+	return false
+}
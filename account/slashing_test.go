@@ -0,0 +1,53 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+)
+
+func TestSlash(t *testing.T) {
+	initTestStakingAccounts()
+	addr := [common.AddressLength]byte{7}
+	StakingAccounts[3].AllStakingAccounts[addr] = StakingAccount{
+		StakedBalance:  1000000,
+		Address:        addr,
+		StakingDetails: map[int64][]StakingDetail{},
+	}
+
+	slashed, err := Slash(addr[:], 3, 90, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000000/1000*common.SlashingPermille, slashed)
+	acc := GetStakingAccountByAddressBytes(addr[:], 3)
+	assert.Equal(t, 1000000-slashed, acc.StakedBalance)
+	assert.True(t, IsSlashed(addr[:], 3, 90))
+	assert.True(t, IsJailed(3, 100+common.JailBlocks-1))
+	assert.False(t, IsJailed(3, 100+common.JailBlocks))
+	assert.False(t, IsJailed(4, 100))
+
+	_, err = Slash(addr[:], 3, 90, 101)
+	assert.Error(t, err)
+
+	err = Unstake(addr[:], -1, 200, 3)
+	assert.Error(t, err)
+}
+
+func TestStakingAccountMarshalSlashing(t *testing.T) {
+	addr := [common.AddressLength]byte{7}
+	sa := StakingAccount{StakedBalance: 5, Address: addr, StakingDetails: map[int64][]StakingDetail{}}
+	plain := sa.Marshal()
+	sa.JailedUntil = 300
+	sa.SlashedHeights = []int64{90, 120}
+	data := sa.Marshal()
+	assert.Equal(t, plain, data[:len(plain)])
+
+	var restored StakingAccount
+	assert.NoError(t, restored.Unmarshal(data))
+	assert.Equal(t, int64(300), restored.JailedUntil)
+	assert.Equal(t, []int64{90, 120}, restored.SlashedHeights)
+
+	assert.NoError(t, restored.Unmarshal(plain))
+	assert.Equal(t, int64(0), restored.JailedUntil)
+	assert.Empty(t, restored.SlashedHeights)
+}
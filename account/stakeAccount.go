@@ -3,6 +3,7 @@ package account
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/wonabru/qwid-node/common"
@@ -19,6 +20,8 @@ type StakingAccount struct {
 	OperationalAccount bool                       `json:"operational_account"`
 	LastStakeHeight    int64                      `json:last_stake_height,omitempty`
 	StakingDetails     map[int64][]StakingDetail  `json:"staking_details,omitempty"` // block number as key of map
+	JailedUntil        int64                      `json:"jailed_until,omitempty"`
+	SlashedHeights     []int64                    `json:"slashed_heights,omitempty"` // heights of equivocation already slashed
 }
 
 type StakingDetail struct {
@@ -107,6 +110,7 @@ func Stake(accb []byte, amount int64, height int64, delegatedAccount int, operat
 	copy(acc.DelegatedAccount[:], da.GetBytes())
 	copy(acc.Address[:], accb[:])
 	StakingAccounts[delegatedAccount].AllStakingAccounts[acc.Address] = acc
+	stakingChanged.add(acc.Address)
 	return nil
 }
 
@@ -127,6 +131,9 @@ func Unstake(accb []byte, amount int64, height int64, delegatedAccount int) erro
 	if height < hmax+common.MinNumberOfBlocksInStake {
 		return fmt.Errorf("staking must be delayed %v blocks", common.MinNumberOfBlocksInStake)
 	}
+	if acc.JailedUntil > height {
+		return fmt.Errorf("stake of jailed account cannot be withdrawn until height %v", acc.JailedUntil)
+	}
 	if acc.StakedBalance+amount < 0 {
 		return fmt.Errorf("insufficient staked balance")
 	}
@@ -165,6 +172,7 @@ func Unstake(accb []byte, amount int64, height int64, delegatedAccount int) erro
 	acc.StakingDetails[height] = append(acc.StakingDetails[height], sd)
 
 	StakingAccounts[delegatedAccount].AllStakingAccounts[acc.Address] = acc
+	stakingChanged.add(acc.Address)
 	return nil
 }
 
@@ -194,6 +202,7 @@ func Reward(accb []byte, reward int64, height int64, delegatedAccount int) error
 	}
 	acc.StakingDetails[height] = append(acc.StakingDetails[height], sd)
 	StakingAccounts[delegatedAccount].AllStakingAccounts[acc.Address] = acc
+	stakingChanged.add(acc.Address)
 	return nil
 }
 
@@ -227,6 +236,7 @@ func WithdrawReward(accb []byte, amount int64, height int64, delegatedAccount in
 	acc.StakingDetails[height] = append(acc.StakingDetails[height], sd)
 
 	StakingAccounts[delegatedAccount].AllStakingAccounts[acc.Address] = acc
+	stakingChanged.add(acc.Address)
 	return nil
 }
 
@@ -253,8 +263,14 @@ func (sa StakingAccount) Marshal() []byte {
 	// StakingDetails count
 	buffer.Write(common.GetByteInt64(int64(len(sa.StakingDetails))))
 
-	// StakingDetails
-	for key, details := range sa.StakingDetails {
+	// StakingDetails, sorted by height so the same account always gives the same bytes
+	keys := make([]int64, 0, len(sa.StakingDetails))
+	for key := range sa.StakingDetails {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, key := range keys {
+		details := sa.StakingDetails[key]
 		buffer.Write(common.GetByteInt64(key))
 		buffer.Write(common.GetByteInt64(int64(len(details))))
 
@@ -264,6 +280,14 @@ func (sa StakingAccount) Marshal() []byte {
 			buffer.Write(common.GetByteInt64(detail.LastUpdated))
 		}
 	}
+	// slashing section is written only for slashed accounts, so bytes of other accounts are as before
+	if sa.JailedUntil != 0 || len(sa.SlashedHeights) > 0 {
+		buffer.Write(common.GetByteInt64(sa.JailedUntil))
+		buffer.Write(common.GetByteInt64(int64(len(sa.SlashedHeights))))
+		for _, h := range sa.SlashedHeights {
+			buffer.Write(common.GetByteInt64(h))
+		}
+	}
 
 	return buffer.Bytes()
 }
@@ -327,6 +351,22 @@ func (sa *StakingAccount) Unmarshal(data []byte) error {
 
 		sa.StakingDetails[key] = details
 	}
+	sa.JailedUntil = 0
+	sa.SlashedHeights = nil
+	if buffer.Len() == 0 {
+		return nil
+	}
+	if buffer.Len() < 16 {
+		return fmt.Errorf("insufficient data for slashing of staking account")
+	}
+	sa.JailedUntil = common.GetInt64FromByte(buffer.Next(8))
+	numSlashed := common.GetInt64FromByte(buffer.Next(8))
+	if int64(buffer.Len()) < 8*numSlashed {
+		return fmt.Errorf("insufficient data for slashed heights")
+	}
+	for i := int64(0); i < numSlashed; i++ {
+		sa.SlashedHeights = append(sa.SlashedHeights, common.GetInt64FromByte(buffer.Next(8)))
+	}
 	return nil
 }
 
@@ -0,0 +1,285 @@
+package account
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/database"
+)
+
+// StateDiff holds changes of one account map between two consecutive heights.
+// Updated keeps marshaled entries which were created or modified, Deleted keeps removed keys.
+type StateDiff struct {
+	Updated map[[common.AddressLength]byte][]byte `json:"updated"`
+	Deleted [][common.AddressLength]byte          `json:"deleted"`
+}
+
+// stateCache keeps marshaled entries of the last stored height, so the next diff
+// does not need to rebuild previous state from DB
+type stateCache struct {
+	height  int64
+	entries map[[common.AddressLength]byte][]byte
+}
+
+func (c *stateCache) set(height int64, entries map[[common.AddressLength]byte][]byte) {
+	c.height = height
+	c.entries = entries
+}
+
+func (c *stateCache) invalidateFrom(height int64) {
+	if c.height >= height {
+		c.height = -1
+		c.entries = nil
+	}
+}
+
+// changedAddresses collects addresses changed since state was stored, so only their entries are marshaled
+// for the next diff. all is set when whole state is replaced, then every entry is compared.
+type changedAddresses struct {
+	mutex sync.Mutex
+	addrs map[[common.AddressLength]byte]bool
+	all   bool
+}
+
+func (c *changedAddresses) add(addr [common.AddressLength]byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.addrs == nil {
+		c.addrs = map[[common.AddressLength]byte]bool{}
+	}
+	c.addrs[addr] = true
+}
+
+func (c *changedAddresses) addAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.all = true
+}
+
+// peek returns copy of changed addresses without clearing them
+func (c *changedAddresses) peek() (map[[common.AddressLength]byte]bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	addrs := make(map[[common.AddressLength]byte]bool, len(c.addrs))
+	for a := range c.addrs {
+		addrs[a] = true
+	}
+	return addrs, c.all
+}
+
+// take returns changed addresses and clears them
+func (c *changedAddresses) take() (map[[common.AddressLength]byte]bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	addrs, all := c.addrs, c.all
+	c.addrs, c.all = nil, false
+	return addrs, all
+}
+
+// diffChanged updates entries of previous height in place with changed addresses and returns their diff.
+// current returns marshaled entry of address and false when address is not in state anymore.
+func diffChanged(entries map[[common.AddressLength]byte][]byte, changed map[[common.AddressLength]byte]bool,
+	current func(addr [common.AddressLength]byte) ([]byte, bool)) StateDiff {
+	sd := StateDiff{
+		Updated: map[[common.AddressLength]byte][]byte{},
+		Deleted: [][common.AddressLength]byte{},
+	}
+	for addr := range changed {
+		b, ok := current(addr)
+		pb, had := entries[addr]
+		if !ok {
+			if had {
+				sd.Deleted = append(sd.Deleted, addr)
+				delete(entries, addr)
+			}
+			continue
+		}
+		if !had || !bytes.Equal(pb, b) {
+			sd.Updated[addr] = b
+			entries[addr] = b
+		}
+	}
+	sortAddresses(sd.Deleted)
+	return sd
+}
+
+func NewStateDiff(prev, curr map[[common.AddressLength]byte][]byte) StateDiff {
+	sd := StateDiff{
+		Updated: map[[common.AddressLength]byte][]byte{},
+		Deleted: [][common.AddressLength]byte{},
+	}
+	for addr, b := range curr {
+		if pb, ok := prev[addr]; !ok || !bytes.Equal(pb, b) {
+			sd.Updated[addr] = b
+		}
+	}
+	for addr := range prev {
+		if _, ok := curr[addr]; !ok {
+			sd.Deleted = append(sd.Deleted, addr)
+		}
+	}
+	sortAddresses(sd.Deleted)
+	return sd
+}
+
+func (sd StateDiff) IsEmpty() bool {
+	return len(sd.Updated) == 0 && len(sd.Deleted) == 0
+}
+
+// Apply modifies entries in place so they reflect state after the diff
+func (sd StateDiff) Apply(entries map[[common.AddressLength]byte][]byte) {
+	for _, addr := range sd.Deleted {
+		delete(entries, addr)
+	}
+	for addr, b := range sd.Updated {
+		entries[addr] = b
+	}
+}
+
+// Marshal converts StateDiff to a binary format. Keys are sorted so the same diff gives the same bytes.
+func (sd StateDiff) Marshal() []byte {
+	var buffer bytes.Buffer
+
+	addrs := make([][common.AddressLength]byte, 0, len(sd.Updated))
+	for addr := range sd.Updated {
+		addrs = append(addrs, addr)
+	}
+	sortAddresses(addrs)
+	buffer.Write(common.GetByteInt64(int64(len(addrs))))
+	for _, addr := range addrs {
+		buffer.Write(addr[:])
+		buffer.Write(common.BytesToLenAndBytes(sd.Updated[addr]))
+	}
+
+	buffer.Write(common.GetByteInt64(int64(len(sd.Deleted))))
+	for _, addr := range sd.Deleted {
+		buffer.Write(addr[:])
+	}
+	return buffer.Bytes()
+}
+
+// Unmarshal decodes StateDiff from a binary format.
+func (sd *StateDiff) Unmarshal(data []byte) error {
+	if len(data) < 16 {
+		return fmt.Errorf("not enough data to unmarshal state diff: need at least 16, have %d", len(data))
+	}
+	n := common.GetInt64FromByte(data[:8])
+	data = data[8:]
+	sd.Updated = make(map[[common.AddressLength]byte][]byte, n)
+	for i := int64(0); i < n; i++ {
+		if len(data) < common.AddressLength {
+			return fmt.Errorf("not enough data for updated entry %d in state diff", i)
+		}
+		var addr [common.AddressLength]byte
+		copy(addr[:], data[:common.AddressLength])
+		bs, left, err := common.BytesWithLenToBytes(data[common.AddressLength:])
+		if err != nil {
+			return err
+		}
+		sd.Updated[addr] = bs
+		data = left
+	}
+
+	if len(data) < 8 {
+		return fmt.Errorf("not enough data for deleted entries in state diff")
+	}
+	n = common.GetInt64FromByte(data[:8])
+	data = data[8:]
+	if len(data) != int(n)*common.AddressLength {
+		return fmt.Errorf("wrong number of bytes for deleted entries in state diff: need %d, have %d", int(n)*common.AddressLength, len(data))
+	}
+	sd.Deleted = make([][common.AddressLength]byte, n)
+	for i := int64(0); i < n; i++ {
+		copy(sd.Deleted[i][:], data[:common.AddressLength])
+		data = data[common.AddressLength:]
+	}
+	return nil
+}
+
+// loadStateDiff reads diff stored at height, there is none at heights where checkpoint is stored
+func loadStateDiff(prefix [2]byte, height int64) (StateDiff, error) {
+	b, err := database.MainDB.Get(stateKey(prefix, height))
+	if err != nil {
+		return StateDiff{}, err
+	}
+	sd := StateDiff{}
+	err = sd.Unmarshal(b)
+	if err != nil {
+		return StateDiff{}, err
+	}
+	return sd, nil
+}
+
+func sortAddresses(addrs [][common.AddressLength]byte) {
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+}
+
+// IsStateCheckpointHeight tells whether full state snapshot is stored at height instead of diff
+func IsStateCheckpointHeight(height int64) bool {
+	return height%common.StateCheckpointInterval == 0
+}
+
+func stateKey(prefix [2]byte, height int64) []byte {
+	hb := common.GetByteInt64(height)
+	return append(prefix[:], hb...)
+}
+
+// nearestCheckpoint finds the highest height not larger than given height at which full state is stored
+func nearestCheckpoint(checkpointKey func(height int64) []byte, height int64) (int64, error) {
+	for h := height; h >= 0; h-- {
+		isKey, err := database.MainDB.IsKey(checkpointKey(h))
+		if err != nil {
+			return -1, err
+		}
+		if isKey {
+			return h, nil
+		}
+	}
+	return -1, fmt.Errorf("no state checkpoint found below height %v", height)
+}
+
+// lastHeightStoredInState returns the last height for which either checkpoint or diff is stored
+func lastHeightStoredInState(checkpointKey func(height int64) []byte, diffPrefix [2]byte) (int64, error) {
+	i := common.GetBaseHeight()
+	for {
+		isKey, err := database.MainDB.IsKey(checkpointKey(i))
+		if err != nil {
+			return i - 1, err
+		}
+		if !isKey {
+			isKey, err = database.MainDB.IsKey(stateKey(diffPrefix, i))
+			if err != nil {
+				return i - 1, err
+			}
+		}
+		if !isKey {
+			break
+		}
+		i++
+	}
+	return i - 1, nil
+}
+
+// deleteStateKeys removes keys which are present in DB. When checkpoint is stored at height
+// the diff at the same height has to disappear and vice versa, so stale records do not shadow fresh ones.
+func deleteStateKeys(keys ...[]byte) error {
+	for _, k := range keys {
+		isKey, err := database.MainDB.IsKey(k)
+		if err != nil {
+			return err
+		}
+		if !isKey {
+			continue
+		}
+		err = database.MainDB.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+func TestStateDiff(t *testing.T) {
+	logger.InitLogger()
+	defer logger.CloseLogger()
+
+	addr1 := [common.AddressLength]byte{1}
+	addr2 := [common.AddressLength]byte{2}
+	addr3 := [common.AddressLength]byte{3}
+
+	prev := map[[common.AddressLength]byte][]byte{
+		addr1: Account{Balance: 100, Address: addr1}.Marshal(),
+		addr2: Account{Balance: 200, Address: addr2}.Marshal(),
+	}
+	curr := map[[common.AddressLength]byte][]byte{
+		addr1: Account{Balance: 100, Address: addr1}.Marshal(),
+		addr2: Account{Balance: 150, Address: addr2}.Marshal(),
+		addr3: Account{Balance: 50, Address: addr3}.Marshal(),
+	}
+
+	t.Run("diff contains only changed entries", func(t *testing.T) {
+		sd := NewStateDiff(prev, curr)
+		assert.Equal(t, 2, len(sd.Updated))
+		assert.Contains(t, sd.Updated, addr2)
+		assert.Contains(t, sd.Updated, addr3)
+		assert.Empty(t, sd.Deleted)
+	})
+
+	t.Run("diff of equal states is empty", func(t *testing.T) {
+		sd := NewStateDiff(curr, curr)
+		assert.True(t, sd.IsEmpty())
+	})
+
+	t.Run("removed entries are deleted", func(t *testing.T) {
+		sd := NewStateDiff(curr, prev)
+		assert.Equal(t, [][common.AddressLength]byte{addr3}, sd.Deleted)
+	})
+
+	t.Run("apply reproduces current state", func(t *testing.T) {
+		entries := map[[common.AddressLength]byte][]byte{}
+		for k, v := range prev {
+			entries[k] = v
+		}
+		NewStateDiff(prev, curr).Apply(entries)
+		assert.Equal(t, curr, entries)
+	})
+
+	t.Run("marshal and unmarshal", func(t *testing.T) {
+		original := NewStateDiff(curr, prev)
+		data := original.Marshal()
+		assert.Equal(t, data, original.Marshal())
+
+		var restored StateDiff
+		err := restored.Unmarshal(data)
+		assert.NoError(t, err)
+		assert.Equal(t, original.Updated, restored.Updated)
+		assert.Equal(t, original.Deleted, restored.Deleted)
+	})
+
+	t.Run("unmarshal with insufficient data", func(t *testing.T) {
+		var sd StateDiff
+		err := sd.Unmarshal([]byte{1, 2, 3})
+		assert.Error(t, err)
+	})
+
+	t.Run("diff of changed addresses equals full diff", func(t *testing.T) {
+		entries := map[[common.AddressLength]byte][]byte{}
+		for k, v := range curr {
+			entries[k] = v
+		}
+		c := changedAddresses{}
+		c.add(addr2)
+		c.add(addr3)
+		changed, all := c.take()
+		assert.False(t, all)
+		sd := diffChanged(entries, changed, func(addr [common.AddressLength]byte) ([]byte, bool) {
+			b, ok := prev[addr]
+			return b, ok
+		})
+		assert.Equal(t, NewStateDiff(curr, prev), sd)
+		assert.Equal(t, prev, entries)
+		changed, _ = c.take()
+		assert.Empty(t, changed)
+	})
+}
+
+func TestStakingDiffsMarshalUnmarshal(t *testing.T) {
+	logger.InitLogger()
+	defer logger.CloseLogger()
+
+	addr := [common.AddressLength]byte{5}
+	diffs := [256]StateDiff{}
+	diffs[7] = NewStateDiff(nil, map[[common.AddressLength]byte][]byte{
+		addr: StakingAccount{StakedBalance: 1000, Address: addr}.Marshal(),
+	})
+
+	restored, err := unmarshalStakingDiffs(marshalStakingDiffs(diffs))
+	assert.NoError(t, err)
+	assert.Equal(t, diffs[7].Updated, restored[7].Updated)
+	for i := 0; i < 256; i++ {
+		if i != 7 {
+			assert.True(t, restored[i].IsEmpty())
+		}
+	}
+}
+
+func TestIsStateCheckpointHeight(t *testing.T) {
+	assert.True(t, IsStateCheckpointHeight(0))
+	assert.True(t, IsStateCheckpointHeight(common.StateCheckpointInterval))
+	assert.False(t, IsStateCheckpointHeight(1))
+	assert.False(t, IsStateCheckpointHeight(common.StateCheckpointInterval+1))
+}
+
+func TestDexAccountMarshalIsDeterministic(t *testing.T) {
+	da := DexAccount{
+		Shares:   map[[common.AddressLength]byte]int64{},
+		CoinPool: 10,
+	}
+	for i := byte(0); i < 20; i++ {
+		da.Shares[[common.AddressLength]byte{i}] = int64(i) + 1
+	}
+	first := da.Marshal()
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, da.Marshal())
+	}
+}
@@ -127,6 +127,12 @@ func (bl Block) StoreBlock() error {
 	if err != nil {
 		return err
 	}
+	for _, h := range bl.TransactionsHashes {
+		err = database.MainDB.Put(append(common.TransactionBlockHeightDBPrefix[:], h.GetBytes()...), bh)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -137,6 +143,27 @@ func RemoveBlockFromDB(height int64) error {
 	if err != nil {
 		return err
 	}
+	err = RemoveBlockLogs(height)
+	if err != nil {
+		return err
+	}
+	err = RemoveOrderEvents(height)
+	if err != nil {
+		return err
+	}
+	bl, err := LoadBlock(height)
+	if err == nil {
+		for _, h := range bl.TransactionsHashes {
+			err = database.MainDB.Delete(append(common.TransactionBlockHeightDBPrefix[:], h.GetBytes()...))
+			if err != nil {
+				return err
+			}
+			err = storeTransactionFailed(h.GetBytes(), false)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	err = database.MainDB.Delete(append(common.BlocksDBPrefix[:], hb...))
 	if err != nil {
 		return err
@@ -149,7 +176,7 @@ func RemoveBlockFromDB(height int64) error {
 }
 
 func LastHeightStoredInBlocks() (int64, error) {
-	i := int64(0)
+	i := common.GetBaseHeight()
 	for {
 		ib := common.GetByteInt64(i)
 		prefix := append(common.BlockByHeightDBPrefix[:], ib...)
@@ -191,3 +218,68 @@ func LoadBlock(height int64) (Block, error) {
 	}
 	return b, nil
 }
+
+func LoadBlockByHash(hash []byte) (Block, error) {
+	abl, err := database.MainDB.Get(append(common.BlocksDBPrefix[:], hash...))
+	if err != nil {
+		return Block{}, err
+	}
+	block := Block{}
+	b, err := block.GetFromBytes(abl)
+	if err != nil {
+		return Block{}, err
+	}
+	return b, nil
+}
+
+// LoadTransactionBlockHeight returns height of the block in which transaction was included
+func LoadTransactionBlockHeight(hash []byte) (int64, error) {
+	hb, err := database.MainDB.Get(append(common.TransactionBlockHeightDBPrefix[:], hash...))
+	if err != nil {
+		return -1, err
+	}
+	if len(hb) != 8 {
+		return -1, fmt.Errorf("wrong length of stored block height of transaction")
+	}
+	return common.GetInt64FromByte(hb), nil
+}
+
+// storeTransactionFailed marks transaction which was included in block but failed, so nothing was transferred.
+// Mark is removed when transaction is evaluated with success, ex. in other block after reorganization.
+func storeTransactionFailed(hash []byte, failed bool) error {
+	key := append(common.TransactionFailedDBPrefix[:], hash...)
+	if failed {
+		return database.MainDB.Put(key, []byte{1})
+	}
+	return database.MainDB.Delete(key)
+}
+
+// IsTransactionFailed reports whether transaction included in block failed
+func IsTransactionFailed(hash []byte) (bool, error) {
+	return database.MainDB.IsKey(append(common.TransactionFailedDBPrefix[:], hash...))
+}
+
+// LoadTransactionProof returns merkle proof of transaction against RootMerkleTree of the block in which it was included
+func LoadTransactionProof(hash []byte) (transactionsPool.MerkleProof, error) {
+	height, err := LoadTransactionBlockHeight(hash)
+	if err != nil {
+		return transactionsPool.MerkleProof{}, err
+	}
+	bl, err := LoadBlock(height)
+	if err != nil {
+		return transactionsPool.MerkleProof{}, err
+	}
+	txs := [][]byte{}
+	for _, h := range bl.TransactionsHashes {
+		txs = append(txs, h.GetBytes())
+	}
+	proof, err := transactionsPool.NewMerkleProof(transactionsPool.BlockMerkleLeaves(txs), hash)
+	if err != nil {
+		return transactionsPool.MerkleProof{}, err
+	}
+	proof.Height = height
+	if !proof.Verify(bl.GetHeader().RootMerkleTree.GetBytes()) {
+		return transactionsPool.MerkleProof{}, fmt.Errorf("transactions of block %d do not match its merkle root", height)
+	}
+	return proof, nil
+}
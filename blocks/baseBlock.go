@@ -33,6 +33,8 @@ type BaseBlock struct {
 	RandOracle       int64       `json:"rand_oracle"`
 	PriceOracleData  []byte      `json:"price_oracle_data"`
 	RandOracleData   []byte      `json:"rand_oracle_data"`
+	StateRoot        common.Hash `json:"state_root"`    // root of state after transactions and contracts of this block
+	SnapshotRoot     common.Hash `json:"snapshot_root"` // root of state snapshot, set only at snapshot commit heights
 }
 
 func FromBytesToEncryptionConfig(bb []byte, primary bool) (oqs.ConfigEnc, error) {
@@ -73,8 +75,14 @@ func (b *BaseHeader) GetString() string {
 
 // GetString returns a string representation of BaseBlock.
 func (b *BaseBlock) GetString() string {
-	return fmt.Sprintf("Header: {%s}\nBlockHeaderHash: %s\nBlockTimeStamp: %d\nRewardPercentage: %d\nSupply: %d\nPriceOracle: %d\nRandOracle: %d\n",
-		b.BaseHeader.GetString(), b.BlockHeaderHash.GetHex(), b.BlockTimeStamp, b.RewardPercentage, b.Supply, b.PriceOracle, b.RandOracle)
+	return fmt.Sprintf("Header: {%s}\nBlockHeaderHash: %s\nBlockTimeStamp: %d\nRewardPercentage: %d\nSupply: %d\nPriceOracle: %d\nRandOracle: %d\nStateRoot: %s\nSnapshotRoot: %s\n",
+		b.BaseHeader.GetString(), b.BlockHeaderHash.GetHex(), b.BlockTimeStamp, b.RewardPercentage, b.Supply, b.PriceOracle, b.RandOracle, b.StateRoot.GetHex(), b.SnapshotRoot.GetHex())
+}
+
+// IsStateRootHeight tells whether block at height carries state root and snapshot root.
+// Older blocks keep previous layout, so their hashes do not change.
+func IsStateRootHeight(height int64) bool {
+	return height >= common.StateRootForkHeight
 }
 
 func (b *BaseHeader) GetBytesWithoutSignature() []byte {
@@ -107,6 +115,24 @@ func (b *BaseHeader) GetBytes() []byte {
 }
 
 func (bh *BaseHeader) Verify(sigName, sigName2 string, isPaused, isPaused2 bool) bool {
+	a := bh.OperatorAccount
+	//logger.GetLogger().Println("a:", a, "primary:", primary)
+	pk, err := pubkeys.LoadPubKeyWithPrimary(a, bh.IsSignedByPrimary())
+	if err != nil {
+		logger.GetLogger().Println(err)
+		return false
+	}
+	return bh.VerifyWithPubKey(pk.GetBytes(), sigName, sigName2, isPaused, isPaused2)
+}
+
+// IsSignedByPrimary tells which of operator keys signed header
+func (bh *BaseHeader) IsSignedByPrimary() bool {
+	sig := bh.Signature.GetBytes()
+	return len(sig) > 0 && sig[0] == 0
+}
+
+// VerifyWithPubKey verifies signature of header with given public key of operator, it does not need pubkeys in database
+func (bh *BaseHeader) VerifyWithPubKey(pk []byte, sigName, sigName2 string, isPaused, isPaused2 bool) bool {
 	signatureBlockHeaderMessage := bh.GetBytesWithoutSignature()
 	if !bytes.Equal(signatureBlockHeaderMessage, bh.SignatureMessage) {
 		logger.GetLogger().Println("signatures are different")
@@ -117,17 +143,7 @@ func (bh *BaseHeader) Verify(sigName, sigName2 string, isPaused, isPaused2 bool)
 		logger.GetLogger().Println(err)
 		return false
 	}
-	a := bh.OperatorAccount
-	var primary bool
-	sig := bh.Signature.GetBytes()
-	primary = sig[0] == 0
-	//logger.GetLogger().Println("a:", a, "primary:", primary)
-	pk, err := pubkeys.LoadPubKeyWithPrimary(a, primary)
-	if err != nil {
-		logger.GetLogger().Println(err)
-		return false
-	}
-	return wallet.Verify(calcHash, bh.Signature.GetBytes(), pk.GetBytes(), sigName, sigName2, isPaused, isPaused2)
+	return wallet.Verify(calcHash, bh.Signature.GetBytes(), pk, sigName, sigName2, isPaused, isPaused2)
 }
 
 func (bh *BaseHeader) Sign(primary bool) (common.Signature, []byte, error) {
@@ -203,6 +219,10 @@ func (bb *BaseBlock) GetBytes() []byte {
 	b = append(b, common.GetByteInt64(bb.RandOracle)...)
 	b = append(b, common.BytesToLenAndBytes(bb.PriceOracleData)...)
 	b = append(b, common.BytesToLenAndBytes(bb.RandOracleData)...)
+	if IsStateRootHeight(bb.BaseHeader.Height) {
+		b = append(b, bb.StateRoot.GetBytes()...)
+		b = append(b, bb.SnapshotRoot.GetBytes()...)
+	}
 	return b
 }
 
@@ -229,7 +249,17 @@ func (bb *BaseBlock) GetFromBytes(b []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return b[:], nil
+	bb.StateRoot = common.Hash{}
+	bb.SnapshotRoot = common.Hash{}
+	if !IsStateRootHeight(bb.BaseHeader.Height) {
+		return b, nil
+	}
+	if len(b) < 2*common.HashLength {
+		return nil, fmt.Errorf("not enough bytes to decode state and snapshot roots")
+	}
+	bb.StateRoot = common.GetHashFromBytes(b[:common.HashLength])
+	bb.SnapshotRoot = common.GetHashFromBytes(b[common.HashLength : 2*common.HashLength])
+	return b[2*common.HashLength:], nil
 }
 
 func (b *BaseHeader) CalcHash() (common.Hash, error) {
@@ -3,9 +3,9 @@ package blocks
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
-	"github.com/stretchr/testify/assert"
 )
 
 // buildMinimalBaseHeader creates a BaseHeader with minimal valid data for serialization tests.
@@ -248,6 +248,30 @@ func TestBaseBlockGetBytesRoundtrip(t *testing.T) {
 		assert.Equal(t, int64(777), restored.BaseHeader.Height)
 		assert.Equal(t, int32(55), restored.BaseHeader.Difficulty)
 	})
+
+	t.Run("roots are encoded only from fork height", func(t *testing.T) {
+		original := buildBaseBlock()
+		original.StateRoot[0] = 1
+		original.SnapshotRoot[0] = 2
+		original.BaseHeader.Height = common.StateRootForkHeight - 1
+		old := original.GetBytes()
+		original.BaseHeader.Height = common.StateRootForkHeight
+		b := original.GetBytes()
+		assert.Equal(t, len(old)+2*common.HashLength, len(b))
+
+		var restored BaseBlock
+		remaining, err := restored.GetFromBytes(b)
+		assert.NoError(t, err)
+		assert.Empty(t, remaining)
+		assert.Equal(t, original.StateRoot, restored.StateRoot)
+		assert.Equal(t, original.SnapshotRoot, restored.SnapshotRoot)
+
+		remaining, err = restored.GetFromBytes(old)
+		assert.NoError(t, err)
+		assert.Empty(t, remaining)
+		assert.Equal(t, common.Hash{}, restored.StateRoot)
+		assert.Equal(t, common.Hash{}, restored.SnapshotRoot)
+	})
 }
 
 func TestBaseBlockGetString(t *testing.T) {
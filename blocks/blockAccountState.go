@@ -25,6 +25,7 @@ func AddBalance(address [common.AddressLength]byte, addedAmount int64) error {
 		acc.Balance = balance
 		acc.Address = address
 		account.Accounts.AllAccounts[address] = acc
+		account.AccountChanged(address)
 	}
 	if balance+addedAmount < 0 {
 		account.AccountsRWMutex.Unlock()
@@ -0,0 +1,161 @@
+package blocks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/database"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+// ErrUnknownParent is returned when side branch cannot be linked to main chain with blocks known
+var ErrUnknownParent = errors.New("parent of side block is unknown")
+
+func sideBlockKey(hash []byte) []byte {
+	return append(common.SideBlocksDBPrefix[:], hash...)
+}
+
+// CheckSideBlock makes checks which do not need state of chain, before block is kept as side branch
+func CheckSideBlock(bl Block) error {
+	h := common.GetHeight()
+	height := bl.GetHeader().Height
+	if height < 1 || height <= h-common.MaxReorgDepth || height > h+common.MaxReorgDepth {
+		return fmt.Errorf("side block height %d out of reorg window", height)
+	}
+	if height <= common.GetFinalizedHeight() || height <= common.GetBaseHeight() {
+		return fmt.Errorf("side block height %d is finalized", height)
+	}
+	hash, err := bl.CalcBlockHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash.GetBytes(), bl.BlockHash.GetBytes()) {
+		return fmt.Errorf("wrong hash of side block")
+	}
+	if !bl.CheckSeal() {
+		return fmt.Errorf("seal check fails of side block")
+	}
+	head := bl.GetHeader()
+	sigName, sigName2, isPaused, isPaused2, err := bl.GetSigNames()
+	if err != nil {
+		return err
+	}
+	if !head.Verify(sigName, sigName2, isPaused, isPaused2) {
+		return fmt.Errorf("header of side block fails to verify")
+	}
+	return nil
+}
+
+func StoreSideBlock(bl Block) error {
+	return database.MainDB.Put(sideBlockKey(bl.GetBlockHash().GetBytes()), bl.GetBytes())
+}
+
+func LoadSideBlock(hash []byte) (Block, error) {
+	abl, err := database.MainDB.Get(sideBlockKey(hash))
+	if err != nil {
+		return Block{}, err
+	}
+	return Block{}.GetFromBytes(abl)
+}
+
+func RemoveSideBlock(hash []byte) error {
+	return database.MainDB.Delete(sideBlockKey(hash))
+}
+
+// PruneSideBlocks removes side blocks which cannot replace main chain anymore
+func PruneSideBlocks(height int64) {
+	values, err := database.MainDB.LoadAll(common.SideBlocksDBPrefix[:])
+	if err != nil {
+		logger.GetLogger().Println(err)
+		return
+	}
+	for _, v := range values {
+		bl, err := Block{}.GetFromBytes(v)
+		if err != nil || bl.GetHeader().Height <= height-common.MaxReorgDepth {
+			err = RemoveSideBlock(bl.GetBlockHash().GetBytes())
+			if err != nil {
+				logger.GetLogger().Println(err)
+			}
+		}
+	}
+}
+
+// IsOnMainChain checks if block of hash is stored in main chain at height
+func IsOnMainChain(hash []byte, height int64) bool {
+	hb, err := LoadHashOfBlock(height)
+	return err == nil && bytes.Equal(hb, hash)
+}
+
+// GetBranch returns side blocks from first block after main chain up to tip, and height of common ancestor
+func GetBranch(tip []byte) ([]Block, int64, error) {
+	bl, err := LoadSideBlock(tip)
+	if err != nil {
+		return nil, 0, err
+	}
+	branch := []Block{bl}
+	for len(branch) <= int(2*common.MaxReorgDepth) {
+		head := bl.GetHeader()
+		if IsOnMainChain(head.PreviousHash.GetBytes(), head.Height-1) {
+			for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+				branch[i], branch[j] = branch[j], branch[i]
+			}
+			return branch, head.Height - 1, nil
+		}
+		bl, err = LoadSideBlock(head.PreviousHash.GetBytes())
+		if err != nil {
+			return nil, 0, ErrUnknownParent
+		}
+		if bl.GetHeader().Height != head.Height-1 {
+			return nil, 0, fmt.Errorf("wrong height of parent side block")
+		}
+		branch = append(branch, bl)
+	}
+	return nil, 0, fmt.Errorf("side branch is longer than reorg window")
+}
+
+// BlockWeight is difficulty of block plus stake of its delegated account counted in minimal node stakes.
+// Branches are compared against the same staking state, so the stake part is fair for both of them.
+func BlockWeight(header BaseHeader) int64 {
+	w := int64(header.Difficulty)
+	n, err := account.IntDelegatedAccountFromAddress(header.DelegatedAccount)
+	if err != nil || n < 1 || n > 255 || common.MinStakingForNode <= 0 {
+		return w
+	}
+	_, staked, _ := account.GetStakedInDelegatedAccount(n)
+	return w + int64(staked)/common.MinStakingForNode
+}
+
+// BranchWeight sums weights of blocks
+func BranchWeight(branch []Block) int64 {
+	w := int64(0)
+	for _, bl := range branch {
+		w += BlockWeight(bl.GetHeader())
+	}
+	return w
+}
+
+// MainChainWeight sums weights of main chain blocks above ancestor up to height
+func MainChainWeight(ancestor int64, height int64) (int64, error) {
+	w := int64(0)
+	for i := ancestor + 1; i <= height; i++ {
+		bl, err := LoadBlock(i)
+		if err != nil {
+			return 0, err
+		}
+		w += BlockWeight(bl.GetHeader())
+	}
+	return w, nil
+}
+
+// IsBetterBranch decides if side branch should replace main chain above ancestor,
+// branch has to be strictly heavier and reorg cannot go deeper than MaxReorgDepth nor below finalized block
+// or snapshot which node started from
+func IsBetterBranch(sideWeight int64, mainWeight int64, ancestor int64, height int64) bool {
+	if height-ancestor > common.MaxReorgDepth || ancestor < common.GetFinalizedHeight() || ancestor < common.GetBaseHeight() {
+		return false
+	}
+	return sideWeight > mainWeight
+}
@@ -0,0 +1,38 @@
+package blocks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+func TestIsBetterBranch(t *testing.T) {
+	h := int64(1000)
+	// equal weight keeps main chain, so nodes do not flap between forks
+	assert.False(t, IsBetterBranch(10, 10, h-1, h))
+	assert.True(t, IsBetterBranch(11, 10, h-1, h))
+	assert.False(t, IsBetterBranch(9, 10, h-1, h))
+	assert.True(t, IsBetterBranch(1, 0, h, h))
+	assert.True(t, IsBetterBranch(100, 10, h-common.MaxReorgDepth, h))
+	assert.False(t, IsBetterBranch(100, 10, h-common.MaxReorgDepth-1, h))
+
+	// finalized blocks cannot be replaced
+	common.SetFinalizedHeight(h - 1)
+	defer common.SetFinalizedHeight(0)
+	assert.True(t, IsBetterBranch(11, 10, h-1, h))
+	assert.False(t, IsBetterBranch(11, 10, h-2, h))
+}
+
+func TestBranchWeight(t *testing.T) {
+	logger.InitLogger()
+	defer logger.CloseLogger()
+
+	b1 := Block{BaseBlock: BaseBlock{BaseHeader: BaseHeader{Difficulty: 10}}}
+	b2 := Block{BaseBlock: BaseBlock{BaseHeader: BaseHeader{Difficulty: 15}}}
+	// no delegated account in header, so only difficulty counts
+	assert.Equal(t, int64(10), BlockWeight(b1.GetHeader()))
+	assert.Equal(t, int64(25), BranchWeight([]Block{b1, b2}))
+	assert.Equal(t, int64(0), BranchWeight(nil))
+}
@@ -0,0 +1,390 @@
+package blocks
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/stateDB"
+	"github.com/wonabru/qwid-node/database"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+// contractsDiff holds changes of contracts records made by one block
+type contractsDiff struct {
+	Updated []snapshotRecord
+	Deleted [][]byte
+}
+
+// contractsStateCache keeps records of the last stored height, so the next diff does not need to read DB
+type contractsStateCache struct {
+	height   int64
+	entries  map[string][]byte
+	prefixes map[string]map[string]bool // keys of entries grouped by contractsPrefix
+}
+
+// contractsAddressPrefixes are prefixes of contracts records kept for address
+var contractsAddressPrefixes = [][2]byte{snapshotVMAccountKey, snapshotVMCodeKey, snapshotVMCodeHashKey, snapshotVMNonceKey,
+	snapshotVMStorageKey, snapshotVMTokenBalance, snapshotVMTokenKey}
+
+// contractsPrefix is part of key shared by all records of one address, preimage records are grouped alone
+func contractsPrefix(key string) string {
+	if len(key) < 2+common.AddressLength || key[:2] == string(snapshotVMPreimageKey[:]) {
+		return key
+	}
+	return key[:2+common.AddressLength]
+}
+
+func (c *contractsStateCache) set(height int64, entries map[string][]byte) {
+	c.height, c.entries, c.prefixes = height, entries, nil
+	if entries == nil {
+		return
+	}
+	c.prefixes = map[string]map[string]bool{}
+	for k := range entries {
+		c.index(k)
+	}
+}
+
+func (c *contractsStateCache) index(k string) {
+	p := contractsPrefix(k)
+	if c.prefixes[p] == nil {
+		c.prefixes[p] = map[string]bool{}
+	}
+	c.prefixes[p][k] = true
+}
+
+// diffChanged updates entries in place with current records under prefixes and returns their diff
+func (c *contractsStateCache) diffChanged(prefixes [][]byte, recs []snapshotRecord) contractsDiff {
+	d := contractsDiff{Updated: []snapshotRecord{}, Deleted: [][]byte{}}
+	current := map[string]bool{}
+	for _, r := range recs {
+		k := string(r.Key)
+		current[k] = true
+		if pv, ok := c.entries[k]; !ok || string(pv) != string(r.Value) {
+			d.Updated = append(d.Updated, r)
+			c.entries[k] = r.Value
+			c.index(k)
+		}
+	}
+	for _, p := range prefixes {
+		for k := range c.prefixes[string(p)] {
+			if !current[k] {
+				d.Deleted = append(d.Deleted, []byte(k))
+				delete(c.entries, k)
+				delete(c.prefixes[string(p)], k)
+			}
+		}
+	}
+	d.sort()
+	return d
+}
+
+var (
+	contractsCache      = contractsStateCache{height: -1}
+	contractsCacheMutex sync.Mutex
+)
+
+func contractsKey(prefix [2]byte, height int64) []byte {
+	return append(prefix[:], common.GetByteInt64(height)...)
+}
+
+// changedContractsRecords returns prefixes of records of changed addresses and preimages together with
+// their current records, StateMutex has to be locked
+func changedContractsRecords(addrs map[[common.AddressLength]byte]bool, preimages map[common.Hash]bool) ([][]byte, []snapshotRecord) {
+	prefixes := [][]byte{}
+	recs := []snapshotRecord{}
+	for a := range addrs {
+		for _, p := range contractsAddressPrefixes {
+			prefixes = append(prefixes, snapshotKey(p, a[:]))
+		}
+		if acc, ok := State.Accounts[a]; ok {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotVMAccountKey, a[:]), acc.Marshal()})
+		}
+		if c, ok := State.Codes[a]; ok {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotVMCodeKey, a[:]), c})
+		}
+		if h, ok := State.CodeHashes[a]; ok {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotVMCodeHashKey, a[:]), h.GetBytes()})
+		}
+		if n, ok := State.Nonces[a]; ok {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotVMNonceKey, a[:]), common.GetByteInt64(int64(n))})
+		}
+		for k, v := range State.StatesHashes[a] {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotVMStorageKey, a[:], k.GetBytes()), v.GetBytes()})
+		}
+		for t, v := range State.Balances[a] {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotVMTokenBalance, a[:], t[:]), common.GetByteInt64(v)})
+		}
+		if ti, ok := State.Tokens[a]; ok {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotVMTokenKey, a[:]), tokenInfoBytes(ti)})
+		}
+	}
+	for h := range preimages {
+		k := snapshotKey(snapshotVMPreimageKey, h.GetBytes())
+		prefixes = append(prefixes, k)
+		if v, ok := State.States[h]; ok {
+			recs = append(recs, snapshotRecord{k, v})
+		}
+	}
+	return prefixes, recs
+}
+
+func contractsEntries() map[string][]byte {
+	entries := map[string][]byte{}
+	for _, r := range collectContractsRecords() {
+		entries[string(r.Key)] = r.Value
+	}
+	return entries
+}
+
+func entriesToRecords(entries map[string][]byte) []snapshotRecord {
+	recs := make([]snapshotRecord, 0, len(entries))
+	for k, v := range entries {
+		recs = append(recs, snapshotRecord{[]byte(k), v})
+	}
+	sortSnapshotRecords(recs)
+	return recs
+}
+
+func newContractsDiff(prev, curr map[string][]byte) contractsDiff {
+	d := contractsDiff{Updated: []snapshotRecord{}, Deleted: [][]byte{}}
+	for k, v := range curr {
+		if pv, ok := prev[k]; !ok || string(pv) != string(v) {
+			d.Updated = append(d.Updated, snapshotRecord{[]byte(k), v})
+		}
+	}
+	for k := range prev {
+		if _, ok := curr[k]; !ok {
+			d.Deleted = append(d.Deleted, []byte(k))
+		}
+	}
+	d.sort()
+	return d
+}
+
+func (d contractsDiff) sort() {
+	sortSnapshotRecords(d.Updated)
+	sort.Slice(d.Deleted, func(i, j int) bool {
+		return string(d.Deleted[i]) < string(d.Deleted[j])
+	})
+}
+
+func (d contractsDiff) apply(entries map[string][]byte) {
+	for _, k := range d.Deleted {
+		delete(entries, string(k))
+	}
+	for _, r := range d.Updated {
+		entries[string(r.Key)] = r.Value
+	}
+}
+
+func (d contractsDiff) Marshal() []byte {
+	b := common.GetByteInt64(int64(len(d.Updated)))
+	for _, r := range d.Updated {
+		b = append(b, common.BytesToLenAndBytes(r.Key)...)
+		b = append(b, common.BytesToLenAndBytes(r.Value)...)
+	}
+	for _, k := range d.Deleted {
+		b = append(b, common.BytesToLenAndBytes(k)...)
+	}
+	return b
+}
+
+func (d *contractsDiff) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return fmt.Errorf("not enough data to unmarshal contracts diff")
+	}
+	n := common.GetInt64FromByte(b[:8])
+	b = b[8:]
+	d.Updated = []snapshotRecord{}
+	d.Deleted = [][]byte{}
+	for i := int64(0); i < n; i++ {
+		k, left, err := common.BytesWithLenToBytes(b)
+		if err != nil {
+			return err
+		}
+		v, left, err := common.BytesWithLenToBytes(left)
+		if err != nil {
+			return err
+		}
+		d.Updated = append(d.Updated, snapshotRecord{k, v})
+		b = left
+	}
+	for len(b) > 0 {
+		k, left, err := common.BytesWithLenToBytes(b)
+		if err != nil {
+			return err
+		}
+		d.Deleted = append(d.Deleted, k)
+		b = left
+	}
+	return nil
+}
+
+// contractsDiffAt returns changes of contracts state made by block at height
+func contractsDiffAt(height int64) (contractsDiff, error) {
+	b, err := database.MainDB.Get(contractsKey(common.VMStateDiffDBPrefix, height))
+	if err != nil {
+		return contractsDiff{}, err
+	}
+	d := contractsDiff{}
+	err = d.Unmarshal(b)
+	if err != nil {
+		return contractsDiff{}, err
+	}
+	return d, nil
+}
+
+func removeContractsKeys(keys ...[]byte) error {
+	for _, k := range keys {
+		isKey, err := database.MainDB.IsKey(k)
+		if err != nil {
+			return err
+		}
+		if !isKey {
+			continue
+		}
+		err = database.MainDB.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadContractsEntries rebuilds contracts records from the nearest checkpoint and following diffs
+func loadContractsEntries(height int64) (map[string][]byte, error) {
+	c := height
+	for ; c >= common.GetBaseHeight(); c-- {
+		isKey, err := database.MainDB.IsKey(contractsKey(common.VMStateDBPrefix, c))
+		if err != nil {
+			return nil, err
+		}
+		if isKey {
+			break
+		}
+	}
+	if c < common.GetBaseHeight() {
+		return nil, fmt.Errorf("no contracts state checkpoint found below height %d", height)
+	}
+	b, err := database.MainDB.Get(contractsKey(common.VMStateDBPrefix, c))
+	if err != nil {
+		return nil, err
+	}
+	recs, err := parseSnapshotChunk(b)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string][]byte{}
+	for _, r := range recs {
+		entries[string(r.Key)] = r.Value
+	}
+	for h := c + 1; h <= height; h++ {
+		d, err := contractsDiffAt(h)
+		if err != nil {
+			return nil, fmt.Errorf("missing contracts diff at height %d: %w", h, err)
+		}
+		d.apply(entries)
+	}
+	return entries, nil
+}
+
+// StoreStateDB stores changes of contracts state made by block at height, except every
+// common.StateCheckpointInterval blocks when full contracts state is stored
+func StoreStateDB(height int64) error {
+	StateMutex.Lock()
+	addrs, preimages, all := State.TakeChangedState()
+	prefixes, recs := changedContractsRecords(addrs, preimages)
+	StateMutex.Unlock()
+	contractsCacheMutex.Lock()
+	defer contractsCacheMutex.Unlock()
+	if !account.IsStateCheckpointHeight(height) {
+		var d contractsDiff
+		var entries map[string][]byte
+		var err error
+		incremental := !all && contractsCache.height == height-1 && contractsCache.entries != nil
+		if incremental {
+			// only records of addresses changed since previous block are compared
+			d = contractsCache.diffChanged(prefixes, recs)
+		} else {
+			entries = contractsEntries()
+			prev := contractsCache.entries
+			if contractsCache.height != height-1 || prev == nil {
+				prev, err = loadContractsEntries(height - 1)
+			}
+			d = newContractsDiff(prev, entries)
+		}
+		if err == nil {
+			err = database.MainDB.Put(contractsKey(common.VMStateDiffDBPrefix, height), d.Marshal())
+			if err == nil {
+				err = removeContractsKeys(contractsKey(common.VMStateDBPrefix, height))
+			}
+			if err != nil {
+				// entries may already follow state which was not stored
+				contractsCache.set(-1, nil)
+				logger.GetLogger().Println("cannot store contracts state", err)
+				return err
+			}
+			if incremental {
+				contractsCache.height = height
+			} else {
+				contractsCache.set(height, entries)
+			}
+			return nil
+		}
+		logger.GetLogger().Println("no previous contracts state, checkpoint will be stored at height", height, err)
+	}
+	entries := contractsEntries()
+	b := []byte{}
+	for _, c := range chunkSnapshotRecords(entriesToRecords(entries)) {
+		b = append(b, c...)
+	}
+	err := database.MainDB.Put(contractsKey(common.VMStateDBPrefix, height), b)
+	if err != nil {
+		logger.GetLogger().Println("cannot store contracts state", err)
+		return err
+	}
+	err = removeContractsKeys(contractsKey(common.VMStateDiffDBPrefix, height))
+	if err != nil {
+		contractsCache.set(-1, nil)
+		return err
+	}
+	contractsCache.set(height, entries)
+	return nil
+}
+
+// LoadStateDB restores contracts state stored with block at height
+func LoadStateDB(height int64) error {
+	contractsCacheMutex.Lock()
+	defer contractsCacheMutex.Unlock()
+	entries, err := loadContractsEntries(height)
+	if err != nil {
+		return err
+	}
+	state := stateDB.CreateStateDB()
+	for _, r := range entriesToRecords(entries) {
+		err = restoreSnapshotRecord(r, nil, nil, nil, &state, nil)
+		if err != nil {
+			return err
+		}
+	}
+	StateMutex.Lock()
+	State = state
+	StateMutex.Unlock()
+	contractsCache.set(height, entries)
+	return nil
+}
+
+// RemoveStateDBFromDB removes contracts state stored with block at height
+func RemoveStateDBFromDB(height int64) error {
+	contractsCacheMutex.Lock()
+	if contractsCache.height >= height {
+		contractsCache.set(-1, nil)
+	}
+	contractsCacheMutex.Unlock()
+	InvalidateStateTrees(height)
+	return removeContractsKeys(contractsKey(common.VMStateDBPrefix, height), contractsKey(common.VMStateDiffDBPrefix, height))
+}
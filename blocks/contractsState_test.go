@@ -0,0 +1,50 @@
+package blocks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/stateDB"
+)
+
+func TestContractsDiffChanged(t *testing.T) {
+	contract := common.Address{ByteValue: [common.AddressLength]byte{1}}
+	other := common.Address{ByteValue: [common.AddressLength]byte{2}}
+	slot := func(b byte) common.Hash {
+		return common.Hash{b}
+	}
+
+	StateMutex.Lock()
+	State = stateDB.CreateStateDB()
+	State.CreateAccount(contract)
+	State.SetCode(contract, []byte{0x60, 0x00})
+	State.SetState(contract, slot(1), slot(10))
+	State.SetState(contract, slot(2), slot(20))
+	State.SetState(other, slot(1), slot(30))
+	State.SetCoinBalance(other, contract, 100)
+	State.TakeChangedState()
+	StateMutex.Unlock()
+
+	cache := contractsStateCache{}
+	cache.set(0, contractsEntries())
+	prev := contractsEntries()
+
+	StateMutex.Lock()
+	sn := State.Snapshot()
+	State.SetState(contract, slot(3), slot(40))
+	State.RevertToSnapshot(sn)
+	State.SetState(contract, slot(1), slot(11))
+	State.SetNonce(contract, 1)
+	State.SetCoinBalance(other, contract, 90)
+	State.AddPreimage(slot(5), []byte{5})
+	addrs, preimages, all := State.TakeChangedState()
+	prefixes, recs := changedContractsRecords(addrs, preimages)
+	StateMutex.Unlock()
+	assert.False(t, all)
+
+	// only changed addresses are compared, but diff is the same as of whole state
+	d := cache.diffChanged(prefixes, recs)
+	assert.Equal(t, newContractsDiff(prev, contractsEntries()), d)
+	assert.Equal(t, contractsEntries(), cache.entries)
+}
@@ -0,0 +1,389 @@
+package blocks
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/database"
+	dexAmm "github.com/wonabru/qwid-node/dex"
+	"github.com/wonabru/qwid-node/transactionsDefinition"
+)
+
+// OrderEvent is event of limit order as stored for block and returned over RPC.
+// Transaction hash is empty for orders filled or expired at the end of block.
+type OrderEvent struct {
+	Height  int64  `json:"height"`
+	TxHash  string `json:"tx_hash"`
+	Token   string `json:"token"`
+	Kind    string `json:"kind"`
+	ID      int64  `json:"id"`
+	Owner   string `json:"owner"`
+	Sell    bool   `json:"sell"`
+	Amount  int64  `json:"amount"`
+	Coin    int64  `json:"coin"`
+	Price   int64  `json:"price"`
+	Counter int64  `json:"counter"`
+	Refund  int64  `json:"refund"`
+}
+
+// OpenOrder is limit order resting in book as returned over RPC
+type OpenOrder struct {
+	ID     int64  `json:"id"`
+	Owner  string `json:"owner"`
+	Sell   bool   `json:"sell"`
+	Amount int64  `json:"amount"`
+	Price  int64  `json:"price"`
+	Escrow int64  `json:"escrow"`
+	Expiry int64  `json:"expiry"`
+}
+
+type BookLevel struct {
+	Price  int64 `json:"price"`
+	Amount int64 `json:"amount"`
+	Orders int   `json:"orders"`
+}
+
+// OrderBookView is depth of book of token with open orders of owner and events in range of heights
+type OrderBookView struct {
+	Token  string       `json:"token"`
+	Bids   []BookLevel  `json:"bids"`
+	Asks   []BookLevel  `json:"asks"`
+	Orders []OpenOrder  `json:"orders"`
+	Events []OrderEvent `json:"events"`
+}
+
+var eventKinds = map[int]string{
+	dexAmm.EventPlaced:    "placed",
+	dexAmm.EventFilled:    "filled",
+	dexAmm.EventCancelled: "cancelled",
+	dexAmm.EventExpired:   "expired",
+}
+
+// order events of block being evaluated
+var (
+	blockOrderEvents      []OrderEvent
+	blockOrderEventsMutex sync.Mutex
+)
+
+func recordOrderEvents(token common.Address, txHash string, height int64, events []dexAmm.Event) {
+	blockOrderEventsMutex.Lock()
+	defer blockOrderEventsMutex.Unlock()
+	for _, e := range events {
+		owner := common.Address{ByteValue: e.Owner}
+		blockOrderEvents = append(blockOrderEvents, OrderEvent{
+			Height:  height,
+			TxHash:  txHash,
+			Token:   token.GetHex(),
+			Kind:    eventKinds[e.Kind],
+			ID:      e.ID,
+			Owner:   owner.GetHex(),
+			Sell:    e.Sell,
+			Amount:  e.Amount,
+			Coin:    e.Coin,
+			Price:   e.Price,
+			Counter: e.Counter,
+			Refund:  e.Refund,
+		})
+	}
+}
+
+// takeOrderEvents returns order events recorded since the last call
+func takeOrderEvents() []OrderEvent {
+	blockOrderEventsMutex.Lock()
+	defer blockOrderEventsMutex.Unlock()
+	events := blockOrderEvents
+	blockOrderEvents = nil
+	return events
+}
+
+func tokenDecimals(token common.Address) (uint8, error) {
+	StateMutex.RLock()
+	ti, ok := State.Tokens[token.ByteValue]
+	StateMutex.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("no token with a given address %v", token.GetHex())
+	}
+	return ti.Decimals, nil
+}
+
+// payOrderEvents pays coins and tokens from DEX account to owners of orders
+func payOrderEvents(token common.Address, events []dexAmm.Event, tx transactionsDefinition.Transaction, bl Block) (string, error) {
+	dex := common.GetDexAccountAddress()
+	logs := ""
+	for _, e := range events {
+		coin, amount := e.Payout()
+		if coin > 0 {
+			err := AddBalance(dex.ByteValue, -coin)
+			if err != nil {
+				return "", err
+			}
+			err = AddBalance(e.Owner, coin)
+			if err != nil {
+				return "", err
+			}
+		}
+		if amount > 0 {
+			l, _, _, _, err := EvaluateSCDex(token, dex, dexTransferData(common.Address{ByteValue: e.Owner}, amount), tx, bl)
+			if err != nil {
+				return "", err
+			}
+			logs += l
+		}
+	}
+	return logs, nil
+}
+
+// EvaluateDexOrder places limit order of transaction, which locks coins or tokens of order on DEX account
+// and is matched with book and pool at once, or cancels order returning what is left in escrow.
+// Returns error wrapping dexAmm.ErrLimit or dexAmm.ErrNoOrder when order cannot be placed or cancelled.
+func EvaluateDexOrder(tx transactionsDefinition.Transaction, bl Block, operation int, height int64) (string, error) {
+	token := tx.ContractAddress
+	decimals, err := tokenDecimals(token)
+	if err != nil {
+		return "", err
+	}
+	sender := tx.TxParam.Sender
+	dex := common.GetDexAccountAddress()
+	accDex := account.GetDexAccountByAddressBytes(token.GetBytes())
+	var events []dexAmm.Event
+	logs := ""
+
+	if operation == dexAmm.OpCancelOrder {
+		id, err := dexAmm.CancelFromBytes(tx.TxData.OptData)
+		if err != nil {
+			return "", err
+		}
+		book, e, err := accDex.Book().Cancel(id, sender.ByteValue)
+		if err != nil {
+			return "", err
+		}
+		accDex.SetBook(book)
+		events = []dexAmm.Event{e}
+	} else {
+		pl, err := dexAmm.PlacementFromBytes(tx.TxData.OptData)
+		if err != nil {
+			return "", err
+		}
+		if pl.Expiry > 0 && height > pl.Expiry {
+			return "", fmt.Errorf("%w: order expired at height %v", dexAmm.ErrLimit, pl.Expiry)
+		}
+		o := dexAmm.LimitOrder{Owner: sender.ByteValue, Sell: pl.Sell, Amount: pl.Amount, Price: pl.Price, Expiry: pl.Expiry}
+		book, pool, es, err := accDex.Book().Place(accDex.Pool(), o, common.DexSwapFeeBasisPoints, common.DexMinOrderValue, decimals)
+		if err != nil {
+			return "", err
+		}
+		// escrow is taken before anything is paid for fills, it is checked before anything is moved,
+		// so order not covered by balance of sender fails alone
+		if pl.Sell {
+			balance, err := GetBalance(token, sender)
+			if err != nil {
+				return "", err
+			}
+			if balance < pl.Amount {
+				return "", fmt.Errorf("%w: not enough tokens in account", dexAmm.ErrLimit)
+			}
+			// failed transfer of tokens is reverted by EVM, so nothing is moved either
+			logs, _, _, _, err = EvaluateSCDex(token, sender, dexTransferData(dex, pl.Amount), tx, bl)
+			if err != nil {
+				return "", fmt.Errorf("%w: tokens not locked: %v", dexAmm.ErrLimit, err)
+			}
+		} else {
+			senderAccount, _ := account.GetAccountByAddressBytes(sender.GetBytes())
+			if es[0].Coin < 0 || senderAccount.Balance < es[0].Coin {
+				return "", fmt.Errorf("%w: not enough coins in account", dexAmm.ErrLimit)
+			}
+			err = AddBalance(sender.ByteValue, -es[0].Coin)
+			if err != nil {
+				return "", err
+			}
+			err = AddBalance(dex.ByteValue, es[0].Coin)
+			if err != nil {
+				return "", err
+			}
+		}
+		accDex.SetBook(book)
+		accDex.CoinPool, accDex.TokenPool = pool.Coin, pool.Token
+		accDex.TokenPrice = dexAmm.Price(pool.Coin, pool.Token, decimals)
+		events = es
+	}
+
+	l, err := payOrderEvents(token, events, tx, bl)
+	if err != nil {
+		return "", err
+	}
+	account.SetDexAccountByAddressBytes(token.GetBytes(), accDex)
+	recordOrderEvents(token, tx.Hash.GetHex(), height, events)
+	return logs + l, nil
+}
+
+// SettleDexBooks is done after all transactions of block: expired orders are returned to owners
+// and resting orders are filled with pools moved by trades, books in order of token addresses
+func SettleDexBooks(bl Block, height int64) error {
+	dex := common.GetDexAccountAddress()
+	tx := transactionsDefinition.Transaction{TxParam: transactionsDefinition.TxParam{Sender: dex}}
+	for _, t := range account.TokensWithOrders() {
+		token := common.Address{ByteValue: t}
+		decimals, err := tokenDecimals(token)
+		if err != nil {
+			return err
+		}
+		accDex := account.GetDexAccountByAddressBytes(t[:])
+		book, pool, events := accDex.Book().Settle(accDex.Pool(), height, common.DexSwapFeeBasisPoints, decimals)
+		if len(events) == 0 {
+			continue
+		}
+		_, err = payOrderEvents(token, events, tx, bl)
+		if err != nil {
+			return err
+		}
+		accDex.SetBook(book)
+		accDex.CoinPool, accDex.TokenPool = pool.Coin, pool.Token
+		accDex.TokenPrice = dexAmm.Price(pool.Coin, pool.Token, decimals)
+		account.SetDexAccountByAddressBytes(t[:], accDex)
+		recordOrderEvents(token, "", height, events)
+	}
+	return nil
+}
+
+func orderEventsKey(height int64) []byte {
+	return append(common.DexOrderEventsDBPrefix[:], common.GetByteInt64(height)...)
+}
+
+// orderEventsIndexKeys returns index keys of tokens found in events
+func orderEventsIndexKeys(events []OrderEvent, height int64) [][]byte {
+	seen := map[string]bool{}
+	keys := [][]byte{}
+	for _, e := range events {
+		if seen[e.Token] {
+			continue
+		}
+		seen[e.Token] = true
+		token, err := hex.DecodeString(e.Token)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, logsIndexKey(common.DexOrderEventsTokenIndexDBPrefix, token, height))
+	}
+	return keys
+}
+
+// StoreOrderEvents stores order events of block with index of tokens, events stored before at the same
+// height are removed first
+func StoreOrderEvents(height int64, events []OrderEvent) error {
+	err := RemoveOrderEvents(height)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	err = database.MainDB.Put(orderEventsKey(height), b)
+	if err != nil {
+		return err
+	}
+	for _, k := range orderEventsIndexKeys(events, height) {
+		err = database.MainDB.Put(k, []byte{1})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadOrderEvents returns order events of block at height
+func LoadOrderEvents(height int64) ([]OrderEvent, error) {
+	isKey, err := database.MainDB.IsKey(orderEventsKey(height))
+	if err != nil {
+		return nil, err
+	}
+	if !isKey {
+		return []OrderEvent{}, nil
+	}
+	b, err := database.MainDB.Get(orderEventsKey(height))
+	if err != nil {
+		return nil, err
+	}
+	events := []OrderEvent{}
+	err = json.Unmarshal(b, &events)
+	return events, err
+}
+
+func RemoveOrderEvents(height int64) error {
+	events, err := LoadOrderEvents(height)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	for _, k := range orderEventsIndexKeys(events, height) {
+		err = database.MainDB.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return database.MainDB.Delete(orderEventsKey(height))
+}
+
+// FilterOrderEvents returns events of orders of token between heights inclusive, only of owner when given
+func FilterOrderEvents(token common.Address, owner *common.Address, fromHeight, toHeight int64) ([]OrderEvent, error) {
+	heights, err := heightsFromIndex(common.DexOrderEventsTokenIndexDBPrefix, [][]byte{token.GetBytes()})
+	if err != nil {
+		return nil, err
+	}
+	hs := []int64{}
+	for h := range heights {
+		if h >= fromHeight && h <= toHeight {
+			hs = append(hs, h)
+		}
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i] < hs[j] })
+	events := []OrderEvent{}
+	for _, h := range hs {
+		es, err := LoadOrderEvents(h)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range es {
+			if e.Token != token.GetHex() || owner != nil && e.Owner != owner.GetHex() {
+				continue
+			}
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// GetOrderBook returns depth of book of token, open orders and events of owner (all when owner is nil)
+// between heights
+func GetOrderBook(token common.Address, owner *common.Address, fromHeight, toHeight int64) (OrderBookView, error) {
+	book := account.GetDexAccountByAddressBytes(token.GetBytes()).Book()
+	v := OrderBookView{Token: token.GetHex(), Bids: []BookLevel{}, Asks: []BookLevel{}, Orders: []OpenOrder{}}
+	bids, asks := book.Depth()
+	for _, l := range bids {
+		v.Bids = append(v.Bids, BookLevel{Price: l.Price, Amount: l.Amount, Orders: l.Orders})
+	}
+	for _, l := range asks {
+		v.Asks = append(v.Asks, BookLevel{Price: l.Price, Amount: l.Amount, Orders: l.Orders})
+	}
+	orders := book.Orders
+	if owner != nil {
+		orders = book.OwnerOrders(owner.ByteValue)
+	}
+	for _, o := range orders {
+		a := common.Address{ByteValue: o.Owner}
+		v.Orders = append(v.Orders, OpenOrder{ID: o.ID, Owner: a.GetHex(), Sell: o.Sell, Amount: o.Amount, Price: o.Price, Escrow: o.Escrow, Expiry: o.Expiry})
+	}
+	var err error
+	v.Events, err = FilterOrderEvents(token, owner, fromHeight, toHeight)
+	return v, err
+}
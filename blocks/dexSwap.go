@@ -0,0 +1,137 @@
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/stateDB"
+	dexAmm "github.com/wonabru/qwid-node/dex"
+	"github.com/wonabru/qwid-node/transactionsDefinition"
+)
+
+// SwapHop is one trade of DEX swap as quoted over RPC
+type SwapHop struct {
+	Token       string `json:"token"`
+	Sell        bool   `json:"sell"` // token is sold for coin, otherwise coin buys token
+	AmountIn    int64  `json:"amount_in"`
+	AmountOut   int64  `json:"amount_out"`
+	PriceImpact int64  `json:"price_impact_basis_points"`
+}
+
+// SwapQuote is expected result of DEX swap on current pools
+type SwapQuote struct {
+	Hops      []SwapHop `json:"hops"`
+	AmountOut int64     `json:"amount_out"`
+}
+
+// dexTransferData is input of token contract transferring amount to recipient
+func dexTransferData(recipient common.Address, amount int64) []byte {
+	b := append([]byte{}, stateDB.TransferFunc...)
+	b = append(b, common.LeftPadBytes(recipient.GetBytes(), 32)...)
+	return append(b, common.LeftPadBytes(common.GetInt64ToBytesSC(amount), 32)...)
+}
+
+// routeDexSwap computes swap of first token along path on current pools
+func routeDexSwap(first common.Address, s dexAmm.Swap) ([]common.Address, []account.DexAccount, []dexAmm.Hop, int64, error) {
+	tokens := []common.Address{first}
+	for _, p := range s.Path {
+		if bytes.Equal(p[:], first.GetBytes()) {
+			return nil, nil, nil, 0, fmt.Errorf("token repeats in path of swap")
+		}
+		tokens = append(tokens, common.Address{ByteValue: p})
+	}
+	accs := make([]account.DexAccount, len(tokens))
+	pools := make([]dexAmm.Pool, len(tokens))
+	decimals := make([]uint8, len(tokens))
+	for i, t := range tokens {
+		StateMutex.RLock()
+		ti, ok := State.Tokens[t.ByteValue]
+		StateMutex.RUnlock()
+		if !ok {
+			return nil, nil, nil, 0, fmt.Errorf("no token with a given address %v", t.GetHex())
+		}
+		accs[i] = account.GetDexAccountByAddressBytes(t.GetBytes())
+		pools[i] = accs[i].Pool()
+		decimals[i] = ti.Decimals
+	}
+	hops, out, err := dexAmm.Route(pools, s.Order.Amount, common.DexSwapFeeBasisPoints, decimals)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	return tokens, accs, hops, out, nil
+}
+
+// QuoteDexSwap returns expected output and price impact of every hop of swap of first token
+func QuoteDexSwap(first common.Address, s dexAmm.Swap) (SwapQuote, error) {
+	tokens, _, hops, out, err := routeDexSwap(first, s)
+	if err != nil {
+		return SwapQuote{}, err
+	}
+	q := SwapQuote{AmountOut: out}
+	for _, h := range hops {
+		q.Hops = append(q.Hops, SwapHop{
+			Token:       tokens[h.Pool].GetHex(),
+			Sell:        h.Quote.Token < 0,
+			AmountIn:    h.AmountIn,
+			AmountOut:   h.AmountOut,
+			PriceImpact: h.PriceImpact,
+		})
+	}
+	return q, nil
+}
+
+// EvaluateDexSwap swaps token of transaction through coin to the last token of path in one transaction.
+// Coins between hops stay on dex account, so only the first and the last token are transferred.
+// Returns error wrapping dexAmm.ErrLimit when swap cannot be routed, is out of its bounds or is not covered by balances.
+func EvaluateDexSwap(tx transactionsDefinition.Transaction, bl Block, height int64) (string, error) {
+	s, err := dexAmm.SwapFromBytes(tx.TxData.OptData)
+	if err != nil {
+		return "", err
+	}
+	tokens, accs, hops, out, err := routeDexSwap(tx.ContractAddress, s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", dexAmm.ErrLimit, err)
+	}
+	err = s.Order.Check(dexAmm.OpSwap, dexAmm.Quote{Token: out}, height)
+	if err != nil {
+		return "", err
+	}
+	sender := tx.TxParam.Sender
+	dex := common.GetDexAccountAddress()
+	last := tokens[len(tokens)-1]
+	balance, err := GetBalance(tokens[0], sender)
+	if err != nil {
+		return "", err
+	}
+	if balance < s.Order.Amount {
+		return "", fmt.Errorf("%w: not enough tokens in account", dexAmm.ErrLimit)
+	}
+	balanceDex, err := GetBalance(last, dex)
+	if err != nil {
+		return "", err
+	}
+	if balanceDex < out {
+		return "", fmt.Errorf("%w: not enough tokens in dex account", dexAmm.ErrLimit)
+	}
+
+	l, _, _, _, err := EvaluateSCDex(tokens[0], sender, dexTransferData(dex, s.Order.Amount), tx, bl)
+	if err != nil {
+		return "", err
+	}
+	l2, _, _, _, err := EvaluateSCDex(last, dex, dexTransferData(sender, out), tx, bl)
+	if err != nil {
+		return "", err
+	}
+	for _, h := range hops {
+		acc := &accs[h.Pool]
+		acc.CoinPool -= h.Quote.Coin
+		acc.TokenPool -= h.Quote.Token
+		acc.TokenPrice = h.Quote.Price
+	}
+	for i, t := range tokens {
+		account.SetDexAccountByAddressBytes(t.GetBytes(), accs[i])
+	}
+	return l + l2, nil
+}
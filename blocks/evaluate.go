@@ -3,15 +3,17 @@ package blocks
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/wonabru/qwid-node/account"
 	"github.com/wonabru/qwid-node/common"
 	vm "github.com/wonabru/qwid-node/core/evm"
 	"github.com/wonabru/qwid-node/core/stateDB"
+	dexAmm "github.com/wonabru/qwid-node/dex"
 	loggerMain "github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/params"
 	"github.com/wonabru/qwid-node/transactionsDefinition"
-	"math"
+	"maps"
 	"math/big"
 	"sync"
 )
@@ -32,22 +34,25 @@ func InitStateDB() {
 	State = stateDB.CreateStateDB()
 }
 
-func GenerateOptDataDEX(tx transactionsDefinition.Transaction, operation int) ([]byte, common.Address, int64, int64, float64, error) {
+// GenerateOptDataDEX computes DEX operation of transaction at given height. Returns error wrapping dexAmm.ErrLimit
+// when order is out of its bounds.
+func GenerateOptDataDEX(tx transactionsDefinition.Transaction, operation int, height int64) ([]byte, common.Address, dexAmm.Quote, error) {
 	// 2 - adding liquidity, 3 - buy trade, 4 -sell trade, 5 - withdraw token, 6 - withdraw KURA (5,6 inactive, just withdraw is selling opposite)
-	amountToken := common.GetInt64FromByte(tx.TxData.OptData)
+	order, err := dexAmm.OrderFromBytes(tx.TxData.OptData)
+	if err != nil {
+		return nil, common.Address{}, dexAmm.Quote{}, err
+	}
+	amountToken := order.Amount
 	sender := tx.TxParam.Sender
 	tokenAddress := tx.ContractAddress
 	if operation == 2 && (tx.TxData.Amount < 0 || amountToken < 0) || (operation == 3 || operation == 4) && (amountToken == 0) || operation == 5 && amountToken == 0 || operation == 6 && tx.TxData.Amount == 0 {
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("withdraw one can perform on one currency the second should be 0")
+		return nil, common.Address{}, dexAmm.Quote{}, fmt.Errorf("withdraw one can perform on one currency the second should be 0")
 	}
 
 	accDex := account.GetDexAccountByAddressBytes(tokenAddress.GetBytes())
-	poolPrice := float64(0)
-	price := 0.0
-	var amountCoinInt64, amountTokenInt64 int64
 	balanceToken, err := GetBalance(tx.ContractAddress, sender)
 	if err != nil {
-		return nil, common.Address{}, 0, 0, 0, err
+		return nil, common.Address{}, dexAmm.Quote{}, err
 	}
 	ba := [common.AddressLength]byte{}
 	copy(ba[:], tx.ContractAddress.GetBytes())
@@ -55,94 +60,58 @@ func GenerateOptDataDEX(tx transactionsDefinition.Transaction, operation int) ([
 	ti, ok := State.Tokens[ba]
 	StateMutex.RUnlock()
 	if !ok {
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("no token with a given address")
-	}
-
-	tokenPoolAmount := account.Int64toFloat64ByDecimals(accDex.TokenPool, ti.Decimals)
-	coinPoolAmount := account.Int64toFloat64(accDex.CoinPool)
-	amountTokenFloat := account.Int64toFloat64ByDecimals(amountToken, ti.Decimals)
-	amountCoinFloat := account.Int64toFloat64ByDecimals(tx.TxData.Amount, common.Decimals)
-
-	if coinPoolAmount > 0 && tokenPoolAmount > 0 {
-		poolPrice = common.RoundToken(coinPoolAmount/tokenPoolAmount, int(common.Decimals+ti.Decimals))
+		return nil, common.Address{}, dexAmm.Quote{}, fmt.Errorf("no token with a given address")
 	}
 
 	// dex account where all tokens liquidity are stored
 	dex := common.GetDexAccountAddress()
 
-	switch operation {
-	case 2: // add liquidity
-		amountCoinInt64 = int64(-amountCoinFloat * math.Pow10(int(common.Decimals)))
-		amountTokenInt64 = int64(-amountTokenFloat * math.Pow10(int(ti.Decimals)))
-		price = common.RoundToken(amountCoinFloat/amountTokenFloat, int(common.Decimals+ti.Decimals))
-	case 5: // withdraw token
-		if poolPrice > 0 {
-			price = poolPrice
-			amount := common.RoundCoin(poolPrice * amountTokenFloat)
-			amountCoinInt64 = int64(amount * math.Pow10(int(common.Decimals)))
-			amountTokenInt64 = int64(amountTokenFloat * math.Pow10(int(ti.Decimals)))
-		}
-	case 6: // withdraw Coin
-		if poolPrice > 0 {
-			price = poolPrice
-			amount := common.RoundToken(1.0/poolPrice*amountCoinFloat, int(ti.Decimals))
-			amountTokenInt64 = int64(amount * math.Pow10(int(ti.Decimals)))
-			amountCoinInt64 = int64(amountCoinFloat * math.Pow10(int(common.Decimals)))
-		}
-	case 3: //buy
-		if coinPoolAmount > 0 && tokenPoolAmount-2*amountTokenFloat > 0 {
-			price = common.RoundToken(coinPoolAmount/(tokenPoolAmount-2*amountTokenFloat), int(common.Decimals+ti.Decimals))
-		}
-		if price > 0 {
-			amount := common.RoundCoin(-price * amountTokenFloat)
-			amountCoinInt64 = int64(amount * math.Pow10(int(common.Decimals)))
-			amountTokenInt64 = int64(amountTokenFloat * math.Pow10(int(ti.Decimals)))
-		}
-	case 4: //sell
-		amountTokenFloat *= -1
-		if coinPoolAmount > 0 && tokenPoolAmount-2*amountTokenFloat > 0 {
-			price = common.RoundToken(coinPoolAmount/(tokenPoolAmount-2*amountTokenFloat), int(common.Decimals+ti.Decimals))
-		}
-		if price > 0 {
-			amount := common.RoundCoin(-price * amountTokenFloat)
-			amountCoinInt64 = int64(amount * math.Pow10(int(common.Decimals)))
-			amountTokenInt64 = int64(amountTokenFloat * math.Pow10(int(ti.Decimals)))
-		}
-	default:
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("wrong operation on dex")
+	// amounts are computed with integers only, floats could give different results on different nodes.
+	// Older blocks were computed with floats and are replayed the same way.
+	var q dexAmm.Quote
+	if height < common.DexForkHeight {
+		q, err = dexAmm.LegacyQuote(accDex.Pool(), operation, tx.TxData.Amount, amountToken, common.Decimals, ti.Decimals)
+	} else {
+		q, err = dexAmm.QuoteOperation(accDex.Pool(), operation, tx.TxData.Amount, amountToken, common.DexSwapFeeBasisPoints, ti.Decimals)
 	}
+	if err != nil {
+		return nil, common.Address{}, dexAmm.Quote{}, err
+	}
+	// bounds of order protect trader against price moved before block, e.g. by sandwiching trades
+	err = order.Check(operation, q, height)
+	if err != nil {
+		return nil, common.Address{}, dexAmm.Quote{}, err
+	}
+	amountCoinInt64, amountTokenInt64 := q.Coin, q.Token
 
 	senderAccount, exist := account.GetAccountByAddressBytes(tx.TxParam.Sender.GetBytes())
 	if !exist || !bytes.Equal(senderAccount.Address[:], tx.TxParam.Sender.GetBytes()) {
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("no account found in dex transfer")
+		return nil, common.Address{}, dexAmm.Quote{}, fmt.Errorf("no account found in dex transfer")
 	}
 
 	dexAccount := account.SetAccountByAddressBytes(dex.GetBytes())
 
 	if dexAccount.Balance-amountCoinInt64 < 0 {
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("not enough coins in dex account")
+		return nil, common.Address{}, dexAmm.Quote{}, fmt.Errorf("not enough coins in dex account")
 	}
 	balanceDexToken, err := GetBalance(tx.ContractAddress, dex)
 	if err != nil {
-		return nil, common.Address{}, 0, 0, 0, err
+		return nil, common.Address{}, dexAmm.Quote{}, err
 	}
 
 	if balanceDexToken-amountTokenInt64 < 0 {
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("not enough tokens in dex account")
+		return nil, common.Address{}, dexAmm.Quote{}, fmt.Errorf("not enough tokens in dex account")
 	}
 
 	if senderAccount.Balance+amountCoinInt64 < 0 {
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("not enough coins in account")
+		return nil, common.Address{}, dexAmm.Quote{}, fmt.Errorf("not enough coins in account")
 	}
 	if balanceToken+amountTokenInt64 < 0 {
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("not enough tokens in account")
+		return nil, common.Address{}, dexAmm.Quote{}, fmt.Errorf("not enough tokens in account")
 	}
 
-	if accDex.Balances[senderAccount.Address].CoinBalance-amountCoinInt64 < 0 && (operation == 6 || operation == 5) {
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("not enough coins in dex account")
-	}
-	if accDex.Balances[senderAccount.Address].TokenBalance-amountTokenInt64 < 0 && (operation == 6 || operation == 5) {
-		return nil, common.Address{}, 0, 0, 0, fmt.Errorf("not enough tokens in dex account")
+	if accDex.Shares[senderAccount.Address] < -q.Shares {
+		return nil, common.Address{}, dexAmm.Quote{}, fmt.Errorf("not enough liquidity shares in dex account")
 	}
 
 	var fromAccountAddress common.Address
@@ -165,7 +134,7 @@ func GenerateOptDataDEX(tx transactionsDefinition.Transaction, operation int) ([
 	}
 
 	loggerMain.GetLogger().Println(optData)
-	return common.Hex2Bytes(optData), fromAccountAddress, amountCoinInt64, amountTokenInt64, price, nil
+	return common.Hex2Bytes(optData), fromAccountAddress, q, nil
 }
 
 func EvaluateSCForBlock(bl Block) (bool, map[[common.HashLength]byte]string, map[[common.HashLength]byte]common.Address, map[[common.AddressLength]byte][]byte, map[[common.HashLength]byte][]byte) {
@@ -174,7 +143,11 @@ func EvaluateSCForBlock(bl Block) (bool, map[[common.HashLength]byte]string, map
 	rets := map[[common.HashLength]byte][]byte{}
 	height := bl.GetHeader().Height
 	optDatas := map[[common.AddressLength]byte][]byte{}
-	for _, th := range bl.GetBlockTransactionsHashes() {
+	StateMutex.Lock()
+	State.TakeLogs()
+	StateMutex.Unlock()
+	takeOrderEvents()
+	for i, th := range bl.GetBlockTransactionsHashes() {
 		poolprefix := common.TransactionPoolHashesDBPrefix[:]
 		t, err := transactionsDefinition.LoadFromDBPoolTx(poolprefix, th.GetBytes())
 		if err != nil {
@@ -191,22 +164,64 @@ func EvaluateSCForBlock(bl Block) (bool, map[[common.HashLength]byte]string, map
 			loggerMain.GetLogger().Println("no account exist with this address")
 			continue
 		}
-		if senderAcc.TransactionDelay > 0 && t.GetHeight()+senderAcc.TransactionDelay > height {
-			//TODO escrow does not execute SC
-			continue
-
-		} else if senderAcc.MultiSignNumber > 0 {
-			//TODO MultiSignNumber does not execute SC
+		if !senderExecutesSC(t, senderAcc, height) {
+			//TODO escrow and MultiSignNumber do not execute SC
 			continue
 		}
 
+		StateMutex.Lock()
+		State.SetTxContext(t.Hash, i)
+		StateMutex.Unlock()
+
 		addressRecipient := t.TxData.Recipient
 		n, err := account.IntDelegatedAccountFromAddress(addressRecipient)
-		if err == nil && n > 512 { // 514 == operation 2 etc...
+		if err == nil && n > 512 && n != int(common.SlashingAccountID) { // 514 == operation 2 etc...
 			operation := n - 512
+			if operation == dexAmm.OpSwap || operation == dexAmm.OpPlaceOrder || operation == dexAmm.OpCancelOrder {
+				var l string
+				if operation == dexAmm.OpSwap {
+					l, err = EvaluateDexSwap(t, bl, height)
+				} else {
+					l, err = EvaluateDexOrder(t, bl, operation, height)
+				}
+				if err != nil && !errors.Is(err, dexAmm.ErrLimit) && !errors.Is(err, dexAmm.ErrNoOrder) {
+					loggerMain.GetLogger().Println(err)
+					return false, nil, nil, nil, nil
+				}
+				failed := err != nil
+				if failed {
+					// nothing is transferred, sender pays only fee and block stays valid
+					loggerMain.GetLogger().Println(err)
+					l = err.Error()
+				}
+				t.OutputLogs = []byte(l)
+				err = t.StoreToDBPoolTx(poolprefix)
+				if err == nil {
+					err = storeTransactionFailed(t.Hash.GetBytes(), failed)
+				}
+				if err != nil {
+					loggerMain.GetLogger().Println(err)
+					return false, logs, map[[common.HashLength]byte]common.Address{}, map[[common.AddressLength]byte][]byte{}, map[[common.HashLength]byte][]byte{}
+				}
+				continue
+			}
 			//DEX checking transaction
-			dexOptData, fromAddress, coinAmount, tokenAmount, price, err := GenerateOptDataDEX(t, operation)
-			loggerMain.GetLogger().Printf("Token Price: %v\n", price)
+			dexOptData, fromAddress, q, err := GenerateOptDataDEX(t, operation, height)
+			loggerMain.GetLogger().Printf("Token Price: %v\n", q.Price)
+			if errors.Is(err, dexAmm.ErrLimit) {
+				// nothing is transferred, sender pays only fee and block stays valid
+				loggerMain.GetLogger().Println(err)
+				t.OutputLogs = []byte(err.Error())
+				err = t.StoreToDBPoolTx(poolprefix)
+				if err == nil {
+					err = storeTransactionFailed(t.Hash.GetBytes(), true)
+				}
+				if err != nil {
+					loggerMain.GetLogger().Println(err)
+					return false, logs, map[[common.HashLength]byte]common.Address{}, map[[common.AddressLength]byte][]byte{}, map[[common.HashLength]byte][]byte{}
+				}
+				continue
+			}
 			if err != nil {
 				loggerMain.GetLogger().Println(err)
 				return false, nil, nil, nil, nil
@@ -219,6 +234,9 @@ func EvaluateSCForBlock(bl Block) (bool, map[[common.HashLength]byte]string, map
 			}
 			t.OutputLogs = []byte(l)
 			err = t.StoreToDBPoolTx(poolprefix)
+			if err == nil {
+				err = storeTransactionFailed(t.Hash.GetBytes(), false)
+			}
 			if err != nil {
 				loggerMain.GetLogger().Println(err)
 				return false, logs, map[[common.HashLength]byte]common.Address{}, map[[common.AddressLength]byte][]byte{}, map[[common.HashLength]byte][]byte{}
@@ -230,12 +248,12 @@ func EvaluateSCForBlock(bl Block) (bool, map[[common.HashLength]byte]string, map
 			copy(da[:], dex.GetBytes())
 			// transfering coins KURA
 
-			err = AddBalance(aa, coinAmount)
+			err = AddBalance(aa, q.Coin)
 			if err != nil {
 				loggerMain.GetLogger().Println(err)
 				return false, nil, nil, nil, nil
 			}
-			err = AddBalance(da, -coinAmount)
+			err = AddBalance(da, -q.Coin)
 			if err != nil {
 				loggerMain.GetLogger().Println(err)
 				return false, nil, nil, nil, nil
@@ -253,33 +271,19 @@ func EvaluateSCForBlock(bl Block) (bool, map[[common.HashLength]byte]string, map
 
 			accDex := account.GetDexAccountByAddressBytes(t.ContractAddress.GetBytes())
 
-			accDex.TokenPrice = int64(price * math.Pow10(int(common.Decimals+ti.Decimals)))
-
-			if operation == 2 || operation > 4 { // no sell or buy
-				balances := accDex.Balances
-				if balances == nil {
-					balances = make(map[[common.AddressLength]byte]account.CoinTokenDetails)
-				}
-				coinAmountTmp := accDex.Balances[aa].CoinBalance - coinAmount
-				tokenAmountTmp := accDex.Balances[aa].TokenBalance - tokenAmount
-				balances[aa] = account.CoinTokenDetails{
-					CoinBalance:  coinAmountTmp,
-					TokenBalance: tokenAmountTmp,
-				}
-				accDex.Balances = balances
+			accDex.TokenPrice = q.Price
+			if height < common.DexForkHeight {
+				accDex.ApplyLegacyQuote(aa, operation, q, ti.Decimals)
 			} else {
-				coinPercentTmp := float64(-coinAmount) / float64(accDex.CoinPool)
-				tokenPercentTmp := float64(-tokenAmount) / float64(accDex.TokenPool)
-
-				for addr, acc := range accDex.Balances {
-					balances := accDex.Balances[addr]
-					balances.TokenBalance += int64(common.RoundToken(tokenPercentTmp*float64(acc.TokenBalance), int(ti.Decimals)))
-					balances.CoinBalance += int64(common.RoundToken(coinPercentTmp*float64(acc.CoinBalance), int(common.Decimals)))
-					accDex.Balances[addr] = balances
+				// fees of trades stay in pool, so value of every share grows without touching providers
+				err = accDex.AddShares(aa, q.Shares)
+				if err != nil {
+					loggerMain.GetLogger().Println(err)
+					return false, nil, nil, nil, nil
 				}
 			}
-			accDex.TokenPool += -tokenAmount
-			accDex.CoinPool += -coinAmount
+			accDex.TokenPool -= q.Token
+			accDex.CoinPool -= q.Coin
 			account.SetDexAccountByAddressBytes(t.ContractAddress.GetBytes(), accDex)
 
 			continue
@@ -329,6 +333,9 @@ func EvaluateSCForBlock(bl Block) (bool, map[[common.HashLength]byte]string, map
 
 		t.OutputLogs = outputLogs[:]
 		err = t.StoreToDBPoolTx(poolprefix)
+		if err == nil {
+			err = storeTransactionFailed(t.Hash.GetBytes(), false)
+		}
 		if err != nil {
 			loggerMain.GetLogger().Println(err)
 			return false, logs, map[[common.HashLength]byte]common.Address{}, map[[common.AddressLength]byte][]byte{}, map[[common.HashLength]byte][]byte{}
@@ -342,9 +349,37 @@ func EvaluateSCForBlock(bl Block) (bool, map[[common.HashLength]byte]string, map
 		copy(aa[:], address.GetBytes()[:])
 		optDatas[aa] = t.TxData.OptData
 	}
+	// limit orders crossing pools moved in block are filled after all transactions
+	err := SettleDexBooks(bl, height)
+	if err != nil {
+		loggerMain.GetLogger().Println(err)
+		return false, nil, nil, nil, nil
+	}
 	return true, logs, addresses, optDatas, rets
 }
 
+// NewBlockContext builds EVM block context only from data of the evaluated block, so proposer,
+// validators and nodes replaying the chain during sync see the same TIMESTAMP, COINBASE and PREVRANDAO.
+func NewBlockContext(bl Block) vm.BlockContext {
+	header := bl.GetHeader()
+	random := common.BytesToHash(new(big.Int).SetUint64(uint64(bl.BaseBlock.RandOracle)).Bytes())
+	return vm.BlockContext{
+		CanTransfer: CanTransfer,
+		Transfer:    Transfer,
+		GetHash: func(height uint64) common.Hash {
+			hashBytes, _ := LoadHashOfBlock(int64(height))
+			return common.BytesToHash(hashBytes)
+		},
+		Coinbase:    header.OperatorAccount,
+		GasLimit:    uint64(common.MaxGasUsage),
+		BlockNumber: new(big.Int).SetInt64(header.Height),
+		Time:        new(big.Int).SetInt64(bl.GetBlockTimeStamp()),
+		Difficulty:  new(big.Int).SetInt64(int64(header.Difficulty)),
+		BaseFee:     new(big.Int).SetInt64(common.BaseFeePerGas),
+		Random:      &random,
+	}
+}
+
 func EvaluateSC(tx transactionsDefinition.Transaction, bl Block) (logs string, ret []byte, address common.Address, leftOverGas uint64, err error) {
 	if len(tx.TxData.OptData) == 0 {
 		loggerMain.GetLogger().Println("no smart contract in transaction")
@@ -354,21 +389,7 @@ func EvaluateSC(tx transactionsDefinition.Transaction, bl Block) (logs string, r
 
 	origin := tx.TxParam.Sender
 	code := tx.TxData.OptData
-	blockCtx := vm.BlockContext{
-		CanTransfer: nil,
-		Transfer:    nil,
-		GetHash: func(height uint64) common.Hash {
-			hashBytes, _ := LoadHashOfBlock(int64(height))
-			return common.BytesToHash(hashBytes)
-		},
-		Coinbase:    common.EmptyAddress(),
-		GasLimit:    uint64(common.MaxGasUsage) * uint64(gasMult),
-		BlockNumber: new(big.Int).SetInt64(bl.GetHeader().Height),
-		Time:        new(big.Int).SetInt64(common.GetCurrentTimeStampInSecond()),
-		Difficulty:  new(big.Int).SetInt64(int64(bl.GetHeader().Difficulty)),
-		BaseFee:     new(big.Int).SetInt64(int64(0)),
-		Random:      nil,
-	}
+	blockCtx := NewBlockContext(bl)
 	logger := vm.CreateGVMLogger()
 	jumpTable := vm.GetGenericJumpTable()
 
@@ -386,6 +407,7 @@ func EvaluateSC(tx transactionsDefinition.Transaction, bl Block) (logs string, r
 	}
 	StateMutex.Lock()
 	defer StateMutex.Unlock()
+	defer State.ClearBalanceJournal()
 
 	VM = vm.NewEVM(blockCtx, txCtx, &State, params.AllEthashProtocolChanges, configCtx)
 	defer VM.Cancel()
@@ -393,9 +415,10 @@ func EvaluateSC(tx transactionsDefinition.Transaction, bl Block) (logs string, r
 	VM.Origin = origin
 	VM.GasPrice = new(big.Int).SetInt64(0)
 	nonce := uint64(tx.TxParam.Nonce)
+	value := new(big.Int).SetInt64(tx.TxData.Amount)
 
 	if tx.TxData.Recipient == common.EmptyAddress() {
-		ret, address, leftOverGas, err = VM.Create(vm.AccountRef(origin), code, uint64(tx.GasUsage)*uint64(gasMult), new(big.Int).SetInt64(0), nonce)
+		ret, address, leftOverGas, err = VM.Create(vm.AccountRef(origin), code, uint64(tx.GasUsage)*uint64(gasMult), value, nonce)
 
 		if err != nil {
 			loggerMain.GetLogger().Println(err)
@@ -403,7 +426,7 @@ func EvaluateSC(tx transactionsDefinition.Transaction, bl Block) (logs string, r
 		}
 	} else {
 		address = tx.TxData.Recipient
-		ret, leftOverGas, err = VM.Call(vm.AccountRef(origin), address, code, uint64(tx.GasUsage)*uint64(gasMult), new(big.Int).SetInt64(0))
+		ret, leftOverGas, err = VM.Call(vm.AccountRef(origin), address, code, uint64(tx.GasUsage)*uint64(gasMult), value)
 		if err != nil {
 			loggerMain.GetLogger().Println(err)
 			return logger.ToString(), ret, address, leftOverGas, err
@@ -413,25 +436,46 @@ func EvaluateSC(tx transactionsDefinition.Transaction, bl Block) (logs string, r
 	return logger.ToString(), ret, address, uint64(float64(leftOverGas) / gasMult), nil
 }
 
+// CanTransfer checks whether there are enough native coins on the account to transfer amount in EVM.
+// When it fails, EVM fails the call with insufficient balance.
+func CanTransfer(db vm.StateDB, addr common.Address, amount *big.Int) bool {
+	return amount.Sign() >= 0 && db.GetBalance(addr).Cmp(amount) >= 0
+}
+
+// Transfer moves native coins between accounts in EVM. It fails when sender cannot cover amount
+// or balance of recipient would overflow, so the call fails and coins are never credited without being debited.
+func Transfer(db vm.StateDB, sender, recipient common.Address, amount *big.Int) error {
+	if !CanTransfer(db, sender, amount) {
+		return vm.ErrInsufficientBalance
+	}
+	if !new(big.Int).Add(db.GetBalance(recipient), amount).IsInt64() {
+		return fmt.Errorf("balance of %v overflows in EVM transfer", recipient.GetHex())
+	}
+	db.SubBalance(sender, amount)
+	db.AddBalance(recipient, amount)
+	return nil
+}
+
+// senderExecutesSC reports whether smart contracts of transaction are run in block at height.
+// Escrowed transactions and transactions of multisig accounts do not run them.
+func senderExecutesSC(tx transactionsDefinition.Transaction, senderAcc account.Account, height int64) bool {
+	if senderAcc.TransactionDelay > 0 && tx.GetHeight()+senderAcc.TransactionDelay > height {
+		return false
+	}
+	return senderAcc.MultiSignNumber == 0
+}
+
+// EVMTransfersAmount reports whether amount of transaction is moved by EVM as call value
+// instead of native transfer, so when EvaluateSCForBlock runs EVM for it.
+func EVMTransfersAmount(tx transactionsDefinition.Transaction, senderAcc account.Account, height int64) bool {
+	return len(tx.TxData.OptData) > 0 && senderExecutesSC(tx, senderAcc, height)
+}
+
 func EvaluateSCDex(tokenAddress common.Address, sender common.Address, optData []byte, tx transactionsDefinition.Transaction, bl Block) (logs string, ret []byte, address common.Address, leftOverGas uint64, err error) {
 
 	gasMult := 10.0
 
-	blockCtx := vm.BlockContext{
-		CanTransfer: nil,
-		Transfer:    nil,
-		GetHash: func(height uint64) common.Hash {
-			hashBytes, _ := LoadHashOfBlock(int64(height))
-			return common.BytesToHash(hashBytes)
-		},
-		Coinbase:    common.EmptyAddress(),
-		GasLimit:    uint64(common.MaxGasUsage) * uint64(gasMult),
-		BlockNumber: new(big.Int).SetInt64(bl.GetHeader().Height),
-		Time:        new(big.Int).SetInt64(common.GetCurrentTimeStampInSecond()),
-		Difficulty:  new(big.Int).SetInt64(int64(bl.GetHeader().Difficulty)),
-		BaseFee:     new(big.Int).SetInt64(int64(0)),
-		Random:      nil,
-	}
+	blockCtx := NewBlockContext(bl)
 	logger := vm.CreateGVMLogger()
 	jumpTable := vm.GetGenericJumpTable()
 
@@ -449,6 +493,7 @@ func EvaluateSCDex(tokenAddress common.Address, sender common.Address, optData [
 	}
 	StateMutex.Lock()
 	defer StateMutex.Unlock()
+	defer State.ClearBalanceJournal()
 
 	//nonce := new(big.Int).SetInt64(int64(tx.TxParam.Nonce))
 
@@ -470,21 +515,7 @@ func GetViewFunctionReturns(contractAddr common.Address, OptData []byte, bl Bloc
 
 	origin := common.EmptyAddress()
 	input := OptData
-	blockCtx := vm.BlockContext{
-		CanTransfer: nil,
-		Transfer:    nil,
-		GetHash: func(height uint64) common.Hash {
-			hashBytes, _ := LoadHashOfBlock(int64(height))
-			return common.BytesToHash(hashBytes)
-		},
-		Coinbase:    common.EmptyAddress(),
-		GasLimit:    uint64(common.MaxGasUsage),
-		BlockNumber: new(big.Int).SetInt64(bl.GetHeader().Height),
-		Time:        new(big.Int).SetInt64(common.GetCurrentTimeStampInSecond()),
-		Difficulty:  new(big.Int).SetInt64(int64(bl.GetHeader().Difficulty)),
-		BaseFee:     new(big.Int).SetInt64(int64(0)),
-		Random:      nil,
-	}
+	blockCtx := NewBlockContext(bl)
 	logger := vm.CreateGVMLogger()
 	jumpTable := vm.GetGenericJumpTable()
 
@@ -502,6 +533,7 @@ func GetViewFunctionReturns(contractAddr common.Address, OptData []byte, bl Bloc
 	}
 	StateMutex.Lock()
 	defer StateMutex.Unlock()
+	defer State.ClearBalanceJournal()
 	VM = vm.NewEVM(blockCtx, txCtx, &State, params.AllEthashProtocolChanges, configCtx)
 	defer VM.Cancel()
 
@@ -523,6 +555,52 @@ func GetViewFunctionReturns(contractAddr common.Address, OptData []byte, bl Bloc
 	return logger.Output, decodedString, ret, address, leftOverGas, nil
 }
 
+// SimulateCall executes call of contract as sent by from with value attached, like eth_call.
+// All changes of state are reverted afterwards, so nothing is stored.
+func SimulateCall(from common.Address, contractAddr common.Address, input []byte, value *big.Int, bl Block) ([]byte, error) {
+	blockCtx := NewBlockContext(bl)
+	logger := vm.CreateGVMLogger()
+	jumpTable := vm.GetGenericJumpTable()
+
+	configCtx := vm.Config{
+		Debug:                   true,
+		Tracer:                  &logger,
+		NoBaseFee:               true,
+		EnablePreimageRecording: true,
+		JumpTable:               &jumpTable,
+		ExtraEips:               []int{},
+	}
+	txCtx := vm.TxContext{
+		Origin:   from,
+		GasPrice: new(big.Int).SetInt64(0),
+	}
+	StateMutex.Lock()
+	defer StateMutex.Unlock()
+	defer State.ClearBalanceJournal()
+
+	// storage, logs and balances are journaled, contracts created by call are not
+	sn := State.Snapshot()
+	accounts := maps.Clone(State.Accounts)
+	codes := maps.Clone(State.Codes)
+	codeHashes := maps.Clone(State.CodeHashes)
+	nonces := maps.Clone(State.Nonces)
+	defer func() {
+		State.RevertToSnapshot(sn)
+		State.Accounts = accounts
+		State.Codes = codes
+		State.CodeHashes = codeHashes
+		State.Nonces = nonces
+	}()
+
+	VM = vm.NewEVM(blockCtx, txCtx, &State, params.AllEthashProtocolChanges, configCtx)
+	defer VM.Cancel()
+
+	VM.Origin = from
+	VM.GasPrice = new(big.Int).SetInt64(0)
+	ret, _, err := VM.Call(vm.AccountRef(from), contractAddr, input, uint64(common.MaxGasUsage), value)
+	return ret, err
+}
+
 func IsTokenToRegister(code []byte) bool {
 	toRegister := true
 	if bytes.Index(code, stateDB.NameFunc) < 0 {
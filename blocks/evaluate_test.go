@@ -0,0 +1,87 @@
+package blocks
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/stateDB"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+func TestNewBlockContext(t *testing.T) {
+	bl := buildMinimalBlock()
+	bl.BaseBlock.RandOracle = 123456789
+
+	ctx := NewBlockContext(bl)
+	assert.Equal(t, big.NewInt(bl.BaseBlock.BlockTimeStamp), ctx.Time)
+	assert.Equal(t, bl.GetHeader().OperatorAccount, ctx.Coinbase)
+	assert.Equal(t, big.NewInt(bl.GetHeader().Height), ctx.BlockNumber)
+	assert.Equal(t, uint64(common.MaxGasUsage), ctx.GasLimit)
+	assert.Equal(t, big.NewInt(common.BaseFeePerGas), ctx.BaseFee)
+	assert.NotNil(t, ctx.Random)
+	assert.Equal(t, big.NewInt(123456789), ctx.Random.Big())
+
+	again := NewBlockContext(bl)
+	assert.Equal(t, ctx.Time, again.Time)
+	assert.Equal(t, *ctx.Random, *again.Random)
+}
+
+func TestStateBalanceTransferRevert(t *testing.T) {
+	logger.InitLogger()
+	defer logger.CloseLogger()
+	initTestAccounts()
+
+	sender := common.Address{ByteValue: [common.AddressLength]byte{1}}
+	recipient := common.Address{ByteValue: [common.AddressLength]byte{2}}
+	account.SetBalance(sender.ByteValue, 1000)
+	sa := stateDB.CreateStateDB()
+
+	assert.True(t, CanTransfer(&sa, sender, big.NewInt(1000)))
+	assert.False(t, CanTransfer(&sa, sender, big.NewInt(1001)))
+	assert.False(t, CanTransfer(&sa, sender, big.NewInt(-1)))
+
+	// sender which cannot cover amount does not create coins on recipient
+	assert.Error(t, Transfer(&sa, sender, recipient, big.NewInt(1001)))
+	assert.Equal(t, int64(1000), account.GetBalance(sender.ByteValue))
+	assert.Equal(t, big.NewInt(0), sa.GetBalance(recipient))
+
+	assert.NoError(t, Transfer(&sa, sender, recipient, big.NewInt(300)))
+	assert.Equal(t, int64(700), account.GetBalance(sender.ByteValue))
+	assert.Equal(t, big.NewInt(300), sa.GetBalance(recipient))
+
+	snap := sa.Snapshot()
+	assert.NoError(t, Transfer(&sa, recipient, sender, big.NewInt(100)))
+	assert.NoError(t, Transfer(&sa, sender, recipient, big.NewInt(500)))
+	assert.Equal(t, int64(300), account.GetBalance(sender.ByteValue))
+	sa.RevertToSnapshot(snap)
+	assert.Equal(t, int64(700), account.GetBalance(sender.ByteValue))
+	assert.Equal(t, int64(300), account.GetBalance(recipient.ByteValue))
+
+	sa.RevertToSnapshot(0)
+	assert.Equal(t, int64(1000), account.GetBalance(sender.ByteValue))
+	_, exist := account.GetAccountByAddressBytes(recipient.GetBytes())
+	assert.False(t, exist)
+
+	// balances are reverted by their own snapshot numbers, between other state changes
+	contract := common.Address{ByteValue: [common.AddressLength]byte{3}}
+	sa.SetState(contract, common.Hash{1}, common.Hash{2})
+	snap = sa.Snapshot()
+	assert.NoError(t, Transfer(&sa, sender, recipient, big.NewInt(100)))
+	sa.SetState(contract, common.Hash{1}, common.Hash{3})
+	inner := sa.Snapshot()
+	assert.NoError(t, Transfer(&sa, sender, recipient, big.NewInt(200)))
+	sa.RevertToSnapshot(inner)
+	assert.Equal(t, int64(900), account.GetBalance(sender.ByteValue))
+	assert.Equal(t, int64(100), account.GetBalance(recipient.ByteValue))
+	sa.RevertToSnapshot(snap)
+	assert.Equal(t, int64(1000), account.GetBalance(sender.ByteValue))
+	assert.Equal(t, snap, sa.Snapshot())
+
+	assert.NoError(t, Transfer(&sa, sender, recipient, big.NewInt(10)))
+	sa.ClearBalanceJournal()
+	sa.RevertToSnapshot(0)
+	assert.Equal(t, int64(990), account.GetBalance(sender.ByteValue))
+}
@@ -0,0 +1,317 @@
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/types"
+	"github.com/wonabru/qwid-node/database"
+)
+
+func marshalLogs(logs []*types.Log) []byte {
+	var buffer bytes.Buffer
+	buffer.Write(common.GetByteInt64(int64(len(logs))))
+	for _, l := range logs {
+		buffer.Write(l.Address.GetBytes())
+		buffer.Write(common.GetByteInt64(int64(len(l.Topics))))
+		for _, t := range l.Topics {
+			buffer.Write(t.GetBytes())
+		}
+		buffer.Write(common.BytesToLenAndBytes(l.Data))
+		buffer.Write(l.TxHash.GetBytes())
+		buffer.Write(common.GetByteInt64(int64(l.TxIndex)))
+		buffer.Write(common.GetByteInt64(int64(l.Index)))
+	}
+	return buffer.Bytes()
+}
+
+func unmarshalLogs(data []byte, height int64) ([]*types.Log, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("not enough data to unmarshal logs")
+	}
+	n := common.GetInt64FromByte(data[:8])
+	data = data[8:]
+	logs := make([]*types.Log, 0, n)
+	for i := int64(0); i < n; i++ {
+		if len(data) < common.AddressLength+8 {
+			return nil, fmt.Errorf("not enough data for log %d", i)
+		}
+		l := &types.Log{BlockNumber: uint64(height)}
+		err := l.Address.Init(data[:common.AddressLength])
+		if err != nil {
+			return nil, err
+		}
+		nt := common.GetInt64FromByte(data[common.AddressLength : common.AddressLength+8])
+		data = data[common.AddressLength+8:]
+		if int64(len(data)) < nt*int64(common.HashLength) {
+			return nil, fmt.Errorf("not enough data for topics of log %d", i)
+		}
+		l.Topics = make([]common.Hash, nt)
+		for j := range l.Topics {
+			l.Topics[j] = common.GetHashFromBytes(data[:common.HashLength])
+			data = data[common.HashLength:]
+		}
+		l.Data, data, err = common.BytesWithLenToBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < common.HashLength+16 {
+			return nil, fmt.Errorf("not enough data for transaction of log %d", i)
+		}
+		l.TxHash = common.GetHashFromBytes(data[:common.HashLength])
+		l.TxIndex = uint(common.GetInt64FromByte(data[common.HashLength : common.HashLength+8]))
+		l.Index = uint(common.GetInt64FromByte(data[common.HashLength+8 : common.HashLength+16]))
+		data = data[common.HashLength+16:]
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+func logsIndexKey(prefix [2]byte, key []byte, height int64) []byte {
+	k := append(prefix[:], key...)
+	return append(k, common.GetByteInt64(height)...)
+}
+
+// logsIndexKeys returns index keys of all addresses and topics found in logs, without duplicates
+func logsIndexKeys(logs []*types.Log, height int64) [][]byte {
+	seen := map[string]bool{}
+	keys := [][]byte{}
+	add := func(k []byte) {
+		if !seen[string(k)] {
+			seen[string(k)] = true
+			keys = append(keys, k)
+		}
+	}
+	for _, l := range logs {
+		add(logsIndexKey(common.EvmLogsAddressIndexDBPrefix, l.Address.GetBytes(), height))
+		for _, t := range l.Topics {
+			add(logsIndexKey(common.EvmLogsTopicIndexDBPrefix, t.GetBytes(), height))
+		}
+	}
+	return keys
+}
+
+// StoreBlockLogs stores EVM logs emitted in block together with address and topic indices.
+// Logs stored before at the same height are removed first.
+func StoreBlockLogs(height int64, logs []*types.Log) error {
+	err := RemoveBlockLogs(height)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	err = database.MainDB.Put(append(common.EvmLogsDBPrefix[:], common.GetByteInt64(height)...), marshalLogs(logs))
+	if err != nil {
+		return err
+	}
+	for _, k := range logsIndexKeys(logs, height) {
+		err = database.MainDB.Put(k, []byte{1})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadStoredLogs(height int64) ([]*types.Log, error) {
+	key := append(common.EvmLogsDBPrefix[:], common.GetByteInt64(height)...)
+	isKey, err := database.MainDB.IsKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !isKey {
+		return []*types.Log{}, nil
+	}
+	b, err := database.MainDB.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalLogs(b, height)
+}
+
+func RemoveBlockLogs(height int64) error {
+	logs, err := loadStoredLogs(height)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	for _, k := range logsIndexKeys(logs, height) {
+		err = database.MainDB.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return database.MainDB.Delete(append(common.EvmLogsDBPrefix[:], common.GetByteInt64(height)...))
+}
+
+// LoadBlockLogs returns EVM logs emitted in block at height with block hash filled
+func LoadBlockLogs(height int64) ([]*types.Log, error) {
+	logs, err := loadStoredLogs(height)
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		return logs, nil
+	}
+	hb, err := LoadHashOfBlock(height)
+	if err == nil {
+		bh := common.GetHashFromBytes(hb)
+		for _, l := range logs {
+			l.BlockHash = bh
+		}
+	}
+	return logs, nil
+}
+
+func LoadTransactionLogs(hash []byte) ([]*types.Log, error) {
+	height, err := LoadTransactionBlockHeight(hash)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := LoadBlockLogs(height)
+	if err != nil {
+		return nil, err
+	}
+	txLogs := []*types.Log{}
+	for _, l := range logs {
+		if bytes.Equal(l.TxHash.GetBytes(), hash) {
+			txLogs = append(txLogs, l)
+		}
+	}
+	return txLogs, nil
+}
+
+// MatchLog checks log against filter. Empty addresses match any address and empty topic position matches any topic.
+func MatchLog(l *types.Log, addresses [][common.AddressLength]byte, topics [][]common.Hash) bool {
+	if len(addresses) > 0 {
+		found := false
+		for _, a := range addresses {
+			if a == l.Address.ByteValue {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(topics) > len(l.Topics) {
+		return false
+	}
+	for i, position := range topics {
+		if len(position) == 0 {
+			continue
+		}
+		found := false
+		for _, t := range position {
+			if t == l.Topics[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// heightsFromIndex returns heights at which any of keys appears in logs index
+func heightsFromIndex(prefix [2]byte, keys [][]byte) (map[int64]bool, error) {
+	heights := map[int64]bool{}
+	for _, k := range keys {
+		p := append(prefix[:], k...)
+		indexKeys, err := database.MainDB.LoadAllKeys(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, ik := range indexKeys {
+			if len(ik) != len(p)+8 {
+				continue
+			}
+			heights[common.GetInt64FromByte(ik[len(p):])] = true
+		}
+	}
+	return heights, nil
+}
+
+// FilterLogs returns logs from blocks between fromHeight and toHeight inclusive which match filter.
+// Address and topic indices are used to skip blocks without matching logs.
+func FilterLogs(fromHeight, toHeight int64, addresses [][common.AddressLength]byte, topics [][]common.Hash) ([]*types.Log, error) {
+	if fromHeight < 0 {
+		fromHeight = 0
+	}
+	if toHeight < fromHeight {
+		return []*types.Log{}, nil
+	}
+	var candidates map[int64]bool
+	if len(addresses) > 0 {
+		keys := [][]byte{}
+		for _, a := range addresses {
+			keys = append(keys, a[:])
+		}
+		hs, err := heightsFromIndex(common.EvmLogsAddressIndexDBPrefix, keys)
+		if err != nil {
+			return nil, err
+		}
+		candidates = hs
+	}
+	for _, position := range topics {
+		if len(position) == 0 {
+			continue
+		}
+		keys := [][]byte{}
+		for _, t := range position {
+			keys = append(keys, t.GetBytes())
+		}
+		hs, err := heightsFromIndex(common.EvmLogsTopicIndexDBPrefix, keys)
+		if err != nil {
+			return nil, err
+		}
+		if candidates == nil {
+			candidates = hs
+			continue
+		}
+		for h := range candidates {
+			if !hs[h] {
+				delete(candidates, h)
+			}
+		}
+	}
+
+	heights := []int64{}
+	if candidates == nil {
+		if toHeight-fromHeight+1 > common.MaxLogsQueryBlockRange {
+			return nil, fmt.Errorf("query exceeds %v blocks, narrow block range or filter by address or topic", common.MaxLogsQueryBlockRange)
+		}
+		for h := fromHeight; h <= toHeight; h++ {
+			heights = append(heights, h)
+		}
+	} else {
+		for h := range candidates {
+			if h >= fromHeight && h <= toHeight {
+				heights = append(heights, h)
+			}
+		}
+		sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	}
+
+	result := []*types.Log{}
+	for _, h := range heights {
+		logs, err := LoadBlockLogs(h)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range logs {
+			if MatchLog(l, addresses, topics) {
+				result = append(result, l)
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,109 @@
+package blocks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/stateDB"
+	"github.com/wonabru/qwid-node/core/types"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+func buildTestLogs() []*types.Log {
+	addr1 := common.Address{}
+	addr1.Init([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20})
+	addr2 := common.Address{}
+	addr2.Init(make([]byte, common.AddressLength))
+	return []*types.Log{
+		{
+			Address: addr1,
+			Topics:  []common.Hash{{1}, {2}},
+			Data:    []byte{0xde, 0xad},
+			TxHash:  common.Hash{9},
+			TxIndex: 0,
+			Index:   0,
+		},
+		{
+			Address: addr2,
+			Topics:  []common.Hash{},
+			Data:    []byte{},
+			TxHash:  common.Hash{8},
+			TxIndex: 1,
+			Index:   1,
+		},
+	}
+}
+
+func TestLogsMarshalUnmarshal(t *testing.T) {
+	logger.InitLogger()
+	defer logger.CloseLogger()
+
+	logs := buildTestLogs()
+	restored, err := unmarshalLogs(marshalLogs(logs), 42)
+	assert.NoError(t, err)
+	assert.Equal(t, len(logs), len(restored))
+	for i := range logs {
+		assert.Equal(t, logs[i].Address.ByteValue, restored[i].Address.ByteValue)
+		assert.Equal(t, logs[i].Topics, restored[i].Topics)
+		assert.Equal(t, logs[i].Data, restored[i].Data)
+		assert.Equal(t, logs[i].TxHash, restored[i].TxHash)
+		assert.Equal(t, logs[i].TxIndex, restored[i].TxIndex)
+		assert.Equal(t, logs[i].Index, restored[i].Index)
+		assert.Equal(t, uint64(42), restored[i].BlockNumber)
+	}
+
+	_, err = unmarshalLogs(marshalLogs(logs)[:30], 42)
+	assert.Error(t, err)
+}
+
+func TestMatchLog(t *testing.T) {
+	l := buildTestLogs()[0]
+	other := [common.AddressLength]byte{7}
+
+	assert.True(t, MatchLog(l, nil, nil))
+	assert.True(t, MatchLog(l, [][common.AddressLength]byte{other, l.Address.ByteValue}, nil))
+	assert.False(t, MatchLog(l, [][common.AddressLength]byte{other}, nil))
+	assert.True(t, MatchLog(l, nil, [][]common.Hash{{}, {{2}, {3}}}))
+	assert.False(t, MatchLog(l, nil, [][]common.Hash{{{2}}}))
+	assert.False(t, MatchLog(l, nil, [][]common.Hash{{}, {}, {}}))
+}
+
+func TestStateLogsRevert(t *testing.T) {
+	sa := stateDB.CreateStateDB()
+	sa.SetTxContext(common.Hash{5}, 3)
+
+	sa.AddLog(&types.Log{})
+	snap := sa.Snapshot()
+	sa.AddLog(&types.Log{})
+	sa.RevertToSnapshot(snap)
+
+	logs := sa.TakeLogs()
+	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, common.Hash{5}, logs[0].TxHash)
+	assert.Equal(t, uint(3), logs[0].TxIndex)
+	assert.Empty(t, sa.TakeLogs())
+
+	// logs are reverted by their own snapshot numbers, between other state changes
+	contract := common.Address{ByteValue: [common.AddressLength]byte{9}}
+	sa.AddLog(&types.Log{})
+	snap = sa.Snapshot()
+	sa.SetState(contract, common.Hash{1}, common.Hash{2})
+	sa.AddLog(&types.Log{})
+	inner := sa.Snapshot()
+	sa.AddLog(&types.Log{})
+	sa.SetState(contract, common.Hash{1}, common.Hash{3})
+	sa.RevertToSnapshot(inner)
+	assert.Equal(t, 2, len(sa.Logs))
+	sa.RevertToSnapshot(snap)
+	assert.Equal(t, 1, len(sa.Logs))
+	assert.Equal(t, snap, sa.Snapshot())
+	sa.AddLog(&types.Log{})
+	logs = sa.TakeLogs()
+	assert.Equal(t, 2, len(logs))
+	assert.Equal(t, uint(1), logs[1].Index)
+
+	// taken logs are not touched by revert
+	sa.RevertToSnapshot(0)
+	assert.Empty(t, sa.TakeLogs())
+}
@@ -0,0 +1,117 @@
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/database"
+	"github.com/wonabru/qwid-node/pubkeys"
+)
+
+// Headers and blocks downloaded in headers-first sync are kept by height until they are applied to main chain,
+// so sync resumes after restart.
+
+// GetHeaderBlock returns block without transactions hashes, its hash still commits to them by merkle root
+func (tb Block) GetHeaderBlock() Block {
+	return Block{BaseBlock: tb.BaseBlock, BlockHash: tb.BlockHash}
+}
+
+// CheckHeader makes checks of header which do not need state of chain
+func CheckHeader(header Block, previousHash []byte) error {
+	if !bytes.Equal(header.GetHeader().PreviousHash.GetBytes(), previousHash) {
+		return fmt.Errorf("header %d does not link to previous one", header.GetHeader().Height)
+	}
+	hash, err := header.CalcBlockHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash.GetBytes(), header.BlockHash.GetBytes()) {
+		return fmt.Errorf("wrong hash of header %d", header.GetHeader().Height)
+	}
+	if !header.CheckSeal() {
+		return fmt.Errorf("seal check fails of header %d", header.GetHeader().Height)
+	}
+	return nil
+}
+
+// CheckSyncedHeader checks header following previous one in headers-first sync: checks of CheckHeader,
+// difficulty expected by consensus engine and signature of operator. Operator key is taken from pubkeys
+// known to node, header of operator which key is not known yet is checked again when its block is applied.
+func CheckSyncedHeader(header Block, previous Block) error {
+	head := header.GetHeader()
+	if head.Height != previous.GetHeader().Height+1 {
+		return fmt.Errorf("header %d does not follow header %d", head.Height, previous.GetHeader().Height)
+	}
+	err := CheckHeader(header, previous.BlockHash.GetBytes())
+	if err != nil {
+		return err
+	}
+	if !header.CheckDifficulty(previous) {
+		return fmt.Errorf("wrong difficulty %d of header %d", head.Difficulty, head.Height)
+	}
+	pk, err := pubkeys.LoadPubKeyWithPrimary(head.OperatorAccount, head.IsSignedByPrimary())
+	if err != nil {
+		return nil
+	}
+	sigName, sigName2, isPaused, isPaused2, err := header.GetSigNames()
+	if err != nil {
+		return err
+	}
+	if !head.VerifyWithPubKey(pk.GetBytes(), sigName, sigName2, isPaused, isPaused2) {
+		return fmt.Errorf("wrong operator signature of header %d", head.Height)
+	}
+	return nil
+}
+
+func heightKey(prefix [2]byte, height int64) []byte {
+	return append(prefix[:], common.GetByteInt64(height)...)
+}
+
+func StoreSyncHeader(header Block) error {
+	return database.MainDB.Put(heightKey(common.BlockHeaderDBPrefix, header.GetHeader().Height), header.GetHeaderBlock().GetBytes())
+}
+
+func LoadSyncHeader(height int64) (Block, error) {
+	b, err := database.MainDB.Get(heightKey(common.BlockHeaderDBPrefix, height))
+	if err != nil {
+		return Block{}, err
+	}
+	return Block{}.GetFromBytes(b)
+}
+
+func RemoveSyncHeader(height int64) error {
+	return database.MainDB.Delete(heightKey(common.BlockHeaderDBPrefix, height))
+}
+
+func StoreDownloadedBlock(bl Block) error {
+	return database.MainDB.Put(heightKey(common.DownloadedBlocksDBPrefix, bl.GetHeader().Height), bl.GetBytes())
+}
+
+func LoadDownloadedBlock(height int64) (Block, error) {
+	b, err := database.MainDB.Get(heightKey(common.DownloadedBlocksDBPrefix, height))
+	if err != nil {
+		return Block{}, err
+	}
+	return Block{}.GetFromBytes(b)
+}
+
+func RemoveDownloadedBlock(height int64) error {
+	return database.MainDB.Delete(heightKey(common.DownloadedBlocksDBPrefix, height))
+}
+
+// LoadDownloadedHeights returns heights of blocks downloaded but not applied yet
+func LoadDownloadedHeights() ([]int64, error) {
+	keys, err := database.MainDB.LoadAllKeys(common.DownloadedBlocksDBPrefix[:])
+	if err != nil {
+		return nil, err
+	}
+	heights := []int64{}
+	for _, k := range keys {
+		if len(k) != 10 {
+			continue
+		}
+		heights = append(heights, common.GetInt64FromByte(k[2:]))
+	}
+	return heights, nil
+}
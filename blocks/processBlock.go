@@ -54,8 +54,11 @@ func CheckBaseBlock(newBlock Block, lastBlock Block, forceShouldCheck bool) (*tr
 		return nil, fmt.Errorf("last block hash not match to one stored in new block")
 	}
 	// needs to check block and process
-	if newBlock.CheckProofOfSynergy() == false {
-		return nil, fmt.Errorf("proof of synergy fails of block")
+	if newBlock.CheckSeal() == false {
+		return nil, fmt.Errorf("seal check fails of block")
+	}
+	if newBlock.GetHeader().Height > 0 && !newBlock.CheckDifficulty(lastBlock) {
+		return nil, fmt.Errorf("wrong difficulty of block")
 	}
 	hash, err := newBlock.CalcBlockHash()
 	if err != nil {
@@ -64,14 +67,18 @@ func CheckBaseBlock(newBlock Block, lastBlock Block, forceShouldCheck bool) (*tr
 	if !bytes.Equal(hash.GetBytes(), newBlock.BlockHash.GetBytes()) {
 		return nil, fmt.Errorf("wrong hash of block")
 	}
+	err = checkSnapshotRoot(newBlock)
+	if err != nil {
+		return nil, err
+	}
 	rootMerkleTrie := newBlock.GetHeader().RootMerkleTree
 	txs := newBlock.TransactionsHashes
-	txsBytes := make([][]byte, len(txs))
+	txsBytes := [][]byte{}
 	for _, tx := range txs {
 		hash := tx.GetBytes()
 		txsBytes = append(txsBytes, hash)
 	}
-	merkleTrie, err := transactionsPool.BuildMerkleTree(blockHeight, txsBytes, transactionsPool.GlobalMerkleTree.DB)
+	merkleTrie, err := transactionsPool.BuildMerkleTree(blockHeight, transactionsPool.BlockMerkleLeaves(txsBytes), transactionsPool.GlobalMerkleTree.DB)
 	if err != nil {
 		return nil, err
 	}
@@ -195,6 +202,7 @@ func CheckBlockTransfers(block Block, lastBlock Block, tree *transactionsPool.Me
 	lastSupply := lastBlock.GetBlockSupply()
 	accounts := map[[common.AddressLength]byte]account.Account{}
 	stakingAccounts := map[[common.AddressLength]byte]account.StakingAccount{}
+	slashed := map[string]bool{}
 	totalFee := int64(0)
 	logger.GetLogger().Printf("CheckBlockTransfers: block %d has %d transactions, lastSupply=%d", block.GetHeader().Height, len(txs), lastSupply)
 	for i, tx := range txs {
@@ -276,6 +284,22 @@ func CheckBlockTransfers(block Block, lastBlock Block, tree *transactionsPool.Me
 				return 0, 0, fmt.Errorf("staking transactions checking fails: CheckBlockTransfers")
 			}
 		}
+		if err == nil && n == int(common.SlashingAccountID) { // evidence of equivocation
+			e, _, err := CheckSlashingTransaction(poolTx, block.GetHeader().Height)
+			if err == nil {
+				// the same offence can be reported by many nodes, only one report is processed
+				offender := e.GetOffender()
+				key := string(append(offender.GetBytes(), common.GetByteInt64(e.GetHeight())...))
+				if slashed[key] {
+					err = fmt.Errorf("offender is slashed twice in block")
+				}
+				slashed[key] = true
+			}
+			if err != nil {
+				transactionsPool.RemoveBadTransactionByHash(poolTx.Hash.GetBytes(), block.GetHeader().Height, tree)
+				return 0, 0, fmt.Errorf("slashing transaction checking fails: %v: CheckBlockTransfers", err)
+			}
+		}
 		acc, exist := account.GetAccountByAddressBytes(address.GetBytes())
 		if !exist || !bytes.Equal(acc.Address[:], address.GetBytes()) {
 			// remove bad transaction from pool
@@ -302,7 +326,7 @@ func CheckBlockTransfers(block Block, lastBlock Block, tree *transactionsPool.Me
 		}
 
 	}
-	reward := account.GetReward(lastSupply)
+	reward := BlockReward(lastSupply)
 
 	if lastSupply+reward != block.GetBlockSupply() {
 		logger.GetLogger().Println("lastSupply:", lastSupply, "block.GetBlockSupply()", block.GetBlockSupply())
@@ -410,7 +434,18 @@ func EvaluateSmartContracts(bl *Block) bool {
 		for _, a := range addresses {
 			State.RecordContractCreation(height, a.ByteValue)
 		}
+		blockLogs := State.TakeLogs()
 		StateMutex.Unlock()
+		err := StoreBlockLogs(height, blockLogs)
+		if err != nil {
+			logger.GetLogger().Println("Cannot store evm logs", err)
+			return false
+		}
+		err = StoreOrderEvents(height, takeOrderEvents())
+		if err != nil {
+			logger.GetLogger().Println("Cannot store dex order events", err)
+			return false
+		}
 		for th, a := range addresses {
 
 			prefix := common.OutputLogsHashesDBPrefix[:]
@@ -448,6 +483,9 @@ func CheckBlockAndTransactions(newBlock *Block, lastBlock Block, merkleTrie *tra
 	if _, sumStaked, opAcc := account.GetStakedInDelegatedAccount(n); int64(sumStaked) < common.MinStakingForNode || !bytes.Equal(opAcc.Address[:], opAccBlockAddr.GetBytes()) {
 		return fmt.Errorf("not enough staked coins to be a node or not valid operetional account: CheckBlockAndTransactions")
 	}
+	if account.IsJailed(n, newBlock.GetHeader().Height) {
+		return fmt.Errorf("delegated account %v is jailed for equivocation: CheckBlockAndTransactions", n)
+	}
 
 	reward, totalFee, err := CheckBlockTransfers(*newBlock, lastBlock, merkleTrie, true)
 	if err != nil {
@@ -455,6 +493,10 @@ func CheckBlockAndTransactions(newBlock *Block, lastBlock Block, merkleTrie *tra
 	}
 	newBlock.BlockFee = totalFee + lastBlock.BlockFee
 
+	if newBlock.GetHeader().Height == common.DexForkHeight {
+		// providers get shares before the first block with integer DEX pricing, migrated accounts are stored with the block
+		account.MigrateDexAccountsToShares()
+	}
 	if EvaluateSmartContracts(newBlock) == false {
 		return fmt.Errorf("evaluation of smart contracts in block fails: CheckBlockAndTransactions")
 	}
@@ -482,6 +524,16 @@ func CheckBlockAndTransactions(newBlock *Block, lastBlock Block, merkleTrie *tra
 }
 
 func CheckBlockAndTransferFunds(newBlock *Block, lastBlock Block, merkleTrie *transactionsPool.MerkleTree, checkWhenNotSync bool) error {
+	return checkBlockAndTransferFunds(newBlock, lastBlock, merkleTrie, false)
+}
+
+// TransferFundsOfOwnBlock processes block made by this node. State root is known only after transactions
+// and contracts of block are processed, so it is set here and block hash changes.
+func TransferFundsOfOwnBlock(newBlock *Block, lastBlock Block, merkleTrie *transactionsPool.MerkleTree) error {
+	return checkBlockAndTransferFunds(newBlock, lastBlock, merkleTrie, true)
+}
+
+func checkBlockAndTransferFunds(newBlock *Block, lastBlock Block, merkleTrie *transactionsPool.MerkleTree, own bool) error {
 
 	defer RemoveAllTransactionsRelatedToBlock(*newBlock)
 	n, err := account.IntDelegatedAccountFromAddress(newBlock.GetHeader().DelegatedAccount)
@@ -492,6 +544,9 @@ func CheckBlockAndTransferFunds(newBlock *Block, lastBlock Block, merkleTrie *tr
 	if _, sumStaked, opAcc := account.GetStakedInDelegatedAccount(n); int64(sumStaked) < common.MinStakingForNode || !bytes.Equal(opAcc.Address[:], opAccBlockAddr.GetBytes()) {
 		return fmt.Errorf("not enough staked coins to be a node or not valid operetional account: CheckBlockAndTransferFunds %v %v %v %v", int64(sumStaked), common.MinStakingForNode, opAcc.Address[:5], opAccBlockAddr.GetBytes()[:5])
 	}
+	if account.IsJailed(n, newBlock.GetHeader().Height) {
+		return fmt.Errorf("delegated account %v is jailed for equivocation: CheckBlockAndTransferFunds", n)
+	}
 
 	reward, totalFee, err := CheckBlockTransfers(*newBlock, lastBlock, merkleTrie, false)
 	if err != nil {
@@ -499,6 +554,10 @@ func CheckBlockAndTransferFunds(newBlock *Block, lastBlock Block, merkleTrie *tr
 	}
 	newBlock.BlockFee = totalFee + lastBlock.BlockFee
 
+	if newBlock.GetHeader().Height == common.DexForkHeight {
+		// providers get shares before the first block with integer DEX pricing, migrated accounts are stored with the block
+		account.MigrateDexAccountsToShares()
+	}
 	if EvaluateSmartContracts(newBlock) == false {
 		return fmt.Errorf("evaluation of smart contracts in block fails: CheckBlockAndTransferFunds")
 	}
@@ -567,5 +626,21 @@ func CheckBlockAndTransferFunds(newBlock *Block, lastBlock Block, merkleTrie *tr
 	if err != nil {
 		logger.GetLogger().Println("process block encryption fails", err)
 	}
+	if newBlock.GetHeader().Height > 0 && IsStateRootHeight(newBlock.GetHeader().Height) {
+		if own {
+			err = setStateRoot(newBlock)
+		} else {
+			err = checkStateRoot(*newBlock)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if IsSnapshotHeight(newBlock.GetHeader().Height) {
+		err = MakeSnapshot(*newBlock)
+		if err != nil {
+			logger.GetLogger().Println("cannot make snapshot", err)
+		}
+	}
 	return nil
 }
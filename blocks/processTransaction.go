@@ -265,6 +265,9 @@ func ProcessTransaction(tx transactionsDefinition.Transaction, height int64) err
 				return fmt.Errorf("wrong amount in rewarding: ProcessTransaction")
 			}
 		}
+		if n == int(common.SlashingAccountID) { // evidence of equivocation
+			return ProcessSlashingTransaction(tx, height)
+		}
 		if n >= 512 { // DEX operation - deduct gas fee from sender
 			err = AddBalance(address.ByteValue, -fee)
 			if err != nil {
@@ -289,14 +292,18 @@ func ProcessTransaction(tx transactionsDefinition.Transaction, height int64) err
 			if bytes.Equal(tx.TxParam.MultiSignTx.GetBytes(), ZerosHash) == false {
 				transactionsPool.PoolTxMultiSign.AddTransaction(tx, tx.TxParam.MultiSignTx)
 			}
-			err = AddBalance(address.ByteValue, -amount)
-			if err != nil {
-				return err
-			}
+			// amount sent with smart contract call is transferred by EVM as call value. Failed call invalidates
+			// the whole block, so amount is never moved twice
+			if !EVMTransfersAmount(tx, senderAcc, height) {
+				err = AddBalance(address.ByteValue, -amount)
+				if err != nil {
+					return err
+				}
 
-			err = AddBalance(addressRecipient.ByteValue, amount)
-			if err != nil {
-				return err
+				err = AddBalance(addressRecipient.ByteValue, amount)
+				if err != nil {
+					return err
+				}
 			}
 		}
 		// escrow tx and multisigned should be paid fee upfront
@@ -30,6 +30,17 @@ func minimalHashProof(difficulty int32) float64 {
 	return hashProof
 }
 
+// CheckDifficultyOfSynergy checks that difficulty is adjusted by interval between timestamps of block and parent.
+// Below DifficultyForkHeight difficulty followed time of nonce transaction, which is not kept in block,
+// so there only the size of change is checked.
+func CheckDifficultyOfSynergy(parent BaseBlock, bb BaseBlock) bool {
+	d, pd := bb.BaseHeader.Difficulty, parent.BaseHeader.Difficulty
+	if bb.BaseHeader.Height >= common.DifficultyForkHeight {
+		return d == AdjustDifficulty(pd, bb.BlockTimeStamp-parent.BlockTimeStamp)
+	}
+	return d >= AdjustDifficulty(pd, math.MaxInt64) && d <= AdjustDifficulty(pd, math.MinInt64)
+}
+
 func AdjustDifficulty(lastDifficulty int32, interval int64) int32 {
 	if float64(interval) > float64(common.BlockTimeInterval)*1.33 {
 		lastDifficulty -= int32(common.DifficultyChange)
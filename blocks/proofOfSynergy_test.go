@@ -3,9 +3,9 @@ package blocks
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
-	"github.com/stretchr/testify/assert"
 )
 
 func TestMinimalHashProof(t *testing.T) {
@@ -143,3 +143,45 @@ func TestCheckProofOfSynergy(t *testing.T) {
 		assert.False(t, result)
 	})
 }
+
+func TestCheckDifficultyOfSynergy(t *testing.T) {
+	parent := BaseBlock{BaseHeader: BaseHeader{Difficulty: 100}}
+	change := int32(common.DifficultyChange)
+	for _, d := range []int32{100, 100 - change, 100 + change} {
+		assert.True(t, CheckDifficultyOfSynergy(parent, BaseBlock{BaseHeader: BaseHeader{Difficulty: d}}))
+	}
+	for _, d := range []int32{100 - change - 1, 100 + change + 1, 0xff00} {
+		assert.False(t, CheckDifficultyOfSynergy(parent, BaseBlock{BaseHeader: BaseHeader{Difficulty: d}}))
+	}
+}
+
+func TestDifficultyFollowsBlockTimestamps(t *testing.T) {
+	change := int32(common.DifficultyChange)
+	fast := int64(1)
+	slow := int64(2 * common.BlockTimeInterval)
+	parent := BaseBlock{BaseHeader: BaseHeader{Height: common.DifficultyForkHeight, Difficulty: 100}, BlockTimeStamp: 1000}
+	next := func(interval int64) BaseBlock {
+		bb := BaseBlock{
+			BaseHeader:     BaseHeader{Height: parent.BaseHeader.Height + 1, Difficulty: AdjustDifficulty(parent.BaseHeader.Difficulty, interval)},
+			BlockTimeStamp: parent.BlockTimeStamp + interval,
+		}
+		assert.True(t, CheckDifficultyOfSynergy(parent, bb))
+		return bb
+	}
+
+	for i := 0; i < 5; i++ {
+		parent = next(fast)
+	}
+	assert.Equal(t, 100+5*change, parent.BaseHeader.Difficulty)
+
+	stale := BaseBlock{
+		BaseHeader:     BaseHeader{Height: parent.BaseHeader.Height + 1, Difficulty: parent.BaseHeader.Difficulty + change},
+		BlockTimeStamp: parent.BlockTimeStamp + slow,
+	}
+	assert.False(t, CheckDifficultyOfSynergy(parent, stale))
+
+	for i := 0; i < 5; i++ {
+		parent = next(slow)
+	}
+	assert.Equal(t, int32(100), parent.BaseHeader.Difficulty)
+}
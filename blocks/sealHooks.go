@@ -0,0 +1,26 @@
+package blocks
+
+import (
+	"github.com/wonabru/qwid-node/account"
+)
+
+// Rules of consensus engine used by block checks, they are set by consensus.SetEngine.
+// Proof of synergy is used when no engine is set.
+var (
+	// SealVerifier checks that block was produced according to consensus rules
+	SealVerifier = CheckProofOfSynergy
+	// DifficultyVerifier checks difficulty of block following parent
+	DifficultyVerifier = CheckDifficultyOfSynergy
+	// BlockReward is reward of block following block of given supply
+	BlockReward = account.GetReward
+)
+
+// CheckSeal checks block with rules of consensus engine
+func (tb Block) CheckSeal() bool {
+	return SealVerifier(tb.BaseBlock)
+}
+
+// CheckDifficulty checks difficulty of block following parent with rules of consensus engine
+func (tb Block) CheckDifficulty(parent Block) bool {
+	return DifficultyVerifier(parent.BaseBlock, tb.BaseBlock)
+}
@@ -0,0 +1,318 @@
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/transactionsDefinition"
+)
+
+// Evidence is proof that operator signed two conflicting messages, it is sent in transaction to slashing account
+type Evidence interface {
+	GetBytes() []byte
+	// Check verifies evidence and returns delegated account of offender
+	Check() (int, error)
+	GetOffender() common.Address
+	GetHeight() int64
+}
+
+const (
+	blocksEvidenceKind = byte('B')
+	nonceEvidenceKind  = byte('N')
+)
+
+var (
+	noncesMutex sync.Mutex
+	// nonces are the first nonce transactions received from delegated accounts for every voted block at noncesHeight
+	nonces       = map[string]transactionsDefinition.Transaction{}
+	noncesHeight int64
+)
+
+// Equivocation is evidence that operator signed two different blocks following the same block.
+// Honest operator signs at most one block for given parent, so blocks of other branches after reorg
+// are never evidence, they follow different parents.
+type Equivocation struct {
+	First  BaseHeader
+	Second BaseHeader
+}
+
+// NonceEquivocation is evidence that operator signed two different nonce transactions voting for the same block.
+// Honest operator sends the same nonce transaction until the next block, so it cannot grind candidate blocks.
+// Nonce transactions voting for different blocks at the same height after reorg are not evidence.
+type NonceEquivocation struct {
+	First  transactionsDefinition.Transaction
+	Second transactionsDefinition.Transaction
+}
+
+// EvidenceFromBytes restores evidence of any kind
+func EvidenceFromBytes(b []byte) (Evidence, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty evidence")
+	}
+	switch b[0] {
+	case blocksEvidenceKind:
+		return EquivocationFromBytes(b)
+	case nonceEvidenceKind:
+		return NonceEquivocationFromBytes(b)
+	}
+	return nil, fmt.Errorf("unknown kind of evidence %v", b[0])
+}
+
+func evidencePair(kind byte, b []byte) ([]byte, []byte, error) {
+	if len(b) == 0 || b[0] != kind {
+		return nil, nil, fmt.Errorf("wrong kind of evidence")
+	}
+	fb, b, err := common.BytesWithLenToBytes(b[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	sb, b, err := common.BytesWithLenToBytes(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(b) > 0 {
+		return nil, nil, fmt.Errorf("too many bytes in equivocation evidence")
+	}
+	return fb, sb, nil
+}
+
+func (e Equivocation) GetBytes() []byte {
+	b := append([]byte{blocksEvidenceKind}, common.BytesToLenAndBytes(e.First.GetBytes())...)
+	return append(b, common.BytesToLenAndBytes(e.Second.GetBytes())...)
+}
+
+func (e Equivocation) GetOffender() common.Address {
+	return e.First.OperatorAccount
+}
+
+func (e Equivocation) GetHeight() int64 {
+	return e.First.Height
+}
+
+func EquivocationFromBytes(b []byte) (Equivocation, error) {
+	e := Equivocation{}
+	fb, sb, err := evidencePair(blocksEvidenceKind, b)
+	if err != nil {
+		return Equivocation{}, err
+	}
+	_, err = e.First.GetFromBytes(fb)
+	if err != nil {
+		return Equivocation{}, err
+	}
+	_, err = e.Second.GetFromBytes(sb)
+	if err != nil {
+		return Equivocation{}, err
+	}
+	return e, nil
+}
+
+// Check verifies that both headers are signed by the same operator for the same parent and differ.
+// Returns delegated account of offender.
+func (e Equivocation) Check() (int, error) {
+	f, s := e.First, e.Second
+	if f.Height < 1 || f.Height != s.Height {
+		return 0, fmt.Errorf("headers of evidence are of different heights")
+	}
+	if !bytes.Equal(f.PreviousHash.GetBytes(), s.PreviousHash.GetBytes()) {
+		return 0, fmt.Errorf("headers of evidence follow different blocks")
+	}
+	if !bytes.Equal(f.OperatorAccount.GetBytes(), s.OperatorAccount.GetBytes()) ||
+		!bytes.Equal(f.DelegatedAccount.GetBytes(), s.DelegatedAccount.GetBytes()) {
+		return 0, fmt.Errorf("headers of evidence are signed by different operators")
+	}
+	// signatures are randomized, so the same header signed twice is not equivocation
+	if bytes.Equal(f.GetBytesWithoutSignature(), s.GetBytesWithoutSignature()) {
+		return 0, fmt.Errorf("headers of evidence are the same")
+	}
+	n, err := account.IntDelegatedAccountFromAddress(f.DelegatedAccount)
+	if err != nil || n <= 0 || n >= 256 {
+		return 0, fmt.Errorf("headers of evidence are not signed for delegated account")
+	}
+	for _, head := range []BaseHeader{f, s} {
+		bl := Block{BaseBlock: BaseBlock{BaseHeader: head}}
+		sigName, sigName2, isPaused, isPaused2, err := bl.GetSigNames()
+		if err != nil {
+			return 0, err
+		}
+		if !head.Verify(sigName, sigName2, isPaused, isPaused2) {
+			return 0, fmt.Errorf("header of evidence fails to verify")
+		}
+	}
+	return n, nil
+}
+
+func (e NonceEquivocation) GetBytes() []byte {
+	b := append([]byte{nonceEvidenceKind}, common.BytesToLenAndBytes(e.First.GetBytes())...)
+	return append(b, common.BytesToLenAndBytes(e.Second.GetBytes())...)
+}
+
+func (e NonceEquivocation) GetOffender() common.Address {
+	return e.First.TxParam.Sender
+}
+
+func (e NonceEquivocation) GetHeight() int64 {
+	return e.First.Height
+}
+
+func NonceEquivocationFromBytes(b []byte) (NonceEquivocation, error) {
+	e := NonceEquivocation{}
+	fb, sb, err := evidencePair(nonceEvidenceKind, b)
+	if err != nil {
+		return NonceEquivocation{}, err
+	}
+	e.First, _, err = e.First.GetFromBytes(fb)
+	if err != nil {
+		return NonceEquivocation{}, err
+	}
+	e.Second, _, err = e.Second.GetFromBytes(sb)
+	if err != nil {
+		return NonceEquivocation{}, err
+	}
+	return e, nil
+}
+
+// Check verifies that both nonce transactions are signed by the same operator, vote for the same block and differ.
+// Returns delegated account of offender.
+func (e NonceEquivocation) Check() (int, error) {
+	f, s := e.First, e.Second
+	if f.Height < 1 || f.Height != s.Height {
+		return 0, fmt.Errorf("nonce transactions of evidence are of different heights")
+	}
+	// nodes older than the fork send new nonce transaction every few seconds
+	if f.Height < common.SlashingForkHeight {
+		return 0, fmt.Errorf("nonce transactions are evidence only from fork height")
+	}
+	if len(f.TxData.OptData) < 8+common.HashLength || len(s.TxData.OptData) < 8+common.HashLength ||
+		!bytes.Equal(f.TxData.OptData[:8+common.HashLength], s.TxData.OptData[:8+common.HashLength]) {
+		return 0, fmt.Errorf("nonce transactions of evidence vote for different blocks")
+	}
+	if f.TxData.Amount != 0 || s.TxData.Amount != 0 {
+		return 0, fmt.Errorf("transactions of evidence are not nonce transactions")
+	}
+	if !bytes.Equal(f.TxParam.Sender.GetBytes(), s.TxParam.Sender.GetBytes()) ||
+		!bytes.Equal(f.TxData.Recipient.GetBytes(), s.TxData.Recipient.GetBytes()) {
+		return 0, fmt.Errorf("nonce transactions of evidence are signed by different operators")
+	}
+	// hash is checked by Verify and signatures are randomized, so only different content is equivocation
+	if bytes.Equal(f.Hash.GetBytes(), s.Hash.GetBytes()) {
+		return 0, fmt.Errorf("nonce transactions of evidence are the same")
+	}
+	n, err := account.IntDelegatedAccountFromAddress(f.TxData.Recipient)
+	if err != nil || n <= 0 || n >= 256 {
+		return 0, fmt.Errorf("nonce transactions of evidence are not sent to delegated account")
+	}
+	for _, tx := range []transactionsDefinition.Transaction{f, s} {
+		if !tx.Verify(common.SigName(), common.SigName2(), common.IsPaused(), common.IsPaused2()) {
+			return 0, fmt.Errorf("nonce transaction of evidence fails to verify")
+		}
+	}
+	return n, nil
+}
+
+// FindEquivocation compares block with main chain block at the same height
+func FindEquivocation(bl Block) (Equivocation, bool) {
+	height := bl.GetHeader().Height
+	if height < 1 || height > common.GetHeight() {
+		return Equivocation{}, false
+	}
+	mainBlock, err := LoadBlock(height)
+	if err != nil {
+		return Equivocation{}, false
+	}
+	e := Equivocation{First: mainBlock.GetHeader(), Second: bl.GetHeader()}
+	if _, err := e.Check(); err != nil {
+		return Equivocation{}, false
+	}
+	return e, true
+}
+
+// FindNonceEquivocation compares valid nonce transaction with the first one of the same delegated account
+// voting for the same block
+func FindNonceEquivocation(tx transactionsDefinition.Transaction) (NonceEquivocation, bool) {
+	if len(tx.TxData.OptData) < 8+common.HashLength {
+		return NonceEquivocation{}, false
+	}
+	noncesMutex.Lock()
+	defer noncesMutex.Unlock()
+	if tx.Height < noncesHeight {
+		return NonceEquivocation{}, false
+	}
+	if tx.Height > noncesHeight {
+		nonces = map[string]transactionsDefinition.Transaction{}
+		noncesHeight = tx.Height
+	}
+	key := string(append(tx.TxData.Recipient.GetBytes(), tx.TxData.OptData[:8+common.HashLength]...))
+	first, ok := nonces[key]
+	if !ok {
+		nonces[key] = tx
+		return NonceEquivocation{}, false
+	}
+	e := NonceEquivocation{First: first, Second: tx}
+	if _, err := e.Check(); err != nil {
+		return NonceEquivocation{}, false
+	}
+	return e, true
+}
+
+// CheckSlashingTransaction verifies evidence sent in transaction to slashing account.
+// Returns evidence and delegated account of offender.
+func CheckSlashingTransaction(tx transactionsDefinition.Transaction, height int64) (Evidence, int, error) {
+	if tx.TxData.Amount != 0 {
+		return nil, 0, fmt.Errorf("slashing transaction cannot transfer coins")
+	}
+	e, err := EvidenceFromBytes(tx.TxData.OptData)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := e.Check()
+	if err != nil {
+		return nil, 0, err
+	}
+	evidenceHeight := e.GetHeight()
+	if evidenceHeight > height || height-evidenceHeight > common.SlashingEvidenceMaxAge {
+		return nil, 0, fmt.Errorf("evidence of height %v is too old", evidenceHeight)
+	}
+	offender := e.GetOffender()
+	sender := tx.GetSenderAddress()
+	if bytes.Equal(offender.GetBytes(), sender.GetBytes()) {
+		return nil, 0, fmt.Errorf("offender cannot report itself")
+	}
+	acc := account.GetStakingAccountByAddressBytes(offender.GetBytes(), n)
+	if !bytes.Equal(acc.Address[:], offender.GetBytes()) {
+		return nil, 0, fmt.Errorf("offender has no stake in delegated account %v", n)
+	}
+	if account.IsSlashed(offender.GetBytes(), n, evidenceHeight) {
+		return nil, 0, fmt.Errorf("offender was already slashed for height %v", evidenceHeight)
+	}
+	return e, n, nil
+}
+
+// ProcessSlashingTransaction slashes offender, part of slashed coins goes to reporter and the rest stays
+// on slashing account, which nobody can spend.
+func ProcessSlashingTransaction(tx transactionsDefinition.Transaction, height int64) error {
+	fee := tx.GasPrice * tx.GasUsage
+	e, n, err := CheckSlashingTransaction(tx, height)
+	if err != nil {
+		return err
+	}
+	offender := e.GetOffender()
+	slashed, err := account.Slash(offender.GetBytes(), n, e.GetHeight(), height)
+	if err != nil {
+		return err
+	}
+	reward := slashed / 1000 * common.SlashingReporterPermille
+	err = AddBalance(tx.GetSenderAddress().ByteValue, reward-fee)
+	if err != nil {
+		return err
+	}
+	err = AddBalance(common.GetDelegatedAccountAddress(common.SlashingAccountID).ByteValue, slashed-reward)
+	if err != nil {
+		return err
+	}
+	logger.GetLogger().Println("operator", offender.GetHex(), "slashed", slashed, "for equivocation at height", e.GetHeight())
+	return nil
+}
@@ -0,0 +1,51 @@
+package blocks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/transactionsDefinition"
+)
+
+func TestNonceEquivocation(t *testing.T) {
+	nonce := func(height int64, voted byte, oracle byte) transactionsDefinition.Transaction {
+		optData := append(common.GetByteInt64(height-1), make([]byte, common.HashLength)...)
+		optData[8] = voted
+		tx := transactionsDefinition.Transaction{
+			TxData: transactionsDefinition.TxData{
+				Recipient: common.GetDelegatedAccountAddress(5),
+				OptData:   append(optData, oracle),
+			},
+			TxParam: transactionsDefinition.TxParam{Sender: common.Address{ByteValue: [common.AddressLength]byte{1}}},
+			Height:  height,
+		}
+		assert.NoError(t, tx.CalcHashAndSet())
+		return tx
+	}
+	height := common.SlashingForkHeight + 10
+
+	// the same nonce sent again or nonces voting for different blocks after reorg are not evidence
+	_, ok := FindNonceEquivocation(nonce(height, 1, 1))
+	assert.False(t, ok)
+	_, ok = FindNonceEquivocation(nonce(height, 1, 1))
+	assert.False(t, ok)
+	_, ok = FindNonceEquivocation(nonce(height, 2, 1))
+	assert.False(t, ok)
+
+	_, err := NonceEquivocation{First: nonce(height, 1, 1), Second: nonce(height, 1, 1)}.Check()
+	assert.Error(t, err)
+	_, err = NonceEquivocation{First: nonce(height, 1, 1), Second: nonce(height, 2, 2)}.Check()
+	assert.Error(t, err)
+	_, err = NonceEquivocation{First: nonce(height, 1, 1), Second: nonce(height+1, 1, 2)}.Check()
+	assert.Error(t, err)
+	if common.SlashingForkHeight > 0 {
+		_, err = NonceEquivocation{First: nonce(common.SlashingForkHeight-1, 1, 1), Second: nonce(common.SlashingForkHeight-1, 1, 2)}.Check()
+		assert.ErrorContains(t, err, "fork height")
+	}
+
+	_, err = EvidenceFromBytes([]byte{'X'})
+	assert.Error(t, err)
+	_, err = EquivocationFromBytes(NonceEquivocation{}.GetBytes())
+	assert.Error(t, err)
+}
@@ -0,0 +1,664 @@
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/stateDB"
+	"github.com/wonabru/qwid-node/database"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+// Snapshot is whole state after block at snapshot height: accounts, staking and dex accounts, contracts state
+// and pubkeys tries. State is written as records sorted by key and cut into chunks, root of snapshot
+// is hash of chunks hashes and it is included in block SnapshotCommitDelay blocks later.
+
+// keys of snapshot records, pubkeys tries are copied as DB key and value
+var (
+	snapshotAccountKey       = [2]byte{'A', 'C'}
+	snapshotStakingKey       = [2]byte{'S', 'A'}
+	snapshotDexKey           = [2]byte{'D', 'A'}
+	snapshotBlockFeeKey      = [2]byte{'B', 'F'}
+	snapshotVMAccountKey     = [2]byte{'E', 'A'}
+	snapshotVMCodeKey        = [2]byte{'E', 'C'}
+	snapshotVMCodeHashKey    = [2]byte{'E', 'H'}
+	snapshotVMNonceKey       = [2]byte{'E', 'N'}
+	snapshotVMStorageKey     = [2]byte{'E', 'S'}
+	snapshotVMPreimageKey    = [2]byte{'E', 'P'}
+	snapshotVMTokenBalance   = [2]byte{'E', 'B'}
+	snapshotVMTokenKey       = [2]byte{'E', 'T'}
+	snapshotDBKey            = [2]byte{'K', 'V'}
+	snapshotDBPrefixesCopied = [][2]byte{
+		common.PubKeyMarshalDBPrefix,
+		common.PubKeyMerkleTrieDBPrefix,
+		common.PubKeyRootHashMerkleTreeDBPrefix,
+		common.PubKeyBytesMerkleTrieDBPrefix,
+	}
+)
+
+type snapshotRecord struct {
+	Key   []byte
+	Value []byte
+}
+
+// SnapshotManifest describes snapshot, Block is full block at snapshot height
+type SnapshotManifest struct {
+	Height      int64         `json:"height"`
+	BlockHash   common.Hash   `json:"block_hash"`
+	ChunkHashes []common.Hash `json:"chunk_hashes"`
+	Block       []byte        `json:"block"`
+}
+
+// IsSnapshotHeight tells whether snapshot of state is made after block at height
+func IsSnapshotHeight(height int64) bool {
+	return height > 0 && height%common.SnapshotInterval == 0
+}
+
+// IsSnapshotCommitHeight tells whether block at height has to include root of snapshot
+func IsSnapshotCommitHeight(height int64) bool {
+	return IsSnapshotHeight(height - common.SnapshotCommitDelay)
+}
+
+// Root commits to snapshot height, block and all chunks
+func (m SnapshotManifest) Root() (common.Hash, error) {
+	b := common.GetByteInt64(m.Height)
+	b = append(b, m.BlockHash.GetBytes()...)
+	for _, h := range m.ChunkHashes {
+		b = append(b, h.GetBytes()...)
+	}
+	return common.CalcHashFromBytes(b)
+}
+
+func (m SnapshotManifest) GetBytes() []byte {
+	b := common.GetByteInt64(m.Height)
+	b = append(b, m.BlockHash.GetBytes()...)
+	b = append(b, common.GetByteInt64(int64(len(m.ChunkHashes)))...)
+	for _, h := range m.ChunkHashes {
+		b = append(b, h.GetBytes()...)
+	}
+	b = append(b, common.BytesToLenAndBytes(m.Block)...)
+	return b
+}
+
+func (m SnapshotManifest) GetFromBytes(b []byte) (SnapshotManifest, error) {
+	if len(b) < 48 {
+		return SnapshotManifest{}, fmt.Errorf("not enough bytes to decode snapshot manifest")
+	}
+	m.Height = common.GetInt64FromByte(b[:8])
+	m.BlockHash = common.GetHashFromBytes(b[8:40])
+	n := common.GetInt64FromByte(b[40:48])
+	b = b[48:]
+	if n < 0 || int64(len(b)) < n*int64(common.HashLength) {
+		return SnapshotManifest{}, fmt.Errorf("wrong number of chunks in snapshot manifest")
+	}
+	m.ChunkHashes = make([]common.Hash, n)
+	for i := range m.ChunkHashes {
+		m.ChunkHashes[i] = common.GetHashFromBytes(b[:common.HashLength])
+		b = b[common.HashLength:]
+	}
+	bl, left, err := common.BytesWithLenToBytes(b)
+	if err != nil {
+		return SnapshotManifest{}, err
+	}
+	if len(left) != 0 {
+		return SnapshotManifest{}, fmt.Errorf("too many bytes in snapshot manifest")
+	}
+	m.Block = bl
+	return m, nil
+}
+
+// Check makes checks of manifest which do not need header chain, returns snapshot block
+func (m SnapshotManifest) Check() (Block, error) {
+	if !IsSnapshotHeight(m.Height) {
+		return Block{}, fmt.Errorf("%d is not snapshot height", m.Height)
+	}
+	if len(m.ChunkHashes) == 0 {
+		return Block{}, fmt.Errorf("snapshot has no chunks")
+	}
+	bl, err := Block{}.GetFromBytes(m.Block)
+	if err != nil {
+		return Block{}, err
+	}
+	hash, err := bl.CalcBlockHash()
+	if err != nil {
+		return Block{}, err
+	}
+	if bl.GetHeader().Height != m.Height || !bytes.Equal(hash.GetBytes(), m.BlockHash.GetBytes()) ||
+		!bytes.Equal(bl.BlockHash.GetBytes(), m.BlockHash.GetBytes()) {
+		return Block{}, fmt.Errorf("snapshot block does not match manifest")
+	}
+	return bl, nil
+}
+
+func snapshotKey(prefix [2]byte, parts ...[]byte) []byte {
+	k := append([]byte{}, prefix[:]...)
+	for _, p := range parts {
+		k = append(k, p...)
+	}
+	return k
+}
+
+func sortSnapshotRecords(recs []snapshotRecord) {
+	sort.Slice(recs, func(i, j int) bool {
+		return bytes.Compare(recs[i].Key, recs[j].Key) < 0
+	})
+}
+
+// collectSnapshotRecords reads current state, should be called after block at snapshot height is processed
+func collectSnapshotRecords(blockFee int64) ([]snapshotRecord, error) {
+	recs := []snapshotRecord{{Key: snapshotBlockFeeKey[:], Value: common.GetByteInt64(blockFee)}}
+	recs = append(recs, collectAccountsRecords()...)
+	recs = append(recs, collectStakingRecords()...)
+	recs = append(recs, collectDexRecords()...)
+	recs = append(recs, collectContractsRecords()...)
+	for _, prefix := range snapshotDBPrefixesCopied {
+		keys, err := database.MainDB.LoadAllKeys(prefix[:])
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			v, err := database.MainDB.Get(k)
+			if err != nil {
+				return nil, err
+			}
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotDBKey, k), v})
+		}
+	}
+	sortSnapshotRecords(recs)
+	return recs, nil
+}
+
+func collectAccountsRecords() []snapshotRecord {
+	recs := []snapshotRecord{}
+	account.AccountsRWMutex.RLock()
+	for a, acc := range account.Accounts.AllAccounts {
+		recs = append(recs, snapshotRecord{snapshotKey(snapshotAccountKey, a[:]), acc.Marshal()})
+	}
+	account.AccountsRWMutex.RUnlock()
+	return recs
+}
+
+func collectStakingRecords() []snapshotRecord {
+	recs := []snapshotRecord{}
+	account.StakingRWMutex.RLock()
+	for i := range account.StakingAccounts {
+		for a, sa := range account.StakingAccounts[i].AllStakingAccounts {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotStakingKey, []byte{byte(i)}, a[:]), sa.Marshal()})
+		}
+	}
+	account.StakingRWMutex.RUnlock()
+	return recs
+}
+
+func collectDexRecords() []snapshotRecord {
+	recs := []snapshotRecord{}
+	account.DexRWMutex.RLock()
+	for a, da := range account.DexAccounts.AllDexAccounts {
+		recs = append(recs, snapshotRecord{snapshotKey(snapshotDexKey, a[:]), da.Marshal()})
+	}
+	account.DexRWMutex.RUnlock()
+	return recs
+}
+
+func collectContractsRecords() []snapshotRecord {
+	recs := []snapshotRecord{}
+	StateMutex.RLock()
+	for a, acc := range State.Accounts {
+		recs = append(recs, snapshotRecord{snapshotKey(snapshotVMAccountKey, a[:]), acc.Marshal()})
+	}
+	for a, c := range State.Codes {
+		recs = append(recs, snapshotRecord{snapshotKey(snapshotVMCodeKey, a[:]), c})
+	}
+	for a, h := range State.CodeHashes {
+		recs = append(recs, snapshotRecord{snapshotKey(snapshotVMCodeHashKey, a[:]), h.GetBytes()})
+	}
+	for a, n := range State.Nonces {
+		recs = append(recs, snapshotRecord{snapshotKey(snapshotVMNonceKey, a[:]), common.GetByteInt64(int64(n))})
+	}
+	for a, slots := range State.StatesHashes {
+		for k, v := range slots {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotVMStorageKey, a[:], k.GetBytes()), v.GetBytes()})
+		}
+	}
+	for h, v := range State.States {
+		recs = append(recs, snapshotRecord{snapshotKey(snapshotVMPreimageKey, h.GetBytes()), v})
+	}
+	for t, balances := range State.Balances {
+		for a, v := range balances {
+			recs = append(recs, snapshotRecord{snapshotKey(snapshotVMTokenBalance, t[:], a[:]), common.GetByteInt64(v)})
+		}
+	}
+	for a, ti := range State.Tokens {
+		recs = append(recs, snapshotRecord{snapshotKey(snapshotVMTokenKey, a[:]), tokenInfoBytes(ti)})
+	}
+	StateMutex.RUnlock()
+	return recs
+}
+
+func tokenInfoBytes(ti stateDB.TokenInfo) []byte {
+	v := common.BytesToLenAndBytes([]byte(ti.Name))
+	v = append(v, common.BytesToLenAndBytes([]byte(ti.Symbols))...)
+	return append(v, ti.Decimals)
+}
+
+// chunkSnapshotRecords cuts sorted records into chunks of about common.SnapshotChunkSize bytes
+func chunkSnapshotRecords(recs []snapshotRecord) [][]byte {
+	chunks := [][]byte{}
+	chunk := []byte{}
+	for _, r := range recs {
+		chunk = append(chunk, common.BytesToLenAndBytes(r.Key)...)
+		chunk = append(chunk, common.BytesToLenAndBytes(r.Value)...)
+		if len(chunk) >= common.SnapshotChunkSize {
+			chunks = append(chunks, chunk)
+			chunk = []byte{}
+		}
+	}
+	if len(chunk) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func parseSnapshotChunk(chunk []byte) ([]snapshotRecord, error) {
+	recs := []snapshotRecord{}
+	for len(chunk) > 0 {
+		k, left, err := common.BytesWithLenToBytes(chunk)
+		if err != nil {
+			return nil, err
+		}
+		v, left, err := common.BytesWithLenToBytes(left)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, snapshotRecord{k, v})
+		chunk = left
+	}
+	return recs, nil
+}
+
+// NewSnapshot makes snapshot of current state after block bl
+func NewSnapshot(bl Block) (SnapshotManifest, [][]byte, error) {
+	recs, err := collectSnapshotRecords(bl.BlockFee)
+	if err != nil {
+		return SnapshotManifest{}, nil, err
+	}
+	chunks := chunkSnapshotRecords(recs)
+	m := SnapshotManifest{
+		Height:    bl.GetHeader().Height,
+		BlockHash: bl.GetBlockHash(),
+		Block:     bl.GetBytes(),
+	}
+	for _, c := range chunks {
+		h, err := common.CalcHashFromBytes(c)
+		if err != nil {
+			return SnapshotManifest{}, nil, err
+		}
+		m.ChunkHashes = append(m.ChunkHashes, h)
+	}
+	return m, chunks, nil
+}
+
+// MakeSnapshot stores snapshot of state after block bl and removes older snapshots
+func MakeSnapshot(bl Block) error {
+	m, chunks, err := NewSnapshot(bl)
+	if err != nil {
+		return err
+	}
+	// snapshot of other branch could be stored at the same height
+	err = RemoveSnapshot(m.Height)
+	if err != nil && err.Error() != "key not found" {
+		return err
+	}
+	for i, c := range chunks {
+		err = StoreSnapshotChunk(m.Height, i, c)
+		if err != nil {
+			return err
+		}
+	}
+	err = StoreSnapshotManifest(m)
+	if err != nil {
+		return err
+	}
+	root, _ := m.Root()
+	logger.GetLogger().Printf("snapshot at height %d, %d chunks, root %x", m.Height, len(chunks), root.GetBytes())
+	PruneSnapshots(m.Height)
+	return nil
+}
+
+func StoreSnapshotManifest(m SnapshotManifest) error {
+	return database.MainDB.Put(heightKey(common.SnapshotManifestDBPrefix, m.Height), m.GetBytes())
+}
+
+func LoadSnapshotManifest(height int64) (SnapshotManifest, error) {
+	b, err := database.MainDB.Get(heightKey(common.SnapshotManifestDBPrefix, height))
+	if err != nil {
+		return SnapshotManifest{}, err
+	}
+	return SnapshotManifest{}.GetFromBytes(b)
+}
+
+func snapshotChunkKey(height int64, index int) []byte {
+	return append(heightKey(common.SnapshotChunkDBPrefix, height), common.GetByteInt32(int32(index))...)
+}
+
+func StoreSnapshotChunk(height int64, index int, chunk []byte) error {
+	return database.MainDB.Put(snapshotChunkKey(height, index), chunk)
+}
+
+func LoadSnapshotChunk(height int64, index int) ([]byte, error) {
+	return database.MainDB.Get(snapshotChunkKey(height, index))
+}
+
+// LoadSnapshotManifests returns snapshots stored, ex. to offer them to peers
+func LoadSnapshotManifests() []SnapshotManifest {
+	values, err := database.MainDB.LoadAll(common.SnapshotManifestDBPrefix[:])
+	if err != nil {
+		logger.GetLogger().Println(err)
+		return nil
+	}
+	ms := []SnapshotManifest{}
+	for _, v := range values {
+		m, err := SnapshotManifest{}.GetFromBytes(v)
+		if err != nil {
+			continue
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// RemoveSnapshot removes manifest and chunks of snapshot at height
+func RemoveSnapshot(height int64) error {
+	keys, err := database.MainDB.LoadAllKeys(heightKey(common.SnapshotChunkDBPrefix, height))
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		err = database.MainDB.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return database.MainDB.Delete(heightKey(common.SnapshotManifestDBPrefix, height))
+}
+
+// PruneSnapshots keeps only snapshot at height and the previous one
+func PruneSnapshots(height int64) {
+	for _, m := range LoadSnapshotManifests() {
+		if m.Height < height-common.SnapshotInterval || m.Height > height {
+			err := RemoveSnapshot(m.Height)
+			if err != nil {
+				logger.GetLogger().Println(err)
+			}
+		}
+	}
+}
+
+// SnapshotRootForBlock returns root which block at height should include, empty hash at other heights.
+// Returns false when snapshot should be included but it is not known.
+func SnapshotRootForBlock(height int64) (common.Hash, bool) {
+	if !IsSnapshotCommitHeight(height) || !IsStateRootHeight(height) {
+		return common.Hash{}, true
+	}
+	sh := height - common.SnapshotCommitDelay
+	m, err := LoadSnapshotManifest(sh)
+	if err != nil || !IsOnMainChain(m.BlockHash.GetBytes(), sh) {
+		return common.Hash{}, false
+	}
+	root, err := m.Root()
+	if err != nil {
+		return common.Hash{}, false
+	}
+	return root, true
+}
+
+// checkSnapshotRoot compares root included in block with snapshot made locally. Node which has no snapshot
+// (ex. it was restarted after snapshot height) accepts root, so it can still follow the chain.
+func checkSnapshotRoot(bl Block) error {
+	height := bl.GetHeader().Height
+	root := bl.BaseBlock.SnapshotRoot
+	if !IsSnapshotCommitHeight(height) || !IsStateRootHeight(height) {
+		if !bytes.Equal(root.GetBytes(), common.EmptyHash().GetBytes()) {
+			return fmt.Errorf("snapshot root is not allowed at height %d", height)
+		}
+		return nil
+	}
+	expected, ok := SnapshotRootForBlock(height)
+	if !ok {
+		logger.GetLogger().Println("no local snapshot to check root in block", height)
+		return nil
+	}
+	if !bytes.Equal(root.GetBytes(), expected.GetBytes()) {
+		return fmt.Errorf("snapshot root in block %d is %x, local snapshot root is %x", height, root.GetBytes(), expected.GetBytes())
+	}
+	return nil
+}
+
+// RestoreSnapshot replaces current state with snapshot, chunks have to be checked against manifest before.
+// Should be called with common.BlockMutex locked.
+func RestoreSnapshot(m SnapshotManifest, chunks [][]byte) error {
+	bl, err := m.Check()
+	if err != nil {
+		return err
+	}
+	accounts := account.AccountsType{AllAccounts: map[[common.AddressLength]byte]account.Account{}, Height: m.Height}
+	staking := [256]account.StakingAccountsType{}
+	for i := range staking {
+		staking[i].AllStakingAccounts = map[[common.AddressLength]byte]account.StakingAccount{}
+	}
+	dex := account.DexAccountsType{AllDexAccounts: map[[common.AddressLength]byte]account.DexAccount{}}
+	state := stateDB.CreateStateDB()
+	dbRecords := []snapshotRecord{}
+
+	for _, c := range chunks {
+		recs, err := parseSnapshotChunk(c)
+		if err != nil {
+			return err
+		}
+		for _, r := range recs {
+			err = restoreSnapshotRecord(r, &accounts, &staking, &dex, &state, &bl)
+			if err != nil {
+				return err
+			}
+			if bytes.HasPrefix(r.Key, snapshotDBKey[:]) {
+				dbRecords = append(dbRecords, snapshotRecord{r.Key[2:], r.Value})
+			}
+		}
+	}
+	for _, r := range dbRecords {
+		err = database.MainDB.Put(r.Key, r.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	account.AccountsRWMutex.Lock()
+	account.Accounts = accounts
+	account.AccountsRWMutex.Unlock()
+	account.StakingRWMutex.Lock()
+	account.StakingAccounts = staking
+	account.StakingRWMutex.Unlock()
+	account.DexRWMutex.Lock()
+	account.DexAccounts = dex
+	account.DexRWMutex.Unlock()
+	account.StateReplaced()
+	StateMutex.Lock()
+	State = state
+	StateMutex.Unlock()
+
+	err = bl.StoreBlock()
+	if err != nil {
+		return err
+	}
+	err = account.StoreAccounts(m.Height)
+	if err != nil {
+		return err
+	}
+	err = account.StoreStakingAccounts(m.Height)
+	if err != nil {
+		return err
+	}
+	err = account.StoreDexAccounts(m.Height)
+	if err != nil {
+		return err
+	}
+	err = StoreStateDB(m.Height)
+	if err != nil {
+		return err
+	}
+	err = StoreBaseHeight(m.Height)
+	if err != nil {
+		return err
+	}
+	err = SetEncryptionFromBlock(m.Height)
+	if err != nil {
+		return err
+	}
+	common.SetHeight(m.Height)
+	return nil
+}
+
+func restoreSnapshotRecord(r snapshotRecord, accounts *account.AccountsType, staking *[256]account.StakingAccountsType,
+	dex *account.DexAccountsType, state *stateDB.StateAccount, bl *Block) error {
+	if len(r.Key) < 2 {
+		return fmt.Errorf("wrong snapshot record key")
+	}
+	kind := [2]byte{r.Key[0], r.Key[1]}
+	k := r.Key[2:]
+	addr := func(b []byte) [common.AddressLength]byte {
+		a := [common.AddressLength]byte{}
+		copy(a[:], b)
+		return a
+	}
+	wrongKey := fmt.Errorf("wrong length of snapshot record key %c%c", kind[0], kind[1])
+	switch kind {
+	case snapshotBlockFeeKey:
+		if len(r.Value) != 8 {
+			return fmt.Errorf("wrong block fee in snapshot")
+		}
+		bl.BlockFee = common.GetInt64FromByte(r.Value)
+	case snapshotAccountKey, snapshotVMAccountKey:
+		if len(k) != common.AddressLength {
+			return wrongKey
+		}
+		acc := account.Account{}
+		if err := acc.Unmarshal(r.Value); err != nil {
+			return err
+		}
+		if kind == snapshotAccountKey {
+			accounts.AllAccounts[addr(k)] = acc
+		} else {
+			state.Accounts[addr(k)] = acc
+		}
+	case snapshotStakingKey:
+		if len(k) != 1+common.AddressLength {
+			return wrongKey
+		}
+		sa := account.StakingAccount{}
+		if err := sa.Unmarshal(r.Value); err != nil {
+			return err
+		}
+		staking[k[0]].AllStakingAccounts[addr(k[1:])] = sa
+	case snapshotDexKey:
+		if len(k) != common.AddressLength {
+			return wrongKey
+		}
+		da := account.DexAccount{}
+		if err := da.Unmarshal(r.Value); err != nil {
+			return err
+		}
+		dex.AllDexAccounts[addr(k)] = da
+	case snapshotVMCodeKey:
+		if len(k) != common.AddressLength {
+			return wrongKey
+		}
+		state.Codes[addr(k)] = r.Value
+	case snapshotVMCodeHashKey:
+		if len(k) != common.AddressLength || len(r.Value) != common.HashLength {
+			return wrongKey
+		}
+		state.CodeHashes[addr(k)] = common.GetHashFromBytes(r.Value)
+	case snapshotVMNonceKey:
+		if len(k) != common.AddressLength || len(r.Value) != 8 {
+			return wrongKey
+		}
+		state.Nonces[addr(k)] = uint64(common.GetInt64FromByte(r.Value))
+	case snapshotVMStorageKey:
+		if len(k) != common.AddressLength+common.HashLength || len(r.Value) != common.HashLength {
+			return wrongKey
+		}
+		a := addr(k)
+		if _, ok := state.StatesHashes[a]; !ok {
+			state.StatesHashes[a] = map[common.Hash]common.Hash{}
+		}
+		state.StatesHashes[a][common.GetHashFromBytes(k[common.AddressLength:])] = common.GetHashFromBytes(r.Value)
+	case snapshotVMPreimageKey:
+		if len(k) != common.HashLength {
+			return wrongKey
+		}
+		state.States[common.GetHashFromBytes(k)] = r.Value
+	case snapshotVMTokenBalance:
+		if len(k) != 2*common.AddressLength || len(r.Value) != 8 {
+			return wrongKey
+		}
+		t := addr(k)
+		if _, ok := state.Balances[t]; !ok {
+			state.Balances[t] = map[[common.AddressLength]byte]int64{}
+		}
+		state.Balances[t][addr(k[common.AddressLength:])] = common.GetInt64FromByte(r.Value)
+	case snapshotVMTokenKey:
+		if len(k) != common.AddressLength {
+			return wrongKey
+		}
+		name, left, err := common.BytesWithLenToBytes(r.Value)
+		if err != nil {
+			return err
+		}
+		symbol, left, err := common.BytesWithLenToBytes(left)
+		if err != nil {
+			return err
+		}
+		if len(left) != 1 {
+			return fmt.Errorf("wrong token info in snapshot")
+		}
+		state.Tokens[addr(k)] = stateDB.TokenInfo{Name: string(name), Symbols: string(symbol), Decimals: left[0]}
+	case snapshotDBKey:
+		if len(k) < 2 || !isSnapshotDBPrefix([2]byte{k[0], k[1]}) {
+			return fmt.Errorf("database key not allowed in snapshot")
+		}
+	default:
+		return fmt.Errorf("unknown snapshot record %c%c", kind[0], kind[1])
+	}
+	return nil
+}
+
+func isSnapshotDBPrefix(prefix [2]byte) bool {
+	for _, p := range snapshotDBPrefixesCopied {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// StoreBaseHeight remembers that blocks and state below height are not stored, because node started from snapshot
+func StoreBaseHeight(height int64) error {
+	err := database.MainDB.Put(common.BaseHeightDBPrefix[:], common.GetByteInt64(height))
+	if err != nil {
+		return err
+	}
+	common.SetBaseHeight(height)
+	return nil
+}
+
+// LoadBaseHeight sets base height stored in database, it is 0 for node synced from genesis
+func LoadBaseHeight() {
+	b, err := database.MainDB.Get(common.BaseHeightDBPrefix[:])
+	if err != nil || len(b) != 8 {
+		return
+	}
+	common.SetBaseHeight(common.GetInt64FromByte(b))
+}
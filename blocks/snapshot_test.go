@@ -0,0 +1,81 @@
+package blocks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/stateDB"
+)
+
+func TestSnapshotHeights(t *testing.T) {
+	assert.False(t, IsSnapshotHeight(0))
+	assert.True(t, IsSnapshotHeight(common.SnapshotInterval))
+	assert.False(t, IsSnapshotHeight(common.SnapshotInterval+1))
+	assert.True(t, IsSnapshotCommitHeight(common.SnapshotInterval+common.SnapshotCommitDelay))
+	assert.False(t, IsSnapshotCommitHeight(common.SnapshotCommitDelay))
+	assert.False(t, IsSnapshotCommitHeight(common.SnapshotInterval))
+}
+
+func TestSnapshotManifestBytes(t *testing.T) {
+	m := SnapshotManifest{
+		Height:      common.SnapshotInterval,
+		BlockHash:   common.GetHashFromBytes(make([]byte, 32)),
+		ChunkHashes: []common.Hash{common.GetHashFromBytes([]byte("01234567890123456789012345678901"))},
+		Block:       []byte{1, 2, 3},
+	}
+	m2, err := SnapshotManifest{}.GetFromBytes(m.GetBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, m, m2)
+
+	r1, err := m.Root()
+	assert.NoError(t, err)
+	m2.ChunkHashes = append(m2.ChunkHashes, m.BlockHash)
+	r2, err := m2.Root()
+	assert.NoError(t, err)
+	assert.NotEqual(t, r1, r2)
+
+	_, err = SnapshotManifest{}.GetFromBytes(m.GetBytes()[:50])
+	assert.Error(t, err)
+}
+
+func TestSnapshotChunks(t *testing.T) {
+	recs := []snapshotRecord{
+		{Key: []byte("AC1"), Value: []byte{1}},
+		{Key: []byte("AC2"), Value: []byte{}},
+		{Key: []byte("BF"), Value: common.GetByteInt64(7)},
+	}
+	chunks := chunkSnapshotRecords(recs)
+	assert.Len(t, chunks, 1)
+	parsed, err := parseSnapshotChunk(chunks[0])
+	assert.NoError(t, err)
+	assert.Len(t, parsed, 3)
+	assert.Equal(t, recs[2].Value, parsed[2].Value)
+
+	// empty state still gives one chunk, so root commits to it
+	assert.Len(t, chunkSnapshotRecords(nil), 1)
+}
+
+func TestRestoreSnapshotRecord(t *testing.T) {
+	accounts := account.AccountsType{AllAccounts: map[[common.AddressLength]byte]account.Account{}}
+	staking := [256]account.StakingAccountsType{}
+	dex := account.DexAccountsType{}
+	state := stateDB.CreateStateDB()
+	bl := Block{}
+
+	err := restoreSnapshotRecord(snapshotRecord{snapshotBlockFeeKey[:], common.GetByteInt64(5)}, &accounts, &staking, &dex, &state, &bl)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), bl.BlockFee)
+
+	addr := make([]byte, common.AddressLength)
+	err = restoreSnapshotRecord(snapshotRecord{snapshotKey(snapshotVMNonceKey, addr), common.GetByteInt64(3)}, &accounts, &staking, &dex, &state, &bl)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), state.Nonces[[common.AddressLength]byte{}])
+
+	// only pubkeys tries can be written to database
+	err = restoreSnapshotRecord(snapshotRecord{snapshotKey(snapshotDBKey, common.BlocksDBPrefix[:]), []byte{1}}, &accounts, &staking, &dex, &state, &bl)
+	assert.Error(t, err)
+	err = restoreSnapshotRecord(snapshotRecord{snapshotKey(snapshotAccountKey, addr[:5]), []byte{1}}, &accounts, &staking, &dex, &state, &bl)
+	assert.Error(t, err)
+}
@@ -0,0 +1,360 @@
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/transactionsPool"
+)
+
+// StateRoots are hashes of parts of state, their hash is state root included in block after which state is,
+// so nodes which agree on block hashes agree on balances, stakes, dex pools and contracts as well
+type StateRoots struct {
+	Accounts  common.Hash `json:"accounts"`
+	Staking   common.Hash `json:"staking"`
+	Dex       common.Hash `json:"dex"`
+	Contracts common.Hash `json:"contracts"`
+}
+
+// stateTrees are merkle trees of parts of state after block at height. They follow chain by diffs
+// which blocks store, so whole state is hashed only at checkpoints and after reorganisation.
+type stateTrees struct {
+	height    int64
+	accounts  *stateTree
+	staking   *stateTree
+	dex       *stateTree
+	contracts *stateTree
+}
+
+var (
+	trees      = stateTrees{height: -1}
+	treesMutex sync.Mutex
+)
+
+// InvalidateStateTrees drops trees when state at height or above is removed
+func InvalidateStateTrees(height int64) {
+	treesMutex.Lock()
+	defer treesMutex.Unlock()
+	if trees.height >= height {
+		trees = stateTrees{height: -1}
+	}
+}
+
+func (t *stateTrees) rebuild(height int64) error {
+	var err error
+	nt := stateTrees{height: height}
+	if nt.accounts, err = newStateTree(collectAccountsRecords()); err != nil {
+		return err
+	}
+	if nt.staking, err = newStateTree(collectStakingRecords()); err != nil {
+		return err
+	}
+	if nt.dex, err = newStateTree(collectDexRecords()); err != nil {
+		return err
+	}
+	if nt.contracts, err = newStateTree(collectContractsRecords()); err != nil {
+		return err
+	}
+	*t = nt
+	return nil
+}
+
+func diffRecords(prefix [2]byte, sd account.StateDiff, parts ...[]byte) ([]snapshotRecord, [][]byte) {
+	updated := []snapshotRecord{}
+	for a, v := range sd.Updated {
+		updated = append(updated, snapshotRecord{snapshotKey(prefix, append(append([][]byte{}, parts...), a[:])...), v})
+	}
+	deleted := [][]byte{}
+	for _, a := range sd.Deleted {
+		deleted = append(deleted, snapshotKey(prefix, append(append([][]byte{}, parts...), a[:])...))
+	}
+	return updated, deleted
+}
+
+// update applies diffs stored by blocks after trees height up to height
+func (t *stateTrees) update(height int64) error {
+	if t.height < 0 || t.height > height || height-t.height > common.StateCheckpointInterval {
+		return fmt.Errorf("state trees at height %d cannot follow to height %d", t.height, height)
+	}
+	for h := t.height + 1; h <= height; h++ {
+		sd, err := account.AccountsDiffAt(h)
+		if err != nil {
+			return err
+		}
+		if err = t.accounts.update(diffRecords(snapshotAccountKey, sd)); err != nil {
+			return err
+		}
+		sds, err := account.StakingAccountsDiffsAt(h)
+		if err != nil {
+			return err
+		}
+		for i, sd := range sds {
+			if err = t.staking.update(diffRecords(snapshotStakingKey, sd, []byte{byte(i)})); err != nil {
+				return err
+			}
+		}
+		sd, err = account.DexAccountsDiffAt(h)
+		if err != nil {
+			return err
+		}
+		if err = t.dex.update(diffRecords(snapshotDexKey, sd)); err != nil {
+			return err
+		}
+		cd, err := contractsDiffAt(h)
+		if err != nil {
+			return err
+		}
+		if err = t.contracts.update(cd.Updated, cd.Deleted); err != nil {
+			return err
+		}
+		t.height = h
+	}
+	return nil
+}
+
+// currentStateTrees brings trees to current state, which is state after last block. Should be called with treesMutex locked.
+func currentStateTrees() error {
+	height := common.GetHeight()
+	if trees.height == height {
+		return nil
+	}
+	if err := trees.update(height); err != nil {
+		// at checkpoints full state is stored instead of diffs
+		trees = stateTrees{height: -1}
+		return trees.rebuild(height)
+	}
+	return nil
+}
+
+// applyChanged brings trees from state after previous block to current state. Only records of accounts
+// and contracts changed since state was stored are hashed. It returns false when whole state was replaced.
+func (t *stateTrees) applyChanged() (bool, error) {
+	accounts, all := account.ChangedAccounts()
+	staking, allStaking := account.ChangedStakingAccounts()
+	dex, allDex := account.ChangedDexAccounts()
+	StateMutex.RLock()
+	contracts, preimages, allContracts := State.ChangedState()
+	contractsPrefixes, contractsRecs := changedContractsRecords(contracts, preimages)
+	StateMutex.RUnlock()
+	if all || allStaking || allDex || allContracts {
+		return false, nil
+	}
+
+	prefixes, recs := [][]byte{}, []snapshotRecord{}
+	account.AccountsRWMutex.RLock()
+	for a := range accounts {
+		k := snapshotKey(snapshotAccountKey, a[:])
+		prefixes = append(prefixes, k)
+		if acc, ok := account.Accounts.AllAccounts[a]; ok {
+			recs = append(recs, snapshotRecord{k, acc.Marshal()})
+		}
+	}
+	account.AccountsRWMutex.RUnlock()
+	if err := t.accounts.replace(prefixes, recs); err != nil {
+		return false, err
+	}
+
+	prefixes, recs = [][]byte{}, []snapshotRecord{}
+	account.StakingRWMutex.RLock()
+	for a := range staking {
+		for i := range account.StakingAccounts {
+			k := snapshotKey(snapshotStakingKey, []byte{byte(i)}, a[:])
+			prefixes = append(prefixes, k)
+			if sa, ok := account.StakingAccounts[i].AllStakingAccounts[a]; ok {
+				recs = append(recs, snapshotRecord{k, sa.Marshal()})
+			}
+		}
+	}
+	account.StakingRWMutex.RUnlock()
+	if err := t.staking.replace(prefixes, recs); err != nil {
+		return false, err
+	}
+
+	prefixes, recs = [][]byte{}, []snapshotRecord{}
+	account.DexRWMutex.RLock()
+	for a := range dex {
+		k := snapshotKey(snapshotDexKey, a[:])
+		prefixes = append(prefixes, k)
+		if da, ok := account.DexAccounts.AllDexAccounts[a]; ok {
+			recs = append(recs, snapshotRecord{k, da.Marshal()})
+		}
+	}
+	account.DexRWMutex.RUnlock()
+	if err := t.dex.replace(prefixes, recs); err != nil {
+		return false, err
+	}
+
+	if err := t.contracts.replace(contractsPrefixes, contractsRecs); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pendingStateRoots returns roots of current state, which is state after block at height being processed
+// before it is stored. Trees follow the state, so they are already at height when diffs of block are stored.
+func pendingStateRoots(height int64) (StateRoots, error) {
+	treesMutex.Lock()
+	defer treesMutex.Unlock()
+	ok := trees.height == height-1 || trees.update(height-1) == nil
+	if ok {
+		applied, err := trees.applyChanged()
+		ok = applied && err == nil
+	}
+	if !ok {
+		// state after previous block is not known, so current state is hashed whole
+		trees = stateTrees{height: -1}
+		if err := trees.rebuild(height); err != nil {
+			return StateRoots{}, err
+		}
+	}
+	trees.height = height
+	return trees.roots(), nil
+}
+
+func (t *stateTrees) roots() StateRoots {
+	return StateRoots{
+		Accounts:  t.accounts.root(),
+		Staking:   t.staking.root(),
+		Dex:       t.dex.root(),
+		Contracts: t.contracts.root(),
+	}
+}
+
+// CalcStateRoots returns roots of parts of current state
+func CalcStateRoots() (StateRoots, error) {
+	treesMutex.Lock()
+	defer treesMutex.Unlock()
+	if err := currentStateTrees(); err != nil {
+		return StateRoots{}, err
+	}
+	return trees.roots(), nil
+}
+
+// Root is hash of all parts of state
+func (r StateRoots) Root() (common.Hash, error) {
+	b := r.Accounts.GetBytes()
+	b = append(b, r.Staking.GetBytes()...)
+	b = append(b, r.Dex.GetBytes()...)
+	b = append(b, r.Contracts.GetBytes()...)
+	return common.CalcHashFromBytes(b)
+}
+
+func (r StateRoots) GetString() string {
+	return fmt.Sprintf("accounts: %s, staking: %s, dex: %s, contracts: %s",
+		r.Accounts.GetHex(), r.Staking.GetHex(), r.Dex.GetHex(), r.Contracts.GetHex())
+}
+
+// CalcStateRoot returns root of current state, which is state after last block
+func CalcStateRoot() (common.Hash, error) {
+	r, err := CalcStateRoots()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return r.Root()
+}
+
+// checkStateRoot compares state root of new block with local state after its transactions and contracts.
+// Different root means that this node or block producer processed the block differently.
+func checkStateRoot(newBlock Block) error {
+	header := newBlock.GetHeader()
+	r, err := pendingStateRoots(header.Height)
+	if err != nil {
+		return err
+	}
+	root, err := r.Root()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(root.GetBytes(), newBlock.BaseBlock.StateRoot.GetBytes()) {
+		return nil
+	}
+	logger.GetLogger().Printf("STATE DIVERGENCE: block %d from operator %s has state root %s, local state after it has root %s (%s)",
+		header.Height, header.OperatorAccount.GetHex(), newBlock.BaseBlock.StateRoot.GetHex(), root.GetHex(), r.GetString())
+	return fmt.Errorf("state root of block %d does not match local state", header.Height)
+}
+
+// setStateRoot sets root of local state after transactions and contracts of block made by this node,
+// so block hash is calculated again
+func setStateRoot(newBlock *Block) error {
+	r, err := pendingStateRoots(newBlock.GetHeader().Height)
+	if err != nil {
+		return err
+	}
+	root, err := r.Root()
+	if err != nil {
+		return err
+	}
+	newBlock.BaseBlock.StateRoot = root
+	hash, err := newBlock.CalcBlockHash()
+	if err != nil {
+		return err
+	}
+	newBlock.BlockHash = hash
+	return nil
+}
+
+// AccountProof proves account in state after block at Height, it is checked with state root of that block
+type AccountProof struct {
+	Height   int64                      `json:"height"`
+	Address  [common.AddressLength]byte `json:"address"`
+	Account  []byte                     `json:"account"`
+	Index    int64                      `json:"index"`
+	Siblings []common.Hash              `json:"siblings"`
+	Roots    StateRoots                 `json:"roots"`
+}
+
+// NewAccountProof makes proof of account in current state from state trees kept for the last block,
+// should be called with common.BlockMutex locked
+func NewAccountProof(address [common.AddressLength]byte) (AccountProof, error) {
+	account.AccountsRWMutex.RLock()
+	acc, ok := account.Accounts.AllAccounts[address]
+	account.AccountsRWMutex.RUnlock()
+	if !ok {
+		return AccountProof{}, fmt.Errorf("account not found")
+	}
+	treesMutex.Lock()
+	defer treesMutex.Unlock()
+	if err := currentStateTrees(); err != nil {
+		return AccountProof{}, err
+	}
+	p := AccountProof{Height: trees.height, Address: address, Account: acc.Marshal(), Roots: trees.roots()}
+	index, siblings, err := trees.accounts.proof(snapshotKey(snapshotAccountKey, address[:]))
+	if err != nil {
+		return AccountProof{}, err
+	}
+	p.Index = index
+	p.Siblings = siblings
+	return p, nil
+}
+
+func (p AccountProof) leaf() []byte {
+	leaf := common.BytesToLenAndBytes(snapshotKey(snapshotAccountKey, p.Address[:]))
+	return append(leaf, common.BytesToLenAndBytes(p.Account)...)
+}
+
+// Verify checks proof against state root of trusted block Height and returns proven account
+func (p AccountProof) Verify(stateRoot []byte) (account.Account, error) {
+	root, err := p.Roots.Root()
+	if err != nil {
+		return account.Account{}, err
+	}
+	if !bytes.Equal(root.GetBytes(), stateRoot) {
+		return account.Account{}, fmt.Errorf("state roots in proof do not match state root of block %d", p.Height)
+	}
+	if !transactionsPool.VerifyMerkleProof(p.leaf(), p.Index, p.Siblings, p.Roots.Accounts.GetBytes()) {
+		return account.Account{}, fmt.Errorf("account proof fails")
+	}
+	acc := account.Account{}
+	err = acc.Unmarshal(p.Account)
+	if err != nil {
+		return account.Account{}, err
+	}
+	if acc.Address != p.Address {
+		return account.Account{}, fmt.Errorf("proven account has different address")
+	}
+	return acc, nil
+}
@@ -0,0 +1,95 @@
+package blocks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/stateDB"
+)
+
+func TestStateTreeOrder(t *testing.T) {
+	recs := []snapshotRecord{
+		{Key: []byte("AC2"), Value: []byte{2}},
+		{Key: []byte("AC1"), Value: []byte{1}},
+	}
+	t1, err := newStateTree(recs)
+	assert.NoError(t, err)
+	t2, err := newStateTree([]snapshotRecord{recs[1], recs[0]})
+	assert.NoError(t, err)
+	assert.Equal(t, t1.root(), t2.root())
+
+	recs[0].Value = []byte{3}
+	t3, err := newStateTree(recs)
+	assert.NoError(t, err)
+	assert.NotEqual(t, t1.root(), t3.root())
+}
+
+func TestStateRootsRoot(t *testing.T) {
+	r := StateRoots{}
+	root1, err := r.Root()
+	assert.NoError(t, err)
+	r.Dex = common.GetHashFromBytes([]byte("01234567890123456789012345678901"))
+	root2, err := r.Root()
+	assert.NoError(t, err)
+	assert.NotEqual(t, root1, root2)
+	r.Staking, r.Dex = r.Dex, common.Hash{}
+	root3, err := r.Root()
+	assert.NoError(t, err)
+	assert.NotEqual(t, root2, root3)
+}
+
+func TestAccountProof(t *testing.T) {
+	a1 := [common.AddressLength]byte{1}
+	a2 := [common.AddressLength]byte{2}
+	account.AccountsRWMutex.Lock()
+	account.Accounts = account.AccountsType{AllAccounts: map[[common.AddressLength]byte]account.Account{
+		a1: {Address: a1, Balance: 5},
+		a2: {Address: a2, Balance: 7},
+	}}
+	account.AccountsRWMutex.Unlock()
+
+	p, err := NewAccountProof(a2)
+	assert.NoError(t, err)
+	root, err := CalcStateRoot()
+	assert.NoError(t, err)
+	acc, err := p.Verify(root.GetBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), acc.Balance)
+
+	p.Address = a1
+	_, err = p.Verify(root.GetBytes())
+	assert.Error(t, err)
+
+	_, err = NewAccountProof([common.AddressLength]byte{3})
+	assert.Error(t, err)
+}
+
+func TestPendingStateRoots(t *testing.T) {
+	a1 := [common.AddressLength]byte{1}
+	a2 := [common.AddressLength]byte{2}
+	account.AccountsRWMutex.Lock()
+	account.Accounts = account.AccountsType{AllAccounts: map[[common.AddressLength]byte]account.Account{
+		a1: {Address: a1, Balance: 5},
+	}}
+	account.AccountsRWMutex.Unlock()
+	StateMutex.Lock()
+	State = stateDB.CreateStateDB()
+	State.TakeChangedState()
+	StateMutex.Unlock()
+	InvalidateStateTrees(0)
+	_, err := pendingStateRoots(1)
+	assert.NoError(t, err)
+
+	// block 2 changes balance of a1 and creates a2
+	account.SetBalance(a1, 3)
+	account.SetAccountByAddressBytes(a2[:])
+	account.SetBalance(a2, 2)
+	r, err := pendingStateRoots(2)
+	assert.NoError(t, err)
+
+	full := stateTrees{}
+	assert.NoError(t, full.rebuild(2))
+	assert.Equal(t, full.roots(), r)
+}
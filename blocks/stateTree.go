@@ -0,0 +1,175 @@
+package blocks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wonabru/qwid-node/common"
+)
+
+// stateTree is merkle tree of sorted records of one part of state, built the same way as
+// transactionsPool.NewMerkleTree. It keeps all levels, so change of few records rehashes only their paths.
+type stateTree struct {
+	keys   []string
+	levels [][][]byte // levels[0] are hashes of leaves, the last level is root
+}
+
+func recordLeaf(r snapshotRecord) []byte {
+	leaf := common.BytesToLenAndBytes(r.Key)
+	return append(leaf, common.BytesToLenAndBytes(r.Value)...)
+}
+
+func newStateTree(recs []snapshotRecord) (*stateTree, error) {
+	sortSnapshotRecords(recs)
+	t := &stateTree{keys: make([]string, 0, len(recs)), levels: [][][]byte{make([][]byte, 0, len(recs))}}
+	for _, r := range recs {
+		h, err := common.CalcHashToByte(recordLeaf(r))
+		if err != nil {
+			return nil, err
+		}
+		t.keys = append(t.keys, string(r.Key))
+		t.levels[0] = append(t.levels[0], h)
+	}
+	return t, t.rehash(map[int]bool{}, 0)
+}
+
+func (t *stateTree) find(key []byte) (int, bool) {
+	i := sort.SearchStrings(t.keys, string(key))
+	return i, i < len(t.keys) && t.keys[i] == string(key)
+}
+
+// update applies changed and removed records. Leaves after inserted or removed one change positions,
+// so all nodes from the first of them are rehashed, other changes rehash only paths to root.
+func (t *stateTree) update(updated []snapshotRecord, deleted [][]byte) error {
+	dirty := map[int]bool{}
+	from := len(t.keys)
+	for _, k := range deleted {
+		i, ok := t.find(k)
+		if !ok {
+			return fmt.Errorf("removed record %x is not in state tree", k)
+		}
+		t.keys = append(t.keys[:i], t.keys[i+1:]...)
+		t.levels[0] = append(t.levels[0][:i], t.levels[0][i+1:]...)
+		if i < from {
+			from = i
+		}
+	}
+	for _, r := range updated {
+		h, err := common.CalcHashToByte(recordLeaf(r))
+		if err != nil {
+			return err
+		}
+		i, ok := t.find(r.Key)
+		if ok {
+			t.levels[0][i] = h
+			dirty[i] = true
+			continue
+		}
+		t.keys = append(t.keys, "")
+		copy(t.keys[i+1:], t.keys[i:])
+		t.keys[i] = string(r.Key)
+		t.levels[0] = append(t.levels[0], nil)
+		copy(t.levels[0][i+1:], t.levels[0][i:])
+		t.levels[0][i] = h
+		if i < from {
+			from = i
+		}
+	}
+	return t.rehash(dirty, from)
+}
+
+// replace sets records under prefixes to recs, records under prefixes which are not in recs are removed
+func (t *stateTree) replace(prefixes [][]byte, recs []snapshotRecord) error {
+	current := map[string]bool{}
+	for _, r := range recs {
+		current[string(r.Key)] = true
+	}
+	deleted := [][]byte{}
+	for _, p := range prefixes {
+		for i, _ := t.find(p); i < len(t.keys) && strings.HasPrefix(t.keys[i], string(p)); i++ {
+			if !current[t.keys[i]] {
+				deleted = append(deleted, []byte(t.keys[i]))
+			}
+		}
+	}
+	return t.update(recs, deleted)
+}
+
+// rehash recalculates parents of dirty nodes and of all nodes from position from, level by level
+func (t *stateTree) rehash(dirty map[int]bool, from int) error {
+	l := 0
+	for ; len(t.levels[l]) > 1; l++ {
+		level := t.levels[l]
+		n := (len(level) + 1) / 2
+		if len(t.levels) == l+1 {
+			t.levels = append(t.levels, [][]byte{})
+		}
+		next := t.levels[l+1]
+		for len(next) < n {
+			next = append(next, nil)
+		}
+		next = next[:n]
+		parents := map[int]bool{}
+		for i := range dirty {
+			if i/2 < from/2 {
+				parents[i/2] = true
+			}
+		}
+		for j := range parents {
+			if err := t.hashNode(level, next, j); err != nil {
+				return err
+			}
+		}
+		for j := from / 2; j < n; j++ {
+			if err := t.hashNode(level, next, j); err != nil {
+				return err
+			}
+		}
+		t.levels[l+1] = next
+		dirty = parents
+		from /= 2
+	}
+	t.levels = t.levels[:l+1]
+	return nil
+}
+
+func (t *stateTree) hashNode(level, next [][]byte, j int) error {
+	left := level[2*j]
+	right := left
+	if 2*j+1 < len(level) {
+		right = level[2*j+1]
+	}
+	h, err := common.CalcHashToByte(append(append([]byte{}, left...), right...))
+	if err != nil {
+		return err
+	}
+	next[j] = h
+	return nil
+}
+
+func (t *stateTree) root() common.Hash {
+	if len(t.keys) == 0 {
+		return common.EmptyHash()
+	}
+	return common.GetHashFromBytes(t.levels[len(t.levels)-1][0])
+}
+
+// proof returns position of record and its merkle siblings, the same as transactionsPool.NewMerkleProof
+func (t *stateTree) proof(key []byte) (int64, []common.Hash, error) {
+	i, ok := t.find(key)
+	if !ok {
+		return 0, nil, fmt.Errorf("record not found")
+	}
+	index := int64(i)
+	siblings := []common.Hash{}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		s := i ^ 1
+		if s >= len(level) {
+			s = i
+		}
+		siblings = append(siblings, common.GetHashFromBytes(level[s]))
+		i /= 2
+	}
+	return index, siblings, nil
+}
@@ -0,0 +1,107 @@
+package blocks
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/transactionsPool"
+)
+
+func TestStateTreeUpdate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	state := map[string][]byte{}
+	records := func() []snapshotRecord {
+		recs := []snapshotRecord{}
+		for k, v := range state {
+			recs = append(recs, snapshotRecord{[]byte(k), v})
+		}
+		return recs
+	}
+	for i := 0; i < 25; i++ {
+		state[fmt.Sprintf("AC%03d", r.Intn(100))] = []byte{byte(i)}
+	}
+	tree, err := newStateTree(records())
+	assert.NoError(t, err)
+
+	for step := 0; step < 30; step++ {
+		updated, deleted := []snapshotRecord{}, [][]byte{}
+		seen := map[string]bool{}
+		for i := 0; i < 5; i++ {
+			k := fmt.Sprintf("AC%03d", r.Intn(100))
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			if _, ok := state[k]; ok && r.Intn(2) == 0 {
+				delete(state, k)
+				deleted = append(deleted, []byte(k))
+				continue
+			}
+			state[k] = []byte{byte(r.Intn(256))}
+			updated = append(updated, snapshotRecord{[]byte(k), state[k]})
+		}
+		assert.NoError(t, tree.update(updated, deleted))
+
+		// the same root and proofs as merkle tree built from whole state
+		recs := records()
+		sortSnapshotRecords(recs)
+		leaves := [][]byte{}
+		for _, r := range recs {
+			leaves = append(leaves, recordLeaf(r))
+		}
+		nodes, err := transactionsPool.NewMerkleTree(leaves)
+		assert.NoError(t, err)
+		root := common.GetHashFromBytes(nodes[0].Data)
+		assert.Equal(t, root, tree.root())
+		for k, v := range state {
+			index, siblings, err := tree.proof([]byte(k))
+			assert.NoError(t, err)
+			leaf := recordLeaf(snapshotRecord{[]byte(k), v})
+			assert.True(t, transactionsPool.VerifyMerkleProof(leaf, index, siblings, root.GetBytes()))
+		}
+	}
+
+	assert.Error(t, tree.update(nil, [][]byte{[]byte("AC999")}))
+	_, _, err = tree.proof([]byte("AC999"))
+	assert.Error(t, err)
+}
+
+func TestContractsDiff(t *testing.T) {
+	prev := map[string][]byte{"EA1": {1}, "EA2": {2}, "ES1": {3}}
+	curr := map[string][]byte{"EA1": {1}, "EA2": {5}, "EN1": {}}
+	d := newContractsDiff(prev, curr)
+	assert.Equal(t, []snapshotRecord{{[]byte("EA2"), []byte{5}}, {[]byte("EN1"), []byte{}}}, d.Updated)
+	assert.Equal(t, [][]byte{[]byte("ES1")}, d.Deleted)
+
+	restored := contractsDiff{}
+	assert.NoError(t, restored.Unmarshal(d.Marshal()))
+	assert.Equal(t, d, restored)
+	restored.apply(prev)
+	assert.Equal(t, curr, prev)
+}
+
+func TestStateTreeReplace(t *testing.T) {
+	recs := []snapshotRecord{
+		{Key: []byte("ES1a"), Value: []byte{1}},
+		{Key: []byte("ES1b"), Value: []byte{2}},
+		{Key: []byte("ES2a"), Value: []byte{3}},
+		{Key: []byte("EN1"), Value: []byte{4}},
+	}
+	tree, err := newStateTree(recs)
+	assert.NoError(t, err)
+
+	// slot b of address 1 is removed, slot c is added and nonce of address 1 is gone
+	current := []snapshotRecord{
+		{Key: []byte("ES1a"), Value: []byte{5}},
+		{Key: []byte("ES1c"), Value: []byte{6}},
+	}
+	assert.NoError(t, tree.replace([][]byte{[]byte("ES1"), []byte("EN1")}, current))
+
+	expected, err := newStateTree([]snapshotRecord{current[0], current[1], recs[2]})
+	assert.NoError(t, err)
+	assert.Equal(t, expected.root(), tree.root())
+	assert.Equal(t, expected.keys, tree.keys)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	clientrpc "github.com/wonabru/qwid-node/rpc/client"
+)
+
+// orderBookToJSON converts amounts of book to QWD and tokens, prices are QWD per token
+func orderBookToJSON(v blocks.OrderBookView) map[string]interface{} {
+	levels := func(ls []blocks.BookLevel) []map[string]interface{} {
+		r := []map[string]interface{}{}
+		for _, l := range ls {
+			r = append(r, map[string]interface{}{
+				"price":  account.Int64toFloat64(l.Price),
+				"amount": account.Int64toFloat64(l.Amount),
+				"orders": l.Orders,
+			})
+		}
+		return r
+	}
+	orders := []map[string]interface{}{}
+	for _, o := range v.Orders {
+		orders = append(orders, map[string]interface{}{
+			"id":     o.ID,
+			"owner":  o.Owner,
+			"sell":   o.Sell,
+			"amount": account.Int64toFloat64(o.Amount),
+			"price":  account.Int64toFloat64(o.Price),
+			"escrow": account.Int64toFloat64(o.Escrow),
+			"expiry": o.Expiry,
+		})
+	}
+	events := []map[string]interface{}{}
+	for _, e := range v.Events {
+		events = append(events, map[string]interface{}{
+			"height":  e.Height,
+			"txHash":  e.TxHash,
+			"kind":    e.Kind,
+			"id":      e.ID,
+			"owner":   e.Owner,
+			"sell":    e.Sell,
+			"amount":  account.Int64toFloat64(e.Amount),
+			"coin":    account.Int64toFloat64(e.Coin),
+			"price":   account.Int64toFloat64(e.Price),
+			"counter": e.Counter,
+			"refund":  account.Int64toFloat64(e.Refund),
+		})
+	}
+	return map[string]interface{}{
+		"token":  v.Token,
+		"bids":   levels(v.Bids),
+		"asks":   levels(v.Asks),
+		"orders": orders,
+		"events": events,
+	}
+}
+
+// GetOrderBook returns depth of order book of token with open orders and order events
+// (token, optional owner, from and to parameters)
+func GetOrderBook(w http.ResponseWriter, r *http.Request) {
+	token, err := hex.DecodeString(r.URL.Query().Get("token"))
+	if err != nil || len(token) != common.AddressLength {
+		jsonError(w, "Invalid token format (expected 40 hex characters)", http.StatusBadRequest)
+		return
+	}
+	from, to := int64(0), int64(-1)
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		from, err = strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			jsonError(w, "Invalid from format", http.StatusBadRequest)
+			return
+		}
+	}
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		to, err = strconv.ParseInt(toStr, 10, 64)
+		if err != nil {
+			jsonError(w, "Invalid to format", http.StatusBadRequest)
+			return
+		}
+	}
+	query := append(token, common.GetByteInt64(from)...)
+	query = append(query, common.GetByteInt64(to)...)
+	if ownerStr := r.URL.Query().Get("owner"); ownerStr != "" {
+		owner, err := hex.DecodeString(ownerStr)
+		if err != nil || len(owner) != common.AddressLength {
+			jsonError(w, "Invalid owner format (expected 40 hex characters)", http.StatusBadRequest)
+			return
+		}
+		query = append(query, owner...)
+	}
+
+	clientrpc.InRPC <- SignMessage(append([]byte("DORD"), query...))
+	reply := <-clientrpc.OutRPC
+	if bytes.Equal(reply, []byte("Timeout")) {
+		jsonError(w, "Timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if len(reply) < 2 || string(reply[:2]) != "OB" {
+		jsonError(w, string(reply), http.StatusNotFound)
+		return
+	}
+	v := blocks.OrderBookView{}
+	err = json.Unmarshal(reply[2:], &v)
+	if err != nil {
+		jsonError(w, "Failed to parse order book", http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, orderBookToJSON(v))
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/core/types"
+	clientrpc "github.com/wonabru/qwid-node/rpc/client"
+)
+
+func logToJSON(l *types.Log) map[string]interface{} {
+	topics := []string{}
+	for _, t := range l.Topics {
+		topics = append(topics, t.GetHex())
+	}
+	return map[string]interface{}{
+		"address":   l.Address.GetHex(),
+		"topics":    topics,
+		"data":      hex.EncodeToString(l.Data),
+		"height":    l.BlockNumber,
+		"blockHash": l.BlockHash.GetHex(),
+		"txHash":    l.TxHash.GetHex(),
+		"txIndex":   l.TxIndex,
+		"logIndex":  l.Index,
+	}
+}
+
+// GetLogs returns EVM logs of transaction (tx parameter) or of contract (address, from and to parameters)
+func GetLogs(w http.ResponseWriter, r *http.Request) {
+	query := []byte{}
+	if txStr := r.URL.Query().Get("tx"); txStr != "" {
+		b, err := hex.DecodeString(txStr)
+		if err != nil || len(b) != common.HashLength {
+			jsonError(w, "Invalid hash format (expected 64 hex characters)", http.StatusBadRequest)
+			return
+		}
+		query = b
+	} else if addrStr := r.URL.Query().Get("address"); addrStr != "" {
+		b, err := hex.DecodeString(addrStr)
+		if err != nil || len(b) != common.AddressLength {
+			jsonError(w, "Invalid address format (expected 40 hex characters)", http.StatusBadRequest)
+			return
+		}
+		to := int64(-1)
+		if toStr := r.URL.Query().Get("to"); toStr != "" {
+			to, err = strconv.ParseInt(toStr, 10, 64)
+			if err != nil {
+				jsonError(w, "Invalid to format", http.StatusBadRequest)
+				return
+			}
+		}
+		from := int64(0)
+		if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+			from, err = strconv.ParseInt(fromStr, 10, 64)
+			if err != nil {
+				jsonError(w, "Invalid from format", http.StatusBadRequest)
+				return
+			}
+		}
+		query = append(b, common.GetByteInt64(from)...)
+		query = append(query, common.GetByteInt64(to)...)
+	} else {
+		jsonError(w, "tx or address parameter required", http.StatusBadRequest)
+		return
+	}
+
+	clientrpc.InRPC <- SignMessage(append([]byte("LOGS"), query...))
+	reply := <-clientrpc.OutRPC
+	if bytes.Equal(reply, []byte("Timeout")) {
+		jsonError(w, "Timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if len(reply) < 2 || string(reply[:2]) != "LG" {
+		jsonError(w, string(reply), http.StatusNotFound)
+		return
+	}
+
+	logs := []*types.Log{}
+	err := json.Unmarshal(reply[2:], &logs)
+	if err != nil {
+		jsonError(w, "Failed to parse logs", http.StatusInternalServerError)
+		return
+	}
+	resp := []map[string]interface{}{}
+	for _, l := range logs {
+		resp = append(resp, logToJSON(l))
+	}
+	jsonResponse(w, resp)
+}
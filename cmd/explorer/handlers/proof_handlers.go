@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	clientrpc "github.com/wonabru/qwid-node/rpc/client"
+	"github.com/wonabru/qwid-node/transactionsPool"
+)
+
+// GetProof returns merkle inclusion proof of transaction (tx parameter), proof is checked against root from block header
+func GetProof(w http.ResponseWriter, r *http.Request) {
+	txStr := r.URL.Query().Get("tx")
+	txHash, err := hex.DecodeString(txStr)
+	if err != nil || len(txHash) != common.HashLength {
+		jsonError(w, "Invalid hash format (expected 64 hex characters)", http.StatusBadRequest)
+		return
+	}
+
+	clientrpc.InRPC <- SignMessage(append([]byte("PRUF"), txHash...))
+	reply := <-clientrpc.OutRPC
+	if bytes.Equal(reply, []byte("Timeout")) {
+		jsonError(w, "Timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if len(reply) < 2 || string(reply[:2]) != "PF" {
+		jsonError(w, string(reply), http.StatusNotFound)
+		return
+	}
+	proof := transactionsPool.MerkleProof{}
+	err = json.Unmarshal(reply[2:], &proof)
+	if err != nil {
+		jsonError(w, "Failed to parse proof", http.StatusInternalServerError)
+		return
+	}
+
+	clientrpc.InRPC <- SignMessage(append([]byte("DETS"), common.GetByteInt64(proof.Height)...))
+	reply = <-clientrpc.OutRPC
+	if len(reply) < 3 || string(reply[:2]) != "BL" {
+		jsonError(w, "Block not found", http.StatusNotFound)
+		return
+	}
+	bb, err := blocks.Block{}.GetFromBytes(reply[2:])
+	if err != nil {
+		jsonError(w, "Failed to parse block", http.StatusInternalServerError)
+		return
+	}
+
+	siblings := []string{}
+	for _, s := range proof.Siblings {
+		siblings = append(siblings, s.GetHex())
+	}
+	jsonResponse(w, map[string]interface{}{
+		"txHash":    proof.TxHash.GetHex(),
+		"height":    proof.Height,
+		"blockHash": bb.BlockHash.GetHex(),
+		"index":     proof.Index,
+		"siblings":  siblings,
+		"root":      proof.Root.GetHex(),
+		"verified":  proof.Verify(bb.GetHeader().RootMerkleTree.GetBytes()),
+	})
+}
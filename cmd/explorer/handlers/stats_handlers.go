@@ -15,6 +15,7 @@ import (
 type StatsResponse struct {
 	Height              int64   `json:"height"`
 	HeightMax           int64   `json:"heightMax"`
+	FinalizedHeight     int64   `json:"finalizedHeight"`
 	TimeInterval        int64   `json:"timeInterval"`
 	Transactions        int     `json:"transactions"`
 	TransactionsPending int     `json:"transactionsPending"`
@@ -48,6 +49,7 @@ func GetStats(w http.ResponseWriter, r *http.Request) {
 	resp := StatsResponse{
 		Height:              st.Height,
 		HeightMax:           st.HeightMax,
+		FinalizedHeight:     st.FinalizedHeight,
 		TimeInterval:        st.TimeInterval,
 		Transactions:        st.Transactions,
 		TransactionsPending: st.TransactionsPending,
@@ -48,10 +48,13 @@ func main() {
 	mux.HandleFunc("/api/block", corsMiddleware(handlers.GetBlock))
 	mux.HandleFunc("/api/blocks", corsMiddleware(handlers.GetBlocks))
 	mux.HandleFunc("/api/tx", corsMiddleware(handlers.GetTransaction))
+	mux.HandleFunc("/api/logs", corsMiddleware(handlers.GetLogs))
+	mux.HandleFunc("/api/proof", corsMiddleware(handlers.GetProof))
 	mux.HandleFunc("/api/account", corsMiddleware(handlers.GetAccount))
 	mux.HandleFunc("/api/search", corsMiddleware(handlers.Search))
 	mux.HandleFunc("/api/validators", corsMiddleware(handlers.GetValidators))
 	mux.HandleFunc("/api/validators/blocks", corsMiddleware(handlers.GetValidatorBlocks))
+	mux.HandleFunc("/api/dex/book", corsMiddleware(handlers.GetOrderBook))
 	mux.HandleFunc("/api/contact", corsMiddleware(handlers.SendContact))
 
 	staticFS, _ := fs.Sub(staticFiles, "static")
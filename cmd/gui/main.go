@@ -2,21 +2,24 @@ package main
 
 import (
 	"fmt"
-	"net"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/therecipe/qt/widgets"
 	"github.com/wonabru/qwid-node/cmd/gui/qtwidgets"
 	"github.com/wonabru/qwid-node/common"
 	clientrpc "github.com/wonabru/qwid-node/rpc/client"
 	"github.com/wonabru/qwid-node/statistics"
 	"github.com/wonabru/qwid-node/tcpip"
 	"github.com/wonabru/qwid-node/wallet"
-	"github.com/therecipe/qt/widgets"
 )
 
 func main() {
+	if err := common.EnvError(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	var ip string
 	if len(os.Args) > 1 {
@@ -29,8 +32,7 @@ func main() {
 	time.Sleep(time.Second)
 	fmt.Println(os.Args)
 
-	ip_this := tcpip.MyIP
-	ip_str := net.IPv4(ip_this[0], ip_this[1], ip_this[2], ip_this[3])
+	ip_str := tcpip.MyIP
 	// needs to be called once before you can start using the QWidgets
 	app := widgets.NewQApplication(len(os.Args), os.Args)
 	// create a window
@@ -3,15 +3,16 @@ package qtwidgets
 import (
 	"bytes"
 	"fmt"
+	"net/netip"
+
+	"github.com/therecipe/qt/widgets"
 	"github.com/wonabru/qwid-node/account"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
 	clientrpc "github.com/wonabru/qwid-node/rpc/client"
 	"github.com/wonabru/qwid-node/statistics"
+	"github.com/wonabru/qwid-node/tcpip"
 	"github.com/wonabru/qwid-node/wallet"
-	"github.com/therecipe/qt/widgets"
-	"strconv"
-	"strings"
 )
 
 var StatsLabel *widgets.QLabel
@@ -170,20 +171,11 @@ func ShowAccountPage() *widgets.QTabWidget {
 		defer func(nfo *string) {
 			widgets.QMessageBox_Information(nil, "Info", *nfo, widgets.QMessageBox__Ok, widgets.QMessageBox__Ok)
 		}(info)
-		ips := strings.Split(ipLineEdit.Text(), ".")
-		if len(ips) != 4 {
+		ip, err := tcpip.ParseIP(ipLineEdit.Text())
+		if err != nil {
 			v = "Invalid IP address format"
 			return
 		}
-		var ip [4]byte
-		for i := 0; i < 4; i++ {
-			num, err := strconv.Atoi(ips[i])
-			if err != nil {
-				v = fmt.Sprintf("Invalid IP address segment:", ips[i])
-				return
-			}
-			ip[i] = byte(num)
-		}
 		v = startMining(ip)
 		return
 	})
@@ -197,8 +189,8 @@ func ShowAccountPage() *widgets.QTabWidget {
 	return widget
 }
 
-func startMining(ip [4]byte) string {
-	clientrpc.InRPC <- SignMessage(append([]byte("MINE"), ip[:]...))
+func startMining(ip netip.Addr) string {
+	clientrpc.InRPC <- SignMessage(append([]byte("MINE"), ip.AsSlice()...))
 	var reply []byte
 	reply = <-clientrpc.OutRPC
 	if string(reply) == "Timeout" {
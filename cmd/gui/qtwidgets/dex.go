@@ -4,16 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/therecipe/qt/core"
+	"github.com/therecipe/qt/widgets"
 	"github.com/wonabru/qwid-node/account"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/core/stateDB"
+	"github.com/wonabru/qwid-node/dex"
 	"github.com/wonabru/qwid-node/logger"
 	clientrpc "github.com/wonabru/qwid-node/rpc/client"
 	"github.com/wonabru/qwid-node/services/transactionServices"
 	"github.com/wonabru/qwid-node/statistics"
 	"github.com/wonabru/qwid-node/transactionsDefinition"
-	"github.com/therecipe/qt/core"
-	"github.com/therecipe/qt/widgets"
 	"golang.org/x/exp/rand"
 	"math"
 	"strconv"
@@ -28,6 +29,8 @@ var amountQAD *widgets.QLineEdit
 var amountTokens *widgets.QLineEdit
 var priceToken *widgets.QLineEdit
 var poolPriceToken *widgets.QLineEdit
+var slippageEdit *widgets.QLineEdit
+var expiryEdit *widgets.QLineEdit
 var humanReadable, humanReadableQAD, price, priceBid, priceAsk float64
 var symbol string
 var poolCoin, poolToken float64
@@ -258,6 +261,16 @@ func ShowDexPage() *widgets.QTabWidget {
 	poolPriceToken.SetPlaceholderText("Price of token you get in QWD")
 	widget.Layout().AddWidget(poolPriceToken)
 
+	slippageEdit = widgets.NewQLineEdit(nil)
+	slippageEdit.SetPlaceholderText("Max slippage in %, empty for no limit")
+	slippageEdit.SetText(strconv.FormatFloat(float64(dex.DefaultSlippage)/100, 'f', -1, 64))
+	widget.Layout().AddWidget(slippageEdit)
+
+	expiryEdit = widgets.NewQLineEdit(nil)
+	expiryEdit.SetPlaceholderText("Expires after number of blocks, empty for never")
+	expiryEdit.SetText(strconv.Itoa(dex.DefaultExpiryBlocks))
+	widget.Layout().AddWidget(expiryEdit)
+
 	poolTokensButton = widgets.NewQPushButton2("Add liquidity to Pool", nil)
 
 	poolPriceToken.SetEnabled(false)
@@ -482,10 +495,13 @@ func GetAllTokensAccountInfo(a common.Address, symbolAddr common.Address) string
 		accDex := GetAccountDex(a)
 		symb := strings.Trim(info.Symbols, string(byte(0)))
 
-		if bal, ok := accDex.Balances[myacc.Address]; ok {
-			humanReadable = account.Int64toFloat64ByDecimals(bal.TokenBalance, info.Decimals)
+		_, hasShares := accDex.Shares[myacc.Address]
+		_, hasBalances := accDex.Balances[myacc.Address]
+		if hasShares || hasBalances {
+			coinBalance, tokenBalance := accDex.ProviderValue(myacc.Address)
+			humanReadable = account.Int64toFloat64ByDecimals(tokenBalance, info.Decimals)
 			txt += fmt.Sprintln(addr, " = ", humanReadable, " ", symb)
-			humanReadableQAD = account.Int64toFloat64ByDecimals(bal.CoinBalance, common.Decimals)
+			humanReadableQAD = account.Int64toFloat64ByDecimals(coinBalance, common.Decimals)
 			txt += fmt.Sprintln(addr, " = ", humanReadableQAD, " QWD")
 		}
 	}
@@ -493,6 +509,32 @@ func GetAllTokensAccountInfo(a common.Address, symbolAddr common.Address) string
 	return txt
 }
 
+// orderData bounds DEX operation by slippage and expiry given by user, so it fails instead of
+// executing at price moved before it is included in block
+func orderData(coinAddr common.Address, operation int, coin, token, height int64) ([]byte, error) {
+	slippage := int64(-1)
+	if s := strings.TrimSpace(slippageEdit.Text()); s != "" {
+		sf, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		slippage = int64(math.Round(sf * 100))
+	}
+	expiry := int64(0)
+	if s := strings.TrimSpace(expiryEdit.Text()); s != "" {
+		e, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		expiry = e
+	}
+	order, err := dex.NewOrder(GetAccountDex(coinAddr).Pool(), operation, coin, token, common.DexSwapFeeBasisPoints, slippage, height, expiry)
+	if err != nil {
+		return nil, err
+	}
+	return order.GetBytes(), nil
+}
+
 func MakeTransaction(sender, coinAddr common.Address, primary bool) {
 	//balance := GetBalance(sender, coinAddr)
 	//myAcc, _ := GetAccount(sender)
@@ -580,6 +622,12 @@ func MakeTransaction(sender, coinAddr common.Address, primary bool) {
 			return
 		}
 		tx.Height = st.Height
+		tx.TxData.OptData, err = orderData(coinAddr, operation, QADam, am, st.Height)
+		if err != nil {
+			v = fmt.Sprint("Can not bound DEX order ", err)
+			info = &v
+			return
+		}
 		tx.GasUsage = tx.GasUsageEstimate()
 		err = tx.CalcHashAndSet()
 		if err != nil {
@@ -685,6 +733,12 @@ func MakeTrade(sender, coinAddr common.Address, isBuy bool, primary bool) {
 			return
 		}
 		tx.Height = st.Height
+		tx.TxData.OptData, err = orderData(coinAddr, operation, am, am, st.Height)
+		if err != nil {
+			v = fmt.Sprint("Can not bound DEX order ", err)
+			info = &v
+			return
+		}
 		tx.GasUsage = tx.GasUsageEstimate()
 		err = tx.CalcHashAndSet()
 		if err != nil {
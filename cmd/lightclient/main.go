@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/lightClient"
+	"github.com/wonabru/qwid-node/logger"
+	clientrpc "github.com/wonabru/qwid-node/rpc/client"
+)
+
+// light client verifies headers given by node and checks balances of addresses and inclusion of transactions
+// given as arguments: lightclient <node ip> [address or transaction hash in hex]...
+// LIGHT_CHECKPOINT=height:hash sets trusted header, otherwise genesis header given by node is trusted.
+func main() {
+	logger.InitLogger()
+	defer logger.CloseLogger()
+
+	ip := "127.0.0.1"
+	if len(os.Args) > 1 {
+		ip = os.Args[1]
+	}
+	addresses := [][common.AddressLength]byte{}
+	txs := [][]byte{}
+	for _, arg := range os.Args[min(len(os.Args), 2):] {
+		b, err := hex.DecodeString(arg)
+		if err != nil {
+			logger.GetLogger().Fatal("wrong hex argument:", arg)
+		}
+		switch len(b) {
+		case common.AddressLength:
+			a := [common.AddressLength]byte{}
+			copy(a[:], b)
+			addresses = append(addresses, a)
+		case common.HashLength:
+			txs = append(txs, b)
+		default:
+			logger.GetLogger().Fatal("argument is neither address nor transaction hash:", arg)
+		}
+	}
+
+	checkpoint := int64(0)
+	var checkpointHash []byte
+	if cp := os.Getenv("LIGHT_CHECKPOINT"); cp != "" {
+		parts := strings.Split(cp, ":")
+		if len(parts) != 2 {
+			logger.GetLogger().Fatal("LIGHT_CHECKPOINT should be height:hash")
+		}
+		var err error
+		checkpoint, err = strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			logger.GetLogger().Fatal("wrong checkpoint height:", err)
+		}
+		checkpointHash, err = hex.DecodeString(parts[1])
+		if err != nil || len(checkpointHash) != common.HashLength {
+			logger.GetLogger().Fatal("wrong checkpoint hash")
+		}
+	}
+
+	homePath, err := os.UserHomeDir()
+	if err != nil {
+		logger.GetLogger().Fatal("failed to get home directory:", err)
+	}
+	chain, err := lightClient.NewHeaderChain(homePath + common.DefaultLightClientHomePath)
+	if err != nil {
+		logger.GetLogger().Fatal("cannot load headers:", err)
+	}
+	client := lightClient.NewClient(chain)
+
+	go clientrpc.ConnectRPC(ip)
+	err = client.Start(checkpoint, checkpointHash)
+	if err != nil {
+		logger.GetLogger().Fatal("cannot start from checkpoint:", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Duration(common.BlockTimeInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		err = client.Sync()
+		if err != nil {
+			logger.GetLogger().Println("header sync fails:", err)
+		}
+		fmt.Println("verified headers up to height", chain.Height())
+		for _, a := range addresses {
+			acc, h, err := client.ProveAccount(a)
+			if lightClient.IsNotCommittedYet(err) {
+				continue
+			}
+			if err != nil {
+				fmt.Printf("account %x: %v\n", a, err)
+				continue
+			}
+			fmt.Printf("account %x: balance %v after block %d\n", a, account.Int64toFloat64(acc.Balance), h)
+		}
+		for _, tx := range txs {
+			h, err := client.ProveTransaction(tx)
+			if err != nil {
+				fmt.Printf("transaction %x: %v\n", tx, err)
+				continue
+			}
+			fmt.Printf("transaction %x: included in block %d\n", tx, h)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
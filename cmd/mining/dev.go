@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/consensus"
+	"github.com/wonabru/qwid-node/genesis"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/rpc/ethrpc"
+	serverrpc "github.com/wonabru/qwid-node/rpc/server"
+	nonceService "github.com/wonabru/qwid-node/services/nonceService"
+	"github.com/wonabru/qwid-node/tcpip"
+	"github.com/wonabru/qwid-node/wallet"
+)
+
+// initDevWallet generates wallet of the only validator of dev chain, it is lost when node stops
+func initDevWallet() {
+	w, err := wallet.NewEphemeralWallet(0, common.SigName(), common.SigName2())
+	if err != nil {
+		logger.GetLogger().Fatal("cannot generate dev wallet:", err)
+	}
+	wallet.SetActiveWallet(w)
+	consensus.SetEngine(consensus.NewDevEngine(w.MainAddress))
+}
+
+// runDevNode serves RPC only and seals block whenever transaction arrives or SEAL is called
+func runDevNode() {
+	logger.GetLogger().Println("Starting RPC server...")
+	go serverrpc.ListenRPC()
+
+	logger.GetLogger().Println("Starting Ethereum JSON-RPC server...")
+	go ethrpc.ListenEthRPC()
+
+	go nonceService.StartDevSealing()
+
+	w := wallet.GetActiveWallet()
+	fmt.Println("Dev chain started, chain id", common.GetChainID())
+	fmt.Println("Validator:", w.MainAddress.GetHex())
+	ws, dir := genesis.DevWallets()
+	fmt.Println("Wallets of pre-funded accounts are in", dir, "with password", genesis.DevWalletPassword)
+	for _, w := range ws {
+		fmt.Println("Pre-funded account:", w.MainAddress.GetHex(), "balance:", genesis.DevAccountBalance, "wallet:", filepath.Join(dir, fmt.Sprintf("wallet%d.json", w.WalletNumber)))
+	}
+	for _, a := range genesis.DevAccounts()[len(ws):] {
+		fmt.Println("Pre-funded account:", a.GetHex(), "balance:", genesis.DevAccountBalance)
+	}
+
+	<-tcpip.Quit
+	logger.GetLogger().Println("Received quit signal, shutting down dev chain...")
+	// chain is lost, so are its wallets
+	os.RemoveAll(dir)
+}
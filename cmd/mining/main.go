@@ -3,12 +3,12 @@ package main
 import (
 	_ "net/http/pprof"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/wonabru/qwid-node/blocks"
 	"github.com/wonabru/qwid-node/database"
+	"github.com/wonabru/qwid-node/finality"
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/pubkeys"
 	"github.com/wonabru/qwid-node/services"
@@ -20,6 +20,7 @@ import (
 	"github.com/wonabru/qwid-node/account"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/genesis"
+	"github.com/wonabru/qwid-node/rpc/ethrpc"
 	serverrpc "github.com/wonabru/qwid-node/rpc/server"
 	nonceService "github.com/wonabru/qwid-node/services/nonceService"
 	syncServices "github.com/wonabru/qwid-node/services/syncService"
@@ -39,23 +40,54 @@ func main() {
 	}
 	logger.InitLogger()
 	defer logger.CloseLogger()
-	database.InitDB()
+	// local chain of dev mode needs no .env
+	for _, arg := range os.Args[1:] {
+		if arg == "-dev" || arg == "--dev" {
+			common.SetDevMode()
+			break
+		}
+	}
+	if err = common.EnvError(); err != nil && !common.DevMode {
+		logger.GetLogger().Fatal(err)
+	}
+	if common.DevMode {
+		database.InitInMemoryDB()
+	} else {
+		database.InitDB()
+	}
 	defer database.CloseDB()
 	pubkeys.InitTrie()
 	// Now you can use log functions as usual
 	logger.GetLogger().Println("Application started")
-	logger.GetLogger().Println("Password:")
-	password, err := term.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		logger.GetLogger().Fatal(err)
+	if common.DevMode {
+		initDevWallet()
+	} else {
+		logger.GetLogger().Println("Password:")
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		if err != nil {
+			logger.GetLogger().Fatal(err)
+		}
+		// Initialize wallet
+		logger.GetLogger().Println("Initializing wallet...")
+		wallet.InitActiveWallet(0, string(password), common.SigName(), common.SigName2())
 	}
-	// Initialize wallet
-	logger.GetLogger().Println("Initializing wallet...")
-	wallet.InitActiveWallet(0, string(password), common.SigName(), common.SigName2())
 
 	// Initialize genesis block
 	logger.GetLogger().Println("Initializing genesis block for setting init params...")
-	genesis.InitGenesis(false)
+	if common.DevMode {
+		genesis.InitDevGenesis(false)
+	} else {
+		genesis.InitGenesis(false)
+	}
+
+	// node started from snapshot has no blocks and state below base height
+	blocks.LoadBaseHeight()
+
+	// older nodes stored full state at every height
+	err = account.MigrateStateSnapshots()
+	if err != nil {
+		logger.GetLogger().Fatal("Failed to migrate state snapshots:", err)
+	}
 
 	// Load accounts
 	logger.GetLogger().Println("Loading accounts...")
@@ -160,13 +192,34 @@ func main() {
 	// Initialize statistics
 	statistics.InitStatsManager()
 
+	// blocks up to finalized height are never reverted
+	err = finality.LoadFinalized()
+	if err != nil {
+		logger.GetLogger().Println("no finalized block:", err)
+	}
+
 	//Load Main Blockchain
 	services.SetBlockHeightAfterCheck()
 
+	// contracts state is part of state root, it is stored with every block
+	err = blocks.LoadStateDB(common.GetHeight())
+	if err != nil {
+		logger.GetLogger().Println("contracts state not loaded, state root may not match:", err)
+	}
+
 	if common.GetHeight() < 0 {
 		// Initialize genesis block
 		logger.GetLogger().Println("Initializing genesis block with processing transactions...")
-		genesis.InitGenesis(true)
+		if common.DevMode {
+			genesis.InitDevGenesis(true)
+		} else {
+			genesis.InitGenesis(true)
+		}
+	}
+
+	if common.DevMode {
+		runDevNode()
+		return
 	}
 
 	// Initialize services
@@ -179,13 +232,16 @@ func main() {
 	logger.GetLogger().Println("Starting RPC server...")
 	go serverrpc.ListenRPC()
 
+	logger.GetLogger().Println("Starting Ethereum JSON-RPC server...")
+	go ethrpc.ListenEthRPC()
+
 	logger.GetLogger().Println("Initializing nonce service...")
 	nonceService.InitNonceService()
 	go nonceService.StartSubscribingNonceMsgSelf()
-	go nonceService.StartSubscribingNonceMsg(tcpip.MyIP)
+	go nonceService.StartSubscribingNonceMsg(tcpip.MyPeerAddress())
 
-	go transactionServices.StartSubscribingTransactionMsg(tcpip.MyIP)
-	go syncServices.StartSubscribingSyncMsg(tcpip.MyIP)
+	go transactionServices.StartSubscribingTransactionMsg(tcpip.MyPeerAddress())
+	go syncServices.StartSubscribingSyncMsg(tcpip.MyPeerAddress())
 
 	time.Sleep(time.Second)
 
@@ -200,34 +256,28 @@ func main() {
 
 	if peerIPArg != "" {
 		logger.GetLogger().Println("Processing command line arguments...")
-		ips := strings.Split(peerIPArg, ".")
-		if len(ips) != 4 {
-			logger.GetLogger().Println("Invalid IP address format")
+		ip, err := tcpip.ParseIP(peerIPArg)
+		if err != nil {
+			logger.GetLogger().Println("Invalid IP address format", err)
 			return
 		}
-		var ip [4]byte
-		for i := 0; i < 4; i++ {
-			num, err := strconv.Atoi(ips[i])
-			if err != nil {
-				logger.GetLogger().Println("Invalid IP address segment:", ips[i])
-				return
-			}
-			ip[i] = byte(num)
-		}
 
-		logger.GetLogger().Println("Connecting to peer:", ip)
-		go nonceService.StartSubscribingNonceMsg(ip)
-		go syncServices.StartSubscribingSyncMsg(ip)
-		go transactionServices.StartSubscribingTransactionMsg(ip)
+		peer := tcpip.PeerAddress{IP: ip}
+		tcpip.AddPeerAddresses([]tcpip.PeerAddress{peer})
+		logger.GetLogger().Println("Connecting to peer:", peer)
+		go nonceService.StartSubscribingNonceMsg(peer)
+		go syncServices.StartSubscribingSyncMsg(peer)
+		go transactionServices.StartSubscribingTransactionMsg(peer)
 	}
 
 	time.Sleep(time.Second)
 
+	logger.GetLogger().Println("Loading peer store...")
+	tcpip.LoadPeerStore()
+
 	logger.GetLogger().Println("Starting peer discovery...")
-	go tcpip.LookUpForNewPeersToConnect(tcpip.ChanPeer)
-	topic := [2]byte{}
-	ip := [4]byte{}
-	lastReconnect := make(map[[6]byte]time.Time)
+	go tcpip.LookUpForNewPeersToConnect(tcpip.ChanPeer, tcpip.ChanDial)
+	lastReconnect := make(map[tcpip.PeerKey]time.Time)
 	reconnectCooldown := 10 * time.Second
 
 	logger.GetLogger().Println("Entering main loop...")
@@ -235,13 +285,15 @@ QF:
 	for {
 		select {
 
-		case topicip := <-tcpip.ChanPeer:
-			copy(topic[:], topicip[:2])
-			copy(ip[:], topicip[2:])
-			var key [6]byte
-			copy(key[:], topicip[:6])
+		case key := <-tcpip.ChanPeer:
+			topic := key.Topic
 			if time.Since(lastReconnect[key]) < reconnectCooldown {
-				logger.GetLogger().Printf("Reconnect cooldown for %c%c %v, skipping", topic[0], topic[1], ip)
+				logger.GetLogger().Printf("Reconnect cooldown for %c%c %v, skipping", topic[0], topic[1], key.ID)
+				continue
+			}
+			// node is dialed at address where it was seen last time
+			ip, ok := tcpip.GetPeerHint(key.ID)
+			if !ok || tcpip.IsPeerBanned(key.ID) {
 				continue
 			}
 			lastReconnect[key] = time.Now()
@@ -258,6 +310,12 @@ QF:
 				go syncServices.StartSubscribingSyncMsg(ip)
 			}
 
+		case addr := <-tcpip.ChanDial:
+			logger.GetLogger().Println("Connecting to stored peer:", addr)
+			go nonceService.StartSubscribingNonceMsg(addr)
+			go syncServices.StartSubscribingSyncMsg(addr)
+			go transactionServices.StartSubscribingTransactionMsg(addr)
+
 		case <-tcpip.Quit:
 			logger.GetLogger().Println("Received quit signal, shutting down...")
 			break QF
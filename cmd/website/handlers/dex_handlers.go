@@ -5,11 +5,13 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
 	"net/http"
 
 	"github.com/wonabru/qwid-node/account"
 	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/dex"
 	clientrpc "github.com/wonabru/qwid-node/rpc/client"
 	"github.com/wonabru/qwid-node/services/transactionServices"
 	"github.com/wonabru/qwid-node/statistics"
@@ -96,10 +98,12 @@ func TradeDex(w http.ResponseWriter, r *http.Request) {
 	wl := sess.Wallet
 
 	var req struct {
-		TokenAddress         string  `json:"tokenAddress"`
-		Action               string  `json:"action"`
-		Amount               float64 `json:"amount"`
-		UsePrimaryEncryption bool    `json:"usePrimaryEncryption"`
+		TokenAddress         string   `json:"tokenAddress"`
+		Action               string   `json:"action"`
+		Amount               float64  `json:"amount"`
+		UsePrimaryEncryption bool     `json:"usePrimaryEncryption"`
+		SlippagePercent      *float64 `json:"slippagePercent"` // default 1%, negative for no limit
+		ExpiryBlocks         *int64   `json:"expiryBlocks"`    // default 60 blocks, 0 for no expiry
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		JsonError(w, "Invalid request body", http.StatusBadRequest)
@@ -172,6 +176,12 @@ func TradeDex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tx.Height = st.Height
+	optData, err := dexOrderData(coinAddr, operation, am, am, req.SlippagePercent, req.ExpiryBlocks, st.Height)
+	if err != nil {
+		JsonError(w, fmt.Sprintf("Failed to bound DEX order: %v", err), http.StatusBadRequest)
+		return
+	}
+	tx.TxData.OptData = optData
 	tx.GasUsage = tx.GasUsageEstimate()
 
 	if err := tx.CalcHashAndSet(); err != nil {
@@ -202,6 +212,34 @@ func TradeDex(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// dexOrderData bounds DEX operation by slippage from current pool and by expiry height,
+// so the operation fails instead of executing at price moved before it is included in block
+func dexOrderData(coinAddr common.Address, operation int, coin, token int64, slippagePercent *float64, expiryBlocks *int64, height int64) ([]byte, error) {
+	slippage := int64(dex.DefaultSlippage)
+	if slippagePercent != nil {
+		slippage = int64(math.Round(*slippagePercent * 100))
+	}
+	expiry := int64(dex.DefaultExpiryBlocks)
+	if expiryBlocks != nil {
+		expiry = *expiryBlocks
+	}
+	m := []byte("ADEX")
+	m = append(m, coinAddr.GetBytes()...)
+	clientrpc.InRPC <- SignMessage(m)
+	reply := <-clientrpc.OutRPC
+	dexAcc := account.DexAccount{}
+	if len(reply) > 8 {
+		if err := dexAcc.Unmarshal(reply); err != nil {
+			return nil, err
+		}
+	}
+	order, err := dex.NewOrder(dexAcc.Pool(), operation, coin, token, common.DexSwapFeeBasisPoints, slippage, height, expiry)
+	if err != nil {
+		return nil, err
+	}
+	return order.GetBytes(), nil
+}
+
 func ExecuteDex(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		JsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -217,11 +255,13 @@ func ExecuteDex(w http.ResponseWriter, r *http.Request) {
 	wl := sess.Wallet
 
 	var req struct {
-		TokenAddress         string  `json:"tokenAddress"`
-		Operation            string  `json:"operation"`
-		TokenAmount          float64 `json:"tokenAmount"`
-		QwdAmount            float64 `json:"qwdAmount"`
-		UsePrimaryEncryption bool    `json:"usePrimaryEncryption"`
+		TokenAddress         string   `json:"tokenAddress"`
+		Operation            string   `json:"operation"`
+		TokenAmount          float64  `json:"tokenAmount"`
+		QwdAmount            float64  `json:"qwdAmount"`
+		UsePrimaryEncryption bool     `json:"usePrimaryEncryption"`
+		SlippagePercent      *float64 `json:"slippagePercent"` // default 1%, negative for no limit
+		ExpiryBlocks         *int64   `json:"expiryBlocks"`    // default 60 blocks, 0 for no expiry
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		JsonError(w, "Invalid request body", http.StatusBadRequest)
@@ -298,6 +338,12 @@ func ExecuteDex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tx.Height = st.Height
+	optData, err := dexOrderData(coinAddr, operation, qwdAm, tokenAm, req.SlippagePercent, req.ExpiryBlocks, st.Height)
+	if err != nil {
+		JsonError(w, fmt.Sprintf("Failed to bound DEX order: %v", err), http.StatusBadRequest)
+		return
+	}
+	tx.TxData.OptData = optData
 	tx.GasUsage = tx.GasUsageEstimate()
 
 	if err := tx.CalcHashAndSet(); err != nil {
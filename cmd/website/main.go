@@ -6,7 +6,6 @@ import (
 	"embed"
 	"fmt"
 	"io/fs"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -71,8 +70,7 @@ func main() {
 	go clientrpc.ConnectRPC(ip)
 	time.Sleep(time.Second)
 
-	ipThis := tcpip.MyIP
-	ipStr := net.IPv4(ipThis[0], ipThis[1], ipThis[2], ipThis[3])
+	ipStr := tcpip.MyIP
 
 	// Test which encryption the node is using and set accordingly
 	handlers.TestAndSetEncryption()
@@ -103,6 +101,10 @@ func main() {
 	}
 	handlers.NodeWallet = nodeWallet
 	handlers.NodeIP = ipStr.String()
+	if err = common.EnvError(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	handlers.DelegatedAccount = int(common.NumericalDelegatedAccountAddress(common.GetDelegatedAccount()))
 
 	// Initialize user registry
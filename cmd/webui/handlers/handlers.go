@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
 	"net/http"
 	"os"
@@ -18,6 +19,7 @@ import (
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/crypto"
 	"github.com/wonabru/qwid-node/crypto/oqs"
+	"github.com/wonabru/qwid-node/dex"
 	"github.com/wonabru/qwid-node/logger"
 	clientrpc "github.com/wonabru/qwid-node/rpc/client"
 	"github.com/wonabru/qwid-node/services/transactionServices"
@@ -1613,6 +1615,34 @@ func GetDexInfo(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// dexOrderData bounds DEX operation by slippage from current pool and by expiry height,
+// so the operation fails instead of executing at price moved before it is included in block
+func dexOrderData(coinAddr common.Address, operation int, coin, token int64, slippagePercent *float64, expiryBlocks *int64, height int64) ([]byte, error) {
+	slippage := int64(dex.DefaultSlippage)
+	if slippagePercent != nil {
+		slippage = int64(math.Round(*slippagePercent * 100))
+	}
+	expiry := int64(dex.DefaultExpiryBlocks)
+	if expiryBlocks != nil {
+		expiry = *expiryBlocks
+	}
+	m := []byte("ADEX")
+	m = append(m, coinAddr.GetBytes()...)
+	clientrpc.InRPC <- SignMessage(m)
+	reply := <-clientrpc.OutRPC
+	dexAcc := account.DexAccount{}
+	if len(reply) > 8 {
+		if err := dexAcc.Unmarshal(reply); err != nil {
+			return nil, err
+		}
+	}
+	order, err := dex.NewOrder(dexAcc.Pool(), operation, coin, token, common.DexSwapFeeBasisPoints, slippage, height, expiry)
+	if err != nil {
+		return nil, err
+	}
+	return order.GetBytes(), nil
+}
+
 func ExecuteDex(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1625,11 +1655,13 @@ func ExecuteDex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		TokenAddress         string  `json:"tokenAddress"`
-		Operation            string  `json:"operation"` // addLiquidity, withdrawToken, withdrawQWD, trade
-		TokenAmount          float64 `json:"tokenAmount"`
-		QwdAmount            float64 `json:"qwdAmount"`
-		UsePrimaryEncryption bool    `json:"usePrimaryEncryption"`
+		TokenAddress         string   `json:"tokenAddress"`
+		Operation            string   `json:"operation"` // addLiquidity, withdrawToken, withdrawQWD, trade
+		TokenAmount          float64  `json:"tokenAmount"`
+		QwdAmount            float64  `json:"qwdAmount"`
+		UsePrimaryEncryption bool     `json:"usePrimaryEncryption"`
+		SlippagePercent      *float64 `json:"slippagePercent"` // default 1%, negative for no limit
+		ExpiryBlocks         *int64   `json:"expiryBlocks"`    // default 60 blocks, 0 for no expiry
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "Invalid request body", http.StatusBadRequest)
@@ -1704,6 +1736,12 @@ func ExecuteDex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tx.Height = st.Height
+	optData, err := dexOrderData(coinAddr, operation, qwdAm, tokenAm, req.SlippagePercent, req.ExpiryBlocks, st.Height)
+	if err != nil {
+		jsonError(w, fmt.Sprintf("Failed to bound DEX order: %v", err), http.StatusBadRequest)
+		return
+	}
+	tx.TxData.OptData = optData
 	tx.GasUsage = tx.GasUsageEstimate()
 
 	if err := tx.CalcHashAndSet(); err != nil {
@@ -1745,10 +1783,12 @@ func TradeDex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		TokenAddress         string  `json:"tokenAddress"`
-		Action               string  `json:"action"` // buy or sell
-		Amount               float64 `json:"amount"`
-		UsePrimaryEncryption bool    `json:"usePrimaryEncryption"`
+		TokenAddress         string   `json:"tokenAddress"`
+		Action               string   `json:"action"` // buy or sell
+		Amount               float64  `json:"amount"`
+		UsePrimaryEncryption bool     `json:"usePrimaryEncryption"`
+		SlippagePercent      *float64 `json:"slippagePercent"` // default 1%, negative for no limit
+		ExpiryBlocks         *int64   `json:"expiryBlocks"`    // default 60 blocks, 0 for no expiry
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "Invalid request body", http.StatusBadRequest)
@@ -1818,6 +1858,12 @@ func TradeDex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tx.Height = st.Height
+	optData, err := dexOrderData(coinAddr, operation, am, am, req.SlippagePercent, req.ExpiryBlocks, st.Height)
+	if err != nil {
+		jsonError(w, fmt.Sprintf("Failed to bound DEX order: %v", err), http.StatusBadRequest)
+		return
+	}
+	tx.TxData.OptData = optData
 	tx.GasUsage = tx.GasUsageEstimate()
 
 	if err := tx.CalcHashAndSet(); err != nil {
@@ -1847,6 +1893,449 @@ func TradeDex(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// quoteSwap asks node for swap of amount of the first token of path to the last one
+func quoteSwap(path []string, amount, height int64) (common.Address, dex.Swap, blocks.SwapQuote, error) {
+	if len(path) < 2 {
+		return common.Address{}, dex.Swap{}, blocks.SwapQuote{}, fmt.Errorf("path needs at least two tokens")
+	}
+	tokens := make([]common.Address, len(path))
+	for i, p := range path {
+		ba, err := hex.DecodeString(p)
+		if err != nil {
+			return common.Address{}, dex.Swap{}, blocks.SwapQuote{}, fmt.Errorf("invalid token address %v", p)
+		}
+		if err := tokens[i].Init(ba); err != nil {
+			return common.Address{}, dex.Swap{}, blocks.SwapQuote{}, err
+		}
+	}
+	s := dex.Swap{Order: dex.Order{Amount: amount}}
+	for _, t := range tokens[1:] {
+		s.Path = append(s.Path, t.ByteValue)
+	}
+	pf := blocks.PasiveFunction{
+		Height:  height,
+		OptData: append(tokens[0].GetBytes(), s.GetBytes()...),
+		Address: common.GetDelegatedAccountAddress(int16(512 + dex.OpSwap)),
+	}
+	b, _ := json.Marshal(pf)
+	clientrpc.InRPC <- SignMessage(append([]byte("VIEW"), b...))
+	reply := <-clientrpc.OutRPC
+	q := blocks.SwapQuote{}
+	if err := json.Unmarshal(reply, &q); err != nil {
+		return common.Address{}, dex.Swap{}, blocks.SwapQuote{}, fmt.Errorf("%s", reply)
+	}
+	return tokens[0], s, q, nil
+}
+
+// QuoteSwapDex returns expected output and price impact of every hop of swap
+func QuoteSwapDex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Path   []string `json:"path"` // token addresses, coin is between every two of them
+		Amount float64  `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	_, _, q, err := quoteSwap(req.Path, int64(req.Amount*1e8), 0)
+	if err != nil {
+		jsonError(w, fmt.Sprintf("Failed to quote swap: %v", err), http.StatusBadRequest)
+		return
+	}
+	hops := []map[string]interface{}{}
+	for _, h := range q.Hops {
+		hops = append(hops, map[string]interface{}{
+			"token":              h.Token,
+			"sell":               h.Sell,
+			"amountIn":           account.Int64toFloat64(h.AmountIn),
+			"amountOut":          account.Int64toFloat64(h.AmountOut),
+			"priceImpactPercent": float64(h.PriceImpact) / 100,
+		})
+	}
+	jsonResponse(w, map[string]interface{}{
+		"hops":      hops,
+		"amountOut": account.Int64toFloat64(q.AmountOut),
+	})
+}
+
+// SwapDex swaps the first token of path for the last one in one transaction
+func SwapDex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !walletReady() {
+		jsonError(w, "Load wallet first", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Path                 []string `json:"path"` // token addresses, coin is between every two of them
+		Amount               float64  `json:"amount"`
+		UsePrimaryEncryption bool     `json:"usePrimaryEncryption"`
+		SlippagePercent      *float64 `json:"slippagePercent"` // default 1%, negative for no limit
+		ExpiryBlocks         *int64   `json:"expiryBlocks"`    // default 60 blocks, 0 for no expiry
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	clientrpc.InRPC <- SignMessage([]byte("STAT"))
+	reply := <-clientrpc.OutRPC
+	sm := statistics.GetStatsManager()
+	st := sm.Stats
+	if err := common.Unmarshal(reply, common.StatDBPrefix, &st); err != nil {
+		jsonError(w, "Failed to get network stats", http.StatusInternalServerError)
+		return
+	}
+
+	first, s, q, err := quoteSwap(req.Path, int64(req.Amount*1e8), st.Height)
+	if err != nil {
+		jsonError(w, fmt.Sprintf("Failed to quote swap: %v", err), http.StatusBadRequest)
+		return
+	}
+	slippage := int64(dex.DefaultSlippage)
+	if req.SlippagePercent != nil {
+		slippage = int64(math.Round(*req.SlippagePercent * 100))
+	}
+	if slippage >= 0 {
+		s.Order.Limit, err = dex.SwapLimit(q.AmountOut, slippage)
+		if err != nil {
+			jsonError(w, fmt.Sprintf("Failed to bound swap: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	s.Order.Expiry = st.Height + dex.DefaultExpiryBlocks
+	if req.ExpiryBlocks != nil {
+		s.Order.Expiry = 0
+		if *req.ExpiryBlocks > 0 {
+			s.Order.Expiry = st.Height + *req.ExpiryBlocks
+		}
+	}
+
+	sender := common.Address{}
+	sender.Init(append([]byte{0}, MainWallet.MainAddress.GetBytes()...))
+
+	txd := transactionsDefinition.TxData{
+		Recipient:                  common.GetDelegatedAccountAddress(int16(512 + dex.OpSwap)),
+		Amount:                     0,
+		OptData:                    s.GetBytes(),
+		Pubkey:                     common.PubKey{},
+		LockedAmount:               0,
+		ReleasePerBlock:            0,
+		DelegatedAccountForLocking: common.GetDelegatedAccountAddress(1),
+	}
+
+	par := transactionsDefinition.TxParam{
+		ChainID:     int16(23),
+		Sender:      sender,
+		SendingTime: common.GetCurrentTimeStampInSecond(),
+		Nonce:       int16(rand.Intn(0xffff)),
+	}
+
+	tx := transactionsDefinition.Transaction{
+		TxData:          txd,
+		TxParam:         par,
+		Hash:            common.Hash{},
+		Signature:       common.Signature{},
+		Height:          st.Height,
+		GasPrice:        int64(rand.Intn(0x0000000f)) + 1,
+		GasUsage:        0,
+		ContractAddress: first,
+	}
+	tx.GasUsage = tx.GasUsageEstimate()
+
+	if err := tx.CalcHashAndSet(); err != nil {
+		jsonError(w, fmt.Sprintf("Failed to calculate hash: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Sign(MainWallet, req.UsePrimaryEncryption); err != nil {
+		jsonError(w, fmt.Sprintf("Failed to sign transaction: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	msg, err := transactionServices.GenerateTransactionMsg([]transactionsDefinition.Transaction{tx}, []byte("tx"), [2]byte{'T', 'T'})
+	if err != nil {
+		jsonError(w, fmt.Sprintf("Failed to generate message: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	tmm := msg.GetBytes()
+	clientrpc.InRPC <- SignMessage(append([]byte("TRAN"), tmm...))
+	<-clientrpc.OutRPC
+
+	jsonResponse(w, map[string]interface{}{
+		"success":   "true",
+		"txHash":    tx.Hash.GetHex(),
+		"amountOut": account.Int64toFloat64(q.AmountOut),
+		"minimum":   account.Int64toFloat64(s.Order.Limit),
+		"message":   "swap order sent",
+	})
+}
+
+// orderBookToJSON converts amounts of book to QWD and tokens, prices are QWD per token
+func orderBookToJSON(v blocks.OrderBookView) map[string]interface{} {
+	levels := func(ls []blocks.BookLevel) []map[string]interface{} {
+		r := []map[string]interface{}{}
+		for _, l := range ls {
+			r = append(r, map[string]interface{}{
+				"price":  account.Int64toFloat64(l.Price),
+				"amount": account.Int64toFloat64(l.Amount),
+				"orders": l.Orders,
+			})
+		}
+		return r
+	}
+	orders := []map[string]interface{}{}
+	for _, o := range v.Orders {
+		orders = append(orders, map[string]interface{}{
+			"id":     o.ID,
+			"sell":   o.Sell,
+			"amount": account.Int64toFloat64(o.Amount),
+			"price":  account.Int64toFloat64(o.Price),
+			"escrow": account.Int64toFloat64(o.Escrow),
+			"expiry": o.Expiry,
+		})
+	}
+	events := []map[string]interface{}{}
+	for _, e := range v.Events {
+		events = append(events, map[string]interface{}{
+			"height":  e.Height,
+			"txHash":  e.TxHash,
+			"kind":    e.Kind,
+			"id":      e.ID,
+			"sell":    e.Sell,
+			"amount":  account.Int64toFloat64(e.Amount),
+			"coin":    account.Int64toFloat64(e.Coin),
+			"price":   account.Int64toFloat64(e.Price),
+			"counter": e.Counter,
+			"refund":  account.Int64toFloat64(e.Refund),
+		})
+	}
+	return map[string]interface{}{
+		"bids":   levels(v.Bids),
+		"asks":   levels(v.Asks),
+		"orders": orders,
+		"events": events,
+	}
+}
+
+// GetOrderBook returns depth of order book of token with open orders and order events of wallet
+func GetOrderBook(w http.ResponseWriter, r *http.Request) {
+	token, err := hex.DecodeString(r.URL.Query().Get("token"))
+	if err != nil || len(token) != common.AddressLength {
+		jsonError(w, "Invalid token address", http.StatusBadRequest)
+		return
+	}
+	from := int64(0)
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		from, err = strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			jsonError(w, "Invalid from height", http.StatusBadRequest)
+			return
+		}
+	}
+	query := append(token, common.GetByteInt64(from)...)
+	query = append(query, common.GetByteInt64(-1)...)
+	if walletReady() {
+		query = append(query, MainWallet.MainAddress.GetBytes()...)
+	}
+
+	clientrpc.InRPC <- SignMessage(append([]byte("DORD"), query...))
+	reply := <-clientrpc.OutRPC
+	if len(reply) < 2 || string(reply[:2]) != "OB" {
+		jsonError(w, fmt.Sprintf("Failed to get order book: %s", reply), http.StatusInternalServerError)
+		return
+	}
+	v := blocks.OrderBookView{}
+	if err := json.Unmarshal(reply[2:], &v); err != nil {
+		jsonError(w, "Failed to parse order book", http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, orderBookToJSON(v))
+}
+
+// sendDexOrderTransaction signs and sends transaction placing or cancelling limit order on book of token
+func sendDexOrderTransaction(operation int, token common.Address, optData []byte, height int64, usePrimaryEncryption bool) (transactionsDefinition.Transaction, error) {
+	sender := common.Address{}
+	sender.Init(append([]byte{0}, MainWallet.MainAddress.GetBytes()...))
+
+	txd := transactionsDefinition.TxData{
+		Recipient:                  common.GetDelegatedAccountAddress(int16(512 + operation)),
+		Amount:                     0,
+		OptData:                    optData,
+		Pubkey:                     common.PubKey{},
+		LockedAmount:               0,
+		ReleasePerBlock:            0,
+		DelegatedAccountForLocking: common.GetDelegatedAccountAddress(1),
+	}
+
+	par := transactionsDefinition.TxParam{
+		ChainID:     int16(23),
+		Sender:      sender,
+		SendingTime: common.GetCurrentTimeStampInSecond(),
+		Nonce:       int16(rand.Intn(0xffff)),
+	}
+
+	tx := transactionsDefinition.Transaction{
+		TxData:          txd,
+		TxParam:         par,
+		Hash:            common.Hash{},
+		Signature:       common.Signature{},
+		Height:          height,
+		GasPrice:        int64(rand.Intn(0x0000000f)) + 1,
+		GasUsage:        0,
+		ContractAddress: token,
+	}
+	tx.GasUsage = tx.GasUsageEstimate()
+
+	if err := tx.CalcHashAndSet(); err != nil {
+		return tx, fmt.Errorf("failed to calculate hash: %v", err)
+	}
+	if err := tx.Sign(MainWallet, usePrimaryEncryption); err != nil {
+		return tx, fmt.Errorf("failed to sign transaction: %v", err)
+	}
+	msg, err := transactionServices.GenerateTransactionMsg([]transactionsDefinition.Transaction{tx}, []byte("tx"), [2]byte{'T', 'T'})
+	if err != nil {
+		return tx, fmt.Errorf("failed to generate message: %v", err)
+	}
+	clientrpc.InRPC <- SignMessage(append([]byte("TRAN"), msg.GetBytes()...))
+	<-clientrpc.OutRPC
+	return tx, nil
+}
+
+// PlaceOrderDex places limit order, price is in QWD per token
+func PlaceOrderDex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !walletReady() {
+		jsonError(w, "Load wallet first", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		TokenAddress         string  `json:"tokenAddress"`
+		Side                 string  `json:"side"` // buy or sell
+		Amount               float64 `json:"amount"`
+		Price                float64 `json:"price"`
+		UsePrimaryEncryption bool    `json:"usePrimaryEncryption"`
+		ExpiryBlocks         int64   `json:"expiryBlocks"` // 0 for order which never expires
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Side != "buy" && req.Side != "sell" {
+		jsonError(w, "Side has to be buy or sell", http.StatusBadRequest)
+		return
+	}
+
+	token := common.Address{}
+	ba, err := hex.DecodeString(req.TokenAddress)
+	if err != nil || token.Init(ba) != nil {
+		jsonError(w, "Invalid token address", http.StatusBadRequest)
+		return
+	}
+
+	clientrpc.InRPC <- SignMessage([]byte("STAT"))
+	reply := <-clientrpc.OutRPC
+	sm := statistics.GetStatsManager()
+	st := sm.Stats
+	if err := common.Unmarshal(reply, common.StatDBPrefix, &st); err != nil {
+		jsonError(w, "Failed to get network stats", http.StatusInternalServerError)
+		return
+	}
+
+	pl := dex.Placement{
+		Sell:   req.Side == "sell",
+		Amount: int64(math.Round(req.Amount * 1e8)),
+		Price:  int64(math.Round(req.Price * 1e8)),
+	}
+	if req.ExpiryBlocks > 0 {
+		pl.Expiry = st.Height + req.ExpiryBlocks
+	}
+	if pl.Amount <= 0 || pl.Price <= 0 {
+		jsonError(w, "Amount and price have to be positive", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := sendDexOrderTransaction(dex.OpPlaceOrder, token, pl.GetBytes(), st.Height, req.UsePrimaryEncryption)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"success": "true",
+		"txHash":  tx.Hash.GetHex(),
+		"expiry":  pl.Expiry,
+		"message": "limit order sent",
+	})
+}
+
+// CancelOrderDex cancels limit order of wallet, escrow of order is returned
+func CancelOrderDex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !walletReady() {
+		jsonError(w, "Load wallet first", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		TokenAddress         string `json:"tokenAddress"`
+		OrderID              int64  `json:"orderId"`
+		UsePrimaryEncryption bool   `json:"usePrimaryEncryption"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token := common.Address{}
+	ba, err := hex.DecodeString(req.TokenAddress)
+	if err != nil || token.Init(ba) != nil {
+		jsonError(w, "Invalid token address", http.StatusBadRequest)
+		return
+	}
+
+	clientrpc.InRPC <- SignMessage([]byte("STAT"))
+	reply := <-clientrpc.OutRPC
+	sm := statistics.GetStatsManager()
+	st := sm.Stats
+	if err := common.Unmarshal(reply, common.StatDBPrefix, &st); err != nil {
+		jsonError(w, "Failed to get network stats", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := sendDexOrderTransaction(dex.OpCancelOrder, token, dex.CancelBytes(req.OrderID), st.Height, req.UsePrimaryEncryption)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"success": "true",
+		"txHash":  tx.Hash.GetHex(),
+		"message": "order cancel sent",
+	})
+}
+
 // GetPeers returns information about connected and banned peers
 func GetPeers(w http.ResponseWriter, r *http.Request) {
 	clientrpc.InRPC <- SignMessage([]byte("PEER"))
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/fs"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -45,8 +44,7 @@ func main() {
 	go clientrpc.ConnectRPC(ip)
 	time.Sleep(time.Second)
 
-	ipThis := tcpip.MyIP
-	ipStr := net.IPv4(ipThis[0], ipThis[1], ipThis[2], ipThis[3])
+	ipStr := tcpip.MyIP
 
 	// Test which encryption the node is using and set accordingly
 	handlers.TestAndSetEncryption()
@@ -69,6 +67,10 @@ func main() {
 	w := wallet.EmptyWallet(0, sigName, sigName2)
 	handlers.MainWallet = &w
 	handlers.NodeIP = ipStr.String()
+	if err := common.EnvError(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	handlers.DelegatedAccount = int(common.NumericalDelegatedAccountAddress(common.GetDelegatedAccount()))
 
 	// Setup routes
@@ -96,6 +98,11 @@ func main() {
 	mux.HandleFunc("/api/dex/trade", corsMiddleware(handlers.TradeDex))
 	mux.HandleFunc("/api/dex/info", corsMiddleware(handlers.GetDexInfo))
 	mux.HandleFunc("/api/dex/execute", corsMiddleware(handlers.ExecuteDex))
+	mux.HandleFunc("/api/dex/quote", corsMiddleware(handlers.QuoteSwapDex))
+	mux.HandleFunc("/api/dex/swap", corsMiddleware(handlers.SwapDex))
+	mux.HandleFunc("/api/dex/orders", corsMiddleware(handlers.GetOrderBook))
+	mux.HandleFunc("/api/dex/order", corsMiddleware(handlers.PlaceOrderDex))
+	mux.HandleFunc("/api/dex/order/cancel", corsMiddleware(handlers.CancelOrderDex))
 	mux.HandleFunc("/api/vote", corsMiddleware(handlers.Vote))
 	mux.HandleFunc("/api/encryption-status", corsMiddleware(handlers.GetEncryptionStatus))
 	mux.HandleFunc("/api/pubkey-info", corsMiddleware(handlers.GetPubKeyInfo))
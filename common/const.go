@@ -27,6 +27,7 @@ var (
 	DifficultyChange               float32 = 10
 	MaxGasUsage                    int64   = 13700000 // circa 6.5k transactions in block
 	MaxGasPrice                    int64   = 100000
+	BaseFeePerGas                  int64   = 0    // no burned base fee, BASEFEE opcode returns it
 	MaxTransactionsPerBlock        int16   = 5000 // on average 500 TPS
 	MaxTransactionInPool                   = 50000
 	MaxPeersConnected              int     = 6
@@ -47,10 +48,33 @@ var (
 	MaxMessageSizeBytes            int32   = 151126018           // should be adjusted to maximal message sent
 	DefaultWalletHomePath                  = "/.qwid/wallet/"
 	DefaultBlockchainHomePath              = "/.qwid/db/blockchain/"
-	ConnectionsWithoutVerification         = [][]byte{[]byte("TRAN"), []byte("STAT"), []byte("ENCR"), []byte("DETS"), []byte("STAK"), []byte("ADEX"), []byte("PUBA"), []byte("HELO"), []byte("VALS")}
+	DefaultLightClientHomePath             = "/.qwid/lightclient/"
+	ConnectionsWithoutVerification         = [][]byte{[]byte("TRAN"), []byte("STAT"), []byte("ENCR"), []byte("DETS"), []byte("STAK"), []byte("ADEX"), []byte("PUBA"), []byte("HELO"), []byte("VALS"), []byte("LOGS"), []byte("PRUF"), []byte("HDRS"), []byte("PKEY"), []byte("APRF"), []byte("SEAL"), []byte("DORD")}
 	CurrentHeightOfNetwork         int64   = 23
+	StateCheckpointInterval        int64   = 1000  // full accounts snapshot every 1000 blocks, diffs in between
+	MaxLogsQueryBlockRange         int64   = 10000 // blocks scanned by logs query without address or topic filter
+	MaxReorgDepth                  int64   = 60    // deepest main chain block which can be replaced by heavier side branch
+	SnapshotInterval               int64   = 1000  // state snapshot for fast sync every 1000 blocks, the same heights as state checkpoints
+	SnapshotCommitDelay            int64   = 10    // root of snapshot is included in block 10 blocks after snapshot height
+	SnapshotChunkSize                      = 4 << 20
+	StateRootForkHeight            int64   = 250000 // from this height blocks carry state root and snapshot root
+	DifficultyForkHeight           int64   = 250000 // from this height difficulty follows interval between timestamps of block and its parent
+	SlashingForkHeight             int64   = 250000 // from this height operators signing two nonce transactions for the same block are slashed
+	DexForkHeight                  int64   = 250000 // from this height DEX amounts are computed with integers only
+	DexSwapFeeBasisPoints          int64   = 30     // 0.3% of every DEX trade stays in pool for liquidity providers
+	DexMinOrderValue               int64   = 1e8    // placed limit order has to be worth at least 1 QWD
+	SlashingAccountID              int16   = 1024   // delegated style address receiving evidence of equivocation, it keeps slashed coins
+	SlashingPermille               int64   = 100    // part of offender stake taken for signing two blocks at the same height
+	SlashingReporterPermille       int64   = 100    // part of slashed coins given to sender of evidence
+	JailBlocks                     int64   = 8640   // one day, delegated account of offender cannot produce blocks
+	SlashingEvidenceMaxAge         int64   = 8640   // older evidence is not accepted
+
+	P2PKEMName = "ML-KEM-768" // KEM establishing session keys of encrypted peer connections
 )
 
+// SeedPeers are bootstrap addresses from genesis file, used when address book has no reachable nodes
+var SeedPeers []string
+
 // db prefixes
 var (
 	BlocksDBPrefix                     = [2]byte{'B', 'I'}
@@ -80,6 +104,28 @@ var (
 	TokenDetailsDBPrefix             = [2]byte{'T', 'D'}
 	DexAccountsDBPrefix              = [2]byte{'D', 'A'}
 	BadTransactionDBPrefix           = [2]byte{'B', 'T'}
+	AccountsDiffDBPrefix             = [2]byte{'A', 'D'}
+	StakingAccountsDiffDBPrefix      = [2]byte{'S', 'D'}
+	DexAccountsDiffDBPrefix          = [2]byte{'D', 'D'}
+	TransactionBlockHeightDBPrefix   = [2]byte{'T', 'B'}
+	EvmLogsDBPrefix                  = [2]byte{'L', 'G'}
+	EvmLogsAddressIndexDBPrefix      = [2]byte{'L', 'A'}
+	EvmLogsTopicIndexDBPrefix        = [2]byte{'L', 'T'}
+	PeerStoreDBPrefix                = [2]byte{'P', 'S'}
+	SideBlocksDBPrefix               = [2]byte{'S', 'B'}
+	FinalizedDBPrefix                = [2]byte{'F', 'H'}
+	DownloadedBlocksDBPrefix         = [2]byte{'D', 'B'}
+	SnapshotManifestDBPrefix         = [2]byte{'S', 'M'}
+	SnapshotChunkDBPrefix            = [2]byte{'S', 'N'}
+	BaseHeightDBPrefix               = [2]byte{'B', 'S'}
+	VMStateDBPrefix                  = [2]byte{'V', 'S'}
+	VMStateDiffDBPrefix              = [2]byte{'V', 'D'}
+	DexOrderEventsDBPrefix           = [2]byte{'O', 'E'}
+	DexOrderEventsTokenIndexDBPrefix = [2]byte{'O', 'T'}
+	SealedBlockDBPrefix              = [2]byte{'S', 'L'}
+	SentNonceDBPrefix                = [2]byte{'N', 'O'}
+	StateMigratedDBPrefix            = [2]byte{'M', 'G'}
+	TransactionFailedDBPrefix        = [2]byte{'T', 'F'}
 )
 
 var chainID = int16(23)
@@ -90,6 +136,28 @@ var rewardPercentage int16
 var ShiftToPastInReset int64
 var ShiftToPastMutex sync.RWMutex
 
+// DevMode is set by SetDevMode, node runs local chain of single validator in memory
+var DevMode bool
+
+// envErr keeps error of reading node settings from .env, local chain of dev mode does not need them
+var envErr error
+
+// SetDevMode is called by node started with --dev flag, node operates first delegated account
+func SetDevMode() {
+	DevMode = true
+	StateRootForkHeight = 0
+	DifficultyForkHeight = 0
+	SlashingForkHeight = 0
+	DexForkHeight = 0
+	delegatedAccount = GetDelegatedAccountAddress(1)
+	CurrentHeightOfNetwork = 0
+}
+
+// EnvError returns error of reading .env, programs which need node settings should stop on it
+func EnvError() error {
+	return envErr
+}
+
 func GetChainID() int16 {
 	chainIDMutex.Lock()
 	defer chainIDMutex.Unlock()
@@ -155,33 +223,41 @@ func init() {
 
 	//log.SetOutput(io.Discard)
 	ShiftToPastInReset = 1
+	envErr = loadEnv()
+	if envErr != nil {
+		logger.GetLogger().Println(envErr)
+	}
+}
+
+// loadEnv reads node settings from .env, variables are also used by other packages
+func loadEnv() error {
 	homePath, err := os.UserHomeDir()
 	if err != nil {
-		logger.GetLogger().Fatal(err)
+		return err
 	}
 	err = godotenv.Load(homePath + "/.qwid/.env")
 	if err != nil {
-		logger.GetLogger().Fatal("Error loading .env file", err)
+		return fmt.Errorf("Error loading .env file %v", err)
 	}
 	da, err := strconv.Atoi(os.Getenv("DELEGATED_ACCOUNT"))
 	if err != nil {
-		logger.GetLogger().Fatal("Error getting DELEGATED_ACCOUNT")
+		return fmt.Errorf("Error getting DELEGATED_ACCOUNT")
 	}
 	delegatedAccount = GetDelegatedAccountAddress(int16(da))
 
 	//DefaultPercentageReward int16 = 500 // 50 %
 	v, err := strconv.Atoi(os.Getenv("REWARD_PERCENTAGE"))
 	if err != nil {
-		logger.GetLogger().Fatal("Error getting REWARD_PERCENTAGE")
+		return fmt.Errorf("Error getting REWARD_PERCENTAGE")
 	}
 	rewardPercentage = int16(v)
 	if rewardPercentage > 500 {
-		logger.GetLogger().Fatal("reward for operational account has to be less than 50%")
+		return fmt.Errorf("reward for operational account has to be less than 50%%")
 	}
 	ch, err := strconv.Atoi(os.Getenv("HEIGHT_OF_NETWORK"))
 	if err != nil {
-		logger.GetLogger().Panicln("Warning no declaration of HEIGHT_OF_NETWORK")
-	} else {
-		CurrentHeightOfNetwork = int64(ch)
+		return fmt.Errorf("Warning no declaration of HEIGHT_OF_NETWORK")
 	}
+	CurrentHeightOfNetwork = int64(ch)
+	return nil
 }
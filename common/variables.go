@@ -7,6 +7,8 @@ import (
 
 var height int64
 var heightMax int64
+var finalizedHeight int64
+var baseHeight int64
 var heightMutex sync.RWMutex
 var BlockMutex sync.Mutex
 var NonceMutex sync.Mutex
@@ -35,3 +37,29 @@ func SetHeightMax(hmax int64) {
 	defer heightMutex.Unlock()
 	heightMax = hmax
 }
+
+// GetFinalizedHeight returns height of last finalized block, blocks up to it cannot be reverted
+func GetFinalizedHeight() int64 {
+	heightMutex.RLock()
+	defer heightMutex.RUnlock()
+	return finalizedHeight
+}
+
+func SetFinalizedHeight(h int64) {
+	heightMutex.Lock()
+	defer heightMutex.Unlock()
+	finalizedHeight = h
+}
+
+// GetBaseHeight returns the lowest height of blocks and state stored, it is above 0 when node started from snapshot
+func GetBaseHeight() int64 {
+	heightMutex.RLock()
+	defer heightMutex.RUnlock()
+	return baseHeight
+}
+
+func SetBaseHeight(h int64) {
+	heightMutex.Lock()
+	defer heightMutex.Unlock()
+	baseHeight = h
+}
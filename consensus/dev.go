@@ -0,0 +1,52 @@
+package consensus
+
+import (
+	"bytes"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/finality"
+)
+
+// Dev is deterministic engine of single validator for local networks: every block signed by validator
+// is valid, difficulty does not change and each block is final at once.
+type Dev struct {
+	Validator common.Address
+}
+
+func NewDevEngine(validator common.Address) Dev {
+	return Dev{Validator: validator}
+}
+
+func (Dev) Name() string {
+	return "dev"
+}
+
+func (Dev) CalcDifficulty(parent blocks.Block, timestamp int64) int32 {
+	return parent.GetHeader().Difficulty
+}
+
+func (e Dev) PrepareHeader(parent blocks.Block, header *blocks.BaseHeader, timestamp int64) {
+	header.Difficulty = e.CalcDifficulty(parent, timestamp)
+}
+
+func (e Dev) Seal(candidate blocks.Block) bool {
+	return e.VerifySeal(candidate.BaseBlock)
+}
+
+func (e Dev) VerifySeal(bb blocks.BaseBlock) bool {
+	return bytes.Equal(bb.BaseHeader.OperatorAccount.GetBytes(), e.Validator.GetBytes())
+}
+
+func (Dev) VerifyDifficulty(parent blocks.BaseBlock, bb blocks.BaseBlock) bool {
+	return bb.BaseHeader.Difficulty == parent.BaseHeader.Difficulty
+}
+
+func (Dev) Reward(supply int64) int64 {
+	return account.GetReward(supply)
+}
+
+func (Dev) Finalize(bl blocks.Block) error {
+	return finality.StoreFinalized(bl.GetHeader().Height, bl.GetBlockHash().GetBytes())
+}
@@ -0,0 +1,48 @@
+// Package consensus keeps rules of block production, node uses one engine set at start.
+package consensus
+
+import (
+	"sync"
+
+	"github.com/wonabru/qwid-node/blocks"
+)
+
+// Engine is set of consensus rules
+type Engine interface {
+	Name() string
+	// CalcDifficulty returns difficulty of block following parent, produced at timestamp
+	CalcDifficulty(parent blocks.Block, timestamp int64) int32
+	// PrepareHeader sets consensus fields of header of block following parent, before header is signed
+	PrepareHeader(parent blocks.Block, header *blocks.BaseHeader, timestamp int64)
+	// Seal tells whether signed candidate block can be broadcast as new block
+	Seal(candidate blocks.Block) bool
+	// VerifySeal checks that block was produced according to rules of engine
+	VerifySeal(bb blocks.BaseBlock) bool
+	// VerifyDifficulty checks difficulty of block following parent
+	VerifyDifficulty(parent blocks.BaseBlock, bb blocks.BaseBlock) bool
+	// Reward is reward of block following block of given supply
+	Reward(supply int64) int64
+	// Finalize is called after block is added to main chain
+	Finalize(bl blocks.Block) error
+}
+
+var (
+	engine      Engine = ProofOfSynergy{}
+	engineMutex sync.RWMutex
+)
+
+// SetEngine sets rules used by node, it should be called at start before blocks are processed
+func SetEngine(e Engine) {
+	engineMutex.Lock()
+	defer engineMutex.Unlock()
+	engine = e
+	blocks.SealVerifier = e.VerifySeal
+	blocks.DifficultyVerifier = e.VerifyDifficulty
+	blocks.BlockReward = e.Reward
+}
+
+func GetEngine() Engine {
+	engineMutex.RLock()
+	defer engineMutex.RUnlock()
+	return engine
+}
@@ -0,0 +1,33 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+)
+
+func TestSetEngine(t *testing.T) {
+	defer SetEngine(ProofOfSynergy{})
+
+	validator, err := common.BytesToAddress(make([]byte, common.AddressLength))
+	assert.NoError(t, err)
+	SetEngine(NewDevEngine(validator))
+	assert.Equal(t, "dev", GetEngine().Name())
+
+	bb := blocks.BaseBlock{BaseHeader: blocks.BaseHeader{OperatorAccount: validator, Difficulty: 7}}
+	assert.True(t, blocks.Block{BaseBlock: bb}.CheckSeal())
+	other, err := common.BytesToAddress(append([]byte{1}, make([]byte, common.AddressLength-1)...))
+	assert.NoError(t, err)
+	bb.BaseHeader.OperatorAccount = other
+	assert.False(t, blocks.Block{BaseBlock: bb}.CheckSeal())
+
+	header := blocks.BaseHeader{}
+	GetEngine().PrepareHeader(blocks.Block{BaseBlock: bb}, &header, 100)
+	assert.Equal(t, int32(7), header.Difficulty)
+	assert.True(t, blocks.Block{BaseBlock: blocks.BaseBlock{BaseHeader: header}}.CheckDifficulty(blocks.Block{BaseBlock: bb}))
+	header.Difficulty = 8
+	assert.False(t, blocks.Block{BaseBlock: blocks.BaseBlock{BaseHeader: header}}.CheckDifficulty(blocks.Block{BaseBlock: bb}))
+	assert.Equal(t, GetEngine().Reward(1000), blocks.BlockReward(1000))
+}
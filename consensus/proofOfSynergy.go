@@ -0,0 +1,43 @@
+package consensus
+
+import (
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/blocks"
+)
+
+// ProofOfSynergy is default engine: every operator signs candidate block in each nonce round,
+// block is valid when hash of its header is small enough for difficulty. Blocks are finalized by votes
+// of delegated accounts in nonce transactions (package finality).
+type ProofOfSynergy struct{}
+
+func (ProofOfSynergy) Name() string {
+	return "proof-of-synergy"
+}
+
+func (ProofOfSynergy) CalcDifficulty(parent blocks.Block, timestamp int64) int32 {
+	return blocks.AdjustDifficulty(parent.GetHeader().Difficulty, timestamp-parent.GetBlockTimeStamp())
+}
+
+func (e ProofOfSynergy) PrepareHeader(parent blocks.Block, header *blocks.BaseHeader, timestamp int64) {
+	header.Difficulty = e.CalcDifficulty(parent, timestamp)
+}
+
+func (e ProofOfSynergy) Seal(candidate blocks.Block) bool {
+	return e.VerifySeal(candidate.BaseBlock)
+}
+
+func (ProofOfSynergy) VerifySeal(bb blocks.BaseBlock) bool {
+	return blocks.CheckProofOfSynergy(bb)
+}
+
+func (ProofOfSynergy) VerifyDifficulty(parent blocks.BaseBlock, bb blocks.BaseBlock) bool {
+	return blocks.CheckDifficultyOfSynergy(parent, bb)
+}
+
+func (ProofOfSynergy) Reward(supply int64) int64 {
+	return account.GetReward(supply)
+}
+
+func (ProofOfSynergy) Finalize(bl blocks.Block) error {
+	return nil
+}
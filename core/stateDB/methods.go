@@ -5,7 +5,9 @@ import (
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/core/types"
 	"github.com/wonabru/qwid-node/crypto"
+	"github.com/wonabru/qwid-node/logger"
 	"math/big"
+	"sort"
 )
 
 type TokenInfo struct {
@@ -27,6 +29,55 @@ type StateAccount struct {
 	SnapShotPreimage    map[int]map[[common.AddressLength]byte]common.Hash                  `json:"snapShotPreimage"`
 	HeightToSnapShotNum map[int64]int                                                       `json:"HeightToSnapShotNum"` // suppose int should be replaced by int64
 	ContractsByHeight   map[int64][][common.AddressLength]byte                              `json:"contractsByHeight"`
+	Logs                []*types.Log                                                        `json:"-"`
+	logPreimage         map[int]int
+	txHash              common.Hash
+	txIndex             uint
+	balancePreimage     map[int]balanceChange
+	changed             changedState
+}
+
+// balanceChange keeps native coin balance from before EVM modification, so reverted calls can restore it
+type balanceChange struct {
+	address [common.AddressLength]byte
+	prev    int64
+	existed bool
+}
+
+// changedState collects addresses and preimages changed since it was taken, so only their records
+// are hashed and stored with block. all is set when whole state is new.
+type changedState struct {
+	addrs     map[[common.AddressLength]byte]bool
+	preimages map[common.Hash]bool
+	all       bool
+}
+
+func (sa *StateAccount) markChanged(a [common.AddressLength]byte) {
+	if sa.changed.addrs == nil {
+		(*sa).changed.addrs = map[[common.AddressLength]byte]bool{}
+	}
+	(*sa).changed.addrs[a] = true
+}
+
+// ChangedState returns addresses and preimages changed since TakeChangedState without clearing them,
+// all tells that whole state has to be compared
+func (sa *StateAccount) ChangedState() (map[[common.AddressLength]byte]bool, map[common.Hash]bool, bool) {
+	addrs := make(map[[common.AddressLength]byte]bool, len(sa.changed.addrs))
+	for a := range sa.changed.addrs {
+		addrs[a] = true
+	}
+	preimages := make(map[common.Hash]bool, len(sa.changed.preimages))
+	for h := range sa.changed.preimages {
+		preimages[h] = true
+	}
+	return addrs, preimages, sa.changed.all
+}
+
+// TakeChangedState returns changed addresses and preimages and clears them
+func (sa *StateAccount) TakeChangedState() (map[[common.AddressLength]byte]bool, map[common.Hash]bool, bool) {
+	c := sa.changed
+	(*sa).changed = changedState{}
+	return c.addrs, c.preimages, c.all
 }
 
 func CreateStateDB() StateAccount {
@@ -43,6 +94,7 @@ func CreateStateDB() StateAccount {
 	sa.SnapShotPreimage = map[int]map[[common.AddressLength]byte]common.Hash{}
 	sa.HeightToSnapShotNum = map[int64]int{}
 	sa.ContractsByHeight = map[int64][][common.AddressLength]byte{}
+	sa.changed.all = true
 	return sa
 }
 
@@ -66,6 +118,7 @@ func (sa *StateAccount) CleanupContractsAfterHeight(height int64) {
 				delete(sa.Nonces, addr)
 				delete(sa.Codes, addr)
 				delete(sa.CodeHashes, addr)
+				sa.markChanged(addr)
 			}
 			delete(sa.ContractsByHeight, h)
 		}
@@ -85,6 +138,7 @@ func (sa *StateAccount) CreateAccount(a common.Address) {
 		TransactionsRecipient: make([]common.Hash, 0),
 	}
 	(*sa).Accounts[a.ByteValue] = acc
+	sa.markChanged(a.ByteValue)
 }
 
 func (sa *StateAccount) GetAllRegisteredTokens() map[[common.AddressLength]byte]TokenInfo {
@@ -98,16 +152,70 @@ func (sa *StateAccount) RegisterNewToken(a common.Address, name string, symbol s
 		Decimals: decimals,
 	}
 	(*sa).Tokens[a.ByteValue] = ti
+	sa.markChanged(a.ByteValue)
 }
 
-func (sa *StateAccount) SubBalance(common.Address, *big.Int) {
+// setBalance sets native coin balance in account.Accounts. Previous balance is journaled under snapshot number
+// of the change, so it is reverted together with EVM call.
+func (sa *StateAccount) setBalance(a common.Address, balance int64) {
+	account.AccountsRWMutex.Lock()
+	defer account.AccountsRWMutex.Unlock()
+	acc, ok := account.Accounts.AllAccounts[a.ByteValue]
+	(*sa).SnapShotNum++
+	if sa.balancePreimage == nil {
+		(*sa).balancePreimage = map[int]balanceChange{}
+	}
+	(*sa).balancePreimage[sa.SnapShotNum] = balanceChange{
+		address: a.ByteValue,
+		prev:    acc.Balance,
+		existed: ok,
+	}
+	if !ok {
+		acc = account.Account{
+			Address:               a.ByteValue,
+			MultiSignAddresses:    make([][common.AddressLength]byte, 0),
+			TransactionsSender:    make([]common.Hash, 0),
+			TransactionsRecipient: make([]common.Hash, 0),
+		}
+	}
+	acc.Balance = balance
+	account.Accounts.AllAccounts[a.ByteValue] = acc
+	account.AccountChanged(a.ByteValue)
+}
+
+func (sa *StateAccount) SubBalance(a common.Address, amount *big.Int) {
+	if amount.Sign() == 0 {
+		return
+	}
+	balance := new(big.Int).Sub(sa.GetBalance(a), amount)
+	if !balance.IsInt64() || balance.Sign() < 0 {
+		logger.GetLogger().Println("wrong balance after subtraction in EVM", a.GetHex(), balance)
+		return
+	}
+	sa.setBalance(a, balance.Int64())
+}
 
+func (sa *StateAccount) AddBalance(a common.Address, amount *big.Int) {
+	if amount.Sign() == 0 {
+		return
+	}
+	balance := new(big.Int).Add(sa.GetBalance(a), amount)
+	if !balance.IsInt64() || balance.Sign() < 0 {
+		logger.GetLogger().Println("wrong balance after addition in EVM", a.GetHex(), balance)
+		return
+	}
+	sa.setBalance(a, balance.Int64())
 }
-func (sa *StateAccount) AddBalance(common.Address, *big.Int) {
 
+// GetBalance returns native coin balance in the smallest units
+func (sa *StateAccount) GetBalance(a common.Address) *big.Int {
+	return new(big.Int).SetInt64(account.GetBalance(a.ByteValue))
 }
-func (sa *StateAccount) GetBalance(common.Address) *big.Int {
-	return new(big.Int).SetInt64(0)
+
+// ClearBalanceJournal drops journaled balance changes. Should be called when top level EVM call ends,
+// so later reverts of VM state to block height do not touch account balances.
+func (sa *StateAccount) ClearBalanceJournal() {
+	(*sa).balancePreimage = nil
 }
 
 func (sa *StateAccount) GetNonce(a common.Address) uint64 {
@@ -115,6 +223,7 @@ func (sa *StateAccount) GetNonce(a common.Address) uint64 {
 }
 func (sa *StateAccount) SetNonce(a common.Address, n uint64) {
 	(*sa).Nonces[a.ByteValue] = n
+	sa.markChanged(a.ByteValue)
 }
 
 func (sa *StateAccount) GetCodeHash(a common.Address) common.Hash {
@@ -128,6 +237,7 @@ func (sa *StateAccount) GetCode(a common.Address) []byte {
 func (sa *StateAccount) SetCode(a common.Address, c []byte) {
 	(*sa).Codes[a.ByteValue] = c
 	(*sa).CodeHashes[a.ByteValue] = crypto.Keccak256Hash(c)
+	sa.markChanged(a.ByteValue)
 }
 
 func (sa *StateAccount) GetCodeSize(a common.Address) int {
@@ -160,6 +270,7 @@ func (sa *StateAccount) GetState(a common.Address, h common.Hash) common.Hash {
 }
 func (sa *StateAccount) SetState(a common.Address, h common.Hash, h2 common.Hash) {
 	(*sa).SnapShotNum++
+	sa.markChanged(a.ByteValue)
 
 	_, ok := (*sa).StatesHashes[a.ByteValue]
 	if ok {
@@ -217,6 +328,7 @@ func (sa *StateAccount) AddSlotToAccessList(addr common.Address, slot common.Has
 func (sa *StateAccount) RevertToSnapshot(sn int) {
 	for s := sn + 1; s <= sa.SnapShotNum; s++ {
 		for a, h := range sa.SnapShotPreimage[s] {
+			sa.markChanged(a)
 			if sa.SnapShotPreimage[s][a] == common.EmptyHash() {
 				delete((*sa).StatesHashes[a], h)
 				continue
@@ -224,6 +336,39 @@ func (sa *StateAccount) RevertToSnapshot(sn int) {
 			(*sa).StatesHashes[a][h] = sa.SnapShotPreimage[s][a]
 		}
 	}
+	n := len(sa.Logs)
+	for s, l := range sa.logPreimage {
+		if s > sn {
+			n = min(n, l)
+			delete((*sa).logPreimage, s)
+		}
+	}
+	(*sa).Logs = sa.Logs[:n]
+	reverted := []int{}
+	for s := range sa.balancePreimage {
+		if s > sn {
+			reverted = append(reverted, s)
+		}
+	}
+	if len(reverted) > 0 {
+		// the latest changes are undone first, so every account ends with balance from before the earliest one
+		sort.Sort(sort.Reverse(sort.IntSlice(reverted)))
+		account.AccountsRWMutex.Lock()
+		for _, s := range reverted {
+			bc := sa.balancePreimage[s]
+			delete((*sa).balancePreimage, s)
+			if !bc.existed {
+				delete(account.Accounts.AllAccounts, bc.address)
+				account.AccountChanged(bc.address)
+				continue
+			}
+			acc := account.Accounts.AllAccounts[bc.address]
+			acc.Balance = bc.prev
+			account.Accounts.AllAccounts[bc.address] = acc
+			account.AccountChanged(bc.address)
+		}
+		account.AccountsRWMutex.Unlock()
+	}
 	(*sa).SnapShotNum = sn
 }
 
@@ -231,11 +376,40 @@ func (sa *StateAccount) Snapshot() int {
 	return sa.SnapShotNum
 }
 
-func (sa *StateAccount) AddLog(*types.Log) {
+// SetTxContext sets transaction to which logs emitted by EVM are attributed
+func (sa *StateAccount) SetTxContext(txHash common.Hash, txIndex int) {
+	(*sa).txHash = txHash
+	(*sa).txIndex = uint(txIndex)
+}
 
+// AddLog collects log emitted by EVM. Adding log counts as state change: number of logs before it
+// is journaled under its snapshot number, so reverted calls drop their logs.
+func (sa *StateAccount) AddLog(l *types.Log) {
+	(*sa).SnapShotNum++
+	if sa.logPreimage == nil {
+		(*sa).logPreimage = map[int]int{}
+	}
+	(*sa).logPreimage[sa.SnapShotNum] = len(sa.Logs)
+	l.TxHash = sa.txHash
+	l.TxIndex = sa.txIndex
+	l.Index = uint(len(sa.Logs))
+	(*sa).Logs = append(sa.Logs, l)
+}
+
+// TakeLogs returns logs collected since last call and clears them
+func (sa *StateAccount) TakeLogs() []*types.Log {
+	logs := sa.Logs
+	(*sa).Logs = nil
+	(*sa).logPreimage = nil
+	return logs
 }
+
 func (sa *StateAccount) AddPreimage(h common.Hash, b []byte) {
 	(*sa).States[h] = b
+	if sa.changed.preimages == nil {
+		(*sa).changed.preimages = map[common.Hash]bool{}
+	}
+	(*sa).changed.preimages[h] = true
 }
 
 func (sa *StateAccount) GetCoinBalance(acc common.Address, coin common.Address) int64 {
@@ -248,6 +422,7 @@ func (sa *StateAccount) GetCoinBalance(acc common.Address, coin common.Address)
 }
 
 func (sa *StateAccount) SetCoinBalance(acc common.Address, coin common.Address, value int64) {
+	sa.markChanged(acc.ByteValue)
 	_, ok := sa.Balances[acc.ByteValue]
 	if ok {
 		(*sa).Balances[acc.ByteValue][coin.ByteValue] = value
@@ -32,6 +32,16 @@ func InitDB() {
 	MainDB = pdb
 }
 
+// InitInMemoryDB sets main database kept only in memory, it is used by dev mode
+func InitInMemoryDB() {
+	db := &BlockchainDB{}
+	pdb, err := db.InitInMemory()
+	if err != nil {
+		logger.GetLogger().Fatal("failed to initialize in memory database:", err)
+	}
+	MainDB = pdb
+}
+
 func CloseDB() error {
 	var err error
 
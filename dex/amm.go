@@ -0,0 +1,219 @@
+// Package dex computes trades and liquidity operations of pools of token and coin with integers only,
+// so every node gets the same amounts. Amounts are rounded always in favour of pool.
+package dex
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// feeDenominator of swap fee given in basis points
+const feeDenominator = 10000
+
+// Pool is constant product pool of coin and token, liquidity is owned by holders of shares
+type Pool struct {
+	Coin        int64
+	Token       int64
+	TotalShares int64
+}
+
+// Quote is result of operation on pool, amounts are received by trader and are negative when paid to pool.
+// Shares are minted for provider, negative when burned. Price is coins per token after operation,
+// with common.Decimals+token decimals decimal places.
+type Quote struct {
+	Coin   int64
+	Token  int64
+	Shares int64
+	Price  int64
+}
+
+// Apply returns pool after operation
+func (p Pool) Apply(q Quote) Pool {
+	return Pool{Coin: p.Coin - q.Coin, Token: p.Token - q.Token, TotalShares: p.TotalShares + q.Shares}
+}
+
+// mulDiv returns a*b/c rounded down (towards minus infinity), or up when roundUp. c has to be positive
+func mulDiv(a, b, c int64, roundUp bool) (int64, error) {
+	if c <= 0 {
+		return 0, fmt.Errorf("division by not positive number in dex")
+	}
+	return div(new(big.Int).Mul(big.NewInt(a), big.NewInt(b)), big.NewInt(c), roundUp)
+}
+
+func div(x, y *big.Int, roundUp bool) (int64, error) {
+	if y.Sign() <= 0 {
+		return 0, fmt.Errorf("division by not positive number in dex")
+	}
+	x = new(big.Int).Set(x)
+	if roundUp {
+		x.Add(x, new(big.Int).Sub(y, big.NewInt(1)))
+	}
+	// Div of big.Int rounds towards minus infinity for positive divisor
+	x.Div(x, y)
+	if !x.IsInt64() {
+		return 0, fmt.Errorf("amount overflow in dex")
+	}
+	return x.Int64(), nil
+}
+
+// Price is coin per token of given amounts with common.Decimals+tokenDecimals decimal places, rounded down
+func Price(coin, token int64, tokenDecimals uint8) int64 {
+	if coin <= 0 || token <= 0 {
+		return 0
+	}
+	x := new(big.Int).Mul(big.NewInt(coin), new(big.Int).Exp(big.NewInt(10), big.NewInt(2*int64(tokenDecimals)), nil))
+	x.Div(x, big.NewInt(token))
+	if !x.IsInt64() {
+		return 0
+	}
+	return x.Int64()
+}
+
+// AddLiquidity mints shares for coin and token given by provider. Only amounts in pool ratio are taken,
+// the first provider of empty pool sets the ratio and gets sqrt(coin*token) shares.
+func AddLiquidity(p Pool, coin, token int64, tokenDecimals uint8) (Quote, error) {
+	if coin <= 0 || token <= 0 {
+		return Quote{}, fmt.Errorf("added liquidity has to be positive")
+	}
+	if p.TotalShares == 0 {
+		// otherwise provider would get coins and tokens left in pool
+		if p.Coin != 0 || p.Token != 0 {
+			return Quote{}, fmt.Errorf("pool has liquidity without shares")
+		}
+		shares := new(big.Int).Sqrt(new(big.Int).Mul(big.NewInt(coin), big.NewInt(token)))
+		if shares.Sign() <= 0 || !shares.IsInt64() {
+			return Quote{}, fmt.Errorf("too small liquidity added")
+		}
+		q := Quote{Coin: -coin, Token: -token, Shares: shares.Int64()}
+		q.Price = Price(p.Coin+coin, p.Token+token, tokenDecimals)
+		return q, nil
+	}
+	if p.Coin <= 0 || p.Token <= 0 {
+		return Quote{}, fmt.Errorf("pool has no liquidity")
+	}
+	sharesCoin, err := mulDiv(coin, p.TotalShares, p.Coin, false)
+	if err != nil {
+		return Quote{}, err
+	}
+	sharesToken, err := mulDiv(token, p.TotalShares, p.Token, false)
+	if err != nil {
+		return Quote{}, err
+	}
+	shares := min(sharesCoin, sharesToken)
+	if shares <= 0 {
+		return Quote{}, fmt.Errorf("too small liquidity added")
+	}
+	// provider pays rounded up, so shares are never worth more than paid
+	coinIn, err := mulDiv(shares, p.Coin, p.TotalShares, true)
+	if err != nil {
+		return Quote{}, err
+	}
+	tokenIn, err := mulDiv(shares, p.Token, p.TotalShares, true)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Coin: -coinIn, Token: -tokenIn, Shares: shares, Price: Price(p.Coin+coinIn, p.Token+tokenIn, tokenDecimals)}, nil
+}
+
+// RemoveLiquidity burns shares for their part of pool, rounded down
+func RemoveLiquidity(p Pool, shares int64, tokenDecimals uint8) (Quote, error) {
+	if shares <= 0 || shares > p.TotalShares {
+		return Quote{}, fmt.Errorf("wrong number of shares to burn")
+	}
+	coin, err := mulDiv(shares, p.Coin, p.TotalShares, false)
+	if err != nil {
+		return Quote{}, err
+	}
+	token, err := mulDiv(shares, p.Token, p.TotalShares, false)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Coin: coin, Token: token, Shares: -shares, Price: Price(p.Coin-coin, p.Token-token, tokenDecimals)}, nil
+}
+
+// SharesForToken is number of shares which has to be burned to get token from pool, rounded up
+func SharesForToken(p Pool, token int64) (int64, error) {
+	return mulDiv(token, p.TotalShares, p.Token, true)
+}
+
+// SharesForCoin is number of shares which has to be burned to get coin from pool, rounded up
+func SharesForCoin(p Pool, coin int64) (int64, error) {
+	return mulDiv(coin, p.TotalShares, p.Coin, true)
+}
+
+// Trade gives tokenOut tokens from pool (tokens are sold to pool when negative) for coins, so that product
+// of pool amounts is kept. Swap fee in basis points stays in pool, so it goes to providers.
+func Trade(p Pool, tokenOut, fee int64, tokenDecimals uint8) (Quote, error) {
+	if tokenOut == 0 {
+		return Quote{}, fmt.Errorf("traded amount cannot be 0")
+	}
+	if p.Coin <= 0 || p.Token <= 0 {
+		return Quote{}, fmt.Errorf("pool has no liquidity")
+	}
+	if fee < 0 || fee >= feeDenominator {
+		return Quote{}, fmt.Errorf("wrong swap fee %v", fee)
+	}
+	var q Quote
+	if tokenOut > 0 {
+		if tokenOut >= p.Token {
+			return Quote{}, fmt.Errorf("not enough tokens in pool")
+		}
+		// coinIn = coin*tokenOut/(token-tokenOut)/(1-fee), rounded up
+		x := new(big.Int).Mul(big.NewInt(p.Coin), big.NewInt(tokenOut))
+		x.Mul(x, big.NewInt(feeDenominator))
+		y := new(big.Int).Mul(big.NewInt(p.Token-tokenOut), big.NewInt(feeDenominator-fee))
+		coinIn, err := div(x, y, true)
+		if err != nil {
+			return Quote{}, err
+		}
+		q = Quote{Coin: -coinIn, Token: tokenOut}
+	} else {
+		// coinOut = coin*in/(token+in) where in = tokenIn*(1-fee), rounded down
+		in := new(big.Int).Mul(big.NewInt(-tokenOut), big.NewInt(feeDenominator-fee))
+		x := new(big.Int).Mul(big.NewInt(p.Coin), in)
+		y := new(big.Int).Mul(big.NewInt(p.Token), big.NewInt(feeDenominator))
+		y.Add(y, in)
+		coinOut, err := div(x, y, false)
+		if err != nil {
+			return Quote{}, err
+		}
+		q = Quote{Coin: coinOut, Token: tokenOut}
+	}
+	err := CheckInvariant(p, q)
+	if err != nil {
+		return Quote{}, err
+	}
+	after := p.Apply(q)
+	q.Price = Price(after.Coin, after.Token, tokenDecimals)
+	return q, nil
+}
+
+// CheckInvariant checks that product of pool amounts does not decrease after trade
+func CheckInvariant(p Pool, q Quote) error {
+	newCoin := new(big.Int).Sub(big.NewInt(p.Coin), big.NewInt(q.Coin))
+	newToken := new(big.Int).Sub(big.NewInt(p.Token), big.NewInt(q.Token))
+	if newCoin.Sign() < 0 || newToken.Sign() < 0 {
+		return fmt.Errorf("pool cannot be negative after trade")
+	}
+	k := new(big.Int).Mul(big.NewInt(p.Coin), big.NewInt(p.Token))
+	if new(big.Int).Mul(newCoin, newToken).Cmp(k) < 0 {
+		return fmt.Errorf("product of pool amounts decreases in trade")
+	}
+	return nil
+}
+
+// ShareValue is coin and token which shares are worth, rounded down
+func ShareValue(p Pool, shares int64) (int64, int64) {
+	if shares <= 0 || p.TotalShares <= 0 {
+		return 0, 0
+	}
+	coin, err := mulDiv(shares, p.Coin, p.TotalShares, false)
+	if err != nil {
+		return 0, 0
+	}
+	token, err := mulDiv(shares, p.Token, p.TotalShares, false)
+	if err != nil {
+		return 0, 0
+	}
+	return coin, token
+}
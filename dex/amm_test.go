@@ -0,0 +1,120 @@
+package dex
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+const fee = 30
+
+func TestTradeMatchesConstantProduct(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		p := Pool{Coin: r.Int63n(1e15) + 1, Token: r.Int63n(1e15) + 2, TotalShares: 1}
+		tokenOut := r.Int63n(p.Token/2+1) - p.Token/4
+		if tokenOut == 0 {
+			continue
+		}
+		q, err := Trade(p, tokenOut, fee, uint8(r.Intn(9)))
+		assert.NoError(t, err)
+		assert.Equal(t, tokenOut, q.Token)
+		assert.NoError(t, CheckInvariant(p, q))
+
+		// exact amount of coins without rounding
+		f := big.NewRat(feeDenominator-fee, feeDenominator)
+		var exact *big.Rat
+		if tokenOut > 0 {
+			exact = new(big.Rat).SetFrac(big.NewInt(-p.Coin), big.NewInt(p.Token-tokenOut))
+			exact.Mul(exact, new(big.Rat).SetInt64(tokenOut))
+			exact.Quo(exact, f)
+		} else {
+			in := new(big.Rat).Mul(new(big.Rat).SetInt64(-tokenOut), f)
+			exact = new(big.Rat).Mul(new(big.Rat).SetInt64(p.Coin), in)
+			exact.Quo(exact, in.Add(in, new(big.Rat).SetInt64(p.Token)))
+		}
+		// rounded down, so never in favour of trader
+		coin := new(big.Rat).SetInt64(q.Coin)
+		assert.True(t, coin.Cmp(exact) <= 0)
+		assert.True(t, coin.Add(coin, big.NewRat(1, 1)).Cmp(exact) > 0)
+	}
+}
+
+func TestTradeRoundsInFavourOfPool(t *testing.T) {
+	p := Pool{Coin: 10, Token: 8, TotalShares: 1}
+	// buying 1 token costs 10/7/0.997 coins, selling gives 10*0.997/8.997
+	q, err := Trade(p, 1, fee, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-2), q.Coin)
+	q, err = Trade(p, -1, fee, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), q.Coin)
+
+	_, err = Trade(p, 8, fee, 0)
+	assert.Error(t, err)
+	_, err = Trade(p, 0, fee, 0)
+	assert.Error(t, err)
+	_, err = Trade(Pool{}, 1, fee, 0)
+	assert.Error(t, err)
+}
+
+func TestFeesGoToProviders(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	q, err := AddLiquidity(Pool{}, 1e12, 5e11, 8)
+	assert.NoError(t, err)
+	p := Pool{}.Apply(q)
+	k := new(big.Int).Mul(big.NewInt(p.Coin), big.NewInt(p.Token))
+	for i := 0; i < 1000; i++ {
+		q, err := Trade(p, r.Int63n(p.Token/3)-p.Token/6, fee, 8)
+		if err != nil {
+			continue
+		}
+		assert.NoError(t, CheckInvariant(p, q))
+		p = p.Apply(q)
+	}
+	// product grows with every trade, so shares are worth more
+	assert.Equal(t, 1, new(big.Int).Mul(big.NewInt(p.Coin), big.NewInt(p.Token)).Cmp(k))
+	assert.Error(t, CheckInvariant(Pool{Coin: 100, Token: 100}, Quote{Coin: 10, Token: -10}))
+}
+
+func TestLiquidityShares(t *testing.T) {
+	q, err := AddLiquidity(Pool{}, 400, 100, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Quote{Coin: -400, Token: -100, Shares: 200, Price: 4}, q)
+	p := Pool{}.Apply(q)
+
+	// only amounts in pool ratio are taken, provider pays rounded up
+	q, err = AddLiquidity(p, 401, 1000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), q.Shares)
+	assert.Equal(t, int64(-400), q.Coin)
+	assert.Equal(t, int64(-100), q.Token)
+	p = p.Apply(q)
+	assert.Equal(t, Pool{Coin: 800, Token: 200, TotalShares: 400}, p)
+
+	shares, err := SharesForToken(p, 51)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(102), shares)
+	q, err = RemoveLiquidity(p, shares, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Quote{Coin: 204, Token: 51, Shares: -102, Price: 4}, q)
+	shares, err = SharesForCoin(p, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), shares)
+
+	_, err = RemoveLiquidity(p, 401, 0)
+	assert.Error(t, err)
+	_, err = AddLiquidity(p, 1, 1, 0)
+	assert.Error(t, err)
+
+	coin, token := ShareValue(p, 100)
+	assert.Equal(t, int64(200), coin)
+	assert.Equal(t, int64(50), token)
+
+	// liquidity left without shares cannot be taken by the next provider
+	_, err = AddLiquidity(Pool{Coin: 800, Token: 200}, 1, 1000, 0)
+	assert.Error(t, err)
+	_, err = AddLiquidity(Pool{Token: 1}, 400, 100, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,433 @@
+package dex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// ErrNoOrder is returned when cancelled order is not in book, e.g. it was filled or expired before.
+// Such transaction fails alone and the block stays valid.
+var ErrNoOrder = errors.New("no open dex order")
+
+// MaxOpenOrders is maximum number of orders resting in book of one token
+const MaxOpenOrders = 1000
+
+// MaxOwnerOrders is maximum number of orders of one owner resting in book of one token
+const MaxOwnerOrders = 50
+
+// LimitOrder rests in book of token until it is filled, cancelled or expired. Amount is token left to fill,
+// price is coins per one whole token (10^token decimals). Buy order locks Escrow coins on DEX account,
+// sell order locks Amount tokens. Expiry equal 0 never expires.
+type LimitOrder struct {
+	ID     int64
+	Owner  [20]byte
+	Sell   bool
+	Amount int64
+	Price  int64
+	Escrow int64
+	Expiry int64
+}
+
+// Book is order book of token against coin, orders are in order of placement
+type Book struct {
+	Orders []LimitOrder
+	LastID int64
+}
+
+// kinds of events of orders
+const (
+	EventPlaced    = 1
+	EventFilled    = 2
+	EventCancelled = 3
+	EventExpired   = 4
+)
+
+// Event is change of order. Fill has amount of token and coins traded at price, counter is order
+// on the other side or 0 for pool. Refund is escrow returned to owner when order is closed,
+// coins of buy order and tokens of sell order. Placed event has coins locked by buy order.
+type Event struct {
+	Kind    int
+	ID      int64
+	Owner   [20]byte
+	Sell    bool
+	Amount  int64
+	Coin    int64
+	Price   int64
+	Counter int64
+	Refund  int64
+}
+
+// Payout is coins and tokens which DEX account pays to owner of order for event
+func (e Event) Payout() (int64, int64) {
+	coin, token := int64(0), int64(0)
+	if e.Kind == EventFilled {
+		if e.Sell {
+			coin = e.Coin
+		} else {
+			token = e.Amount
+		}
+	}
+	if e.Sell {
+		token += e.Refund
+	} else {
+		coin += e.Refund
+	}
+	return coin, token
+}
+
+// Placement is OptData of placing of limit order: side, amount of token, price and expiry height
+type Placement struct {
+	Sell   bool
+	Amount int64
+	Price  int64
+	Expiry int64
+}
+
+func (pl Placement) GetBytes() []byte {
+	b := []byte{0}
+	if pl.Sell {
+		b[0] = 1
+	}
+	b = binary.LittleEndian.AppendUint64(b, uint64(pl.Amount))
+	b = binary.LittleEndian.AppendUint64(b, uint64(pl.Price))
+	return binary.LittleEndian.AppendUint64(b, uint64(pl.Expiry))
+}
+
+// PlacementFromBytes decodes placing of order, amount and price have to be positive
+func PlacementFromBytes(b []byte) (Placement, error) {
+	if len(b) != 25 || b[0] > 1 {
+		return Placement{}, fmt.Errorf("wrong dex order placement")
+	}
+	pl := Placement{
+		Sell:   b[0] == 1,
+		Amount: int64(binary.LittleEndian.Uint64(b[1:9])),
+		Price:  int64(binary.LittleEndian.Uint64(b[9:17])),
+		Expiry: int64(binary.LittleEndian.Uint64(b[17:25])),
+	}
+	if pl.Amount <= 0 || pl.Price <= 0 || pl.Expiry < 0 {
+		return Placement{}, fmt.Errorf("amount and price of dex order have to be positive")
+	}
+	return pl, nil
+}
+
+// CancelBytes is OptData of cancelling of order with given id
+func CancelBytes(id int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(id))
+}
+
+func CancelFromBytes(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("wrong length of dex order cancel %v", len(b))
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+func tokenUnit(tokenDecimals uint8) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(tokenDecimals)), nil)
+}
+
+// CoinsForToken is value of token at price, rounded down. Round up gives escrow of buy order.
+func CoinsForToken(token, price int64, tokenDecimals uint8, roundUp bool) (int64, error) {
+	return div(new(big.Int).Mul(big.NewInt(token), big.NewInt(price)), tokenUnit(tokenDecimals), roundUp)
+}
+
+// withinPrice checks that coin for token is not worse than price for order
+func withinPrice(sell bool, coin, token, price int64, tokenDecimals uint8) bool {
+	paid := new(big.Int).Mul(big.NewInt(coin), tokenUnit(tokenDecimals))
+	limit := new(big.Int).Mul(big.NewInt(token), big.NewInt(price))
+	if sell {
+		return paid.Cmp(limit) >= 0
+	}
+	return paid.Cmp(limit) <= 0
+}
+
+// poolFill trades with pool at most maxToken until marginal price of pool reaches price.
+// Returns false when pool gives nothing at this price.
+func poolFill(p Pool, sell bool, price, maxToken, fee int64, tokenDecimals uint8) (Quote, bool) {
+	if p.Coin <= 0 || p.Token <= 0 || price <= 0 || maxToken <= 0 {
+		return Quote{}, false
+	}
+	k := new(big.Int).Mul(big.NewInt(p.Coin), big.NewInt(p.Token))
+	k.Mul(k, tokenUnit(tokenDecimals))
+	t := new(big.Int)
+	if sell {
+		// pool pays price for last token when (token+in)^2 = coin*token*(1-fee)/price, in = t*(1-fee)
+		k.Mul(k, big.NewInt(feeDenominator-fee))
+		k.Div(k, new(big.Int).Mul(big.NewInt(price), big.NewInt(feeDenominator)))
+		t.Sub(k.Sqrt(k), big.NewInt(p.Token))
+		t.Mul(t, big.NewInt(feeDenominator))
+		t.Div(t, big.NewInt(feeDenominator-fee))
+	} else {
+		// price of last token bought is price when (token-t)^2 = coin*token/(1-fee)/price
+		k.Mul(k, big.NewInt(feeDenominator))
+		y := new(big.Int).Mul(big.NewInt(price), big.NewInt(feeDenominator-fee))
+		k.Add(k, new(big.Int).Sub(y, big.NewInt(1)))
+		k.Div(k, y)
+		root := new(big.Int).Sqrt(k)
+		if new(big.Int).Mul(root, root).Cmp(k) < 0 {
+			root.Add(root, big.NewInt(1))
+		}
+		t.Sub(big.NewInt(p.Token), root)
+	}
+	if t.Sign() <= 0 {
+		return Quote{}, false
+	}
+	amount := maxToken
+	if t.IsInt64() && t.Int64() < maxToken {
+		amount = t.Int64()
+	}
+	if sell {
+		amount = -amount
+	}
+	q, err := Trade(p, amount, fee, tokenDecimals)
+	if err != nil || q.Coin == 0 {
+		return Quote{}, false
+	}
+	coin := q.Coin
+	if !sell {
+		coin = -coin
+	}
+	// rounding of small trades can make average price worse than limit
+	if !withinPrice(sell, coin, max(amount, -amount), price, tokenDecimals) {
+		return Quote{}, false
+	}
+	return q, true
+}
+
+// fill trades token for coin of order at price
+func fill(o *LimitOrder, token, coin, price, counter int64) Event {
+	o.Amount -= token
+	if !o.Sell {
+		o.Escrow -= coin
+	}
+	e := Event{Kind: EventFilled, ID: o.ID, Owner: o.Owner, Sell: o.Sell, Amount: token, Coin: coin, Price: price, Counter: counter}
+	if o.Amount == 0 && !o.Sell {
+		e.Refund = o.Escrow
+		o.Escrow = 0
+	}
+	return e
+}
+
+// fillFromPool trades order with pool up to price, pool gets coins or tokens which are in escrow of order
+func fillFromPool(p Pool, o *LimitOrder, price, fee int64, tokenDecimals uint8) (Pool, []Event) {
+	q, ok := poolFill(p, o.Sell, price, o.Amount, fee, tokenDecimals)
+	if !ok {
+		return p, nil
+	}
+	token, coin := q.Token, -q.Coin
+	if o.Sell {
+		token, coin = -q.Token, q.Coin
+	}
+	if !o.Sell && coin > o.Escrow {
+		return p, nil
+	}
+	avg, err := mulDiv(coin, tokenUnit(tokenDecimals).Int64(), token, false)
+	if err != nil {
+		return p, nil
+	}
+	return p.Apply(q), []Event{fill(o, token, coin, avg, 0)}
+}
+
+// best returns index of the best order on given side crossing price, the oldest one among equal prices.
+// Returns -1 when there is no such order.
+func (b Book) best(sell bool, price int64) int {
+	i := -1
+	for j, o := range b.Orders {
+		if o.Sell != sell || sell && o.Price > price || !sell && o.Price < price {
+			continue
+		}
+		if i < 0 || sell && o.Price < b.Orders[i].Price || !sell && o.Price > b.Orders[i].Price {
+			i = j
+		}
+	}
+	return i
+}
+
+func (b *Book) remove(i int) {
+	b.Orders = append(b.Orders[:i:i], b.Orders[i+1:]...)
+}
+
+// Place matches new order against book and pool, always with the better price first: pool is traded
+// until its price reaches price of the best crossing order, then the order is filled at its price.
+// Rest of order is added to book. Order worth less than minValue coins is not placed, so book cannot be
+// filled with cheap orders. Returns new book and pool with events, the first one is placed event.
+func (b Book) Place(p Pool, o LimitOrder, fee, minValue int64, tokenDecimals uint8) (Book, Pool, []Event, error) {
+	if len(b.Orders) >= MaxOpenOrders {
+		return b, p, nil, fmt.Errorf("%w: book is full with %v orders", ErrLimit, len(b.Orders))
+	}
+	if o.Amount <= 0 || o.Price <= 0 {
+		return b, p, nil, fmt.Errorf("amount and price of dex order have to be positive")
+	}
+	value, err := CoinsForToken(o.Amount, o.Price, tokenDecimals, false)
+	if err != nil {
+		return b, p, nil, err
+	}
+	if value < minValue {
+		return b, p, nil, fmt.Errorf("%w: order value %v is below minimum %v", ErrLimit, value, minValue)
+	}
+	owned := 0
+	for _, r := range b.Orders {
+		if r.Owner == o.Owner {
+			owned++
+		}
+	}
+	if owned >= MaxOwnerOrders {
+		return b, p, nil, fmt.Errorf("%w: owner has %v open orders", ErrLimit, owned)
+	}
+	o.Escrow = 0
+	if !o.Sell {
+		escrow, err := CoinsForToken(o.Amount, o.Price, tokenDecimals, true)
+		if err != nil {
+			return b, p, nil, err
+		}
+		o.Escrow = escrow
+	}
+	b.Orders = append([]LimitOrder{}, b.Orders...)
+	b.LastID++
+	o.ID = b.LastID
+	events := []Event{{Kind: EventPlaced, ID: o.ID, Owner: o.Owner, Sell: o.Sell, Amount: o.Amount, Coin: o.Escrow, Price: o.Price}}
+
+	for o.Amount > 0 {
+		i := b.best(!o.Sell, o.Price)
+		target := o.Price
+		if i >= 0 {
+			target = b.Orders[i].Price
+		}
+		var es []Event
+		p, es = fillFromPool(p, &o, target, fee, tokenDecimals)
+		events = append(events, es...)
+		if o.Amount == 0 || i < 0 {
+			break
+		}
+		m := &b.Orders[i]
+		token := min(o.Amount, m.Amount)
+		coin, err := CoinsForToken(token, m.Price, tokenDecimals, false)
+		if err != nil || coin <= 0 || !o.Sell && coin > o.Escrow || o.Sell && coin > m.Escrow {
+			break
+		}
+		events = append(events, fill(m, token, coin, m.Price, o.ID), fill(&o, token, coin, m.Price, m.ID))
+		if m.Amount == 0 {
+			b.remove(i)
+		}
+	}
+	if o.Amount > 0 && b.best(!o.Sell, o.Price) >= 0 {
+		// rest is too small to be traded at price of crossing order, it cannot stay in book
+		events = append(events, closeEvent(o, EventCancelled))
+	} else if o.Amount > 0 {
+		b.Orders = append(b.Orders, o)
+	}
+	return b, p, events, nil
+}
+
+// Cancel removes order of owner from book and returns its escrow
+func (b Book) Cancel(id int64, owner [20]byte) (Book, Event, error) {
+	for i, o := range b.Orders {
+		if o.ID != id {
+			continue
+		}
+		if o.Owner != owner {
+			return b, Event{}, fmt.Errorf("only owner can cancel dex order")
+		}
+		b.Orders = append([]LimitOrder{}, b.Orders...)
+		b.remove(i)
+		return b, closeEvent(o, EventCancelled), nil
+	}
+	return b, Event{}, fmt.Errorf("%w: order %v", ErrNoOrder, id)
+}
+
+func closeEvent(o LimitOrder, kind int) Event {
+	e := Event{Kind: kind, ID: o.ID, Owner: o.Owner, Sell: o.Sell, Amount: o.Amount, Price: o.Price, Refund: o.Escrow}
+	if o.Sell {
+		e.Refund = o.Amount
+	}
+	return e
+}
+
+// Settle is done at the end of every block: orders expired at height are removed, and orders crossing
+// price of pool moved by trades are filled with pool, bids from the highest price and then asks
+// from the lowest one, the oldest first among equal prices.
+func (b Book) Settle(p Pool, height, fee int64, tokenDecimals uint8) (Book, Pool, []Event) {
+	events := []Event{}
+	orders := []LimitOrder{}
+	for _, o := range b.Orders {
+		if o.Expiry > 0 && height > o.Expiry {
+			events = append(events, closeEvent(o, EventExpired))
+			continue
+		}
+		orders = append(orders, o)
+	}
+	idx := make([]int, len(orders))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, c := orders[idx[i]], orders[idx[j]]
+		if a.Sell != c.Sell {
+			return !a.Sell
+		}
+		if a.Sell {
+			return a.Price < c.Price
+		}
+		return a.Price > c.Price
+	})
+	for _, i := range idx {
+		var es []Event
+		p, es = fillFromPool(p, &orders[i], orders[i].Price, fee, tokenDecimals)
+		events = append(events, es...)
+	}
+	b.Orders = []LimitOrder{}
+	for _, o := range orders {
+		if o.Amount > 0 {
+			b.Orders = append(b.Orders, o)
+		}
+	}
+	return b, p, events
+}
+
+// Level is amount of token in orders at one price
+type Level struct {
+	Price  int64
+	Amount int64
+	Orders int
+}
+
+// Depth returns bids from the highest price and asks from the lowest one
+func (b Book) Depth() ([]Level, []Level) {
+	levels := map[bool]map[int64]*Level{false: {}, true: {}}
+	for _, o := range b.Orders {
+		l, ok := levels[o.Sell][o.Price]
+		if !ok {
+			l = &Level{Price: o.Price}
+			levels[o.Sell][o.Price] = l
+		}
+		l.Amount += o.Amount
+		l.Orders++
+	}
+	sides := [2][]Level{}
+	for i, sell := range []bool{false, true} {
+		for _, l := range levels[sell] {
+			sides[i] = append(sides[i], *l)
+		}
+		sort.Slice(sides[i], func(a, c int) bool {
+			if sell {
+				return sides[i][a].Price < sides[i][c].Price
+			}
+			return sides[i][a].Price > sides[i][c].Price
+		})
+	}
+	return sides[0], sides[1]
+}
+
+// OwnerOrders returns open orders of owner
+func (b Book) OwnerOrders(owner [20]byte) []LimitOrder {
+	orders := []LimitOrder{}
+	for _, o := range b.Orders {
+		if o.Owner == owner {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
@@ -0,0 +1,127 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// minValue of placed order is 1 QWD, as on nodes
+const minValue = 1e8
+
+func TestPlacementBytes(t *testing.T) {
+	pl := Placement{Sell: true, Amount: 5, Price: 7, Expiry: 100}
+	restored, err := PlacementFromBytes(pl.GetBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, pl, restored)
+	_, err = PlacementFromBytes(Placement{Amount: 5}.GetBytes())
+	assert.Error(t, err)
+
+	id, err := CancelFromBytes(CancelBytes(3))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), id)
+}
+
+func TestBookMatching(t *testing.T) {
+	const unit = 1e8
+	alice, bob := [20]byte{1}, [20]byte{2}
+	b := Book{}
+
+	// no pool and no minimum of order value, orders rest in book
+	b, p, events, err := b.Place(Pool{}, LimitOrder{Owner: alice, Sell: true, Amount: 100, Price: 2 * unit}, fee, 0, 8)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	b, p, events, err = b.Place(p, LimitOrder{Owner: alice, Sell: true, Amount: 100, Price: 3 * unit}, fee, 0, 8)
+	assert.NoError(t, err)
+	b, p, events, err = b.Place(p, LimitOrder{Owner: bob, Amount: 150, Price: 4 * unit}, fee, 0, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, Event{Kind: EventPlaced, ID: 3, Owner: bob, Amount: 150, Coin: 600, Price: 4 * unit}, events[0])
+	// filled at prices of resting orders, the cheaper first, rest of escrow is returned
+	assert.Equal(t, Event{Kind: EventFilled, ID: 1, Owner: alice, Sell: true, Amount: 100, Coin: 200, Price: 2 * unit, Counter: 3}, events[1])
+	assert.Equal(t, Event{Kind: EventFilled, ID: 3, Owner: bob, Amount: 100, Coin: 200, Price: 2 * unit, Counter: 1}, events[2])
+	assert.Equal(t, Event{Kind: EventFilled, ID: 3, Owner: bob, Amount: 50, Coin: 150, Price: 3 * unit, Counter: 2, Refund: 250}, events[4])
+	coin, token := events[4].Payout()
+	assert.Equal(t, int64(250), coin)
+	assert.Equal(t, int64(50), token)
+	assert.Len(t, b.Orders, 1)
+	assert.Equal(t, int64(50), b.Orders[0].Amount)
+
+	_, _, err = b.Cancel(2, bob)
+	assert.Error(t, err)
+	b, e, err := b.Cancel(2, alice)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), e.Refund)
+	_, _, err = b.Cancel(2, alice)
+	assert.ErrorIs(t, err, ErrNoOrder)
+	assert.Empty(t, b.Orders)
+}
+
+func TestBookPool(t *testing.T) {
+	const unit = 1e8
+	alice := [20]byte{1}
+	p := Pool{Coin: 1e12, Token: 1e12, TotalShares: 1e12}
+
+	// buy below pool price rests in book
+	b, p2, events, err := Book{}.Place(p, LimitOrder{Owner: alice, Amount: 1e9, Price: unit * 99 / 100, Expiry: 10}, fee, minValue, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, p, p2)
+	assert.Len(t, events, 1)
+	bids, asks := b.Depth()
+	assert.Equal(t, []Level{{Price: unit * 99 / 100, Amount: 1e9, Orders: 1}}, bids)
+	assert.Empty(t, asks)
+
+	// pool price moved down by sells, bid is filled with pool at the end of block
+	q, err := Trade(p, -2e10, fee, 8)
+	assert.NoError(t, err)
+	b, p2, events = b.Settle(p.Apply(q), 5, fee, 8)
+	assert.Len(t, events, 1)
+	assert.Equal(t, EventFilled, events[0].Kind)
+	assert.LessOrEqual(t, events[0].Price, int64(unit*99/100))
+	assert.NoError(t, CheckInvariant(p.Apply(q), Quote{Coin: -events[0].Coin, Token: events[0].Amount}))
+	assert.Equal(t, p.Apply(q).Coin+events[0].Coin, p2.Coin)
+	// bought cheaper than limit, so part of escrow is returned
+	assert.Equal(t, int64(1e9), events[0].Amount)
+	assert.Greater(t, events[0].Refund, int64(0))
+	assert.Empty(t, b.Orders)
+
+	b, _, _, err = b.Place(p, LimitOrder{Owner: alice, Amount: 1e9, Price: unit / 2, Expiry: 10}, fee, minValue, 8)
+	assert.NoError(t, err)
+	b, _, events = b.Settle(p, 11, fee, 8)
+	assert.Equal(t, []Event{{Kind: EventExpired, ID: 2, Owner: alice, Amount: 1e9, Price: unit / 2, Refund: 5e8}}, events)
+	assert.Empty(t, b.Orders)
+
+	// sell above pool price is partly filled by pool, up to its limit
+	b, p2, events, err = Book{}.Place(p, LimitOrder{Owner: alice, Sell: true, Amount: 1e11, Price: unit * 99 / 100}, fee, minValue, 8)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.GreaterOrEqual(t, events[1].Price, int64(unit*99/100))
+	assert.Less(t, events[1].Amount, int64(1e11))
+	assert.Equal(t, int64(1e11)-events[1].Amount, b.Orders[0].Amount)
+	assert.Equal(t, p.Token+events[1].Amount, p2.Token)
+}
+
+func TestBookLimits(t *testing.T) {
+	const unit = 1e8
+	alice, bob := [20]byte{1}, [20]byte{2}
+	b := Book{}
+
+	// order worth less than 1 QWD cannot be placed
+	_, _, _, err := b.Place(Pool{}, LimitOrder{Owner: alice, Amount: 1, Price: 1}, fee, minValue, 8)
+	assert.ErrorIs(t, err, ErrLimit)
+	_, _, _, err = b.Place(Pool{}, LimitOrder{Owner: alice, Sell: true, Amount: unit - 1, Price: unit}, fee, minValue, 8)
+	assert.ErrorIs(t, err, ErrLimit)
+	_, _, events, err := b.Place(Pool{}, LimitOrder{Owner: alice, Sell: true, Amount: unit, Price: unit}, fee, minValue, 8)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	// one owner cannot fill the book, others still place orders
+	for i := 0; i < MaxOwnerOrders; i++ {
+		b, _, _, err = b.Place(Pool{}, LimitOrder{Owner: alice, Amount: unit, Price: unit}, fee, minValue, 8)
+		assert.NoError(t, err)
+	}
+	_, _, _, err = b.Place(Pool{}, LimitOrder{Owner: alice, Amount: unit, Price: unit}, fee, minValue, 8)
+	assert.ErrorIs(t, err, ErrLimit)
+	b, _, _, err = b.Place(Pool{}, LimitOrder{Owner: bob, Amount: unit, Price: unit}, fee, minValue, 8)
+	assert.NoError(t, err)
+	assert.Len(t, b.Orders, MaxOwnerOrders+1)
+}
@@ -0,0 +1,68 @@
+package dex
+
+import (
+	"fmt"
+	"math"
+)
+
+// LegacyQuote computes operation on pool with floats, as blocks below fork of integer pricing were computed.
+// It has to give the same amounts as before the fork, so nodes replaying old blocks get the same state.
+// Amounts are 0 when pool has no price.
+func LegacyQuote(p Pool, operation int, coin, token int64, coinDecimals, tokenDecimals uint8) (Quote, error) {
+	tokenPoolAmount := toFloat(p.Token, tokenDecimals)
+	coinPoolAmount := toFloat(p.Coin, coinDecimals)
+	amountTokenFloat := toFloat(token, tokenDecimals)
+	amountCoinFloat := toFloat(coin, coinDecimals)
+	priceDecimals := int(coinDecimals + tokenDecimals)
+
+	poolPrice := float64(0)
+	if coinPoolAmount > 0 && tokenPoolAmount > 0 {
+		poolPrice = round(coinPoolAmount/tokenPoolAmount, priceDecimals)
+	}
+	price := 0.0
+	var q Quote
+	switch operation {
+	case OpAddLiquidity:
+		q.Coin = int64(-amountCoinFloat * math.Pow10(int(coinDecimals)))
+		q.Token = int64(-amountTokenFloat * math.Pow10(int(tokenDecimals)))
+		price = round(amountCoinFloat/amountTokenFloat, priceDecimals)
+	case OpWithdrawToken:
+		if poolPrice > 0 {
+			price = poolPrice
+			amount := round(poolPrice*amountTokenFloat, int(coinDecimals))
+			q.Coin = int64(amount * math.Pow10(int(coinDecimals)))
+			q.Token = int64(amountTokenFloat * math.Pow10(int(tokenDecimals)))
+		}
+	case OpWithdrawCoin:
+		if poolPrice > 0 {
+			price = poolPrice
+			amount := round(1.0/poolPrice*amountCoinFloat, int(tokenDecimals))
+			q.Token = int64(amount * math.Pow10(int(tokenDecimals)))
+			q.Coin = int64(amountCoinFloat * math.Pow10(int(coinDecimals)))
+		}
+	case OpBuy, OpSell:
+		if operation == OpSell {
+			amountTokenFloat *= -1
+		}
+		if coinPoolAmount > 0 && tokenPoolAmount-2*amountTokenFloat > 0 {
+			price = round(coinPoolAmount/(tokenPoolAmount-2*amountTokenFloat), priceDecimals)
+		}
+		if price > 0 {
+			amount := round(-price*amountTokenFloat, int(coinDecimals))
+			q.Coin = int64(amount * math.Pow10(int(coinDecimals)))
+			q.Token = int64(amountTokenFloat * math.Pow10(int(tokenDecimals)))
+		}
+	default:
+		return Quote{}, fmt.Errorf("wrong operation on dex")
+	}
+	q.Price = int64(price * math.Pow10(priceDecimals))
+	return q, nil
+}
+
+func toFloat(value int64, decimals uint8) float64 {
+	return float64(value) * math.Pow10(-int(decimals))
+}
+
+func round(v float64, decimals int) float64 {
+	return math.Round(v*math.Pow10(decimals)) * math.Pow10(-decimals)
+}
@@ -0,0 +1,36 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLegacyQuote(t *testing.T) {
+	const e8 = int64(1e8)
+	p := Pool{Coin: 1000 * e8, Token: 500 * e8}
+
+	q, err := LegacyQuote(p, OpBuy, 0, 50*e8, 8, 8)
+	assert.NoError(t, err)
+	// price of buy is coin pool over token pool lowered by twice the amount: 1000/400
+	assert.Equal(t, Quote{Coin: -125 * e8, Token: 50 * e8, Price: 25 * 1e15}, q)
+
+	q, err = LegacyQuote(p, OpSell, 0, 250*e8, 8, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, Quote{Coin: 250 * e8, Token: -250 * e8, Price: 1e16}, q)
+
+	q, err = LegacyQuote(p, OpAddLiquidity, 10*e8, 5*e8, 8, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, Quote{Coin: -10 * e8, Token: -5 * e8, Price: 2 * 1e16}, q)
+
+	q, err = LegacyQuote(p, OpWithdrawToken, 0, 5*e8, 8, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, Quote{Coin: 10 * e8, Token: 5 * e8, Price: 2 * 1e16}, q)
+
+	q, err = LegacyQuote(Pool{}, OpBuy, 0, 50*e8, 8, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, Quote{}, q)
+
+	_, err = LegacyQuote(p, OpSwap, 0, 50*e8, 8, 8)
+	assert.Error(t, err)
+}
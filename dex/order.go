@@ -0,0 +1,160 @@
+package dex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// operations on DEX, transaction is sent to delegated account 512+operation
+const (
+	OpAddLiquidity  = 2
+	OpBuy           = 3
+	OpSell          = 4
+	OpWithdrawToken = 5
+	OpWithdrawCoin  = 6
+	OpSwap          = 7
+	OpPlaceOrder    = 8
+	OpCancelOrder   = 9
+)
+
+// ErrLimit is returned when order cannot be executed within its limit or is expired.
+// Such transaction fails alone and the block stays valid.
+var ErrLimit = errors.New("dex order limit violated")
+
+// Order is OptData of DEX transaction: amount of token, optionally followed by limit and expiry height.
+// Limit is maximum coins paid for buy, minimum coins received for sell, minimum shares minted
+// when adding liquidity, maximum shares burned when withdrawing and minimum of last token received for swap.
+// Limit or expiry equal 0 are not checked.
+type Order struct {
+	Amount int64
+	Limit  int64
+	Expiry int64
+}
+
+func (o Order) GetBytes() []byte {
+	b := binary.LittleEndian.AppendUint64(nil, uint64(o.Amount))
+	if o.Limit == 0 && o.Expiry == 0 {
+		return b
+	}
+	b = binary.LittleEndian.AppendUint64(b, uint64(o.Limit))
+	return binary.LittleEndian.AppendUint64(b, uint64(o.Expiry))
+}
+
+// OrderFromBytes decodes order, old transactions have only amount of token
+func OrderFromBytes(b []byte) (Order, error) {
+	if len(b) != 8 && len(b) != 24 {
+		return Order{}, fmt.Errorf("wrong length of dex order %v", len(b))
+	}
+	o := Order{Amount: int64(binary.LittleEndian.Uint64(b[:8]))}
+	if len(b) == 24 {
+		o.Limit = int64(binary.LittleEndian.Uint64(b[8:16]))
+		o.Expiry = int64(binary.LittleEndian.Uint64(b[16:24]))
+	}
+	if o.Limit < 0 || o.Expiry < 0 {
+		return Order{}, fmt.Errorf("limit and expiry of dex order cannot be negative")
+	}
+	return o, nil
+}
+
+// Check verifies that quote of operation is within limit of order at given height
+func (o Order) Check(operation int, q Quote, height int64) error {
+	if o.Expiry > 0 && height > o.Expiry {
+		return fmt.Errorf("%w: order expired at height %v", ErrLimit, o.Expiry)
+	}
+	if o.Limit == 0 {
+		return nil
+	}
+	var ok bool
+	switch operation {
+	case OpBuy:
+		ok = -q.Coin <= o.Limit
+	case OpSell:
+		ok = q.Coin >= o.Limit
+	case OpAddLiquidity:
+		ok = q.Shares >= o.Limit
+	case OpWithdrawToken, OpWithdrawCoin:
+		ok = -q.Shares <= o.Limit
+	case OpSwap:
+		ok = q.Token >= o.Limit
+	default:
+		return fmt.Errorf("wrong operation on dex")
+	}
+	if !ok {
+		return fmt.Errorf("%w: got %v coins, %v tokens, %v shares, limit %v", ErrLimit, q.Coin, q.Token, q.Shares, o.Limit)
+	}
+	return nil
+}
+
+// QuoteOperation computes operation on pool as it is done in block, for given amounts of coin and token
+func QuoteOperation(p Pool, operation int, coin, token, fee int64, tokenDecimals uint8) (Quote, error) {
+	switch operation {
+	case OpAddLiquidity:
+		return AddLiquidity(p, coin, token, tokenDecimals)
+	case OpWithdrawToken:
+		shares, err := SharesForToken(p, token)
+		if err != nil {
+			return Quote{}, err
+		}
+		return RemoveLiquidity(p, shares, tokenDecimals)
+	case OpWithdrawCoin:
+		shares, err := SharesForCoin(p, coin)
+		if err != nil {
+			return Quote{}, err
+		}
+		return RemoveLiquidity(p, shares, tokenDecimals)
+	case OpBuy:
+		return Trade(p, token, fee, tokenDecimals)
+	case OpSell:
+		return Trade(p, -token, fee, tokenDecimals)
+	}
+	return Quote{}, fmt.Errorf("wrong operation on dex")
+}
+
+// LimitWithSlippage gives limit of order for current pool, allowing worse execution by slippage in basis points
+func LimitWithSlippage(p Pool, operation int, coin, token, fee, slippage int64) (int64, error) {
+	if slippage < 0 || slippage >= feeDenominator {
+		return 0, fmt.Errorf("wrong slippage %v", slippage)
+	}
+	q, err := QuoteOperation(p, operation, coin, token, fee, 0)
+	if err != nil {
+		return 0, err
+	}
+	var limit int64
+	switch operation {
+	case OpBuy:
+		limit, err = mulDiv(-q.Coin, feeDenominator+slippage, feeDenominator, false)
+	case OpSell:
+		limit, err = mulDiv(q.Coin, feeDenominator-slippage, feeDenominator, false)
+	case OpAddLiquidity:
+		limit, err = mulDiv(q.Shares, feeDenominator-slippage, feeDenominator, false)
+	default:
+		limit, err = mulDiv(-q.Shares, feeDenominator+slippage, feeDenominator, false)
+	}
+	// limit 0 is not checked at all
+	return max(limit, 1), err
+}
+
+// wallets bound orders by 1% of slippage and 60 blocks when user gives no bounds
+const (
+	DefaultSlippage     = 100
+	DefaultExpiryBlocks = 60
+)
+
+// NewOrder creates order of operation on current pool with limit allowing slippage in basis points
+// and expiry after given number of blocks. Negative slippage gives order without limit
+// and expiryBlocks not larger than 0 order which never expires.
+func NewOrder(p Pool, operation int, coin, token, fee, slippage, height, expiryBlocks int64) (Order, error) {
+	o := Order{Amount: token}
+	if expiryBlocks > 0 {
+		o.Expiry = height + expiryBlocks
+	}
+	if slippage >= 0 {
+		limit, err := LimitWithSlippage(p, operation, coin, token, fee, slippage)
+		if err != nil {
+			return Order{}, err
+		}
+		o.Limit = limit
+	}
+	return o, nil
+}
@@ -0,0 +1,61 @@
+package dex
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderBytes(t *testing.T) {
+	o := Order{Amount: 5}
+	b := o.GetBytes()
+	assert.Len(t, b, 8)
+	restored, err := OrderFromBytes(b)
+	assert.NoError(t, err)
+	assert.Equal(t, o, restored)
+
+	o = Order{Amount: -5, Limit: 7, Expiry: 100}
+	restored, err = OrderFromBytes(o.GetBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, o, restored)
+
+	_, err = OrderFromBytes(make([]byte, 16))
+	assert.Error(t, err)
+	_, err = OrderFromBytes(Order{Amount: 1, Limit: -1}.GetBytes())
+	assert.Error(t, err)
+}
+
+func TestOrderCheck(t *testing.T) {
+	p := Pool{Coin: 10000, Token: 10000, TotalShares: 10000}
+	o, err := NewOrder(p, OpBuy, 0, 100, fee, DefaultSlippage, 50, DefaultExpiryBlocks)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50+DefaultExpiryBlocks), o.Expiry)
+
+	q, err := QuoteOperation(p, OpBuy, 0, 100, fee, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, o.Check(OpBuy, q, 60))
+	assert.True(t, errors.Is(o.Check(OpBuy, q, 50+DefaultExpiryBlocks+1), ErrLimit))
+
+	// price moved by trade before ours
+	q, err = QuoteOperation(p.Apply(Quote{Coin: -500, Token: 476}), OpBuy, 0, 100, fee, 0)
+	assert.NoError(t, err)
+	assert.True(t, errors.Is(o.Check(OpBuy, q, 60), ErrLimit))
+
+	o, err = NewOrder(p, OpSell, 0, 100, fee, DefaultSlippage, 50, 0)
+	assert.NoError(t, err)
+	q, err = QuoteOperation(p.Apply(Quote{Coin: 476, Token: -500}), OpSell, 0, 100, fee, 0)
+	assert.NoError(t, err)
+	assert.True(t, errors.Is(o.Check(OpSell, q, 1e9), ErrLimit))
+
+	o, err = NewOrder(p, OpWithdrawToken, 0, 100, fee, 0, 50, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), o.Limit)
+	q, err = QuoteOperation(p, OpWithdrawToken, 0, 100, fee, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, o.Check(OpWithdrawToken, q, 60))
+
+	o, err = NewOrder(p, OpAddLiquidity, 100, 100, fee, -1, 50, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Order{Amount: 100}, o)
+}
@@ -0,0 +1,149 @@
+package dex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// Swap is OptData of swap of tokens: order of first token (limit is minimum of last token received),
+// followed by addresses of next tokens. Every token is sold for coin and the coin buys the next token.
+type Swap struct {
+	Order Order
+	Path  [][20]byte
+}
+
+// Hop is one trade of swap on pool of given token of path. Price impact is how much worse is price
+// than pool price before trade, fee included, in basis points.
+type Hop struct {
+	Pool        int
+	Quote       Quote
+	AmountIn    int64
+	AmountOut   int64
+	PriceImpact int64
+}
+
+func (s Swap) GetBytes() []byte {
+	// swap has always limit and expiry, so path starts at the same place
+	b := binary.LittleEndian.AppendUint64(nil, uint64(s.Order.Amount))
+	b = binary.LittleEndian.AppendUint64(b, uint64(s.Order.Limit))
+	b = binary.LittleEndian.AppendUint64(b, uint64(s.Order.Expiry))
+	for _, a := range s.Path {
+		b = append(b, a[:]...)
+	}
+	return b
+}
+
+// SwapFromBytes decodes swap, path has to be not empty and tokens cannot repeat
+func SwapFromBytes(b []byte) (Swap, error) {
+	if len(b) < 24+20 || (len(b)-24)%20 != 0 {
+		return Swap{}, fmt.Errorf("wrong length of dex swap %v", len(b))
+	}
+	o, err := OrderFromBytes(b[:24])
+	if err != nil {
+		return Swap{}, err
+	}
+	if o.Amount <= 0 {
+		return Swap{}, fmt.Errorf("swapped amount has to be positive")
+	}
+	s := Swap{Order: o}
+	seen := map[[20]byte]bool{}
+	for i := 24; i < len(b); i += 20 {
+		a := [20]byte{}
+		copy(a[:], b[i:i+20])
+		if seen[a] {
+			return Swap{}, fmt.Errorf("token repeats in path of swap")
+		}
+		seen[a] = true
+		s.Path = append(s.Path, a)
+	}
+	return s, nil
+}
+
+// BuyWithCoin gives tokens from pool for coinIn coins, rounded down
+func BuyWithCoin(p Pool, coinIn, fee int64, tokenDecimals uint8) (Quote, error) {
+	if coinIn <= 0 {
+		return Quote{}, fmt.Errorf("traded amount has to be positive")
+	}
+	if p.Coin <= 0 || p.Token <= 0 {
+		return Quote{}, fmt.Errorf("pool has no liquidity")
+	}
+	if fee < 0 || fee >= feeDenominator {
+		return Quote{}, fmt.Errorf("wrong swap fee %v", fee)
+	}
+	// tokenOut = token*in/(coin+in) where in = coinIn*(1-fee)
+	in := new(big.Int).Mul(big.NewInt(coinIn), big.NewInt(feeDenominator-fee))
+	x := new(big.Int).Mul(big.NewInt(p.Token), in)
+	y := new(big.Int).Mul(big.NewInt(p.Coin), big.NewInt(feeDenominator))
+	tokenOut, err := div(x, y.Add(y, in), false)
+	if err != nil {
+		return Quote{}, err
+	}
+	if tokenOut <= 0 {
+		return Quote{}, fmt.Errorf("too small amount traded")
+	}
+	q := Quote{Coin: -coinIn, Token: tokenOut}
+	err = CheckInvariant(p, q)
+	if err != nil {
+		return Quote{}, err
+	}
+	after := p.Apply(q)
+	q.Price = Price(after.Coin, after.Token, tokenDecimals)
+	return q, nil
+}
+
+// priceImpact compares price of trade with price of pool reserves before it
+func priceImpact(in, out, reserveIn, reserveOut int64) int64 {
+	x := new(big.Int).Mul(big.NewInt(out), big.NewInt(reserveIn))
+	x.Mul(x, big.NewInt(feeDenominator))
+	y := new(big.Int).Mul(big.NewInt(in), big.NewInt(reserveOut))
+	if y.Sign() <= 0 {
+		return 0
+	}
+	return feeDenominator - x.Div(x, y).Int64()
+}
+
+// Route swaps amountIn of first token through coin to the last one. Pools are pools of tokens of path
+// including the first token, there are two hops for every next token. Returns amount of last token.
+func Route(pools []Pool, amountIn, fee int64, tokenDecimals []uint8) ([]Hop, int64, error) {
+	if len(pools) < 2 || len(pools) != len(tokenDecimals) {
+		return nil, 0, fmt.Errorf("swap needs at least two tokens")
+	}
+	hops := []Hop{}
+	amount := amountIn
+	for i, p := range pools {
+		if i > 0 {
+			q, err := BuyWithCoin(p, amount, fee, tokenDecimals[i])
+			if err != nil {
+				return nil, 0, err
+			}
+			hops = append(hops, Hop{Pool: i, Quote: q, AmountIn: amount, AmountOut: q.Token,
+				PriceImpact: priceImpact(amount, q.Token, p.Coin, p.Token)})
+			amount = q.Token
+			p = p.Apply(q)
+		}
+		if i < len(pools)-1 {
+			q, err := Trade(p, -amount, fee, tokenDecimals[i])
+			if err != nil {
+				return nil, 0, err
+			}
+			if q.Coin <= 0 {
+				return nil, 0, fmt.Errorf("too small amount traded")
+			}
+			hops = append(hops, Hop{Pool: i, Quote: q, AmountIn: amount, AmountOut: q.Coin,
+				PriceImpact: priceImpact(amount, q.Coin, p.Token, p.Coin)})
+			amount = q.Coin
+		}
+	}
+	return hops, amount, nil
+}
+
+// SwapLimit is minimum of last token allowing slippage in basis points from quoted amount
+func SwapLimit(out, slippage int64) (int64, error) {
+	if slippage < 0 || slippage >= feeDenominator {
+		return 0, fmt.Errorf("wrong slippage %v", slippage)
+	}
+	limit, err := mulDiv(out, feeDenominator-slippage, feeDenominator, false)
+	// limit 0 is not checked at all
+	return max(limit, 1), err
+}
@@ -0,0 +1,62 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwapBytes(t *testing.T) {
+	s := Swap{Order: Order{Amount: 100, Limit: 90}, Path: [][20]byte{{1}, {2}}}
+	restored, err := SwapFromBytes(s.GetBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, s, restored)
+
+	_, err = SwapFromBytes(Swap{Order: Order{Amount: 100}}.GetBytes())
+	assert.Error(t, err)
+	_, err = SwapFromBytes(Swap{Order: Order{Amount: 100}, Path: [][20]byte{{1}, {1}}}.GetBytes())
+	assert.Error(t, err)
+	_, err = SwapFromBytes(Swap{Order: Order{Amount: 0}, Path: [][20]byte{{1}}}.GetBytes())
+	assert.Error(t, err)
+}
+
+func TestRoute(t *testing.T) {
+	a := Pool{Coin: 1e12, Token: 5e11, TotalShares: 1}
+	b := Pool{Coin: 2e12, Token: 4e12, TotalShares: 1}
+	c := Pool{Coin: 1e12, Token: 1e12, TotalShares: 1}
+
+	hops, out, err := Route([]Pool{a, b}, 1e9, fee, []uint8{8, 8})
+	assert.NoError(t, err)
+	assert.Len(t, hops, 2)
+	// the same as selling token and buying the other one with all coins
+	sell, err := Trade(a, -1e9, fee, 8)
+	assert.NoError(t, err)
+	buy, err := BuyWithCoin(b, sell.Coin, fee, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, buy.Token, out)
+	assert.Equal(t, Hop{Pool: 0, Quote: sell, AmountIn: 1e9, AmountOut: sell.Coin, PriceImpact: hops[0].PriceImpact}, hops[0])
+	for _, h := range hops {
+		// fee is part of price impact
+		assert.GreaterOrEqual(t, h.PriceImpact, int64(fee))
+	}
+
+	// token in the middle is bought and sold on the same pool
+	hops, out, err = Route([]Pool{a, b, c}, 1e9, fee, []uint8{8, 8, 8})
+	assert.NoError(t, err)
+	assert.Len(t, hops, 4)
+	assert.Equal(t, 1, hops[1].Pool)
+	assert.Equal(t, 1, hops[2].Pool)
+	assert.Equal(t, hops[1].AmountOut, hops[2].AmountIn)
+	assert.NoError(t, CheckInvariant(b.Apply(hops[1].Quote), hops[2].Quote))
+	assert.Greater(t, out, int64(0))
+
+	_, _, err = Route([]Pool{a, {}}, 1e9, fee, []uint8{8, 8})
+	assert.Error(t, err)
+
+	limit, err := SwapLimit(1000, DefaultSlippage)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(990), limit)
+	o := Order{Amount: 1e9, Limit: limit}
+	assert.NoError(t, o.Check(OpSwap, Quote{Token: 990}, 1))
+	assert.ErrorIs(t, o.Check(OpSwap, Quote{Token: 989}, 1), ErrLimit)
+}
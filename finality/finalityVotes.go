@@ -0,0 +1,118 @@
+// Package finality finalizes blocks signed by more than 2/3 of stake of delegated accounts.
+package finality
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/database"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+// Vote is statement of delegated account, signed in nonce transaction, that block of hash is at height
+type Vote struct {
+	Hash   []byte `json:"hash"`
+	Height int64  `json:"height"`
+	Staked int64  `json:"staked"`
+}
+
+var (
+	Votes        = make(map[int64]map[uint8]Vote)
+	VotesRWMutex sync.RWMutex
+)
+
+// SaveVote keeps vote of delegated account and finalizes height when enough stake voted for the same hash
+func SaveVote(hash []byte, height int64, delegatedAccount common.Address, staked int64) error {
+	id, err := common.GetIDFromDelegatedAccountAddress(delegatedAccount)
+	if err != nil {
+		return err
+	}
+
+	if (id <= 0) || (id >= 256) {
+		return fmt.Errorf("delegated account is invalid: %d", id)
+	}
+	if len(hash) != common.HashLength {
+		return fmt.Errorf("wrong length of hash in finality vote")
+	}
+	if height <= common.GetFinalizedHeight() {
+		return nil
+	}
+	VotesRWMutex.Lock()
+	defer VotesRWMutex.Unlock()
+	if _, ok := Votes[height]; !ok {
+		Votes[height] = make(map[uint8]Vote)
+	}
+	Votes[height][uint8(id)] = Vote{
+		Hash:   hash,
+		Height: height,
+		Staked: staked,
+	}
+	hashVoted, ok := SumVotes(Votes[height], account.GetStakedInAllDelegatedAccounts())
+	if !ok {
+		return nil
+	}
+	if !blocks.IsOnMainChain(hashVoted, height) {
+		logger.GetLogger().Printf("more than 2/3 of stake voted for block %x at height %d, which is not in main chain", hashVoted, height)
+		return nil
+	}
+	err = StoreFinalized(height, hashVoted)
+	if err != nil {
+		return err
+	}
+	for hv := range Votes {
+		if hv <= height {
+			delete(Votes, hv)
+		}
+	}
+	return nil
+}
+
+// SumVotes returns hash for which more than 2/3 of total stake voted
+func SumVotes(votes map[uint8]Vote, totalStaked int64) ([]byte, bool) {
+	staked := map[[common.HashLength]byte]int64{}
+	for _, v := range votes {
+		h := [common.HashLength]byte{}
+		copy(h[:], v.Hash)
+		staked[h] += v.Staked
+		if staked[h] > 2*totalStaked/3 {
+			return v.Hash, true
+		}
+	}
+	return nil, false
+}
+
+// StoreFinalized marks block of hash at height as finalized, ex. after state was restored from snapshot
+func StoreFinalized(height int64, hash []byte) error {
+	err := database.MainDB.Put(common.FinalizedDBPrefix[:], append(common.GetByteInt64(height), hash...))
+	if err != nil {
+		return err
+	}
+	common.SetFinalizedHeight(height)
+	logger.GetLogger().Printf("block %d finalized", height)
+	return nil
+}
+
+// LoadFinalized sets finalized height stored in database, if the block is still in main chain
+func LoadFinalized() error {
+	v, err := database.MainDB.Get(common.FinalizedDBPrefix[:])
+	if err != nil {
+		return err
+	}
+	if len(v) != 8+common.HashLength {
+		return fmt.Errorf("wrong length of finalized checkpoint")
+	}
+	height := common.GetInt64FromByte(v[:8])
+	hash, err := blocks.LoadHashOfBlock(height)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, v[8:]) {
+		return fmt.Errorf("finalized block %d is not in main chain", height)
+	}
+	common.SetFinalizedHeight(height)
+	return nil
+}
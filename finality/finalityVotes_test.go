@@ -0,0 +1,29 @@
+package finality
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSumVotes(t *testing.T) {
+	hashA := make([]byte, 32)
+	hashB := make([]byte, 32)
+	hashB[0] = 1
+	votes := map[uint8]Vote{
+		1: {Hash: hashA, Height: 5, Staked: 40},
+		2: {Hash: hashB, Height: 5, Staked: 30},
+		3: {Hash: hashA, Height: 5, Staked: 26},
+	}
+	// 66 of 100 is not more than 2/3
+	_, ok := SumVotes(votes, 100)
+	assert.False(t, ok)
+
+	votes[4] = Vote{Hash: hashA, Height: 5, Staked: 1}
+	hash, ok := SumVotes(votes, 100)
+	assert.True(t, ok)
+	assert.Equal(t, hashA, hash)
+
+	_, ok = SumVotes(map[uint8]Vote{}, 100)
+	assert.False(t, ok)
+}
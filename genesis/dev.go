@@ -0,0 +1,140 @@
+package genesis
+
+import (
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/wallet"
+)
+
+const (
+	// DevChainID is chain id of local chain in dev mode
+	DevChainID int16 = 1337
+	// DevAccountBalance is amount given in genesis to every dev account
+	DevAccountBalance int64 = 1000000 * 100000000
+	// DevAccountsCount is number of wallets generated for pre-funded dev accounts
+	DevAccountsCount = 5
+	// DevWalletPassword encrypts generated dev wallets
+	DevWalletPassword = "dev"
+)
+
+var (
+	devGenesis     *Genesis
+	devGenesisOnce sync.Once
+	devWallets     []*wallet.Wallet
+	devWalletsDir  string
+	devWalletsOnce sync.Once
+)
+
+// DevWallets generates wallets of pre-funded dev accounts. They are stored in temporary directory
+// with DevWalletPassword, so wallet tools can load them while dev chain runs.
+func DevWallets() ([]*wallet.Wallet, string) {
+	devWalletsOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "qwid-dev-wallets-")
+		if err != nil {
+			logger.GetLogger().Fatal("cannot make directory of dev wallets:", err)
+		}
+		for i := 1; i <= DevAccountsCount; i++ {
+			w, err := wallet.NewEphemeralWallet(uint8(i), common.SigName(), common.SigName2())
+			if err != nil {
+				logger.GetLogger().Fatal("cannot generate dev wallet:", err)
+			}
+			w.HomePath = dir
+			w.SetPassword(DevWalletPassword)
+			err = w.StoreJSON()
+			if err != nil {
+				logger.GetLogger().Fatal("cannot store dev wallet:", err)
+			}
+			devWallets = append(devWallets, w)
+		}
+		devWalletsDir = dir
+	})
+	return devWallets, devWalletsDir
+}
+
+// DevAccounts returns addresses pre-funded in dev genesis: generated dev wallets
+// and optionally addresses given in DEV_ACCOUNTS as comma separated hex
+func DevAccounts() []common.Address {
+	addrs := []common.Address{}
+	ws, _ := DevWallets()
+	for _, w := range ws {
+		addrs = append(addrs, w.MainAddress)
+	}
+	for _, s := range strings.Split(os.Getenv("DEV_ACCOUNTS"), ",") {
+		s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+		if s == "" {
+			continue
+		}
+		ab, err := hex.DecodeString(s)
+		if err != nil {
+			logger.GetLogger().Fatal("wrong address in DEV_ACCOUNTS", s, err)
+		}
+		a, err := common.BytesToAddress(ab)
+		if err != nil {
+			logger.GetLogger().Fatal("wrong address in DEV_ACCOUNTS", s, err)
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
+// DevGenesis makes genesis of local chain, active wallet is the only operator and stakes in the first delegated account.
+// Genesis is made once, so params and genesis block are the same.
+func DevGenesis() Genesis {
+	devGenesisOnce.Do(func() {
+		w := wallet.GetActiveWallet()
+		g := Genesis{
+			Timestamp:                    common.GetCurrentTimeStampInSecond(),
+			ChainID:                      DevChainID,
+			Difficulty:                   1,
+			RewardRatio:                  common.RewardRatio,
+			BlockTimeInterval:            common.BlockTimeInterval,
+			MaxTotalSupply:               common.MaxTotalSupply,
+			InitSupply:                   common.InitSupply,
+			DifficultyMultiplier:         common.DifficultyMultiplier,
+			DifficultyChange:             common.DifficultyChange,
+			MaxGasUsage:                  common.MaxGasUsage,
+			MaxGasPrice:                  common.MaxGasPrice,
+			MaxTransactionsPerBlock:      common.MaxTransactionsPerBlock,
+			MaxTransactionInPool:         common.MaxTransactionInPool,
+			MaxPeersConnected:            common.MaxPeersConnected,
+			NumberOfHashesInBucket:       common.NumberOfHashesInBucket,
+			NumberOfBlocksInBucket:       common.NumberOfBlocksInBucket,
+			MinStakingForNode:            common.MinStakingForNode,
+			MinStakingUser:               common.MinStakingUser,
+			OraclesHeightDistance:        common.OraclesHeightDistance,
+			VotingHeightDistance:         common.VotingHeightDistance,
+			OperatorPubKey:               w.Account1.PublicKey.GetHex(),
+			MaxTransactionDelay:          common.MaxTransactionDelay,
+			MaxTransactionInMultiSigPool: common.MaxTransactionInMultiSigPool,
+			MessageInitialization:        common.MessageInitialization[:],
+			MaxMessageSizeBytes:          common.MaxMessageSizeBytes,
+			StakedBalances: []GenesisStaking{{
+				Account:            hex.EncodeToString(w.MainAddress.GetBytes()),
+				Amount:             common.InitSupply / 10,
+				DelegatedAccount:   1,
+				OperationalAccount: true,
+				PubKey:             w.Account1.PublicKey.GetHex(),
+				PubKey2:            w.Account2.PublicKey.GetHex(),
+			}},
+			Transactions: []GenesisTransactions{},
+		}
+		for _, a := range DevAccounts() {
+			g.Transactions = append(g.Transactions, GenesisTransactions{
+				Account: hex.EncodeToString(a.GetBytes()),
+				Amount:  DevAccountBalance,
+			})
+		}
+		devGenesis = &g
+	})
+	return *devGenesis
+}
+
+// InitDevGenesis sets initial values of local chain of dev mode, genesis block is created when processTransactions
+func InitDevGenesis(processTransactions bool) {
+	initFromGenesis(DevGenesis(), processTransactions)
+}
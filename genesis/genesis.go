@@ -72,6 +72,8 @@ type Genesis struct {
 	MaxTransactionInMultiSigPool int64                 `json:"max_transaction_in_multi_sig_pool"`
 	MessageInitialization        []byte                `json:"message_initialization"`
 	MaxMessageSizeBytes          int32                 `json:"max_message_size_bytes"`
+	SeedPeers                    []string              `json:"seed_peers,omitempty"` // bootstrap node IPs, not part of genesis block
+	DexSwapFeeBasisPoints        *int64                `json:"dex_swap_fee_basis_points,omitempty"`
 }
 
 func storeGenesisPubKey(pubkeystr string, primary bool) common.PubKey {
@@ -112,6 +114,7 @@ func CreateBlockFromGenesis(genesis Genesis) blocks.Block {
 	accDel1.Balance = initSupplyWithoutStaked
 	accDel1.Address = addressOp1.ByteValue
 	account.Accounts.AllAccounts[addressOp1.ByteValue] = accDel1
+	account.AccountChanged(addressOp1.ByteValue)
 
 	walletNonce := int16(0)
 	blockTransactionsHashesBytes := [][]byte{}
@@ -182,6 +185,7 @@ func CreateBlockFromGenesis(genesis Genesis) blocks.Block {
 			logger.GetLogger().Fatal("cannot decode pubkey from string in genesis block")
 		}
 		account.StakingAccounts[stkTx.DelegatedAccount].AllStakingAccounts[addrb] = as
+		account.StakingAccountChanged(addrb)
 	}
 	err := account.StoreStakingAccounts(0)
 	if err != nil {
@@ -235,11 +239,14 @@ func CreateBlockFromGenesis(genesis Genesis) blocks.Block {
 	bh.Signature = *sign
 	logger.GetLogger().Println("Block Signature:", bh.Signature.GetHex())
 
-	signature, err := common.GetSignatureFromString(genesis.Signature, addressOp1)
-	if err != nil {
-		logger.GetLogger().Fatal(err)
+	// genesis of dev mode has no signature, it is signed above by operator at start
+	if genesis.Signature != "" {
+		signature, err := common.GetSignatureFromString(genesis.Signature, addressOp1)
+		if err != nil {
+			logger.GetLogger().Fatal(err)
+		}
+		bh.Signature = signature
 	}
-	bh.Signature = signature
 
 	bhHash, err := bh.CalcHash()
 	if err != nil {
@@ -272,18 +279,21 @@ func CreateBlockFromGenesis(genesis Genesis) blocks.Block {
 }
 
 func GenesisTransaction(sender common.Address, recipient common.Address, genTx GenesisTransactions, walletNonce int16, timestamp int64) transactionsDefinition.Transaction {
-	pkb, err := hex.DecodeString(genTx.PubKey)
-	if err != nil {
-		logger.GetLogger().Fatal(err)
-	}
-	pk := common.PubKey{}
-	err = pk.Init(pkb[:], recipient)
-	if err != nil {
-		logger.GetLogger().Fatal(err)
-	}
-	err = blocks.StorePubKey(pk)
-	if err != nil {
-		logger.GetLogger().Fatal(err)
+	// recipient without pub key registers it with its first transaction
+	if genTx.PubKey != "" {
+		pkb, err := hex.DecodeString(genTx.PubKey)
+		if err != nil {
+			logger.GetLogger().Fatal(err)
+		}
+		pk := common.PubKey{}
+		err = pk.Init(pkb[:], recipient)
+		if err != nil {
+			logger.GetLogger().Fatal(err)
+		}
+		err = blocks.StorePubKey(pk)
+		if err != nil {
+			logger.GetLogger().Fatal(err)
+		}
 	}
 
 	account.SetAccountByAddressBytes(recipient.GetBytes())
@@ -331,17 +341,24 @@ func GenesisTransaction(sender common.Address, recipient common.Address, genTx G
 		GasUsage:  0,
 	}
 
-	err = t.CalcHashAndSet()
+	err := t.CalcHashAndSet()
 	if err != nil {
 		logger.GetLogger().Fatal("calc hash error", err)
 	}
 
-	signature, err := common.GetSignatureFromString(genTx.Signature, sender)
-
-	if err != nil {
-		logger.GetLogger().Fatal(err)
+	if genTx.Signature == "" {
+		// transactions of dev genesis are signed at start by operator
+		err = t.Sign(wallet.GetActiveWallet(), true)
+		if err != nil {
+			logger.GetLogger().Fatal("Signing error", err)
+		}
+	} else {
+		signature, err := common.GetSignatureFromString(genTx.Signature, sender)
+		if err != nil {
+			logger.GetLogger().Fatal(err)
+		}
+		t.Signature = signature
 	}
-	t.Signature = signature
 
 	if t.Verify(common.SigName(), common.SigName2(), false, false) == false {
 		myWallet := wallet.GetActiveWallet()
@@ -367,6 +384,10 @@ func InitGenesis(processTransactions bool) {
 	if err != nil {
 		logger.GetLogger().Fatal(err)
 	}
+	initFromGenesis(genesis, processTransactions)
+}
+
+func initFromGenesis(genesis Genesis, processTransactions bool) {
 	if !processTransactions {
 
 		setInitParams(genesis)
@@ -417,6 +438,10 @@ func InitGenesis(processTransactions bool) {
 		if err != nil {
 			logger.GetLogger().Fatal(err)
 		}
+		err = blocks.StoreStateDB(0)
+		if err != nil {
+			logger.GetLogger().Fatal(err)
+		}
 	}
 }
 
@@ -449,6 +474,10 @@ func setInitParams(genesisConfig Genesis) {
 		genesisConfig.MessageInitialization[2],
 		genesisConfig.MessageInitialization[3]}
 	common.MaxMessageSizeBytes = genesisConfig.MaxMessageSizeBytes
+	common.SeedPeers = genesisConfig.SeedPeers
+	if genesisConfig.DexSwapFeeBasisPoints != nil {
+		common.DexSwapFeeBasisPoints = *genesisConfig.DexSwapFeeBasisPoints
+	}
 }
 
 // Load opens and consumes the genesis file.
@@ -12,6 +12,7 @@ require (
 	github.com/wonabru/bip39 v0.0.0-20220210115453-d4b48138ae51
 	golang.org/x/crypto v0.33.0
 	golang.org/x/exp v0.0.0-20250207012021-f9890c6ad9f3
+	golang.org/x/net v0.34.0
 	golang.org/x/sys v0.30.0
 	golang.org/x/tools v0.29.0
 )
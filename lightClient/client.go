@@ -0,0 +1,257 @@
+package lightClient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+	clientrpc "github.com/wonabru/qwid-node/rpc/client"
+	"github.com/wonabru/qwid-node/transactionsPool"
+)
+
+// headersInRequest is number of headers asked from node at once
+const headersInRequest = 100
+
+var errNotCommittedYet = fmt.Errorf("state of proof is not committed in verified block yet")
+
+// Client syncs and verifies headers only and checks proofs of accounts and transactions given by untrusted node.
+// It does not check stake of operators, which needs whole state.
+type Client struct {
+	Chain   *HeaderChain
+	pubKeys map[[common.AddressLength]byte][]byte
+}
+
+func NewClient(chain *HeaderChain) *Client {
+	return &Client{Chain: chain, pubKeys: map[[common.AddressLength]byte][]byte{}}
+}
+
+// request sends public query to node, rpc client has to be connected by clientrpc.ConnectRPC
+func request(line []byte) ([]byte, error) {
+	clientrpc.InRPC <- common.BytesToLenAndBytes(line)
+	reply := <-clientrpc.OutRPC
+	if bytes.Equal(reply, []byte("Timeout")) {
+		return nil, fmt.Errorf("timeout")
+	}
+	return reply, nil
+}
+
+func requestHeaders(from, count int64) ([]blocks.Block, error) {
+	line := append([]byte("HDRS"), common.GetByteInt64(from)...)
+	reply, err := request(append(line, common.GetByteInt64(count)...))
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) < 2 || string(reply[:2]) != "HD" {
+		return nil, fmt.Errorf("%s", reply)
+	}
+	headers := []blocks.Block{}
+	b := reply[2:]
+	for len(b) > 0 {
+		var hb []byte
+		hb, b, err = common.BytesWithLenToBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		header, err := blocks.Block{}.GetFromBytes(hb)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+func requestHeader(height int64) (blocks.Block, error) {
+	headers, err := requestHeaders(height, 1)
+	if err != nil {
+		return blocks.Block{}, err
+	}
+	if len(headers) != 1 || headers[0].GetHeader().Height != height {
+		return blocks.Block{}, fmt.Errorf("no header %d", height)
+	}
+	return headers[0], nil
+}
+
+// Start sets checkpoint of empty chain, expectedHash can be nil, then checkpoint header is trusted on first use
+func (c *Client) Start(height int64, expectedHash []byte) error {
+	if c.Chain.Height() >= 0 {
+		return nil
+	}
+	header, err := requestHeader(height)
+	if err != nil {
+		return err
+	}
+	if expectedHash == nil {
+		logger.GetLogger().Printf("light client trusts header %d with hash %s given by node", height, header.BlockHash.GetHex())
+	}
+	return c.Chain.SetCheckpoint(header, expectedHash)
+}
+
+// operatorPubKey returns key which signed header. Only primary key from which operator address is derived
+// is proven without state, so headers signed by secondary key or by rotated key are rejected.
+func (c *Client) operatorPubKey(header blocks.Block) ([]byte, error) {
+	head := header.GetHeader()
+	if !head.IsSignedByPrimary() {
+		return nil, fmt.Errorf("header %d is signed by secondary key of operator %s which cannot be proven", head.Height, head.OperatorAccount.GetHex())
+	}
+	k := [common.AddressLength]byte{}
+	copy(k[:], head.OperatorAccount.GetBytes())
+	if pk, ok := c.pubKeys[k]; ok {
+		return pk, nil
+	}
+	reply, err := request(append([]byte("PKEY"), append(k[:], 0)...))
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) < 2 || string(reply[:2]) != "PK" {
+		return nil, fmt.Errorf("%s", reply)
+	}
+	pk := reply[2:]
+	err = checkOperatorPubKey(pk, head.OperatorAccount)
+	if err != nil {
+		return nil, err
+	}
+	c.pubKeys[k] = pk
+	return pk, nil
+}
+
+// checkOperatorPubKey proves that primary key belongs to operator
+func checkOperatorPubKey(pk []byte, operator common.Address) error {
+	addr, err := common.PubKeyToAddress(pk, true)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(addr.GetBytes(), operator.GetBytes()) {
+		return fmt.Errorf("public key given by node is not key of operator %s", operator.GetHex())
+	}
+	return nil
+}
+
+// Sync verifies headers up to the last block of node. When node switched to heavier branch,
+// headers are rolled back up to common.MaxReorgDepth.
+func (c *Client) Sync() error {
+	for {
+		height := c.Chain.Height()
+		if height < 0 {
+			return fmt.Errorf("no checkpoint set")
+		}
+		headers, err := requestHeaders(height+1, headersInRequest)
+		if err != nil {
+			return err
+		}
+		if len(headers) == 0 {
+			return nil
+		}
+		lastHash, err := c.Chain.BlockHash(height)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(headers[0].GetHeader().PreviousHash.GetBytes(), lastHash.GetBytes()) {
+			err = c.rollback()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for _, header := range headers {
+			pk, err := c.operatorPubKey(header)
+			if err != nil {
+				return err
+			}
+			err = c.Chain.AddHeader(header, pk)
+			if err != nil {
+				return err
+			}
+		}
+		if len(headers) < headersInRequest {
+			return nil
+		}
+	}
+}
+
+// rollback finds the last verified header which node still has in main chain
+func (c *Client) rollback() error {
+	height := c.Chain.Height()
+	for h := height - 1; h >= c.Chain.Base() && h >= height-common.MaxReorgDepth; h-- {
+		header, err := requestHeader(h)
+		if err != nil {
+			return err
+		}
+		hash, err := c.Chain.BlockHash(h)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(hash.GetBytes(), header.BlockHash.GetBytes()) {
+			logger.GetLogger().Println("light client rolls back headers to", h)
+			return c.Chain.Rollback(header)
+		}
+	}
+	return fmt.Errorf("node chain differs deeper than %d blocks from verified headers", common.MaxReorgDepth)
+}
+
+// ProveTransaction checks that transaction is included in verified header, returns height of the block
+func (c *Client) ProveTransaction(hash []byte) (int64, error) {
+	reply, err := request(append([]byte("PRUF"), hash...))
+	if err != nil {
+		return -1, err
+	}
+	if len(reply) < 2 || string(reply[:2]) != "PF" {
+		return -1, fmt.Errorf("%s", reply)
+	}
+	proof := transactionsPool.MerkleProof{}
+	err = json.Unmarshal(reply[2:], &proof)
+	if err != nil {
+		return -1, err
+	}
+	if !bytes.Equal(proof.TxHash.GetBytes(), hash) {
+		return -1, fmt.Errorf("proof of different transaction")
+	}
+	root, err := c.Chain.TxRoot(proof.Height)
+	if err != nil {
+		return -1, err
+	}
+	if !proof.Verify(root.GetBytes()) {
+		return -1, fmt.Errorf("proof of transaction fails against header %d", proof.Height)
+	}
+	return proof.Height, nil
+}
+
+// ProveAccount returns account proven by state root of verified header and height of block after which state it is
+func (c *Client) ProveAccount(address [common.AddressLength]byte) (account.Account, int64, error) {
+	reply, err := request(append([]byte("APRF"), address[:]...))
+	if err != nil {
+		return account.Account{}, -1, err
+	}
+	if len(reply) < 2 || string(reply[:2]) != "AP" {
+		return account.Account{}, -1, fmt.Errorf("%s", reply)
+	}
+	proof := blocks.AccountProof{}
+	err = json.Unmarshal(reply[2:], &proof)
+	if err != nil {
+		return account.Account{}, -1, err
+	}
+	if proof.Address != address {
+		return account.Account{}, -1, fmt.Errorf("proof of different account")
+	}
+	if c.Chain.Height() < proof.Height {
+		return account.Account{}, -1, errNotCommittedYet
+	}
+	root, err := c.Chain.StateRoot(proof.Height)
+	if err != nil {
+		return account.Account{}, -1, err
+	}
+	acc, err := proof.Verify(root.GetBytes())
+	if err != nil {
+		return account.Account{}, -1, err
+	}
+	return acc, proof.Height, nil
+}
+
+// IsNotCommittedYet tells that proof is newer than verified headers, its block has to be synced first
+func IsNotCommittedYet(err error) bool {
+	return err == errNotCommittedYet
+}
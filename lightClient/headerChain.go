@@ -0,0 +1,289 @@
+package lightClient
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+// summaryLength is length of stored summary of header: block hash, merkle root of transactions and state root
+const summaryLength = int64(3 * common.HashLength)
+
+// headerSummary is all what light client keeps of verified header, full headers are too big for small devices
+type headerSummary struct {
+	BlockHash      common.Hash
+	RootMerkleTree common.Hash
+	StateRoot      common.Hash
+}
+
+func (s headerSummary) GetBytes() []byte {
+	b := s.BlockHash.GetBytes()
+	b = append(b, s.RootMerkleTree.GetBytes()...)
+	return append(b, s.StateRoot.GetBytes()...)
+}
+
+func summaryFromBytes(b []byte) headerSummary {
+	return headerSummary{
+		BlockHash:      common.GetHashFromBytes(b[:common.HashLength]),
+		RootMerkleTree: common.GetHashFromBytes(b[common.HashLength : 2*common.HashLength]),
+		StateRoot:      common.GetHashFromBytes(b[2*common.HashLength : 3*common.HashLength]),
+	}
+}
+
+// HeaderChain is chain of verified headers starting at checkpoint (base). Only the last header is kept whole,
+// it is needed to link next header and to know current encryption schemes.
+type HeaderChain struct {
+	mutex     sync.RWMutex
+	base      int64
+	summaries []headerSummary
+	last      blocks.Block
+	dir       string
+}
+
+// NewHeaderChain loads chain stored in dir, chain is empty when nothing was stored
+func NewHeaderChain(dir string) (*HeaderChain, error) {
+	c := &HeaderChain{dir: dir}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	lb, err := os.ReadFile(c.lastPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	sb, err := os.ReadFile(c.summariesPath())
+	if err != nil {
+		return nil, err
+	}
+	if len(lb) < 8 {
+		return nil, fmt.Errorf("wrong stored last header")
+	}
+	last, err := blocks.Block{}.GetFromBytes(lb[8:])
+	if err != nil {
+		return nil, err
+	}
+	n := int64(len(sb)) / summaryLength
+	base := common.GetInt64FromByte(lb[:8])
+	height := last.GetHeader().Height
+	if height < base || height-base+1 > n {
+		return nil, fmt.Errorf("stored headers do not match last header %d", height)
+	}
+	n = height - base + 1
+	for i := int64(0); i < n; i++ {
+		c.summaries = append(c.summaries, summaryFromBytes(sb[i*summaryLength:(i+1)*summaryLength]))
+	}
+	if !bytes.Equal(c.summaries[n-1].BlockHash.GetBytes(), last.BlockHash.GetBytes()) {
+		return nil, fmt.Errorf("stored headers do not match last header %d", height)
+	}
+	c.base = base
+	c.last = last
+	err = setEncryption(last)
+	if err != nil {
+		return nil, err
+	}
+	return c, os.Truncate(c.summariesPath(), n*summaryLength)
+}
+
+func (c *HeaderChain) lastPath() string {
+	return filepath.Join(c.dir, "last")
+}
+
+func (c *HeaderChain) summariesPath() string {
+	return filepath.Join(c.dir, "headers")
+}
+
+// Height is height of the last verified header, -1 for empty chain
+func (c *HeaderChain) Height() int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.base + int64(len(c.summaries)) - 1
+}
+
+// Base is height of checkpoint
+func (c *HeaderChain) Base() int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.base
+}
+
+func (c *HeaderChain) summary(height int64) (headerSummary, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if height < c.base || height >= c.base+int64(len(c.summaries)) {
+		return headerSummary{}, fmt.Errorf("no verified header at height %d", height)
+	}
+	return c.summaries[height-c.base], nil
+}
+
+// BlockHash of verified header at height
+func (c *HeaderChain) BlockHash(height int64) (common.Hash, error) {
+	s, err := c.summary(height)
+	return s.BlockHash, err
+}
+
+// TxRoot is merkle root of transactions of verified header at height
+func (c *HeaderChain) TxRoot(height int64) (common.Hash, error) {
+	s, err := c.summary(height)
+	return s.RootMerkleTree, err
+}
+
+// StateRoot is root of state after verified block at height
+func (c *HeaderChain) StateRoot(height int64) (common.Hash, error) {
+	s, err := c.summary(height)
+	return s.StateRoot, err
+}
+
+// SetCheckpoint starts empty chain from trusted header, its hash is checked when expected hash is given
+func (c *HeaderChain) SetCheckpoint(header blocks.Block, expectedHash []byte) error {
+	hash, err := header.CalcBlockHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash.GetBytes(), header.BlockHash.GetBytes()) {
+		return fmt.Errorf("wrong hash of checkpoint header")
+	}
+	if expectedHash != nil && !bytes.Equal(hash.GetBytes(), expectedHash) {
+		return fmt.Errorf("checkpoint header has hash %s, expected %x", hash.GetHex(), expectedHash)
+	}
+	err = setEncryption(header)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.base = header.GetHeader().Height
+	c.summaries = []headerSummary{summarize(header)}
+	c.last = header
+	c.mutex.Unlock()
+	err = os.Remove(c.summariesPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.store(0)
+}
+
+// AddHeader verifies header against the last one and appends it, pk is public key of operator which signed header
+func (c *HeaderChain) AddHeader(header blocks.Block, pk []byte) error {
+	c.mutex.RLock()
+	last := c.last
+	n := len(c.summaries)
+	c.mutex.RUnlock()
+	if n == 0 {
+		return fmt.Errorf("no checkpoint set")
+	}
+	head := header.GetHeader()
+	if head.Height != last.GetHeader().Height+1 {
+		return fmt.Errorf("header %d does not follow %d", head.Height, last.GetHeader().Height)
+	}
+	err := blocks.CheckHeader(header, last.BlockHash.GetBytes())
+	if err != nil {
+		return err
+	}
+	sigName, sigName2, isPaused, isPaused2, err := header.GetSigNames()
+	if err != nil {
+		return err
+	}
+	if !head.VerifyWithPubKey(pk, sigName, sigName2, isPaused, isPaused2) {
+		return fmt.Errorf("signature of header %d fails", head.Height)
+	}
+	if !bytes.Equal(head.Encryption1, last.GetHeader().Encryption1) || !bytes.Equal(head.Encryption2, last.GetHeader().Encryption2) {
+		logger.GetLogger().Println("encryption scheme changed by voting at height", head.Height)
+		err = setEncryption(header)
+		if err != nil {
+			return err
+		}
+	}
+	c.mutex.Lock()
+	c.summaries = append(c.summaries, summarize(header))
+	c.last = header
+	c.mutex.Unlock()
+	return c.store(int64(n))
+}
+
+// Rollback removes headers above height, replaced by heavier branch on nodes. Header at height has to be
+// given again, as only the last header is kept whole.
+func (c *HeaderChain) Rollback(header blocks.Block) error {
+	height := header.GetHeader().Height
+	hash, err := c.BlockHash(height)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash.GetBytes(), header.BlockHash.GetBytes()) {
+		return fmt.Errorf("header %d is not in verified chain", height)
+	}
+	calc, err := header.CalcBlockHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(calc.GetBytes(), hash.GetBytes()) {
+		return fmt.Errorf("wrong hash of header %d", height)
+	}
+	err = setEncryption(header)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.summaries = c.summaries[:height-c.base+1]
+	c.last = header
+	n := int64(len(c.summaries))
+	c.mutex.Unlock()
+	err = os.Truncate(c.summariesPath(), n*summaryLength)
+	if err != nil {
+		return err
+	}
+	return c.store(n)
+}
+
+// store appends summaries from index from and writes the last header
+func (c *HeaderChain) store(from int64) error {
+	c.mutex.RLock()
+	b := []byte{}
+	for _, s := range c.summaries[from:] {
+		b = append(b, s.GetBytes()...)
+	}
+	lb := append(common.GetByteInt64(c.base), c.last.GetBytes()...)
+	c.mutex.RUnlock()
+
+	f, err := os.OpenFile(c.summariesPath(), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(b, from*summaryLength)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.lastPath(), lb, 0644)
+}
+
+func summarize(header blocks.Block) headerSummary {
+	head := header.GetHeader()
+	return headerSummary{BlockHash: header.BlockHash, RootMerkleTree: head.RootMerkleTree, StateRoot: header.BaseBlock.StateRoot}
+}
+
+// setEncryption sets signature schemes from header, so next headers are parsed and verified as on full nodes
+func setEncryption(header blocks.Block) error {
+	enc1, err := blocks.FromBytesToEncryptionConfig(header.GetHeader().Encryption1, true)
+	if err != nil {
+		return err
+	}
+	common.SetEncryption(enc1.SigName, enc1.PubKeyLength, enc1.PrivateKeyLength, enc1.SignatureLength, enc1.IsPaused, true)
+	enc2, err := blocks.FromBytesToEncryptionConfig(header.GetHeader().Encryption2, false)
+	if err != nil {
+		return err
+	}
+	common.SetEncryption(enc2.SigName, enc2.PubKeyLength, enc2.PrivateKeyLength, enc2.SignatureLength, enc2.IsPaused, false)
+	return nil
+}
@@ -7,7 +7,9 @@ import (
 )
 
 // tx - transaction, gt - get transaction, st - sync transaction, "nn" - nonce, "bl" - block, "rb" - reject block, "hi" - GetHeight, "gh" - GetHeaders, "sh" - SendHeaders
-var validHead = []string{"nn", "bl", "rb", "tx", "gt", "st", "bt", "hi", "gh", "sh", "bx"}
+// "gp" - GetPeers, "px" - peer exchange, "hr" - GetHeadersOnly, "hc" - HeadersOnly, "gb" - GetBlocks, "sb" - SendBlocks
+// "gs" - GetSnapshots, "ss" - Snapshots, "gc" - GetChunk, "sc" - SnapshotChunk
+var validHead = []string{"nn", "bl", "rb", "tx", "gt", "st", "bt", "hi", "gh", "sh", "bx", "gp", "px", "hr", "hc", "gb", "sb", "gs", "ss", "gc", "sc"}
 
 type BaseMessage struct {
 	Head    []byte `json:"head"`
@@ -3,6 +3,7 @@ package clientrpc
 import (
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/tcpip"
+	"net"
 	"net/rpc"
 	"strconv"
 	"sync"
@@ -19,7 +20,7 @@ var OutRPC = make(chan []byte)
 var muRPC = sync.Mutex{}
 
 func ConnectRPC(ip string) {
-	address := ip + ":" + strconv.Itoa(tcpip.Ports[tcpip.RPCTopic])
+	address := net.JoinHostPort(ip, strconv.Itoa(tcpip.Ports[tcpip.RPCTopic]))
 	var client *rpc.Client
 	var err error
 	for {
@@ -0,0 +1,398 @@
+package ethrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/common/hexutil"
+	"github.com/wonabru/qwid-node/crypto"
+	"github.com/wonabru/qwid-node/rlp"
+	"github.com/wonabru/qwid-node/services/transactionServices"
+	"github.com/wonabru/qwid-node/tcpip"
+	"github.com/wonabru/qwid-node/transactionsDefinition"
+)
+
+const clientVersion = "qwid-node/v1"
+
+func requireParams(params []json.RawMessage, min int) error {
+	if len(params) < min {
+		return invalidParamsError("missing value for required argument %d", len(params))
+	}
+	return nil
+}
+
+func decodeParam(raw json.RawMessage, v interface{}) error {
+	err := json.Unmarshal(raw, v)
+	if err != nil {
+		return invalidParamsError("invalid argument: %v", err)
+	}
+	return nil
+}
+
+func optionalBlockNumber(params []json.RawMessage, i int) (int64, error) {
+	if len(params) <= i {
+		return latestBlockNumber, nil
+	}
+	return parseBlockNumber(params[i])
+}
+
+// requireLatestState returns error when historical state is asked. VM state is kept only for the last block.
+func requireLatestState(number int64) error {
+	height, exists := resolveHeight(number)
+	if !exists {
+		return fmt.Errorf("header not found")
+	}
+	if height < common.GetHeight() {
+		return fmt.Errorf("historical state is not available, only latest block is supported")
+	}
+	return nil
+}
+
+func web3ClientVersion(params []json.RawMessage) (interface{}, error) {
+	return clientVersion, nil
+}
+
+func web3Sha3(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	var data hexutil.Bytes
+	if err := decodeParam(params[0], &data); err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(crypto.Keccak256(data)), nil
+}
+
+func netVersion(params []json.RawMessage) (interface{}, error) {
+	return fmt.Sprint(common.GetChainID()), nil
+}
+
+func netListening(params []json.RawMessage) (interface{}, error) {
+	return true, nil
+}
+
+func netPeerCount(params []json.RawMessage) (interface{}, error) {
+	return hexutil.Uint64(len(tcpip.GetConnectedPeersInfo())), nil
+}
+
+func ethChainId(params []json.RawMessage) (interface{}, error) {
+	return hexutil.Uint64(common.GetChainID()), nil
+}
+
+func ethBlockNumber(params []json.RawMessage) (interface{}, error) {
+	return hexutil.Uint64(common.GetHeight()), nil
+}
+
+// ethGasPrice returns the minimal gas price accepted in transaction pool
+func ethGasPrice(params []json.RawMessage) (interface{}, error) {
+	return (*hexutil.Big)(big.NewInt(1)), nil
+}
+
+func ethSyncing(params []json.RawMessage) (interface{}, error) {
+	if !common.IsSyncing.Load() {
+		return false, nil
+	}
+	return map[string]hexutil.Uint64{
+		"startingBlock": 0,
+		"currentBlock":  hexutil.Uint64(common.GetHeight()),
+		"highestBlock":  hexutil.Uint64(common.GetHeightMax()),
+	}, nil
+}
+
+func fullTxParam(params []json.RawMessage) (bool, error) {
+	fullTx := false
+	if len(params) > 1 {
+		if err := decodeParam(params[1], &fullTx); err != nil {
+			return false, err
+		}
+	}
+	return fullTx, nil
+}
+
+func ethGetBlockByNumber(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	number, err := parseBlockNumber(params[0])
+	if err != nil {
+		return nil, err
+	}
+	fullTx, err := fullTxParam(params)
+	if err != nil {
+		return nil, err
+	}
+	height, exists := resolveHeight(number)
+	if !exists {
+		return nil, nil
+	}
+	bl, err := blocks.LoadBlock(height)
+	if err != nil {
+		return nil, nil
+	}
+	return newRPCBlock(bl, fullTx)
+}
+
+func ethGetBlockByHash(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	var hash common.Hash
+	if err := decodeParam(params[0], &hash); err != nil {
+		return nil, err
+	}
+	fullTx, err := fullTxParam(params)
+	if err != nil {
+		return nil, err
+	}
+	bl, err := blocks.LoadBlockByHash(hash.GetBytes())
+	if err != nil {
+		return nil, nil
+	}
+	return newRPCBlock(bl, fullTx)
+}
+
+// findTransaction looks for confirmed transaction and the block which includes it. Block is nil for pending transactions.
+func findTransaction(hash common.Hash) (*transactionsDefinition.Transaction, *blocks.Block, int) {
+	tx, err := transactionsDefinition.LoadFromDBPoolTx(common.TransactionDBPrefix[:], hash.GetBytes())
+	if err == nil {
+		height, err := blocks.LoadTransactionBlockHeight(hash.GetBytes())
+		if err != nil {
+			return &tx, nil, 0
+		}
+		bl, err := blocks.LoadBlock(height)
+		if err != nil {
+			return &tx, nil, 0
+		}
+		for i, h := range bl.TransactionsHashes {
+			if h == hash {
+				return &tx, &bl, i
+			}
+		}
+		return &tx, nil, 0
+	}
+	tx, err = transactionsDefinition.LoadFromDBPoolTx(common.TransactionPoolHashesDBPrefix[:], hash.GetBytes())
+	if err == nil {
+		return &tx, nil, 0
+	}
+	return nil, nil, 0
+}
+
+func ethGetTransactionByHash(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	var hash common.Hash
+	if err := decodeParam(params[0], &hash); err != nil {
+		return nil, err
+	}
+	tx, bl, index := findTransaction(hash)
+	if tx == nil {
+		return nil, nil
+	}
+	return newRPCTransaction(*tx, bl, index), nil
+}
+
+func ethGetTransactionReceipt(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	var hash common.Hash
+	if err := decodeParam(params[0], &hash); err != nil {
+		return nil, err
+	}
+	tx, bl, index := findTransaction(hash)
+	if tx == nil || bl == nil {
+		return nil, nil
+	}
+	cumulativeGas := int64(0)
+	for _, h := range bl.TransactionsHashes[:index+1] {
+		t, err := transactionsDefinition.LoadFromDBPoolTx(common.TransactionDBPrefix[:], h.GetBytes())
+		if err == nil {
+			cumulativeGas += t.GasUsage
+		}
+	}
+	logs, err := blocks.LoadTransactionLogs(hash.GetBytes())
+	if err != nil {
+		return nil, err
+	}
+	to := tx.TxData.Recipient
+	r := &rpcReceipt{
+		TransactionHash:   tx.Hash,
+		TransactionIndex:  hexutil.Uint64(index),
+		BlockHash:         bl.GetBlockHash(),
+		BlockNumber:       hexutil.Uint64(bl.GetHeader().Height),
+		From:              tx.TxParam.Sender,
+		To:                &to,
+		GasUsed:           hexutil.Uint64(tx.GasUsage),
+		CumulativeGasUsed: hexutil.Uint64(cumulativeGas),
+		EffectiveGasPrice: (*hexutil.Big)(big.NewInt(tx.GasPrice)),
+		Logs:              logs,
+		LogsBloom:         logsBloom(logs),
+		Status:            1,
+	}
+	failed, err := blocks.IsTransactionFailed(hash.GetBytes())
+	if err != nil {
+		return nil, err
+	}
+	if failed {
+		r.Status = 0
+	}
+	if tx.ContractAddress.ByteValue != [common.AddressLength]byte{} {
+		ca := tx.ContractAddress
+		r.ContractAddress = &ca
+	}
+	return r, nil
+}
+
+// ethGetBalance returns native coin balance in the smallest units, so with common.Decimals decimals
+func ethGetBalance(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	var addr common.Address
+	if err := decodeParam(params[0], &addr); err != nil {
+		return nil, err
+	}
+	number, err := optionalBlockNumber(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	height, exists := resolveHeight(number)
+	if !exists {
+		return nil, fmt.Errorf("header not found")
+	}
+	if height >= common.GetHeight() {
+		return (*hexutil.Big)(big.NewInt(account.GetBalance(addr.ByteValue))), nil
+	}
+	accs, err := account.GetAccountsAtHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(big.NewInt(accs.AllAccounts[addr.ByteValue].Balance)), nil
+}
+
+func ethGetCode(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	var addr common.Address
+	if err := decodeParam(params[0], &addr); err != nil {
+		return nil, err
+	}
+	number, err := optionalBlockNumber(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLatestState(number); err != nil {
+		return nil, err
+	}
+	blocks.StateMutex.RLock()
+	defer blocks.StateMutex.RUnlock()
+	return hexutil.Bytes(blocks.State.GetCode(addr)), nil
+}
+
+func ethCall(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	var args callArgs
+	if err := decodeParam(params[0], &args); err != nil {
+		return nil, err
+	}
+	if args.To == nil {
+		return nil, invalidParamsError("missing \"to\" in call arguments")
+	}
+	number, err := optionalBlockNumber(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLatestState(number); err != nil {
+		return nil, err
+	}
+	bl, err := blocks.LoadBlock(common.GetHeight())
+	if err != nil {
+		return nil, err
+	}
+	from := common.EmptyAddress()
+	if args.From != nil {
+		from = *args.From
+	}
+	value := new(big.Int)
+	if args.Value != nil {
+		value = args.Value.ToInt()
+	}
+	ret, err := blocks.SimulateCall(from, *args.To, args.data(), value, bl)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(ret), nil
+}
+
+func ethGetLogs(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	var q filterQuery
+	if err := decodeParam(params[0], &q); err != nil {
+		return nil, err
+	}
+	lf, err := q.decode()
+	if err != nil {
+		return nil, err
+	}
+	if lf.BlockHash != nil {
+		bl, err := blocks.LoadBlockByHash(lf.BlockHash.GetBytes())
+		if err != nil {
+			return nil, fmt.Errorf("unknown block")
+		}
+		lf.FromHeight = bl.GetHeader().Height
+		lf.ToHeight = lf.FromHeight
+	}
+	return blocks.FilterLogs(lf.FromHeight, lf.ToHeight, lf.Addresses, lf.Topics)
+}
+
+// isEthereumTransaction checks if raw is RLP encoded legacy or typed (EIP-2718) Ethereum transaction
+func isEthereumTransaction(raw []byte) bool {
+	if len(raw) > 0 && raw[0] <= 0x7f {
+		raw = raw[1:]
+	}
+	_, rest, err := rlp.SplitList(raw)
+	return err == nil && len(rest) == 0
+}
+
+// ethSendRawTransaction accepts transaction serialized as in qwid wire format and puts it into transaction pool.
+// Unlike Ethereum, RLP encoded transactions signed with secp256k1 are not accepted, as qwid accounts are
+// secured by post-quantum signatures. Such transactions are rejected with clear error.
+func ethSendRawTransaction(params []json.RawMessage) (interface{}, error) {
+	if err := requireParams(params, 1); err != nil {
+		return nil, err
+	}
+	var raw hexutil.Bytes
+	if err := decodeParam(params[0], &raw); err != nil {
+		return nil, err
+	}
+	tx := transactionsDefinition.Transaction{}
+	tx, _, err := tx.GetFromBytes(raw)
+	if err != nil {
+		if isEthereumTransaction(raw) {
+			return nil, invalidParamsError("RLP encoded Ethereum transactions are not supported, transaction has to be in qwid wire format")
+		}
+		return nil, invalidParamsError("cannot decode transaction: %v", err)
+	}
+	if tx.TxParam.ChainID != common.GetChainID() {
+		return nil, fmt.Errorf("wrong chain id %v", tx.TxParam.ChainID)
+	}
+	if !tx.Verify(common.SigName(), common.SigName2(), common.IsPaused(), common.IsPaused2()) {
+		return nil, fmt.Errorf("transaction verification fails")
+	}
+	msg, err := transactionServices.GenerateTransactionMsg([]transactionsDefinition.Transaction{tx}, []byte("tx"), tcpip.TransactionTopic)
+	if err != nil {
+		return nil, err
+	}
+	transactionServices.OnMessage(tcpip.AnyPeer, msg.GetBytes())
+	return tx.Hash, nil
+}
@@ -0,0 +1,309 @@
+package ethrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/tcpip"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	jsonrpcVersion     = "2.0"
+	maxRequestBodySize = 5 * 1024 * 1024
+)
+
+// error codes as defined by JSON-RPC 2.0 and used by Ethereum clients
+const (
+	errCodeParse          = -32700
+	errCodeInvalidRequest = -32600
+	errCodeMethodNotFound = -32601
+	errCodeInvalidParams  = -32602
+	errCodeInternal       = -32603
+	errCodeServer         = -32000
+)
+
+type jsonError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *jsonError) Error() string {
+	return e.Message
+}
+
+func invalidParamsError(format string, a ...interface{}) *jsonError {
+	return &jsonError{Code: errCodeInvalidParams, Message: fmt.Sprintf(format, a...)}
+}
+
+type jsonRequest struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+func (r jsonRequest) isNotification() bool {
+	return len(r.ID) == 0
+}
+
+type jsonResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type jsonErrorResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *jsonError      `json:"error"`
+}
+
+type handlerFunc func(params []json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handlerFunc{
+	"web3_clientVersion":        web3ClientVersion,
+	"web3_sha3":                 web3Sha3,
+	"net_version":               netVersion,
+	"net_listening":             netListening,
+	"net_peerCount":             netPeerCount,
+	"eth_chainId":               ethChainId,
+	"eth_blockNumber":           ethBlockNumber,
+	"eth_gasPrice":              ethGasPrice,
+	"eth_syncing":               ethSyncing,
+	"eth_getBlockByNumber":      ethGetBlockByNumber,
+	"eth_getBlockByHash":        ethGetBlockByHash,
+	"eth_getTransactionByHash":  ethGetTransactionByHash,
+	"eth_getBalance":            ethGetBalance,
+	"eth_getCode":               ethGetCode,
+	"eth_call":                  ethCall,
+	"eth_getLogs":               ethGetLogs,
+	"eth_sendRawTransaction":    ethSendRawTransaction,
+	"eth_getTransactionReceipt": ethGetTransactionReceipt,
+}
+
+func nullID(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func errorResponse(id json.RawMessage, err *jsonError) jsonErrorResponse {
+	return jsonErrorResponse{Version: jsonrpcVersion, ID: nullID(id), Error: err}
+}
+
+// handleRequest executes single call. Nil is returned for notifications which do not expect any answer.
+func handleRequest(req jsonRequest) interface{} {
+	if req.Version != jsonrpcVersion || req.Method == "" {
+		return errorResponse(req.ID, &jsonError{Code: errCodeInvalidRequest, Message: "invalid request"})
+	}
+	handler, ok := handlers[req.Method]
+	if !ok {
+		if req.isNotification() {
+			return nil
+		}
+		return errorResponse(req.ID, &jsonError{Code: errCodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)})
+	}
+	params := []json.RawMessage{}
+	if len(req.Params) > 0 && !bytes.Equal(req.Params, []byte("null")) {
+		err := json.Unmarshal(req.Params, &params)
+		if err != nil {
+			return errorResponse(req.ID, invalidParamsError("params has to be an array: %v", err))
+		}
+	}
+	result, err := callHandler(handler, params)
+	if req.isNotification() {
+		return nil
+	}
+	if err != nil {
+		if jerr, ok := err.(*jsonError); ok {
+			return errorResponse(req.ID, jerr)
+		}
+		return errorResponse(req.ID, &jsonError{Code: errCodeServer, Message: err.Error()})
+	}
+	return jsonResponse{Version: jsonrpcVersion, ID: req.ID, Result: result}
+}
+
+func callHandler(handler handlerFunc, params []json.RawMessage) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.GetLogger().Println("recover (eth rpc)", r)
+			err = &jsonError{Code: errCodeInternal, Message: "internal error"}
+		}
+	}()
+	return handler(params)
+}
+
+// handleMessage processes raw single or batch request and returns raw answer. Empty answer means nothing to send back.
+func handleMessage(data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	var answer interface{}
+	if len(data) > 0 && data[0] == '[' {
+		reqs := []json.RawMessage{}
+		err := json.Unmarshal(data, &reqs)
+		if err != nil {
+			answer = errorResponse(nil, &jsonError{Code: errCodeParse, Message: err.Error()})
+		} else if len(reqs) == 0 {
+			answer = errorResponse(nil, &jsonError{Code: errCodeInvalidRequest, Message: "empty batch"})
+		} else {
+			resps := []interface{}{}
+			for _, raw := range reqs {
+				resp := handleRawRequest(raw)
+				if resp != nil {
+					resps = append(resps, resp)
+				}
+			}
+			if len(resps) == 0 {
+				return nil
+			}
+			answer = resps
+		}
+	} else {
+		answer = handleRawRequest(data)
+		if answer == nil {
+			return nil
+		}
+	}
+	out, err := json.Marshal(answer)
+	if err != nil {
+		logger.GetLogger().Println("cannot marshal eth rpc answer", err)
+		out, _ = json.Marshal(errorResponse(nil, &jsonError{Code: errCodeInternal, Message: err.Error()}))
+	}
+	return out
+}
+
+func handleRawRequest(raw []byte) interface{} {
+	req := jsonRequest{}
+	err := json.Unmarshal(raw, &req)
+	if err != nil {
+		return errorResponse(nil, &jsonError{Code: errCodeParse, Message: err.Error()})
+	}
+	return handleRequest(req)
+}
+
+// allowedOrigin checks origin of browser request against ETH_RPC_ALLOWED_ORIGINS, comma separated origins or *.
+// By default only pages served from localhost are allowed. Requests without origin do not come from browsers.
+func allowedOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	if origins := os.Getenv("ETH_RPC_ALLOWED_ORIGINS"); origins != "" {
+		for _, o := range strings.Split(origins, ",") {
+			o = strings.TrimSuffix(strings.TrimSpace(o), "/")
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+func httpHandler(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if !allowedOrigin(origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxRequestBodySize {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	out := handleMessage(body)
+	w.Header().Set("Content-Type", "application/json")
+	if out == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Write(out)
+}
+
+func wsHandler(conn *websocket.Conn) {
+	defer conn.Close()
+	conn.MaxPayloadBytes = maxRequestBodySize
+	for {
+		var msg []byte
+		err := websocket.Message.Receive(conn, &msg)
+		if err != nil {
+			if err != io.EOF {
+				logger.GetLogger().Println("eth rpc websocket receive error:", err)
+			}
+			return
+		}
+		out := handleMessage(msg)
+		if out == nil {
+			continue
+		}
+		err = websocket.Message.Send(conn, string(out))
+		if err != nil {
+			logger.GetLogger().Println("eth rpc websocket send error:", err)
+			return
+		}
+	}
+}
+
+// Handler serves JSON-RPC over HTTP POST and over WebSocket when connection upgrade is requested
+func Handler() http.Handler {
+	ws := websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if !allowedOrigin(r.Header.Get("Origin")) {
+				return fmt.Errorf("origin not allowed")
+			}
+			return nil
+		},
+		Handler: wsHandler,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Upgrade") != "" {
+			ws.ServeHTTP(w, r)
+			return
+		}
+		httpHandler(w, r)
+	})
+}
+
+func ListenEthRPC() {
+	address := tcpip.ListenAddress(tcpip.EthRPCTopic)
+	logger.GetLogger().Printf("Ethereum JSON-RPC server listening on %s", address)
+	err := http.ListenAndServe(address, Handler())
+	if err != nil {
+		logger.GetLogger().Fatalf("Ethereum JSON-RPC server fails: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package ethrpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common/hexutil"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+func TestHandleMessage(t *testing.T) {
+	logger.InitLogger()
+	defer logger.CloseLogger()
+
+	t.Run("web3_sha3", func(t *testing.T) {
+		out := handleMessage([]byte(`{"jsonrpc":"2.0","id":1,"method":"web3_sha3","params":["0x68656c6c6f20776f726c64"]}`))
+		assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"0x47173285a8d7341e5e972fc677286384f802f8ef42a5ec5f03bbfa254cb01fad"}`, string(out))
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		out := handleMessage([]byte(`{"jsonrpc":"2.0","id":"a","method":"eth_unknown","params":[]}`))
+		var resp jsonErrorResponse
+		assert.NoError(t, json.Unmarshal(out, &resp))
+		assert.Equal(t, errCodeMethodNotFound, resp.Error.Code)
+		assert.Equal(t, `"a"`, string(resp.ID))
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		out := handleMessage([]byte(`{"jsonrpc":`))
+		var resp jsonErrorResponse
+		assert.NoError(t, json.Unmarshal(out, &resp))
+		assert.Equal(t, errCodeParse, resp.Error.Code)
+		assert.Equal(t, "null", string(resp.ID))
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		out := handleMessage([]byte(`{"jsonrpc":"2.0","id":2,"method":"web3_sha3","params":[]}`))
+		var resp jsonErrorResponse
+		assert.NoError(t, json.Unmarshal(out, &resp))
+		assert.Equal(t, errCodeInvalidParams, resp.Error.Code)
+	})
+
+	t.Run("batch skips notifications", func(t *testing.T) {
+		out := handleMessage([]byte(`[
+			{"jsonrpc":"2.0","id":1,"method":"net_listening"},
+			{"jsonrpc":"2.0","method":"net_listening"},
+			{"jsonrpc":"2.0","id":3,"method":"web3_clientVersion"}
+		]`))
+		resps := []jsonResponse{}
+		assert.NoError(t, json.Unmarshal(out, &resps))
+		assert.Equal(t, 2, len(resps))
+		assert.Equal(t, true, resps[0].Result)
+		assert.Equal(t, clientVersion, resps[1].Result)
+	})
+
+	t.Run("empty batch", func(t *testing.T) {
+		out := handleMessage([]byte(`[]`))
+		var resp jsonErrorResponse
+		assert.NoError(t, json.Unmarshal(out, &resp))
+		assert.Equal(t, errCodeInvalidRequest, resp.Error.Code)
+	})
+
+	t.Run("notification has no answer", func(t *testing.T) {
+		out := handleMessage([]byte(`{"jsonrpc":"2.0","method":"net_listening"}`))
+		assert.Nil(t, out)
+	})
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	t.Setenv("ETH_RPC_ALLOWED_ORIGINS", "")
+	assert.True(t, allowedOrigin(""))
+	assert.True(t, allowedOrigin("http://localhost:3000"))
+	assert.True(t, allowedOrigin("http://127.0.0.1"))
+	assert.True(t, allowedOrigin("http://[::1]:8080"))
+	assert.False(t, allowedOrigin("https://evil.example"))
+
+	t.Setenv("ETH_RPC_ALLOWED_ORIGINS", "https://wallet.example, https://dapp.example/")
+	assert.True(t, allowedOrigin("https://dapp.example"))
+	assert.False(t, allowedOrigin("http://localhost:3000"))
+
+	t.Setenv("ETH_RPC_ALLOWED_ORIGINS", "*")
+	assert.True(t, allowedOrigin("https://evil.example"))
+}
+
+func TestParseBlockNumber(t *testing.T) {
+	for tag, want := range map[string]int64{
+		`"latest"`:    latestBlockNumber,
+		`"pending"`:   latestBlockNumber,
+		`"finalized"`: latestBlockNumber,
+		`"earliest"`:  earliestBlockNumber,
+		`"0x1a"`:      26,
+	} {
+		n, err := parseBlockNumber(json.RawMessage(tag))
+		assert.NoError(t, err)
+		assert.Equal(t, want, n)
+	}
+
+	_, err := parseBlockNumber(json.RawMessage(`"0xZZ"`))
+	assert.Error(t, err)
+	_, err = parseBlockNumber(json.RawMessage(`12`))
+	assert.Error(t, err)
+}
+
+func TestIsEthereumTransaction(t *testing.T) {
+	legacy, err := hexutil.Decode("0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83")
+	assert.NoError(t, err)
+	assert.True(t, isEthereumTransaction(legacy))
+	assert.True(t, isEthereumTransaction(append([]byte{2}, legacy...)))
+	assert.False(t, isEthereumTransaction(legacy[:len(legacy)-1]))
+	assert.False(t, isEthereumTransaction([]byte{0x01, 0x00, 0x05}))
+}
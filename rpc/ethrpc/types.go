@@ -0,0 +1,287 @@
+package ethrpc
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/common/hexutil"
+	"github.com/wonabru/qwid-node/core/types"
+	"github.com/wonabru/qwid-node/crypto"
+	"github.com/wonabru/qwid-node/transactionsDefinition"
+)
+
+// special block numbers which can be passed instead of height
+const (
+	latestBlockNumber   int64 = -1
+	earliestBlockNumber int64 = -2
+)
+
+// parseBlockNumber decodes block tag or hex encoded height. Pending, safe and finalized are treated as latest.
+func parseBlockNumber(raw json.RawMessage) (int64, error) {
+	var s string
+	err := json.Unmarshal(raw, &s)
+	if err != nil {
+		return 0, invalidParamsError("block number has to be a string: %v", err)
+	}
+	switch strings.ToLower(s) {
+	case "", "latest", "pending", "safe", "finalized":
+		return latestBlockNumber, nil
+	case "earliest":
+		return earliestBlockNumber, nil
+	}
+	n, err := hexutil.DecodeUint64(s)
+	if err != nil {
+		return 0, invalidParamsError("wrong block number %v: %v", s, err)
+	}
+	return int64(n), nil
+}
+
+// resolveHeight turns block number into height. Second value is false when block does not exist yet.
+func resolveHeight(number int64) (int64, bool) {
+	height := common.GetHeight()
+	switch number {
+	case latestBlockNumber:
+		return height, true
+	case earliestBlockNumber:
+		return 0, true
+	}
+	return number, number <= height
+}
+
+type rpcTransaction struct {
+	Hash             common.Hash     `json:"hash"`
+	BlockHash        *common.Hash    `json:"blockHash"`
+	BlockNumber      *hexutil.Uint64 `json:"blockNumber"`
+	TransactionIndex *hexutil.Uint64 `json:"transactionIndex"`
+	From             common.Address  `json:"from"`
+	To               common.Address  `json:"to"`
+	Value            *hexutil.Big    `json:"value"`
+	Input            hexutil.Bytes   `json:"input"`
+	Nonce            hexutil.Uint64  `json:"nonce"`
+	Gas              hexutil.Uint64  `json:"gas"`
+	GasPrice         *hexutil.Big    `json:"gasPrice"`
+	ChainID          *hexutil.Big    `json:"chainId"`
+	Type             hexutil.Uint64  `json:"type"`
+}
+
+func newRPCTransaction(tx transactionsDefinition.Transaction, bl *blocks.Block, index int) *rpcTransaction {
+	rt := &rpcTransaction{
+		Hash:     tx.Hash,
+		From:     tx.TxParam.Sender,
+		To:       tx.TxData.Recipient,
+		Value:    (*hexutil.Big)(big.NewInt(tx.TxData.Amount)),
+		Input:    tx.TxData.OptData,
+		Nonce:    hexutil.Uint64(uint16(tx.TxParam.Nonce)),
+		Gas:      hexutil.Uint64(tx.GasUsage),
+		GasPrice: (*hexutil.Big)(big.NewInt(tx.GasPrice)),
+		ChainID:  (*hexutil.Big)(big.NewInt(int64(tx.TxParam.ChainID))),
+	}
+	if bl != nil {
+		bh := bl.GetBlockHash()
+		height := hexutil.Uint64(bl.GetHeader().Height)
+		idx := hexutil.Uint64(index)
+		rt.BlockHash = &bh
+		rt.BlockNumber = &height
+		rt.TransactionIndex = &idx
+	}
+	return rt
+}
+
+type rpcBlock struct {
+	Number           hexutil.Uint64 `json:"number"`
+	Hash             common.Hash    `json:"hash"`
+	ParentHash       common.Hash    `json:"parentHash"`
+	Miner            common.Address `json:"miner"`
+	TransactionsRoot common.Hash    `json:"transactionsRoot"`
+	Difficulty       *hexutil.Big   `json:"difficulty"`
+	GasLimit         hexutil.Uint64 `json:"gasLimit"`
+	GasUsed          hexutil.Uint64 `json:"gasUsed"`
+	Timestamp        hexutil.Uint64 `json:"timestamp"`
+	ExtraData        hexutil.Bytes  `json:"extraData"`
+	LogsBloom        hexutil.Bytes  `json:"logsBloom"`
+	Size             hexutil.Uint64 `json:"size"`
+	Transactions     []interface{}  `json:"transactions"`
+	Uncles           []common.Hash  `json:"uncles"`
+}
+
+// newRPCBlock builds block answer. Transactions are given as hashes unless fullTx is set.
+func newRPCBlock(bl blocks.Block, fullTx bool) (*rpcBlock, error) {
+	header := bl.GetHeader()
+	rb := &rpcBlock{
+		Number:           hexutil.Uint64(header.Height),
+		Hash:             bl.GetBlockHash(),
+		ParentHash:       header.PreviousHash,
+		Miner:            header.OperatorAccount,
+		TransactionsRoot: header.RootMerkleTree,
+		Difficulty:       (*hexutil.Big)(big.NewInt(int64(header.Difficulty))),
+		GasLimit:         hexutil.Uint64(common.MaxGasUsage),
+		Timestamp:        hexutil.Uint64(bl.GetBlockTimeStamp()),
+		ExtraData:        []byte{},
+		Size:             hexutil.Uint64(len(bl.GetBytes())),
+		Transactions:     []interface{}{},
+		Uncles:           []common.Hash{},
+	}
+	gasUsed := int64(0)
+	for i, h := range bl.TransactionsHashes {
+		tx, err := transactionsDefinition.LoadFromDBPoolTx(common.TransactionDBPrefix[:], h.GetBytes())
+		if err != nil {
+			return nil, err
+		}
+		gasUsed += tx.GasUsage
+		if fullTx {
+			rb.Transactions = append(rb.Transactions, newRPCTransaction(tx, &bl, i))
+		} else {
+			rb.Transactions = append(rb.Transactions, h)
+		}
+	}
+	rb.GasUsed = hexutil.Uint64(gasUsed)
+	logs, err := blocks.LoadBlockLogs(header.Height)
+	if err != nil {
+		return nil, err
+	}
+	rb.LogsBloom = logsBloom(logs)
+	return rb, nil
+}
+
+const bloomByteLength = 256
+
+// logsBloom computes 2048 bit bloom filter of log addresses and topics in the same way as Ethereum does
+func logsBloom(logs []*types.Log) []byte {
+	bloom := make([]byte, bloomByteLength)
+	add := func(b []byte) {
+		h := crypto.Keccak256(b)
+		for i := 0; i < 6; i += 2 {
+			v := (uint(h[i])<<8 | uint(h[i+1])) & 2047
+			bloom[bloomByteLength-1-v/8] |= 1 << (v % 8)
+		}
+	}
+	for _, l := range logs {
+		add(l.Address.GetBytes())
+		for _, t := range l.Topics {
+			add(t.GetBytes())
+		}
+	}
+	return bloom
+}
+
+type rpcReceipt struct {
+	TransactionHash   common.Hash     `json:"transactionHash"`
+	TransactionIndex  hexutil.Uint64  `json:"transactionIndex"`
+	BlockHash         common.Hash     `json:"blockHash"`
+	BlockNumber       hexutil.Uint64  `json:"blockNumber"`
+	From              common.Address  `json:"from"`
+	To                *common.Address `json:"to"`
+	GasUsed           hexutil.Uint64  `json:"gasUsed"`
+	CumulativeGasUsed hexutil.Uint64  `json:"cumulativeGasUsed"`
+	EffectiveGasPrice *hexutil.Big    `json:"effectiveGasPrice"`
+	ContractAddress   *common.Address `json:"contractAddress"`
+	Logs              []*types.Log    `json:"logs"`
+	LogsBloom         hexutil.Bytes   `json:"logsBloom"`
+	Status            hexutil.Uint64  `json:"status"`
+	Type              hexutil.Uint64  `json:"type"`
+}
+
+// callArgs are arguments of eth_call. Gas and gas price are ignored, calls are not charged.
+type callArgs struct {
+	From  *common.Address `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value"`
+	Data  *hexutil.Bytes  `json:"data"`
+	Input *hexutil.Bytes  `json:"input"`
+}
+
+func (args callArgs) data() []byte {
+	if args.Input != nil {
+		return *args.Input
+	}
+	if args.Data != nil {
+		return *args.Data
+	}
+	return nil
+}
+
+// filterQuery is a filter of eth_getLogs as sent by clients
+type filterQuery struct {
+	BlockHash *common.Hash      `json:"blockHash"`
+	FromBlock json.RawMessage   `json:"fromBlock"`
+	ToBlock   json.RawMessage   `json:"toBlock"`
+	Addresses json.RawMessage   `json:"address"`
+	Topics    []json.RawMessage `json:"topics"`
+}
+
+// logFilter is a decoded filterQuery. Empty addresses match any address, empty topic position matches any topic.
+type logFilter struct {
+	BlockHash  *common.Hash
+	FromHeight int64
+	ToHeight   int64
+	Addresses  [][common.AddressLength]byte
+	Topics     [][]common.Hash
+}
+
+func (q filterQuery) decode() (logFilter, error) {
+	lf := logFilter{BlockHash: q.BlockHash}
+	height := common.GetHeight()
+	lf.FromHeight, lf.ToHeight = height, height
+	if q.BlockHash != nil && (len(q.FromBlock) > 0 || len(q.ToBlock) > 0) {
+		return lf, invalidParamsError("cannot specify both blockHash and fromBlock/toBlock")
+	}
+	if len(q.FromBlock) > 0 {
+		n, err := parseBlockNumber(q.FromBlock)
+		if err != nil {
+			return lf, err
+		}
+		lf.FromHeight, _ = resolveHeight(n)
+	}
+	if len(q.ToBlock) > 0 {
+		n, err := parseBlockNumber(q.ToBlock)
+		if err != nil {
+			return lf, err
+		}
+		lf.ToHeight, _ = resolveHeight(n)
+	}
+	if lf.ToHeight > height {
+		lf.ToHeight = height
+	}
+	if len(q.Addresses) > 0 && string(q.Addresses) != "null" {
+		addrs := []common.Address{}
+		if q.Addresses[0] == '[' {
+			err := json.Unmarshal(q.Addresses, &addrs)
+			if err != nil {
+				return lf, invalidParamsError("wrong address in filter: %v", err)
+			}
+		} else {
+			var a common.Address
+			err := json.Unmarshal(q.Addresses, &a)
+			if err != nil {
+				return lf, invalidParamsError("wrong address in filter: %v", err)
+			}
+			addrs = append(addrs, a)
+		}
+		for _, a := range addrs {
+			lf.Addresses = append(lf.Addresses, a.ByteValue)
+		}
+	}
+	for _, raw := range q.Topics {
+		topics := []common.Hash{}
+		switch {
+		case len(raw) == 0 || string(raw) == "null":
+		case raw[0] == '[':
+			err := json.Unmarshal(raw, &topics)
+			if err != nil {
+				return lf, invalidParamsError("wrong topic in filter: %v", err)
+			}
+		default:
+			var h common.Hash
+			err := json.Unmarshal(raw, &h)
+			if err != nil {
+				return lf, invalidParamsError("wrong topic in filter: %v", err)
+			}
+			topics = append(topics, h)
+		}
+		lf.Topics = append(lf.Topics, topics)
+	}
+	return lf, nil
+}
@@ -7,14 +7,16 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"net/netip"
 	"net/rpc"
-	"strconv"
 
 	"github.com/wonabru/qwid-node/account"
 	"github.com/wonabru/qwid-node/blocks"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/core/stateDB"
+	"github.com/wonabru/qwid-node/core/types"
 	"github.com/wonabru/qwid-node/crypto/oqs"
+	"github.com/wonabru/qwid-node/dex"
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/pubkeys"
 	nonceServices "github.com/wonabru/qwid-node/services/nonceService"
@@ -26,7 +28,6 @@ import (
 	"github.com/wonabru/qwid-node/wallet"
 )
 
-
 type Listener struct {
 	remoteIP string
 }
@@ -40,7 +41,7 @@ func extractRemoteIP(addr string) string {
 }
 
 func ListenRPC() {
-	var address = "0.0.0.0:" + strconv.Itoa(tcpip.Ports[tcpip.RPCTopic])
+	var address = tcpip.ListenAddress(tcpip.RPCTopic)
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		logger.GetLogger().Fatalf("Error resolving TCP address: %v", err)
@@ -160,6 +161,20 @@ func (l *Listener) Send(lineBeg []byte, reply *[]byte) error {
 		handleHELO(byt, reply)
 	case "VALS":
 		handleVALS(byt, reply)
+	case "LOGS":
+		handleLOGS(byt, reply)
+	case "DORD":
+		handleDORD(byt, reply)
+	case "PRUF":
+		handlePRUF(byt, reply)
+	case "HDRS":
+		handleHDRS(byt, reply)
+	case "PKEY":
+		handlePKEY(byt, reply)
+	case "APRF":
+		handleAPRF(byt, reply)
+	case "SEAL":
+		handleSEAL(byt, reply)
 	default:
 		*reply = []byte("Invalid operation")
 	}
@@ -235,17 +250,17 @@ func handleHELO(line []byte, reply *[]byte) {
 }
 
 func handleMINE(line []byte, reply *[]byte) {
-	ip := [4]byte{0, 0, 0, 0}
-	if len(line) == 4 {
-		copy(ip[:], line)
+	var peer tcpip.PeerAddress
+	if a, ok := netip.AddrFromSlice(line); ok {
+		peer.IP = a.Unmap()
 	}
 	firstDel := common.GetDelegatedAccountAddress(1)
 	if firstDel.GetHex() != common.GetDelegatedAccount().Hex() {
 		nonceServices.InitNonceService()
 		go nonceServices.StartSubscribingNonceMsgSelf()
-		go nonceServices.StartSubscribingNonceMsg(tcpip.MyIP)
-		if bytes.Equal(ip[:], []byte{0, 0, 0, 0}) == false {
-			go nonceServices.StartSubscribingNonceMsg(ip)
+		go nonceServices.StartSubscribingNonceMsg(tcpip.MyPeerAddress())
+		if peer.IP.IsValid() && !peer.IP.IsUnspecified() {
+			go nonceServices.StartSubscribingNonceMsg(peer)
 		}
 		*reply = []byte("Mining initiated")
 	} else {
@@ -349,6 +364,11 @@ func handleVIEW(line []byte, reply *[]byte) {
 		*reply = []byte(fmt.Sprint(err))
 		return
 	}
+	// swap on DEX is quoted on current pools, OptData is the first token followed by swap
+	if n, err := account.IntDelegatedAccountFromAddress(m.Address); err == nil && n == 512+dex.OpSwap {
+		handleSwapQuote(m.OptData, reply)
+		return
+	}
 
 	bl, err := blocks.LoadBlock(m.Height)
 	if err != nil {
@@ -363,6 +383,29 @@ func handleVIEW(line []byte, reply *[]byte) {
 	*reply, _ = hex.DecodeString(l)
 }
 
+func handleSwapQuote(optData []byte, reply *[]byte) {
+	if len(optData) < common.AddressLength {
+		*reply = []byte("no token to swap")
+		return
+	}
+	first := common.Address{}
+	copy(first.ByteValue[:], optData[:common.AddressLength])
+	s, err := dex.SwapFromBytes(optData[common.AddressLength:])
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	q, err := blocks.QuoteDexSwap(first, s)
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	*reply, err = json.Marshal(q)
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+	}
+}
+
 func handleDETS(line []byte, reply *[]byte) {
 
 	switch len(line) {
@@ -482,7 +525,7 @@ func handleSTAK(line []byte, reply *[]byte) {
 func handleTRAN(byt []byte, reply *[]byte) {
 
 	*reply = []byte("transaction sent")
-	transactionServices.OnMessage([4]byte{0, 0, 0, 0}, byt)
+	transactionServices.OnMessage(tcpip.AnyPeer, byt)
 
 }
 
@@ -534,6 +577,7 @@ func handleSTAT(byt []byte, reply *[]byte) {
 	sm.Stats.TransactionsPending = transactionsPool.PoolsTx.NumberOfTransactions()
 	sm.Stats.Height = common.GetHeight()
 	sm.Stats.HeightMax = common.GetHeightMax()
+	sm.Stats.FinalizedHeight = common.GetFinalizedHeight()
 	sm.Stats.Syncing = common.IsSyncing.Load()
 	lastBlock, err := blocks.LoadBlock(sm.Stats.Height)
 	if err == nil {
@@ -751,3 +795,175 @@ func handleVALS(line []byte, reply *[]byte) {
 	}
 	*reply = result
 }
+
+// handleLOGS returns EVM logs of transaction (hash given) or of contract address in range of heights
+// (address, from height, to height given, negative to height means the latest block)
+func handleLOGS(line []byte, reply *[]byte) {
+	var logs []*types.Log
+	var err error
+	switch len(line) {
+	case common.HashLength:
+		logs, err = blocks.LoadTransactionLogs(line)
+	case common.AddressLength + 16:
+		addr := [common.AddressLength]byte{}
+		copy(addr[:], line[:common.AddressLength])
+		from := common.GetInt64FromByte(line[common.AddressLength : common.AddressLength+8])
+		to := common.GetInt64FromByte(line[common.AddressLength+8:])
+		if to < 0 || to > common.GetHeight() {
+			to = common.GetHeight()
+		}
+		logs, err = blocks.FilterLogs(from, to, [][common.AddressLength]byte{addr}, nil)
+	default:
+		*reply = []byte("Invalid query LOGS")
+		return
+	}
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	r, err := json.Marshal(logs)
+	if err != nil {
+		logger.GetLogger().Println("Cannot marshal logs")
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	*reply = append([]byte("LG"), r...)
+}
+
+// handleDORD returns depth of order book of token, its open orders and order events in range of heights
+// (token, from height, to height given, negative to height means the latest block), optionally only
+// orders and events of owner address given at the end
+func handleDORD(line []byte, reply *[]byte) {
+	if len(line) != common.AddressLength+16 && len(line) != 2*common.AddressLength+16 {
+		*reply = []byte("Invalid query DORD")
+		return
+	}
+	token := common.Address{}
+	copy(token.ByteValue[:], line[:common.AddressLength])
+	from := common.GetInt64FromByte(line[common.AddressLength : common.AddressLength+8])
+	to := common.GetInt64FromByte(line[common.AddressLength+8 : common.AddressLength+16])
+	if to < 0 || to > common.GetHeight() {
+		to = common.GetHeight()
+	}
+	var owner *common.Address
+	if len(line) > common.AddressLength+16 {
+		owner = &common.Address{}
+		copy(owner.ByteValue[:], line[common.AddressLength+16:])
+	}
+	v, err := blocks.GetOrderBook(token, owner, from, to)
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	r, err := json.Marshal(v)
+	if err != nil {
+		logger.GetLogger().Println("Cannot marshal order book")
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	*reply = append([]byte("OB"), r...)
+}
+
+// handlePRUF returns merkle proof of transaction (hash given) against root of the block including it,
+// client verifies it with transactionsPool.VerifyMerkleProof and header of the block
+func handlePRUF(line []byte, reply *[]byte) {
+	if len(line) != common.HashLength {
+		*reply = []byte("Invalid query PRUF")
+		return
+	}
+	proof, err := blocks.LoadTransactionProof(line)
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	r, err := json.Marshal(proof)
+	if err != nil {
+		logger.GetLogger().Println("Cannot marshal proof")
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	*reply = append([]byte("PF"), r...)
+}
+
+// maxHeadersInReply limits number of headers returned by HDRS
+const maxHeadersInReply = 500
+
+// handleHDRS returns headers (blocks without transactions hashes) from height, count given, for light clients
+func handleHDRS(line []byte, reply *[]byte) {
+	if len(line) != 16 {
+		*reply = []byte("Invalid query HDRS")
+		return
+	}
+	from := common.GetInt64FromByte(line[:8])
+	count := common.GetInt64FromByte(line[8:])
+	if count > maxHeadersInReply {
+		count = maxHeadersInReply
+	}
+	r := []byte("HD")
+	for h := from; h < from+count && h <= common.GetHeight(); h++ {
+		bl, err := blocks.LoadBlock(h)
+		if err != nil {
+			logger.GetLogger().Println(err)
+			break
+		}
+		r = append(r, common.BytesToLenAndBytes(bl.GetHeaderBlock().GetBytes())...)
+	}
+	*reply = r
+}
+
+// handlePKEY returns public key of operator (address and 0 for primary or 1 for secondary key given)
+func handlePKEY(line []byte, reply *[]byte) {
+	if len(line) != common.AddressLength+1 {
+		*reply = []byte("Invalid query PKEY")
+		return
+	}
+	addr := common.Address{}
+	err := addr.Init(line[:common.AddressLength])
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	pk, err := pubkeys.LoadPubKeyWithPrimary(addr, line[common.AddressLength] == 0)
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	*reply = append([]byte("PK"), pk.GetBytes()...)
+}
+
+// handleSEAL seals block of pending transactions at once, it works only in dev mode.
+// Reply has height and hash of sealed block.
+func handleSEAL(line []byte, reply *[]byte) {
+	bl, err := nonceServices.SealDevBlock()
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	r := append([]byte("SL"), common.GetByteInt64(bl.GetHeader().Height)...)
+	*reply = append(r, bl.GetBlockHash().GetBytes()...)
+}
+
+// handleAPRF returns proof of account (address given) in state after the last block,
+// it is checked against state root in header of the next block
+func handleAPRF(line []byte, reply *[]byte) {
+	if len(line) != common.AddressLength {
+		*reply = []byte("Invalid query APRF")
+		return
+	}
+	addr := [common.AddressLength]byte{}
+	copy(addr[:], line)
+	common.BlockMutex.Lock()
+	proof, err := blocks.NewAccountProof(addr)
+	common.BlockMutex.Unlock()
+	if err != nil {
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	r, err := json.Marshal(proof)
+	if err != nil {
+		logger.GetLogger().Println("Cannot marshal account proof")
+		*reply = []byte(fmt.Sprint(err))
+		return
+	}
+	*reply = append([]byte("AP"), r...)
+}
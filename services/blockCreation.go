@@ -8,6 +8,7 @@ import (
 	"github.com/wonabru/qwid-node/account"
 	"github.com/wonabru/qwid-node/blocks"
 	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/consensus"
 	"github.com/wonabru/qwid-node/crypto/oqs"
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/message"
@@ -27,6 +28,8 @@ var (
 	SendMutexTx        sync.RWMutex
 	SendChanSync       chan []byte
 	SendMutexSync      sync.RWMutex
+	// DevSealChan wakes up sealing of blocks in dev mode
+	DevSealChan = make(chan struct{}, 1)
 )
 
 func CreateBlockFromNonceMessage(nonceTx []transactionsDefinition.Transaction,
@@ -76,19 +79,15 @@ func CreateBlockFromNonceMessage(nonceTx []transactionsDefinition.Transaction,
 		}
 	}
 
-	reward := account.GetReward(lastBlock.GetBlockSupply())
+	engine := consensus.GetEngine()
+	reward := engine.Reward(lastBlock.GetBlockSupply())
 	supply := lastBlock.GetBlockSupply() + reward
 
-	sendingTimeTransaction := nonceTx[0].GetParam().SendingTime
-	ti := sendingTimeTransaction - lastBlock.GetBlockTimeStamp()
-	bblock := lastBlock.GetBaseBlock()
-	diff := blocks.AdjustDifficulty(bblock.BaseHeader.Difficulty, ti)
 	sendingTimeMessage := common.GetByteInt64(nonceTx[0].GetParam().SendingTime)
 	rootMerkleTrie := common.Hash{}
 	rootMerkleTrie.Set(merkleTrie.GetRootHash())
 	bh := blocks.BaseHeader{
 		PreviousHash:     lastBlock.GetBlockHash(),
-		Difficulty:       diff,
 		Height:           heightTransaction,
 		DelegatedAccount: common.GetDelegatedAccount(),
 		OperatorAccount:  myWallet.MainAddress,
@@ -98,6 +97,8 @@ func CreateBlockFromNonceMessage(nonceTx []transactionsDefinition.Transaction,
 		Signature:        common.Signature{},
 		SignatureMessage: sendingTimeMessage,
 	}
+	blockTimeStamp := common.GetCurrentTimeStampInSecond()
+	engine.PrepareHeader(lastBlock, &bh, blockTimeStamp)
 	sign, signatureBlockHeaderMessage, err := bh.Sign(common.GetNodeSignPrimary(heightTransaction))
 	if err != nil {
 		return blocks.Block{}, err
@@ -117,18 +118,24 @@ func CreateBlockFromNonceMessage(nonceTx []transactionsDefinition.Transaction,
 	if err != nil {
 		logger.GetLogger().Println("could not establish rand oracle", err)
 	}
+	snapshotRoot, ok := blocks.SnapshotRootForBlock(heightTransaction)
+	if !ok {
+		return blocks.Block{}, fmt.Errorf("snapshot to include in block %d is not known", heightTransaction)
+	}
 	bb := blocks.BaseBlock{
 		BaseHeader:       bh,
 		BlockHeaderHash:  bhHash,
-		BlockTimeStamp:   common.GetCurrentTimeStampInSecond(),
+		BlockTimeStamp:   blockTimeStamp,
 		RewardPercentage: common.GetMyRewardPercentage(),
 		Supply:           supply,
 		PriceOracle:      priceOracle,
 		RandOracle:       randOracle,
 		PriceOracleData:  priceOracleData,
 		RandOracleData:   randOracleData,
+		SnapshotRoot:     snapshotRoot,
 	}
 
+	// state root is set when block is processed by this node after it is sealed
 	bl := blocks.Block{
 		BaseBlock:          bb,
 		TransactionsHashes: txs,
@@ -160,8 +167,8 @@ func GenerateBlockMessage(bl blocks.Block) message.TransactionsMessage {
 	return atm
 }
 
-func SendNonce(ip [4]byte, nb []byte) {
-	nb = append(ip[:], nb...)
+func SendNonce(ip tcpip.NodeID, nb []byte) {
+	nb = tcpip.PackPeer(ip, nb)
 	SendMutexNonce.Lock()
 	defer SendMutexNonce.Unlock()
 	select {
@@ -172,13 +179,22 @@ func SendNonce(ip [4]byte, nb []byte) {
 
 }
 
+// RequestDevSeal asks node in dev mode to seal block of pending transactions at once
+func RequestDevSeal() {
+	if !common.DevMode {
+		return
+	}
+	select {
+	case DevSealChan <- struct{}{}:
+	default:
+	}
+}
+
 func BroadcastBlock(bl blocks.Block) {
 	atm := GenerateBlockMessage(bl)
 	nb := atm.GetBytes()
-	var ip [4]byte
 	var peers = tcpip.GetPeersConnected(tcpip.NonceTopic)
 	for topicip, _ := range peers {
-		copy(ip[:], topicip[2:])
-		SendNonce(ip, nb)
+		SendNonce(topicip.ID, nb)
 	}
 }
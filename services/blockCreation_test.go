@@ -3,10 +3,11 @@ package services
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/message"
-	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/tcpip"
 )
 
 func TestGenerateBlockMessage(t *testing.T) {
@@ -78,24 +79,24 @@ func TestSendNonce(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	t.Run("send nonce prepends IP address", func(t *testing.T) {
+	t.Run("send nonce prepends node ID", func(t *testing.T) {
 		// Initialize the channel
 		SendMutexNonce.Lock()
 		SendChanNonce = make(chan []byte, 10)
 		SendMutexNonce.Unlock()
 
-		ip := [4]byte{192, 168, 1, 1}
+		id := tcpip.NodeID{192, 168, 1, 1}
 		data := []byte("test nonce data")
 
-		go SendNonce(ip, data)
+		go SendNonce(id, data)
 
 		// Read from channel
 		received := <-SendChanNonce
 
-		// First 4 bytes should be IP
-		assert.Equal(t, ip[:], received[:4])
+		// First bytes should be node ID
+		assert.Equal(t, id[:], received[:tcpip.NodeIDLength])
 		// Rest should be the data
-		assert.Equal(t, data, received[4:])
+		assert.Equal(t, data, received[tcpip.NodeIDLength:])
 	})
 }
 
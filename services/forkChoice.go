@@ -0,0 +1,217 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/wonabru/qwid-node/account"
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/consensus"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/statistics"
+)
+
+// AddSideBlock keeps block which does not extend main chain, so its branch can be chosen later
+func AddSideBlock(bl blocks.Block) error {
+	if blocks.IsOnMainChain(bl.GetBlockHash().GetBytes(), bl.GetHeader().Height) {
+		return nil
+	}
+	err := blocks.CheckSideBlock(bl)
+	if err != nil {
+		return err
+	}
+	return blocks.StoreSideBlock(bl)
+}
+
+// ChooseFork compares branch ending with side block tip with main chain and switches to it when it is heavier.
+// Returns hashes of transactions which are needed before branch can be applied.
+// Should be called with common.BlockMutex locked.
+func ChooseFork(tip []byte) (bool, [][]byte, error) {
+	h := common.GetHeight()
+	blocks.PruneSideBlocks(h)
+	branch, ancestor, err := blocks.GetBranch(tip)
+	if err != nil {
+		return false, nil, err
+	}
+	mainWeight, err := blocks.MainChainWeight(ancestor, h)
+	if err != nil {
+		return false, nil, err
+	}
+	sideWeight := blocks.BranchWeight(branch)
+	if !blocks.IsBetterBranch(sideWeight, mainWeight, ancestor, h) {
+		logger.GetLogger().Printf("keep main chain, weight %d, side branch from %d weight %d", mainWeight, ancestor, sideWeight)
+		return false, nil, nil
+	}
+	missing := [][]byte{}
+	for _, bl := range branch {
+		missing = append(missing, blocks.IsAllTransactions(bl)...)
+	}
+	if len(missing) > 0 {
+		return false, missing, nil
+	}
+	logger.GetLogger().Printf("reorg from height %d to %d, main weight %d, side branch weight %d", h, branch[len(branch)-1].GetHeader().Height, mainWeight, sideWeight)
+	err = reorg(ancestor, branch)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, nil, nil
+}
+
+// validateBranch checks what can be checked without state of branch: blocks follow each other starting
+// from main chain ancestor, their hashes, seals and operator signatures are correct
+func validateBranch(ancestor int64, branch []blocks.Block) (int, error) {
+	last, err := blocks.LoadBlock(ancestor)
+	if err != nil {
+		return 0, err
+	}
+	for i, bl := range branch {
+		if bl.GetHeader().Height != last.GetHeader().Height+1 {
+			return i, fmt.Errorf("side block %d does not follow block %d", bl.GetHeader().Height, last.GetHeader().Height)
+		}
+		err = blocks.CheckHeader(bl, last.GetBlockHash().GetBytes())
+		if err != nil {
+			return i, err
+		}
+		err = blocks.CheckSideBlock(bl)
+		if err != nil {
+			return i, err
+		}
+		last = bl
+	}
+	return len(branch), nil
+}
+
+// reorg replaces main chain above ancestor with branch, replaced blocks become side branch.
+// Branch is validated before main chain is touched, when it turns out to be invalid while applied
+// old main chain is restored.
+func reorg(ancestor int64, branch []blocks.Block) error {
+	n, err := validateBranch(ancestor, branch)
+	if err != nil {
+		logger.GetLogger().Println("side branch is invalid:", err)
+		removeSideBlocks(branch[n:])
+		return err
+	}
+	h := common.GetHeight()
+	old := []blocks.Block{}
+	for i := ancestor + 1; i <= h; i++ {
+		bl, err := blocks.LoadBlock(i)
+		if err != nil {
+			return err
+		}
+		old = append(old, bl)
+	}
+	for _, bl := range old {
+		err := blocks.StoreSideBlock(bl)
+		if err != nil {
+			return err
+		}
+	}
+	wasSyncing := common.IsSyncing.Load()
+	common.IsSyncing.Store(true)
+	defer common.IsSyncing.Store(wasSyncing)
+
+	ResetAccountsAndBlocksSync(ancestor)
+	n, err = applyBlocks(ancestor, branch)
+	if err == nil {
+		removeSideBlocks(branch)
+		return nil
+	}
+	logger.GetLogger().Println("side branch is invalid, restore main chain:", err)
+	// invalid block and its descendants are not kept, so branch is not chosen again
+	removeSideBlocks(branch[n:])
+	ResetAccountsAndBlocksSync(ancestor)
+	_, errOld := applyBlocks(ancestor, old)
+	if errOld != nil {
+		// old blocks stay as side branch, so main chain can be rebuilt from them later
+		return fmt.Errorf("cannot restore main chain after invalid side branch (%v): %w", err, errOld)
+	}
+	removeSideBlocks(old)
+	return err
+}
+
+// applyBlocks appends blocks to main chain at height, returns index of block which failed
+func applyBlocks(height int64, bls []blocks.Block) (int, error) {
+	lastBlock, err := blocks.LoadBlock(height)
+	if err != nil {
+		return 0, err
+	}
+	for i, bl := range bls {
+		err = ApplyBlock(bl, lastBlock)
+		if err != nil {
+			return i, err
+		}
+		lastBlock = bl
+	}
+	return len(bls), nil
+}
+
+func removeSideBlocks(bls []blocks.Block) {
+	for _, bl := range bls {
+		err := blocks.RemoveSideBlock(bl.GetBlockHash().GetBytes())
+		if err != nil {
+			logger.GetLogger().Println(err)
+		}
+	}
+}
+
+// ApplyBlock verifies block following lastBlock, transfers funds and stores it in main chain.
+// Should be called with common.BlockMutex locked.
+func ApplyBlock(newBlock blocks.Block, lastBlock blocks.Block) error {
+	_, err := applyBlock(newBlock, lastBlock, false)
+	return err
+}
+
+// ApplyOwnBlock adds block made by this node to main chain and returns it with state root after it set
+func ApplyOwnBlock(newBlock blocks.Block, lastBlock blocks.Block) (blocks.Block, error) {
+	return applyBlock(newBlock, lastBlock, true)
+}
+
+func applyBlock(newBlock blocks.Block, lastBlock blocks.Block, own bool) (blocks.Block, error) {
+	height := newBlock.GetHeader().Height
+	if height != lastBlock.GetHeader().Height+1 {
+		return blocks.Block{}, fmt.Errorf("block %d does not follow block %d", height, lastBlock.GetHeader().Height)
+	}
+	merkleTrie, err := blocks.CheckBaseBlock(newBlock, lastBlock, false)
+	defer merkleTrie.Destroy()
+	if err != nil {
+		return blocks.Block{}, err
+	}
+	if own {
+		err = blocks.TransferFundsOfOwnBlock(&newBlock, lastBlock, merkleTrie)
+	} else {
+		err = blocks.CheckBlockAndTransferFunds(&newBlock, lastBlock, merkleTrie, false)
+	}
+	if err != nil {
+		ResetAccountsAndBlocksSync(lastBlock.GetHeader().Height)
+		return blocks.Block{}, err
+	}
+	err = newBlock.StoreBlock()
+	if err != nil {
+		ResetAccountsAndBlocksSync(lastBlock.GetHeader().Height)
+		return blocks.Block{}, err
+	}
+	err = account.StoreAccounts(height)
+	if err != nil {
+		logger.GetLogger().Println(err)
+	}
+	err = account.StoreStakingAccounts(height)
+	if err != nil {
+		logger.GetLogger().Println(err)
+	}
+	err = account.StoreDexAccounts(height)
+	if err != nil {
+		logger.GetLogger().Println(err)
+	}
+	err = blocks.StoreStateDB(height)
+	if err != nil {
+		logger.GetLogger().Println(err)
+	}
+	common.SetHeight(height)
+	err = consensus.GetEngine().Finalize(newBlock)
+	if err != nil {
+		logger.GetLogger().Println(err)
+	}
+	sm := statistics.GetStatsManager()
+	sm.UpdateStatistics(newBlock, lastBlock)
+	return newBlock, nil
+}
@@ -33,7 +33,9 @@ func checkBlock(newBlock blocks.Block, lastBlock blocks.Block, checkFinal bool)
 }
 
 func checkMainChain() (int64, error) {
-	lastBlock, err := blocks.LoadBlock(0)
+	// node started from snapshot has no blocks below base height
+	base := common.GetBaseHeight()
+	lastBlock, err := blocks.LoadBlock(base)
 	if err != nil {
 		return -1, err
 	}
@@ -44,7 +46,7 @@ func checkMainChain() (int64, error) {
 		return height - 1, err
 	}
 	logger.GetLogger().Println("blocks.LastHeightStoredInBlocks() height: ", height)
-	if height > 1 {
+	if height > base+1 {
 
 		bl, err := blocks.LoadBlock(height)
 		if err != nil {
@@ -77,7 +79,7 @@ func checkMainChain() (int64, error) {
 		return 0, fmt.Errorf("bad chain storage")
 	}
 	ResetAccountsAndBlocksSync(height - 1)
-	for h := int64(1); h < height; h++ {
+	for h := base + 1; h < height; h++ {
 		bl, err := blocks.LoadBlock(h)
 		if err != nil {
 			logger.GetLogger().Println(err)
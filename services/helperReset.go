@@ -61,6 +61,15 @@ func ResetAccountsAndBlocksSync(height int64) {
 			return
 		}
 	}
+	if f := common.GetFinalizedHeight(); height < f {
+		logger.GetLogger().Println("cannot reset below finalized height", f)
+		height = f
+	}
+	// state below snapshot which node started from is not stored
+	if b := common.GetBaseHeight(); height < b {
+		logger.GetLogger().Println("cannot reset below snapshot height", b)
+		height = b
+	}
 
 	err := account.LoadAccounts(height)
 	if err != nil {
@@ -70,6 +79,11 @@ func ResetAccountsAndBlocksSync(height int64) {
 	if err != nil {
 		return
 	}
+	// dex state was not stored per block by older nodes, so it is not fatal
+	err = account.LoadDexAccounts(height)
+	if err != nil {
+		logger.GetLogger().Println("cannot load dex accounts at height", height, err)
+	}
 
 	ha, err := account.LastHeightStoredInAccounts()
 	if err != nil {
@@ -101,6 +115,10 @@ func ResetAccountsAndBlocksSync(height int64) {
 		if err != nil {
 			logger.GetLogger().Println(err)
 		}
+		err = blocks.RemoveStateDBFromDB(i)
+		if err != nil {
+			logger.GetLogger().Println(err)
+		}
 	}
 	for i := ha; i > height; i-- {
 		err := account.RemoveAccountsFromDB(i)
@@ -132,6 +150,8 @@ func ResetAccountsAndBlocksSync(height int64) {
 		}
 	}
 
+	// state trees may already follow block which was not added
+	blocks.InvalidateStateTrees(height + 1)
 	common.SetHeight(height)
 	logger.GetLogger().Println("reset to ", height, " is successful")
 }
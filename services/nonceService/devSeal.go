@@ -0,0 +1,60 @@
+package nonceServices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/consensus"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/services"
+)
+
+// SealDevBlock produces block of pending transactions at once and adds it to main chain.
+// It is used in dev mode, where node is the only validator and no nonce messages are exchanged.
+func SealDevBlock() (blocks.Block, error) {
+	if !common.DevMode {
+		return blocks.Block{}, fmt.Errorf("blocks are sealed on demand only in dev mode")
+	}
+	common.BlockMutex.Lock()
+	defer common.BlockMutex.Unlock()
+
+	lastBlock, err := blocks.LoadBlock(common.GetHeight())
+	if err != nil {
+		return blocks.Block{}, err
+	}
+	// timestamps of blocks have to increase
+	for common.GetCurrentTimeStampInSecond() <= lastBlock.GetBlockTimeStamp() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	transaction, err := generateNonceTransaction()
+	if err != nil {
+		return blocks.Block{}, err
+	}
+	newBlock, merkleTrie, err := createCandidateBlock(transaction, lastBlock)
+	merkleTrie.Destroy()
+	if err != nil {
+		return blocks.Block{}, err
+	}
+	if !consensus.GetEngine().Seal(newBlock) {
+		return blocks.Block{}, fmt.Errorf("block cannot be sealed by engine %s", consensus.GetEngine().Name())
+	}
+	newBlock, err = services.ApplyOwnBlock(newBlock, lastBlock)
+	if err != nil {
+		return blocks.Block{}, err
+	}
+	logger.GetLogger().Println("Dev block sealed", newBlock.GetHeader().Height, "with", len(newBlock.TransactionsHashes), "transactions")
+	return newBlock, nil
+}
+
+// StartDevSealing seals block whenever new transaction arrives to pool
+func StartDevSealing() {
+	for !services.QUIT.Load() {
+		<-services.DevSealChan
+		_, err := SealDevBlock()
+		if err != nil {
+			logger.GetLogger().Println("cannot seal dev block:", err)
+		}
+	}
+}
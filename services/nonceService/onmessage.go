@@ -2,13 +2,17 @@ package nonceServices
 
 import (
 	"bytes"
+	"fmt"
 	"runtime/debug"
+	"sync"
 
 	"github.com/wonabru/qwid-node/logger"
 
 	"github.com/wonabru/qwid-node/account"
 	"github.com/wonabru/qwid-node/blocks"
 	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/consensus"
+	"github.com/wonabru/qwid-node/finality"
 	"github.com/wonabru/qwid-node/message"
 	"github.com/wonabru/qwid-node/oracles"
 	"github.com/wonabru/qwid-node/services"
@@ -20,7 +24,13 @@ import (
 	"github.com/wonabru/qwid-node/voting"
 )
 
-func OnMessage(addr [4]byte, m []byte) {
+var (
+	sealedMutex sync.Mutex
+	// sealedBlock is the last block sent by this node as operator
+	sealedBlock blocks.Block
+)
+
+func OnMessage(addr tcpip.NodeID, m []byte) {
 	if common.IsSyncing.Load() {
 		return
 	}
@@ -38,7 +48,7 @@ func OnMessage(addr [4]byte, m []byte) {
 	isValid, amsg := message.CheckValidMessage(m)
 	if isValid == false {
 		logger.GetLogger().Println("nonce msg validation fails")
-		tcpip.ReduceAndCheckIfBanIP(addr)
+		tcpip.ReduceAndCheckIfBanPeer(addr)
 		return
 	}
 	tcpip.ValidRegisterPeer(addr)
@@ -74,7 +84,7 @@ func OnMessage(addr [4]byte, m []byte) {
 		isValid = transaction.Verify(common.SigName(), common.SigName2(), common.IsPaused(), common.IsPaused2())
 		if isValid == false {
 			logger.GetLogger().Println("nonce signature is invalid")
-			tcpip.ReduceAndCheckIfBanIP(addr)
+			tcpip.ReduceAndCheckIfBanPeer(addr)
 			return
 		}
 		//register checked Node IP
@@ -124,62 +134,57 @@ func OnMessage(addr [4]byte, m []byte) {
 		// checking if enough coins staked
 		if _, sumStaked, operationalAcc := account.GetStakedInDelegatedAccount(n); int64(sumStaked) < common.MinStakingForNode || !bytes.Equal(operationalAcc.Address[:], mainAddress.GetBytes()) {
 			logger.GetLogger().Println("not enough staked coins to be a node or not valid operational account", sumStaked, common.MinStakingForNode, operationalAcc.Address[:5], mainAddress.GetBytes()[:5])
-			tcpip.ReduceAndCheckIfBanIP(addr)
+			tcpip.ReduceAndCheckIfBanPeer(addr)
 			return
 		}
-
-		lastBlock, err := blocks.LoadBlock(h)
+		if e, ok := blocks.FindNonceEquivocation(transaction); ok {
+			go transactionServices.ReportEquivocation(e)
+		}
+		// nonce transaction signs hash of last block, so it is finality vote of delegated account
+		votedHash := transaction.TxData.OptData[8 : 8+common.HashLength]
+		err = finality.SaveVote(votedHash, common.GetInt64FromByte(transaction.TxData.OptData[:8]), txDelAcc, stakedInDelAccInt)
 		if err != nil {
-			logger.GetLogger().Println(err)
-			return
+			logger.GetLogger().Println("could not save finality vote", err)
 		}
 
-		rawTxs := transactionsPool.PoolsTx.PeekTransactions(int(common.MaxTransactionsPerBlock), nonceHeight)
-		// Filter out transactions that are already confirmed in the blockchain.
-		// Under concurrent load, the memory pool can still hold transactions that
-		// were confirmed moments ago by a parallel block handler, causing
-		// CheckBlockTransfers to fail with "previously added in chain".
-		txs := rawTxs[:0]
-		for _, tx := range rawTxs {
-			if transactionsDefinition.CheckFromDBPoolTx(common.TransactionDBPrefix[:], tx.Hash.GetBytes()) {
-				// Already confirmed — clean it out of the memory pool proactively.
-				transactionsPool.PoolsTx.RemoveTransactionByHash(tx.Hash.GetBytes())
-				continue
-			}
-			txs = append(txs, tx)
-		}
-		txsBytes := make([][]byte, len(txs))
-		transactionsHashes := []common.Hash{}
-		for _, tx := range txs {
-			hash := tx.GetHash().GetBytes()
-			transactionsHashes = append(transactionsHashes, tx.GetHash())
-			txsBytes = append(txsBytes, hash)
+		if account.IsJailed(n, nonceHeight) {
+			logger.GetLogger().Println("delegated account", n, "is jailed for equivocation")
+			return
 		}
-		merkleTrie, err := transactionsPool.BuildMerkleTree(h+1, txsBytes, transactionsPool.GlobalMerkleTree.DB)
-		defer merkleTrie.Destroy()
 
+		lastBlock, err := blocks.LoadBlock(h)
 		if err != nil {
-			logger.GetLogger().Println("cannot build merkleTrie")
+			logger.GetLogger().Println(err)
 			return
 		}
 
-		newBlock, err := services.CreateBlockFromNonceMessage([]transactionsDefinition.Transaction{transaction},
-			lastBlock,
-			merkleTrie,
-			transactionsHashes)
-
+		newBlock, merkleTrie, err := createCandidateBlock(transaction, lastBlock)
+		defer merkleTrie.Destroy()
 		if err != nil {
 			logger.GetLogger().Println(err)
 			return
-
 		}
 
-		if newBlock.CheckProofOfSynergy() {
+		if consensus.GetEngine().Seal(newBlock) {
+			sealedMutex.Lock()
+			defer sealedMutex.Unlock()
+			loadSealedBlock()
+			// candidate is made for every nonce, but only one block following last block can be sent,
+			// the second one would be equivocation
+			if sealedBlock.GetHeader().Height == newBlock.GetHeader().Height &&
+				bytes.Equal(sealedBlock.GetHeader().PreviousHash.GetBytes(), lastBlock.BlockHash.GetBytes()) {
+				services.BroadcastBlock(sealedBlock)
+				return
+			}
 			_, _, err := blocks.CheckBlockTransfers(newBlock, lastBlock, merkleTrie, false)
-			if err == nil {
-				services.BroadcastBlock(newBlock)
-			} else {
+			if err != nil {
 				logger.GetLogger().Println("new block is not valid. Bad transactions included:", err)
+			} else if newBlock, err = addOwnBlock(newBlock, lastBlock); err != nil {
+				logger.GetLogger().Println("cannot add sealed block to chain, block is not sent:", err)
+			} else if err = storeSealedBlock(newBlock); err != nil {
+				logger.GetLogger().Println("cannot store sealed block, block is not sent:", err)
+			} else {
+				services.BroadcastBlock(newBlock)
 			}
 		} else {
 			//logger.GetLogger().Println("new block is not valid")
@@ -212,19 +217,34 @@ func OnMessage(addr [4]byte, m []byte) {
 				if err != nil {
 					logger.GetLogger().Println(err)
 					logger.GetLogger().Println("cannot load blocks from bytes")
-					tcpip.ReduceAndCheckIfBanIP(addr)
+					tcpip.ReduceAndCheckIfBanPeer(addr)
 					return
 				}
 
-				if newBlock.GetHeader().Height != h+1 {
-					logger.GetLogger().Println("block of too short chain")
+				if newBlock.GetHeader().Height != h+1 || !bytes.Equal(newBlock.GetHeader().PreviousHash.GetBytes(), lastBlock.BlockHash.GetBytes()) {
+					// block of other branch, it is kept and main chain is replaced only when branch is heavier
+					err = services.AddSideBlock(newBlock)
+					if err != nil {
+						logger.GetLogger().Println("side block rejected:", err)
+						return
+					}
+					if e, ok := blocks.FindEquivocation(newBlock); ok {
+						go transactionServices.ReportEquivocation(e)
+					}
+					_, missing, err := services.ChooseFork(newBlock.GetBlockHash().GetBytes())
+					if err != nil {
+						logger.GetLogger().Println("fork choice:", err)
+					}
+					if len(missing) > 0 {
+						transactionServices.SendGT(addr, missing, "st")
+					}
 					return
 				}
 				merkleTrie, err := blocks.CheckBaseBlock(newBlock, lastBlock, true)
 				defer merkleTrie.Destroy()
 				if err != nil {
 					logger.GetLogger().Println(err)
-					tcpip.ReduceAndCheckIfBanIP(addr)
+					tcpip.ReduceAndCheckIfBanPeer(addr)
 					return
 				}
 				hashesMissing := blocks.IsAllTransactions(newBlock)
@@ -257,7 +277,21 @@ func OnMessage(addr [4]byte, m []byte) {
 				if err != nil {
 					logger.GetLogger().Println(err)
 				}
+
+				err = account.StoreDexAccounts(newBlock.GetHeader().Height)
+				if err != nil {
+					logger.GetLogger().Println(err)
+				}
+
+				err = blocks.StoreStateDB(newBlock.GetHeader().Height)
+				if err != nil {
+					logger.GetLogger().Println(err)
+				}
 				common.SetHeight(h + 1)
+				err = consensus.GetEngine().Finalize(newBlock)
+				if err != nil {
+					logger.GetLogger().Println(err)
+				}
 				sm := statistics.GetStatsManager()
 				sm.UpdateStatistics(newBlock, lastBlock)
 				logger.GetLogger().Println("TPS: ", sm.Stats.Tps)
@@ -266,3 +300,53 @@ func OnMessage(addr [4]byte, m []byte) {
 	default:
 	}
 }
+
+// addOwnBlock adds sealed block to main chain before it is sent, so it carries state root after its transactions
+func addOwnBlock(newBlock blocks.Block, lastBlock blocks.Block) (blocks.Block, error) {
+	common.BlockMutex.Lock()
+	defer common.BlockMutex.Unlock()
+	if common.GetHeight() != lastBlock.GetHeader().Height {
+		return blocks.Block{}, fmt.Errorf("block following %d was added meanwhile", lastBlock.GetHeader().Height)
+	}
+	return services.ApplyOwnBlock(newBlock, lastBlock)
+}
+
+// createCandidateBlock makes block following lastBlock of transactions from pool, signed by this node
+func createCandidateBlock(transaction transactionsDefinition.Transaction, lastBlock blocks.Block) (blocks.Block, *transactionsPool.MerkleTree, error) {
+	h := lastBlock.GetHeader().Height
+	rawTxs := transactionsPool.PoolsTx.PeekTransactions(int(common.MaxTransactionsPerBlock), h+1)
+	// Filter out transactions that are already confirmed in the blockchain.
+	// Under concurrent load, the memory pool can still hold transactions that
+	// were confirmed moments ago by a parallel block handler, causing
+	// CheckBlockTransfers to fail with "previously added in chain".
+	txs := rawTxs[:0]
+	for _, tx := range rawTxs {
+		if transactionsDefinition.CheckFromDBPoolTx(common.TransactionDBPrefix[:], tx.Hash.GetBytes()) {
+			// Already confirmed — clean it out of the memory pool proactively.
+			transactionsPool.PoolsTx.RemoveTransactionByHash(tx.Hash.GetBytes())
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	txsBytes := [][]byte{}
+	transactionsHashes := []common.Hash{}
+	for _, tx := range txs {
+		hash := tx.GetHash().GetBytes()
+		transactionsHashes = append(transactionsHashes, tx.GetHash())
+		txsBytes = append(txsBytes, hash)
+	}
+	merkleTrie, err := transactionsPool.BuildMerkleTree(h+1, transactionsPool.BlockMerkleLeaves(txsBytes), transactionsPool.GlobalMerkleTree.DB)
+	if err != nil {
+		logger.GetLogger().Println("cannot build merkleTrie")
+		return blocks.Block{}, merkleTrie, err
+	}
+
+	newBlock, err := services.CreateBlockFromNonceMessage([]transactionsDefinition.Transaction{transaction},
+		lastBlock,
+		merkleTrie,
+		transactionsHashes)
+	if err != nil {
+		return blocks.Block{}, merkleTrie, err
+	}
+	return newBlock, merkleTrie, nil
+}
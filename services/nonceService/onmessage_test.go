@@ -3,17 +3,18 @@ package nonceServices
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/message"
-	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/tcpip"
 )
 
 func TestOnMessageInvalidInput(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	addr := [4]byte{192, 168, 1, 1}
+	addr := tcpip.NodeID{192, 168, 1, 1}
 
 	t.Run("empty message", func(t *testing.T) {
 		assert.NotPanics(t, func() {
@@ -47,7 +48,7 @@ func TestOnMessageWhenSyncing(t *testing.T) {
 		common.IsSyncing.Store(true)
 		defer common.IsSyncing.Store(false)
 
-		addr := [4]byte{10, 0, 0, 1}
+		addr := tcpip.NodeID{10, 0, 0, 1}
 
 		// Should return early without processing
 		assert.NotPanics(t, func() {
@@ -60,7 +61,7 @@ func TestOnMessageRecovery(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	addr := [4]byte{10, 0, 0, 1}
+	addr := tcpip.NodeID{10, 0, 0, 1}
 
 	t.Run("recovers from malformed data", func(t *testing.T) {
 		malformedMsg := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
@@ -161,8 +162,8 @@ func TestAddressHandlingNonce(t *testing.T) {
 	// Ensure not syncing for these tests
 	common.IsSyncing.Store(false)
 
-	addresses := [][4]byte{
-		{0, 0, 0, 0},
+	addresses := []tcpip.NodeID{
+		tcpip.AnyPeer,
 		{127, 0, 0, 1},
 		{192, 168, 1, 1},
 		{255, 255, 255, 255},
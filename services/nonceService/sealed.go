@@ -0,0 +1,93 @@
+package nonceServices
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/database"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/transactionsDefinition"
+)
+
+// The last sealed block and the last nonce transaction are kept in DB, so operator restarted in the middle
+// of round sends again the same ones instead of signing different, which would be equivocation.
+
+var (
+	sealedOnce     sync.Once
+	sentNonceMutex sync.Mutex
+	sentNonceOnce  sync.Once
+	// sentNonce is the last nonce transaction made by this node
+	sentNonce transactionsDefinition.Transaction
+)
+
+func loadFromDB(prefix [2]byte) []byte {
+	isKey, err := database.MainDB.IsKey(prefix[:])
+	if err != nil || !isKey {
+		return nil
+	}
+	b, err := database.MainDB.Get(prefix[:])
+	if err != nil {
+		logger.GetLogger().Println("cannot load", string(prefix[:]), err)
+		return nil
+	}
+	return b
+}
+
+// loadSealedBlock restores sealed block stored before restart, sealedMutex has to be locked
+func loadSealedBlock() {
+	sealedOnce.Do(func() {
+		b := loadFromDB(common.SealedBlockDBPrefix)
+		if b == nil {
+			return
+		}
+		bl, err := blocks.Block{}.GetFromBytes(b)
+		if err != nil {
+			logger.GetLogger().Println("cannot restore sealed block", err)
+			return
+		}
+		sealedBlock = bl
+	})
+}
+
+// storeSealedBlock stores block before it is sent, sealedMutex has to be locked
+func storeSealedBlock(bl blocks.Block) error {
+	err := database.MainDB.Put(common.SealedBlockDBPrefix[:], bl.GetBytes())
+	if err != nil {
+		return err
+	}
+	sealedBlock = bl
+	return nil
+}
+
+// lastSentNonce returns nonce transaction already made for block following lastBlockHash, sentNonceMutex has to be locked
+func lastSentNonce(height int64, lastBlockHash []byte) (transactionsDefinition.Transaction, bool) {
+	sentNonceOnce.Do(func() {
+		b := loadFromDB(common.SentNonceDBPrefix)
+		if b == nil {
+			return
+		}
+		tx, _, err := sentNonce.GetFromBytes(b)
+		if err != nil {
+			logger.GetLogger().Println("cannot restore sent nonce transaction", err)
+			return
+		}
+		sentNonce = tx
+	})
+	if sentNonce.Height != height || len(sentNonce.TxData.OptData) < 8+common.HashLength ||
+		!bytes.Equal(sentNonce.TxData.OptData[8:8+common.HashLength], lastBlockHash) {
+		return transactionsDefinition.Transaction{}, false
+	}
+	return sentNonce, true
+}
+
+// storeSentNonce stores nonce transaction before it is sent, sentNonceMutex has to be locked
+func storeSentNonce(tx transactionsDefinition.Transaction) error {
+	err := database.MainDB.Put(common.SentNonceDBPrefix[:], tx.GetBytes())
+	if err != nil {
+		return err
+	}
+	sentNonce = tx
+	return nil
+}
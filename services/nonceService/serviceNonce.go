@@ -18,7 +18,7 @@ import (
 	"golang.org/x/exp/rand"
 )
 
-var lastReplyPerPeer = make(map[[4]byte]time.Time)
+var lastReplyPerPeer = make(map[tcpip.NodeID]time.Time)
 var lastReplyMutex sync.Mutex
 var EncryptionOptData []byte
 var encryptionMutex sync.Mutex
@@ -90,6 +90,25 @@ func InitNonceService() {
 }
 
 func generateNonceMsg(topic [2]byte) (message.TransactionsMessage, error) {
+	nonceTransaction, err := generateNonceTransaction()
+	if err != nil {
+		return message.TransactionsMessage{}, err
+	}
+	bm := message.BaseMessage{
+		Head:    []byte("nn"),
+		ChainID: common.GetChainID(),
+	}
+	bb := nonceTransaction.GetBytes()
+	n := message.TransactionsMessage{
+		BaseMessage:       bm,
+		TransactionsBytes: map[[2]byte][][]byte{topic: {bb}},
+	}
+
+	return n, nil
+}
+
+// generateNonceTransaction makes signed nonce transaction for block following the last block
+func generateNonceTransaction() (transactionsDefinition.Transaction, error) {
 	h := common.GetHeight()
 	w := wallet.GetActiveWallet()
 	primary := common.GetNodeSignPrimary(h)
@@ -106,6 +125,12 @@ func generateNonceMsg(topic [2]byte) (message.TransactionsMessage, error) {
 	if err != nil {
 		lastBlockHash = common.EmptyHash().GetBytes()
 	}
+	// the same nonce transaction is sent until the next block, different one would be equivocation
+	sentNonceMutex.Lock()
+	defer sentNonceMutex.Unlock()
+	if tx, ok := lastSentNonce(h+1, lastBlockHash); ok {
+		return tx, nil
+	}
 	optData := common.GetByteInt64(h)
 	optData = append(optData, lastBlockHash...)
 
@@ -128,7 +153,7 @@ func generateNonceMsg(topic [2]byte) (message.TransactionsMessage, error) {
 	if primary == false {
 		pktrie, err := pubkeys.LoadTreeWithoutAddresses(sender)
 		if err != nil {
-			return message.TransactionsMessage{}, err
+			return transactionsDefinition.Transaction{}, err
 		}
 		isAddr := pktrie.IsAddressInTree(w.Account2.Address)
 		if !isAddr {
@@ -155,32 +180,25 @@ func generateNonceMsg(topic [2]byte) (message.TransactionsMessage, error) {
 
 	err = (&nonceTransaction).CalcHashAndSet()
 	if err != nil {
-		return message.TransactionsMessage{}, err
+		return transactionsDefinition.Transaction{}, err
 	}
 
 	err = (&nonceTransaction).Sign(w, primary)
 	if err != nil {
-		return message.TransactionsMessage{}, err
-	}
-
-	bm := message.BaseMessage{
-		Head:    []byte("nn"),
-		ChainID: common.GetChainID(),
+		return transactionsDefinition.Transaction{}, err
 	}
-	bb := nonceTransaction.GetBytes()
-	n := message.TransactionsMessage{
-		BaseMessage:       bm,
-		TransactionsBytes: map[[2]byte][][]byte{topic: {bb}},
+	err = storeSentNonce(nonceTransaction)
+	if err != nil {
+		return transactionsDefinition.Transaction{}, err
 	}
-
-	return n, nil
+	return nonceTransaction, nil
 }
 
 func sendNonceMsgInLoopSelf() {
 	var topic = [2]byte{'S', 'S'}
 	// Q:
 	for {
-		ret := sendNonceMsgSelf(tcpip.MyIPSelfNonce, topic)
+		ret := sendNonceMsgSelf(tcpip.MyNodeID(), topic)
 
 		if !ret {
 			time.Sleep(3 * time.Second)
@@ -198,7 +216,7 @@ func sendNonceMsgInLoopSelf() {
 	}
 }
 
-func sendNonceMsg(ip [4]byte, topic [2]byte) bool {
+func sendNonceMsg(ip tcpip.NodeID, topic [2]byte) bool {
 	h := common.GetHeight()
 	if h < common.CurrentHeightOfNetwork {
 		return false
@@ -219,7 +237,7 @@ func sendNonceMsg(ip [4]byte, topic [2]byte) bool {
 	return true
 }
 
-func sendNonceMsgSelf(ip [4]byte, topic [2]byte) bool {
+func sendNonceMsgSelf(ip tcpip.NodeID, topic [2]byte) bool {
 	h := common.GetHeight()
 	if h < common.CurrentHeightOfNetwork {
 		return false
@@ -236,8 +254,8 @@ func sendNonceMsgSelf(ip [4]byte, topic [2]byte) bool {
 	return true
 }
 
-func Send(addr [4]byte, nb []byte) bool {
-	nb = append(addr[:], nb...)
+func Send(addr tcpip.NodeID, nb []byte) bool {
+	nb = tcpip.PackPeer(addr, nb)
 	if services.SendMutexNonce.TryLock() {
 		defer services.SendMutexNonce.Unlock()
 		select {
@@ -250,8 +268,8 @@ func Send(addr [4]byte, nb []byte) bool {
 	return false
 }
 
-func SendSelf(addr [4]byte, nb []byte) bool {
-	nb = append(addr[:], nb...)
+func SendSelf(addr tcpip.NodeID, nb []byte) bool {
+	nb = tcpip.PackPeer(addr, nb)
 	if services.SendMutexSelfNonce.TryLock() {
 		defer services.SendMutexSelfNonce.Unlock()
 		select {
@@ -271,7 +289,7 @@ func sendNonceMsgInLoop() {
 			continue
 		}
 		var topic = [2]byte{'N', 'N'}
-		ret := sendNonceMsg([4]byte{0, 0, 0, 0}, topic)
+		ret := sendNonceMsg(tcpip.AnyPeer, topic)
 		if !ret {
 			time.Sleep(3 * time.Second)
 		}
@@ -286,11 +304,10 @@ func startPublishingNonceMsg() {
 	go tcpip.LoopSend(services.SendChanSelfNonce, tcpip.SelfNonceTopic)
 }
 
-func StartSubscribingNonceMsg(ip [4]byte) {
+func StartSubscribingNonceMsg(peer tcpip.PeerAddress) {
 	recvChan := make(chan []byte, 100) // Use a buffered channel
 	quit := false
-	var ipr [4]byte
-	go tcpip.StartNewConnection(ip, recvChan, tcpip.NonceTopic)
+	go tcpip.StartNewConnection(peer, recvChan, tcpip.NonceTopic)
 	for !services.QUIT.Load() && !quit {
 		select {
 		case s := <-recvChan:
@@ -298,11 +315,10 @@ func StartSubscribingNonceMsg(ip [4]byte) {
 				quit = true
 				break
 			}
-			if len(s) > 4 {
-				copy(ipr[:], s[:4])
-				OnMessage(ipr, s[4:])
+			if ipr, msg, ok := tcpip.UnpackPeer(s); ok {
+				OnMessage(ipr, msg)
 				//send reply to valid nonce message from other nodes
-				if !bytes.Equal(ipr[:], tcpip.MyIP[:]) && !bytes.Equal(ipr[:], []byte{0, 0, 0, 0}) {
+				if ipr != tcpip.MyNodeID() && ipr != tcpip.AnyPeer {
 					lastReplyMutex.Lock()
 					lastTime := lastReplyPerPeer[ipr]
 					if time.Since(lastTime) >= 5*time.Second {
@@ -323,7 +339,7 @@ func StartSubscribingNonceMsg(ip [4]byte) {
 	}
 }
 
-func sendReply(addr [4]byte) {
+func sendReply(addr tcpip.NodeID) {
 	logger.GetLogger().Println("send reply to ", addr)
 	var topic = [2]byte{'N', 'N'}
 	n, err := generateNonceMsg(topic)
@@ -332,7 +348,7 @@ func sendReply(addr [4]byte) {
 		return
 	}
 	if Send(addr, n.GetBytes()) {
-		logger.GetLogger().Println("send reply to node ", addr, " my ip ", tcpip.MyIP)
+		logger.GetLogger().Println("send reply to node ", addr, " my node ", tcpip.MyNodeID())
 	}
 }
 
@@ -340,8 +356,7 @@ func StartSubscribingNonceMsgSelf() {
 	recvChanSelf := make(chan []byte, 100) // Use a buffered channel
 	recvChanExit := make(chan []byte, 100) // Use a buffered channel
 	quit := false
-	var ip [4]byte
-	go tcpip.StartNewConnection(tcpip.MyIPSelfNonce, recvChanSelf, tcpip.SelfNonceTopic)
+	go tcpip.StartNewConnection(tcpip.PeerAddress{IP: tcpip.MyIPSelfNonce, Ports: tcpip.MyPorts()}, recvChanSelf, tcpip.SelfNonceTopic)
 
 	for !services.QUIT.Load() && !quit {
 		select {
@@ -351,9 +366,8 @@ func StartSubscribingNonceMsgSelf() {
 				quit = true
 				break
 			}
-			if len(s) > 4 {
-				copy(ip[:], s[:4])
-				OnMessage(ip, s[4:])
+			if ip, msg, ok := tcpip.UnpackPeer(s); ok {
+				OnMessage(ip, msg)
 			}
 		case <-tcpip.Quit:
 			services.QUIT.Store(true)
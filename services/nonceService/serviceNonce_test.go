@@ -3,9 +3,10 @@ package nonceServices
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
-	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/tcpip"
 )
 
 func TestResetToDefaultEncryptionOptData(t *testing.T) {
@@ -74,7 +75,7 @@ func TestSendFunction(t *testing.T) {
 
 	t.Run("send without initialization returns false", func(t *testing.T) {
 		// Without initializing the service, Send should fail gracefully
-		addr := [4]byte{192, 168, 1, 1}
+		addr := tcpip.NodeID{192, 168, 1, 1}
 		data := []byte("test data")
 
 		// This should not panic even without initialization
@@ -0,0 +1,497 @@
+package syncServices
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/message"
+	"github.com/wonabru/qwid-node/services"
+	"github.com/wonabru/qwid-node/services/transactionServices"
+	"github.com/wonabru/qwid-node/statistics"
+	"github.com/wonabru/qwid-node/tcpip"
+)
+
+// Headers-first sync is used when node is far behind. Chain of headers is downloaded and checked first,
+// then blocks are fetched in buckets from many peers in parallel and applied in order while download goes on.
+
+const (
+	// MaxHeadersInMessage is the largest number of headers sent in one message
+	MaxHeadersInMessage = 500
+	// number of buckets downloaded ahead of applied height
+	blocksWindowBuckets = 20
+	// requests sent to one peer at the same time
+	maxRequestsPerPeer = 2
+	requestTimeout     = 15 * time.Second
+	progressInterval   = 5 * time.Second
+)
+
+var errWrongBlocksMessage = errors.New("number of heights and blocks in message differs")
+
+type syncRequest struct {
+	peer tcpip.NodeID
+	sent time.Time
+}
+
+var (
+	headersFirstMutex   sync.Mutex
+	headersFirstRunning atomic.Bool
+	headerTip           int64
+	targetHeight        int64
+	headersRequest      *syncRequest
+	blocksRequests      = map[int64]syncRequest{}
+	downloaded          = map[int64]tcpip.NodeID{}
+)
+
+// HeadersFirstMinGap is distance to height of peers from which headers-first sync is used
+func HeadersFirstMinGap() int64 {
+	return 2 * common.NumberOfBlocksInBucket
+}
+
+// startHeadersFirst sets height to sync to and starts headers-first sync if it is not running
+func startHeadersFirst(height int64) {
+	headersFirstMutex.Lock()
+	if height > targetHeight {
+		targetHeight = height
+	}
+	headersFirstMutex.Unlock()
+	if headersFirstRunning.CompareAndSwap(false, true) {
+		go runHeadersFirst()
+	}
+}
+
+// IsHeadersFirstRunning informs if blocks are synced by headers-first sync
+func IsHeadersFirstRunning() bool {
+	return headersFirstRunning.Load()
+}
+
+func runHeadersFirst() {
+	defer headersFirstRunning.Store(false)
+	loadHeadersFirstState()
+	logger.GetLogger().Println("headers-first sync started, target height", targetHeight, "headers at", headerTip)
+
+	done := make(chan struct{})
+	defer close(done)
+	go applyDownloadedBlocks(done)
+
+	lastReport := time.Now()
+	lastHeight := common.GetHeight()
+	for !services.QUIT.Load() {
+		h := common.GetHeight()
+		headersFirstMutex.Lock()
+		finished := h >= targetHeight
+		headersFirstMutex.Unlock()
+		if finished {
+			break
+		}
+		requestHeaders()
+		requestBlocks()
+		if time.Since(lastReport) > progressInterval {
+			reportProgress(float64(h-lastHeight) / time.Since(lastReport).Seconds())
+			lastReport = time.Now()
+			lastHeight = h
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	reportProgress(0)
+	logger.GetLogger().Println("headers-first sync finished at height", common.GetHeight())
+}
+
+// loadHeadersFirstState finds headers and blocks stored before restart
+func loadHeadersFirstState() {
+	h := common.GetHeight()
+	tip := h
+	for {
+		header, err := blocks.LoadSyncHeader(tip + 1)
+		if err != nil || header.GetHeader().Height != tip+1 {
+			break
+		}
+		tip++
+	}
+	heights, err := blocks.LoadDownloadedHeights()
+	if err != nil {
+		logger.GetLogger().Println(err)
+	}
+	headersFirstMutex.Lock()
+	defer headersFirstMutex.Unlock()
+	headerTip = tip
+	for _, hd := range heights {
+		if hd > h && hd <= tip {
+			downloaded[hd] = tcpip.AnyPeer
+		}
+	}
+}
+
+// peersAtHeight returns connected peers which claimed height at least as given
+func peersAtHeight(height int64) []tcpip.NodeID {
+	peerHeightClaimsMutex.RLock()
+	defer peerHeightClaimsMutex.RUnlock()
+	ids := []tcpip.NodeID{}
+	for key := range tcpip.GetPeersConnected(tcpip.SyncTopic) {
+		if claim, ok := peerHeightClaims[key.ID]; ok && claim.height >= height {
+			ids = append(ids, key.ID)
+		}
+	}
+	return ids
+}
+
+func requestHeaders() {
+	headersFirstMutex.Lock()
+	defer headersFirstMutex.Unlock()
+	if headerTip >= targetHeight {
+		return
+	}
+	if headersRequest != nil && time.Since(headersRequest.sent) < requestTimeout {
+		return
+	}
+	peers := peersAtHeight(headerTip + 1)
+	if len(peers) == 0 {
+		return
+	}
+	// other peer is asked when previous one did not answer
+	peer := peers[int(time.Now().UnixNano()%int64(len(peers)))]
+	eHeight := min(headerTip+MaxHeadersInMessage, targetHeight)
+	if Send(peer, generateSyncMsgGetRange("hr", headerTip+1, eHeight)) {
+		headersRequest = &syncRequest{peer: peer, sent: time.Now()}
+	}
+}
+
+func requestBlocks() {
+	// blocks below snapshot are not needed when state is restored from it
+	if IsFastSyncRunning() {
+		return
+	}
+	h := common.GetHeight()
+	bucket := common.NumberOfBlocksInBucket
+	headersFirstMutex.Lock()
+	defer headersFirstMutex.Unlock()
+	busy := map[tcpip.NodeID]int{}
+	for start, r := range blocksRequests {
+		if time.Since(r.sent) > requestTimeout || start <= h {
+			delete(blocksRequests, start)
+			continue
+		}
+		busy[r.peer]++
+	}
+	for height := range downloaded {
+		// blocks could be applied by other path in the meantime
+		if height <= h {
+			delete(downloaded, height)
+			go forgetDownloaded(height)
+		}
+	}
+	last := min(headerTip, h+blocksWindowBuckets*bucket)
+	for start := h + 1; start <= last; start += bucket {
+		end := min(start+bucket-1, last)
+		if _, ok := blocksRequests[start]; ok || isDownloaded(start, end) {
+			continue
+		}
+		for _, peer := range peersAtHeight(end) {
+			if busy[peer] >= maxRequestsPerPeer {
+				continue
+			}
+			if Send(peer, generateSyncMsgGetRange("gb", start, end)) {
+				blocksRequests[start] = syncRequest{peer: peer, sent: time.Now()}
+				busy[peer]++
+			}
+			break
+		}
+	}
+}
+
+// isDownloaded should be called with headersFirstMutex locked
+func isDownloaded(start int64, end int64) bool {
+	for i := start; i <= end; i++ {
+		if _, ok := downloaded[i]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func reportProgress(rate float64) {
+	h := common.GetHeight()
+	headersFirstMutex.Lock()
+	tip, target, nd := headerTip, targetHeight, len(downloaded)
+	headersFirstMutex.Unlock()
+	if rate > 0 {
+		logger.GetLogger().Printf("headers-first sync: applied %d of %d, headers %d, blocks waiting %d, %.1f blocks/s, ETA %v",
+			h, target, tip, nd, rate, time.Duration(float64(target-h)/rate)*time.Second)
+	}
+	sm := statistics.GetStatsManager()
+	if sm == nil {
+		return
+	}
+	sm.Mu.Lock()
+	sm.Stats.SyncTargetHeight = target
+	sm.Stats.SyncHeadersHeight = tip
+	sm.Stats.SyncBlocksWaiting = nd
+	sm.Mu.Unlock()
+}
+
+// onHeaders checks chain of headers received and stores it, headers which do not link to
+// chain known are dropped together with headers stored above main chain
+func onHeaders(addr tcpip.NodeID, indices []int64, headers []blocks.Block) {
+	headersFirstMutex.Lock()
+	defer headersFirstMutex.Unlock()
+	if headersRequest != nil && headersRequest.peer == addr {
+		headersRequest = nil
+	}
+	for i, header := range headers {
+		height := header.GetHeader().Height
+		if indices[i] != height || height != headerTip+1 {
+			continue
+		}
+		prev, err := headerAt(headerTip)
+		if err != nil {
+			logger.GetLogger().Println(err)
+			return
+		}
+		err = blocks.CheckSyncedHeader(header, prev)
+		if err != nil {
+			logger.GetLogger().Println("header rejected:", err)
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			dropHeadersAboveMainChain()
+			return
+		}
+		err = blocks.StoreSyncHeader(header)
+		if err != nil {
+			logger.GetLogger().Println(err)
+			return
+		}
+		headerTip = height
+	}
+}
+
+// headerAt should be called with headersFirstMutex locked
+func headerAt(height int64) (blocks.Block, error) {
+	if height <= common.GetHeight() {
+		return blocks.LoadBlock(height)
+	}
+	return blocks.LoadSyncHeader(height)
+}
+
+// headerHashAt should be called with headersFirstMutex locked
+func headerHashAt(height int64) ([]byte, error) {
+	if height <= common.GetHeight() {
+		return blocks.LoadHashOfBlock(height)
+	}
+	header, err := blocks.LoadSyncHeader(height)
+	if err != nil {
+		return nil, err
+	}
+	return header.BlockHash.GetBytes(), nil
+}
+
+// dropHeadersAboveMainChain should be called with headersFirstMutex locked
+func dropHeadersAboveMainChain() {
+	h := common.GetHeight()
+	for i := headerTip; i > h; i-- {
+		err := blocks.RemoveSyncHeader(i)
+		if err != nil {
+			logger.GetLogger().Println(err)
+		}
+		if _, ok := downloaded[i]; ok {
+			err = blocks.RemoveDownloadedBlock(i)
+			if err != nil {
+				logger.GetLogger().Println(err)
+			}
+			delete(downloaded, i)
+		}
+	}
+	headerTip = h
+}
+
+// onBlocks stores blocks which match headers checked before
+func onBlocks(addr tcpip.NodeID, indices []int64, blcks []blocks.Block) {
+	headersFirstMutex.Lock()
+	defer headersFirstMutex.Unlock()
+	if len(indices) > 0 {
+		if r, ok := blocksRequests[indices[0]]; ok && r.peer == addr {
+			delete(blocksRequests, indices[0])
+		}
+	}
+	for i, bl := range blcks {
+		height := bl.GetHeader().Height
+		if indices[i] != height || height > headerTip || height <= common.GetHeight() {
+			continue
+		}
+		if _, ok := downloaded[height]; ok {
+			continue
+		}
+		headerHash, err := headerHashAt(height)
+		if err != nil {
+			logger.GetLogger().Println(err)
+			continue
+		}
+		hash, err := bl.CalcBlockHash()
+		if err != nil || !bytes.Equal(bl.BlockHash.GetBytes(), headerHash) || !bytes.Equal(hash.GetBytes(), headerHash) {
+			logger.GetLogger().Println("block does not match header at height", height)
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			return
+		}
+		err = blocks.StoreDownloadedBlock(bl)
+		if err != nil {
+			logger.GetLogger().Println(err)
+			continue
+		}
+		downloaded[height] = addr
+	}
+}
+
+// applyDownloadedBlocks applies downloaded blocks in order while next ones are being downloaded
+func applyDownloadedBlocks(done chan struct{}) {
+	lastAsk := time.Time{}
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		h := common.GetHeight()
+		headersFirstMutex.Lock()
+		peer, ok := downloaded[h+1]
+		headersFirstMutex.Unlock()
+		if !ok {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		bl, err := blocks.LoadDownloadedBlock(h + 1)
+		if err != nil {
+			logger.GetLogger().Println(err)
+			forgetDownloaded(h + 1)
+			continue
+		}
+		missing := blocks.IsAllTransactions(bl)
+		if len(missing) > 0 {
+			if time.Since(lastAsk) > time.Second {
+				if peer == tcpip.AnyPeer {
+					if peers := peersAtHeight(h + 1); len(peers) > 0 {
+						peer = peers[0]
+					}
+				}
+				for i := 0; i < len(missing); i += common.MaxNumberTransactionInChunk {
+					end := min(i+common.MaxNumberTransactionInChunk, len(missing))
+					transactionServices.SendGT(peer, missing[i:end], "bt")
+				}
+				lastAsk = time.Now()
+			}
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		common.IsSyncing.Store(true)
+		err = applyDownloadedBlock(bl)
+		if err != nil {
+			logger.GetLogger().Println("cannot apply downloaded block", h+1, err)
+			if peer != tcpip.AnyPeer {
+				tcpip.ReduceAndCheckIfBanPeer(peer)
+			}
+			headersFirstMutex.Lock()
+			dropHeadersAboveMainChain()
+			headersFirstMutex.Unlock()
+			continue
+		}
+		forgetDownloaded(bl.GetHeader().Height)
+	}
+}
+
+func applyDownloadedBlock(bl blocks.Block) error {
+	common.BlockMutex.Lock()
+	defer common.BlockMutex.Unlock()
+	h := common.GetHeight()
+	if bl.GetHeader().Height != h+1 {
+		return nil
+	}
+	lastBlock, err := blocks.LoadBlock(h)
+	if err != nil {
+		return err
+	}
+	return services.ApplyBlock(bl, lastBlock)
+}
+
+func forgetDownloaded(height int64) {
+	headersFirstMutex.Lock()
+	delete(downloaded, height)
+	headersFirstMutex.Unlock()
+	err := blocks.RemoveDownloadedBlock(height)
+	if err != nil {
+		logger.GetLogger().Println(err)
+	}
+	err = blocks.RemoveSyncHeader(height)
+	if err != nil {
+		logger.GetLogger().Println(err)
+	}
+}
+
+func generateSyncMsgGetRange(head string, bHeight int64, eHeight int64) []byte {
+	bm := message.BaseMessage{
+		Head:    []byte(head),
+		ChainID: common.GetChainID(),
+	}
+	n := message.TransactionsMessage{
+		BaseMessage:       bm,
+		TransactionsBytes: map[[2]byte][][]byte{},
+	}
+	n.TransactionsBytes[[2]byte{'B', 'H'}] = [][]byte{common.GetByteInt64(bHeight)}
+	n.TransactionsBytes[[2]byte{'E', 'H'}] = [][]byte{common.GetByteInt64(eHeight)}
+	return n.GetBytes()
+}
+
+// SendHeadersOnly sends headers of main chain blocks, at most MaxHeadersInMessage
+func SendHeadersOnly(addr tcpip.NodeID, bHeight int64, height int64) {
+	height = min(height, bHeight+MaxHeadersInMessage-1)
+	n := generateSyncMsgBlocks("hc", bHeight, height, true)
+	if len(n) == 0 {
+		return
+	}
+	if !Send(addr, n) {
+		logger.GetLogger().Printf("SendHeadersOnly: could not send to %v", addr)
+	}
+}
+
+// SendBlocks sends blocks for headers-first sync, at most NumberOfBlocksInBucket
+func SendBlocks(addr tcpip.NodeID, bHeight int64, height int64) {
+	height = min(height, bHeight+common.NumberOfBlocksInBucket-1)
+	n := generateSyncMsgBlocks("sb", bHeight, height, false)
+	if len(n) == 0 {
+		return
+	}
+	if !Send(addr, n) {
+		logger.GetLogger().Printf("SendBlocks: could not send to %v", addr)
+	}
+}
+
+// getRange parses heights of range request
+func getRange(txn map[[2]byte][][]byte) (int64, int64, bool) {
+	bh, ok1 := txn[[2]byte{'B', 'H'}]
+	eh, ok2 := txn[[2]byte{'E', 'H'}]
+	if !ok1 || !ok2 || len(bh) == 0 || len(eh) == 0 || len(bh[0]) != 8 || len(eh[0]) != 8 {
+		return 0, 0, false
+	}
+	return common.GetInt64FromByte(bh[0]), common.GetInt64FromByte(eh[0]), true
+}
+
+// getBlocksFromMessage parses heights and blocks sent by generateSyncMsgBlocks
+func getBlocksFromMessage(txn map[[2]byte][][]byte) ([]int64, []blocks.Block, error) {
+	indices := []int64{}
+	for _, b := range txn[[2]byte{'I', 'H'}] {
+		indices = append(indices, common.GetInt64FromByte(b))
+	}
+	blcks := []blocks.Block{}
+	for _, b := range txn[[2]byte{'H', 'V'}] {
+		bl, err := blocks.Block{}.GetFromBytes(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		blcks = append(blcks, bl)
+	}
+	if len(indices) != len(blcks) {
+		return nil, nil, errWrongBlocksMessage
+	}
+	return indices, blcks, nil
+}
@@ -0,0 +1,43 @@
+package syncServices
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/message"
+)
+
+func TestGenerateSyncMsgGetRange(t *testing.T) {
+	logger.InitLogger()
+	defer logger.CloseLogger()
+
+	for _, head := range []string{"hr", "gb"} {
+		result := generateSyncMsgGetRange(head, 3, 40)
+		isValid, msg := message.CheckValidMessage(result)
+		assert.True(t, isValid)
+		assert.Equal(t, []byte(head), msg.GetHead())
+		b, e, ok := getRange(msg.(message.TransactionsMessage).GetTransactionsBytes())
+		assert.True(t, ok)
+		assert.Equal(t, int64(3), b)
+		assert.Equal(t, int64(40), e)
+	}
+}
+
+func TestGetRangeInvalid(t *testing.T) {
+	_, _, ok := getRange(map[[2]byte][][]byte{})
+	assert.False(t, ok)
+	_, _, ok = getRange(map[[2]byte][][]byte{
+		{'B', 'H'}: {common.GetByteInt64(1)},
+		{'E', 'H'}: {{1, 2}},
+	})
+	assert.False(t, ok)
+}
+
+func TestGetBlocksFromMessageCountMismatch(t *testing.T) {
+	_, _, err := getBlocksFromMessage(map[[2]byte][][]byte{
+		{'I', 'H'}: {common.GetByteInt64(1)},
+	})
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package syncServices
 
 import (
 	"bytes"
+	"errors"
 	"runtime/debug"
 	"sync"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/wonabru/qwid-node/account"
 	"github.com/wonabru/qwid-node/blocks"
 	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/consensus"
 	"github.com/wonabru/qwid-node/message"
 	"github.com/wonabru/qwid-node/services"
 	nonceServices "github.com/wonabru/qwid-node/services/nonceService"
@@ -22,8 +24,14 @@ import (
 
 var err error
 
+// connectingKey is address which is being dialed on topic
+type connectingKey struct {
+	topic [2]byte
+	peer  tcpip.PeerAddress
+}
+
 var (
-	connectingPeers      = make(map[[6]byte]bool)
+	connectingPeers      = make(map[connectingKey]bool)
 	connectingPeersMutex sync.Mutex
 	syncProcessMutex     sync.Mutex
 )
@@ -36,7 +44,7 @@ type peerHeightClaim struct {
 }
 
 var (
-	peerHeightClaims      = make(map[[4]byte]peerHeightClaim)
+	peerHeightClaims      = make(map[tcpip.NodeID]peerHeightClaim)
 	peerHeightClaimsMutex sync.RWMutex
 	// MaxHeightJumpWithoutConsensus - if a peer claims height more than this ahead,
 	// require multiple peers to confirm before syncing
@@ -48,7 +56,7 @@ var (
 )
 
 // recordPeerHeightClaim stores a peer's height claim
-func recordPeerHeightClaim(addr [4]byte, height int64, blockHash []byte) {
+func recordPeerHeightClaim(addr tcpip.NodeID, height int64, blockHash []byte) {
 	peerHeightClaimsMutex.Lock()
 	defer peerHeightClaimsMutex.Unlock()
 	peerHeightClaims[addr] = peerHeightClaim{
@@ -120,7 +128,47 @@ func cleanupExpiredClaims() {
 	}
 }
 
-func OnMessage(addr [4]byte, m []byte) {
+// chooseForkFromPeer keeps blocks of other branch received from peer and switches to it when it is heavier.
+// When branch cannot be linked to main chain earlier blocks are requested, up to reorg depth.
+func chooseForkFromPeer(addr tcpip.NodeID, branch []blocks.Block) {
+	common.BlockMutex.Lock()
+	defer common.BlockMutex.Unlock()
+	for _, bl := range branch {
+		err := services.AddSideBlock(bl)
+		if err != nil {
+			logger.GetLogger().Println("side block rejected:", err)
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			return
+		}
+		if e, ok := blocks.FindEquivocation(bl); ok {
+			go transactionServices.ReportEquivocation(e)
+		}
+	}
+	tip := branch[len(branch)-1]
+	reorged, missing, err := services.ChooseFork(tip.GetBlockHash().GetBytes())
+	if errors.Is(err, blocks.ErrUnknownParent) {
+		h := common.GetHeight()
+		eHeight := branch[0].GetHeader().Height - 1
+		bHeight := max(eHeight-common.NumberOfBlocksInBucket+1, h-common.MaxReorgDepth+1, 0)
+		if eHeight >= bHeight {
+			SendGetHeadersRange(addr, bHeight, eHeight)
+		}
+		return
+	}
+	if err != nil {
+		logger.GetLogger().Println("fork choice:", err)
+		return
+	}
+	if len(missing) > 0 {
+		transactionServices.SendGT(addr, missing, "bt")
+		return
+	}
+	if reorged {
+		logger.GetLogger().Println("switched to heavier branch at height", common.GetHeight())
+	}
+}
+
+func OnMessage(addr tcpip.NodeID, m []byte) {
 
 	h := common.GetHeight()
 
@@ -138,7 +186,7 @@ func OnMessage(addr [4]byte, m []byte) {
 	isValid, amsg := message.CheckValidMessage(m)
 	if isValid == false {
 		logger.GetLogger().Println("sync msg validation fails")
-		tcpip.ReduceAndCheckIfBanIP(addr)
+		tcpip.ReduceAndCheckIfBanPeer(addr)
 		return
 	}
 	tcpip.ValidRegisterPeer(addr)
@@ -146,51 +194,46 @@ func OnMessage(addr [4]byte, m []byte) {
 	case "hi": // getheader
 
 		txn := amsg.(message.TransactionsMessage).GetTransactionsBytes()
-		var topicip [6]byte
-		var ip4 [4]byte
 		if tcpip.GetPeersCount() < common.MaxPeersConnected {
 			peers := txn[[2]byte{'P', 'P'}]
-			peersConnectedNN := tcpip.GetPeersConnected(tcpip.NonceTopic)
-			peersConnectedBB := tcpip.GetPeersConnected(tcpip.SyncTopic)
-			peersConnectedTT := tcpip.GetPeersConnected(tcpip.TransactionTopic)
-
-			for _, ip := range peers {
-				copy(ip4[:], ip)
-				copy(topicip[2:], ip)
-				copy(topicip[:2], tcpip.NonceTopic[:])
-				if bytes.Equal(ip4[:], addr[:]) {
+
+			for _, pb := range peers {
+				// peers are sent as IPv4 or IPv6 address with listener ports
+				peer, err := tcpip.PeerAddressFromBytes(pb)
+				if err != nil || tcpip.IsAddressBanned(peer) {
 					continue
 				}
+				tcpip.AddPeerAddresses([]tcpip.PeerAddress{peer})
 				connectingPeersMutex.Lock()
-				copy(topicip[:2], tcpip.NonceTopic[:])
-				if _, ok := peersConnectedNN[topicip]; !ok && !tcpip.IsIPBanned(ip4) && !connectingPeers[topicip] {
-					connectingPeers[topicip] = true
-					go func(pip [4]byte, key [6]byte) {
-						nonceServices.StartSubscribingNonceMsg(pip)
+				key := connectingKey{tcpip.NonceTopic, peer}
+				if !tcpip.IsConnectedTo(key.topic, peer) && !connectingPeers[key] {
+					connectingPeers[key] = true
+					go func(key connectingKey) {
+						nonceServices.StartSubscribingNonceMsg(key.peer)
 						connectingPeersMutex.Lock()
 						delete(connectingPeers, key)
 						connectingPeersMutex.Unlock()
-					}(ip4, topicip)
+					}(key)
 				}
-				copy(topicip[:2], tcpip.SyncTopic[:])
-				if _, ok := peersConnectedBB[topicip]; !ok && !tcpip.IsIPBanned(ip4) && !connectingPeers[topicip] {
-					connectingPeers[topicip] = true
-					go func(pip [4]byte, key [6]byte) {
-						StartSubscribingSyncMsg(pip)
+				key.topic = tcpip.SyncTopic
+				if !tcpip.IsConnectedTo(key.topic, peer) && !connectingPeers[key] {
+					connectingPeers[key] = true
+					go func(key connectingKey) {
+						StartSubscribingSyncMsg(key.peer)
 						connectingPeersMutex.Lock()
 						delete(connectingPeers, key)
 						connectingPeersMutex.Unlock()
-					}(ip4, topicip)
+					}(key)
 				}
-				copy(topicip[:2], tcpip.TransactionTopic[:])
-				if _, ok := peersConnectedTT[topicip]; !ok && !tcpip.IsIPBanned(ip4) && !connectingPeers[topicip] {
-					connectingPeers[topicip] = true
-					go func(pip [4]byte, key [6]byte) {
-						transactionServices.StartSubscribingTransactionMsg(pip)
+				key.topic = tcpip.TransactionTopic
+				if !tcpip.IsConnectedTo(key.topic, peer) && !connectingPeers[key] {
+					connectingPeers[key] = true
+					go func(key connectingKey) {
+						transactionServices.StartSubscribingTransactionMsg(key.peer)
 						connectingPeersMutex.Lock()
 						delete(connectingPeers, key)
 						connectingPeersMutex.Unlock()
-					}(ip4, topicip)
+					}(key)
 				}
 				connectingPeersMutex.Unlock()
 				if tcpip.GetPeersCount() > common.MaxPeersConnected {
@@ -247,6 +290,16 @@ func OnMessage(addr [4]byte, m []byte) {
 		}
 
 		common.IsSyncing.Store(true)
+		if validatedHeight-h > HeadersFirstMinGap() {
+			if shouldFastSync(h, validatedHeight) {
+				startFastSync()
+			}
+			startHeadersFirst(validatedHeight)
+			return
+		}
+		if IsHeadersFirstRunning() {
+			return
+		}
 		SendGetHeaders(addr, validatedHeight)
 		return
 	case "sh":
@@ -282,7 +335,7 @@ func OnMessage(addr [4]byte, m []byte) {
 		hmax := common.GetHeightMax()
 		if len(indices) == 0 || len(blcks) == 0 {
 			logger.GetLogger().Println("empty blocks received from peer - possible fake height claim")
-			tcpip.ReduceAndCheckIfBanIP(addr)
+			tcpip.ReduceAndCheckIfBanPeer(addr)
 			// Exit sync if we have no progress
 			if h >= common.CurrentHeightOfNetwork {
 				common.IsSyncing.Store(false)
@@ -335,11 +388,7 @@ func OnMessage(addr [4]byte, m []byte) {
 					continue
 				}
 				logger.GetLogger().Printf("Block hash mismatch at index %d - potential fork detected", index)
-				services.AdjustShiftInPastInReset(hmax)
-				common.ShiftToPastMutex.RLock()
-				services.ResetAccountsAndBlocksSync(index - common.ShiftToPastInReset)
-				common.ShiftToPastMutex.RUnlock()
-				logger.GetLogger().Println("fork detected at index", index, "- reset done")
+				chooseForkFromPeer(addr, blcks[i:])
 				return
 			}
 			if was {
@@ -351,6 +400,11 @@ func OnMessage(addr [4]byte, m []byte) {
 					logger.GetLogger().Printf("ERROR: Failed to load previous block for index %d: %v", index-1, err)
 					return
 				}
+				if !bytes.Equal(block.GetHeader().PreviousHash.GetBytes(), oldBlock.BlockHash.GetBytes()) {
+					logger.GetLogger().Printf("Block %d does not extend main chain - potential fork detected", index)
+					chooseForkFromPeer(addr, blcks[i:])
+					return
+				}
 				was = true
 				logger.GetLogger().Printf("Loaded previous block from storage for index %d", index)
 			}
@@ -413,7 +467,7 @@ func OnMessage(addr [4]byte, m []byte) {
 			defer merkleTrie.Destroy()
 			if err != nil {
 				logger.GetLogger().Printf("ERROR: Base block verification failed for block %d: %v", index, err)
-				tcpip.ReduceAndCheckIfBanIP(addr)
+				tcpip.ReduceAndCheckIfBanPeer(addr)
 				services.AdjustShiftInPastInReset(hmax)
 				common.ShiftToPastMutex.RLock()
 				services.ResetAccountsAndBlocksSync(index - common.ShiftToPastInReset)
@@ -483,6 +537,11 @@ func OnMessage(addr [4]byte, m []byte) {
 					logger.GetLogger().Printf("ERROR: Failed to load previous block for index %d: %v", index-1, err)
 					return
 				}
+				if !bytes.Equal(block.GetHeader().PreviousHash.GetBytes(), oldBlock.BlockHash.GetBytes()) {
+					logger.GetLogger().Printf("Block %d does not extend main chain - potential fork detected", index)
+					chooseForkFromPeer(addr, blcks[i:])
+					return
+				}
 				was = true
 			}
 
@@ -524,7 +583,21 @@ func OnMessage(addr [4]byte, m []byte) {
 			if err != nil {
 				logger.GetLogger().Println(err)
 			}
+
+			err = account.StoreDexAccounts(block.GetHeader().Height)
+			if err != nil {
+				logger.GetLogger().Println(err)
+			}
+
+			err = blocks.StoreStateDB(block.GetHeader().Height)
+			if err != nil {
+				logger.GetLogger().Println(err)
+			}
 			common.SetHeight(block.GetHeader().Height)
+			err = consensus.GetEngine().Finalize(block)
+			if err != nil {
+				logger.GetLogger().Println(err)
+			}
 
 			sm := statistics.GetStatsManager()
 			sm.UpdateStatistics(block, oldBlock)
@@ -539,6 +612,92 @@ func OnMessage(addr [4]byte, m []byte) {
 		eHeight := common.GetInt64FromByte(txn[[2]byte{'E', 'H'}][0])
 		logger.GetLogger().Printf("gh request: bHeight=%d, eHeight=%d, sending headers to %v", bHeight, eHeight, addr)
 		SendHeaders(addr, bHeight, eHeight)
+	case "gp":
+		SendPeers(addr)
+	case "px":
+		if !tcpip.AllowPeerExchange(addr) {
+			logger.GetLogger().Println("too frequent peer exchange from", addr)
+			return
+		}
+		txn := amsg.(message.TransactionsMessage).GetTransactionsBytes()
+		peers := txn[[2]byte{'P', 'P'}]
+		if len(peers) > tcpip.MaxPeersInExchange {
+			logger.GetLogger().Println("too many addresses in peer exchange from", addr)
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			peers = peers[:tcpip.MaxPeersInExchange]
+		}
+		addrs := []tcpip.PeerAddress{}
+		for _, pb := range peers {
+			peer, err := tcpip.PeerAddressFromBytes(pb)
+			if err != nil || tcpip.IsAddressBanned(peer) {
+				continue
+			}
+			addrs = append(addrs, peer)
+		}
+		tcpip.AddPeerAddresses(addrs)
+	case "hr", "gb":
+		bHeight, eHeight, ok := getRange(amsg.(message.TransactionsMessage).GetTransactionsBytes())
+		if !ok {
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			return
+		}
+		if eHeight > h {
+			eHeight = h
+		}
+		if bHeight > eHeight {
+			return
+		}
+		if string(amsg.GetHead()) == "hr" {
+			SendHeadersOnly(addr, bHeight, eHeight)
+		} else {
+			SendBlocks(addr, bHeight, eHeight)
+		}
+	case "hc", "sb":
+		if !IsHeadersFirstRunning() {
+			return
+		}
+		indices, blcks, err := getBlocksFromMessage(amsg.(message.TransactionsMessage).GetTransactionsBytes())
+		if err != nil {
+			logger.GetLogger().Println(err)
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			return
+		}
+		if string(amsg.GetHead()) == "hc" {
+			onHeaders(addr, indices, blcks)
+		} else {
+			onBlocks(addr, indices, blcks)
+		}
+	case "gs":
+		if !Send(addr, generateSyncMsgSnapshots()) {
+			logger.GetLogger().Println("could not send snapshots to", addr)
+		}
+	case "ss":
+		if !IsFastSyncRunning() {
+			return
+		}
+		onSnapshots(addr, amsg.(message.TransactionsMessage).GetTransactionsBytes()[[2]byte{'S', 'M'}])
+	case "gc":
+		height, index, ok := getChunkIndex(amsg.(message.TransactionsMessage).GetTransactionsBytes())
+		if !ok {
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			return
+		}
+		n := generateSyncMsgChunk(height, index)
+		if len(n) > 0 && !Send(addr, n) {
+			logger.GetLogger().Println("could not send snapshot chunk to", addr)
+		}
+	case "sc":
+		if !IsFastSyncRunning() {
+			return
+		}
+		txn := amsg.(message.TransactionsMessage).GetTransactionsBytes()
+		height, index, ok := getChunkIndex(txn)
+		chunk := txn[[2]byte{'C', 'V'}]
+		if !ok || len(chunk) != 1 {
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			return
+		}
+		onChunk(addr, height, index, chunk[0])
 	default:
 	}
 }
@@ -3,17 +3,18 @@ package syncServices
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/message"
-	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/tcpip"
 )
 
 func TestOnMessageInvalidInput(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	addr := [4]byte{192, 168, 1, 1}
+	addr := tcpip.NodeID{192, 168, 1, 1}
 
 	t.Run("empty message", func(t *testing.T) {
 		assert.NotPanics(t, func() {
@@ -42,7 +43,7 @@ func TestOnMessageRecovery(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	addr := [4]byte{10, 0, 0, 1}
+	addr := tcpip.NodeID{10, 0, 0, 1}
 
 	t.Run("recovers from malformed data", func(t *testing.T) {
 		malformedMsg := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
@@ -134,8 +135,8 @@ func TestAddressHandlingSync(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	addresses := [][4]byte{
-		{0, 0, 0, 0},
+	addresses := []tcpip.NodeID{
+		tcpip.AnyPeer,
 		{127, 0, 0, 1},
 		{192, 168, 1, 1},
 		{255, 255, 255, 255},
@@ -40,13 +40,45 @@ func generateSyncMsgHeight() []byte {
 	}
 	n.TransactionsBytes[[2]byte{'L', 'B'}] = [][]byte{lastBlockHash}
 
-	peers := tcpip.GetIPsConnected()
+	peers := tcpip.GetPeerAddressesConnected()
 
 	n.TransactionsBytes[[2]byte{'P', 'P'}] = peers
 	nb := n.GetBytes()
 	return nb
 }
 
+func generateSyncMsgGetPeers() []byte {
+	bm := message.BaseMessage{
+		Head:    []byte("gp"),
+		ChainID: common.GetChainID(),
+	}
+	n := message.TransactionsMessage{
+		BaseMessage:       bm,
+		TransactionsBytes: map[[2]byte][][]byte{},
+	}
+	return n.GetBytes()
+}
+
+func generateSyncMsgPeers() []byte {
+	bm := message.BaseMessage{
+		Head:    []byte("px"),
+		ChainID: common.GetChainID(),
+	}
+	n := message.TransactionsMessage{
+		BaseMessage:       bm,
+		TransactionsBytes: map[[2]byte][][]byte{},
+	}
+	n.TransactionsBytes[[2]byte{'P', 'P'}] = tcpip.GetPeerAddressesToShare()
+	return n.GetBytes()
+}
+
+// SendPeers answers peer exchange request with addresses from address book
+func SendPeers(addr tcpip.NodeID) {
+	if !Send(addr, generateSyncMsgPeers()) {
+		logger.GetLogger().Printf("SendPeers: could not send to %v", addr)
+	}
+}
+
 func generateSyncMsgGetHeaders(height int64) []byte {
 	if height <= 0 {
 		return nil
@@ -68,6 +100,10 @@ func generateSyncMsgGetHeaders(height int64) []byte {
 			eHeight = height
 		}
 	}
+	return generateSyncMsgGetHeadersRange(bHeight, eHeight)
+}
+
+func generateSyncMsgGetHeadersRange(bHeight int64, eHeight int64) []byte {
 	bm := message.BaseMessage{
 		Head:    []byte("gh"),
 		ChainID: common.GetChainID(),
@@ -83,6 +119,11 @@ func generateSyncMsgGetHeaders(height int64) []byte {
 }
 
 func generateSyncMsgSendHeaders(bHeight int64, height int64) []byte {
+	return generateSyncMsgBlocks("sh", bHeight, height, false)
+}
+
+// generateSyncMsgBlocks packs main chain blocks from bHeight to height, without transactions hashes when headersOnly
+func generateSyncMsgBlocks(head string, bHeight int64, height int64, headersOnly bool) []byte {
 	if height < 0 {
 		logger.GetLogger().Println("height cannot be smaller than 0")
 		return []byte{}
@@ -97,7 +138,7 @@ func generateSyncMsgSendHeaders(bHeight int64, height int64) []byte {
 		return []byte{}
 	}
 	bm := message.BaseMessage{
-		Head:    []byte("sh"),
+		Head:    []byte(head),
 		ChainID: common.GetChainID(),
 	}
 	n := message.TransactionsMessage{
@@ -110,9 +151,12 @@ func generateSyncMsgSendHeaders(bHeight int64, height int64) []byte {
 		indices = append(indices, common.GetByteInt64(i))
 		block, err := blocks.LoadBlock(i)
 		if err != nil {
-			logger.GetLogger().Printf("generateSyncMsgSendHeaders: failed to load block %d: %v", i, err)
+			logger.GetLogger().Printf("generateSyncMsgBlocks: failed to load block %d: %v", i, err)
 			return []byte{}
 		}
+		if headersOnly {
+			block = block.GetHeaderBlock()
+		}
 		blcks = append(blcks, block.GetBytes())
 	}
 	n.TransactionsBytes[[2]byte{'I', 'H'}] = indices
@@ -121,7 +165,7 @@ func generateSyncMsgSendHeaders(bHeight int64, height int64) []byte {
 	return nb
 }
 
-func SendHeaders(addr [4]byte, bHeight int64, height int64) {
+func SendHeaders(addr tcpip.NodeID, bHeight int64, height int64) {
 	n := generateSyncMsgSendHeaders(bHeight, height)
 	if len(n) == 0 {
 		return
@@ -131,7 +175,7 @@ func SendHeaders(addr [4]byte, bHeight int64, height int64) {
 	}
 }
 
-func SendGetHeaders(addr [4]byte, height int64) {
+func SendGetHeaders(addr tcpip.NodeID, height int64) {
 	n := generateSyncMsgGetHeaders(height)
 	if len(n) == 0 {
 		return
@@ -141,8 +185,15 @@ func SendGetHeaders(addr [4]byte, height int64) {
 	}
 }
 
-func Send(addr [4]byte, nb []byte) bool {
-	nb = append(addr[:], nb...)
+// SendGetHeadersRange asks peer for its blocks from bHeight to eHeight, ex. to find where other branch starts
+func SendGetHeadersRange(addr tcpip.NodeID, bHeight int64, eHeight int64) {
+	if !Send(addr, generateSyncMsgGetHeadersRange(bHeight, eHeight)) {
+		logger.GetLogger().Println("could not send get headers")
+	}
+}
+
+func Send(addr tcpip.NodeID, nb []byte) bool {
+	nb = tcpip.PackPeer(addr, nb)
 	if services.SendMutexSync.TryLock() {
 		defer services.SendMutexSync.Unlock()
 		select {
@@ -155,16 +206,26 @@ func Send(addr [4]byte, nb []byte) bool {
 	return false
 }
 
+// peersExchangeInterval is number of 'hi' messages between peer exchange requests
+const peersExchangeInterval = 60
+
 func sendSyncMsgInLoop() {
+	i := 0
 	for {
 		if len(tcpip.GetPeersConnected(tcpip.SyncTopic)) == 0 {
 			time.Sleep(3 * time.Second)
 			continue
 		}
 		n := generateSyncMsgHeight()
-		if !Send([4]byte{0, 0, 0, 0}, n) {
+		if !Send(tcpip.AnyPeer, n) {
 			logger.GetLogger().Println("could not send 'hi' message")
 		}
+		if i%peersExchangeInterval == 0 {
+			if !Send(tcpip.AnyPeer, generateSyncMsgGetPeers()) {
+				logger.GetLogger().Println("could not send 'gp' message")
+			}
+		}
+		i++
 		time.Sleep(time.Second)
 	}
 }
@@ -175,12 +236,11 @@ func startPublishingSyncMsg() {
 	go tcpip.LoopSend(services.SendChanSync, tcpip.SyncTopic)
 }
 
-func StartSubscribingSyncMsg(ip [4]byte) {
+func StartSubscribingSyncMsg(peer tcpip.PeerAddress) {
 
 	recvChan := make(chan []byte, 100) // Use a buffered channel
 	quit := false
-	var ipr [4]byte
-	go tcpip.StartNewConnection(ip, recvChan, tcpip.SyncTopic)
+	go tcpip.StartNewConnection(peer, recvChan, tcpip.SyncTopic)
 	for !services.QUIT.Load() && !quit {
 		select {
 		case s := <-recvChan:
@@ -188,9 +248,8 @@ func StartSubscribingSyncMsg(ip [4]byte) {
 				quit = true
 				break
 			}
-			if len(s) > 4 {
-				copy(ipr[:], s[:4])
-				OnMessage(ipr, s[4:])
+			if ipr, msg, ok := tcpip.UnpackPeer(s); ok {
+				OnMessage(ipr, msg)
 			}
 		case <-tcpip.Quit:
 			services.QUIT.Store(true)
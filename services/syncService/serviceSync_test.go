@@ -3,10 +3,11 @@ package syncServices
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/message"
-	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/tcpip"
 )
 
 func TestGenerateSyncMsgHeight(t *testing.T) {
@@ -77,7 +78,7 @@ func TestSendFunction(t *testing.T) {
 	defer logger.CloseLogger()
 
 	t.Run("send without initialization", func(t *testing.T) {
-		addr := [4]byte{192, 168, 1, 1}
+		addr := tcpip.NodeID{192, 168, 1, 1}
 		data := []byte("test data")
 
 		// Should not panic even without initialization
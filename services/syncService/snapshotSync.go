@@ -0,0 +1,401 @@
+package syncServices
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/message"
+	"github.com/wonabru/qwid-node/services"
+	"github.com/wonabru/qwid-node/tcpip"
+)
+
+// Fast sync is used by fresh node. Snapshots offered by peers are checked against header chain downloaded
+// by headers-first sync: block at snapshot height and root included SnapshotCommitDelay blocks later.
+// Header chain is trusted only up to checkpoint given in FAST_SYNC_CHECKPOINT, so snapshot has to be committed
+// at or below it. Chunks are downloaded from peers in parallel and when all of them match manifest state is restored
+// and headers-first sync continues from snapshot height.
+
+const (
+	maxChunkRequestsPerPeer = 2
+	chunkRequestTimeout     = 30 * time.Second
+	snapshotOfferTimeout    = 2 * time.Minute
+)
+
+type snapshotOffer struct {
+	manifest blocks.SnapshotManifest
+	root     common.Hash
+	peers    map[tcpip.NodeID]bool
+}
+
+var (
+	fastSyncMutex   sync.Mutex
+	fastSyncRunning atomic.Bool
+	fastSyncTried   atomic.Bool
+	snapshotOffers  = map[[common.HashLength]byte]*snapshotOffer{}
+	chosenSnapshot  *snapshotOffer
+	chunkRequests   = map[int]syncRequest{}
+	chunksDone      = map[int]bool{}
+)
+
+// fastSyncEnabled can be switched off by FAST_SYNC=0 in .env, then node replays all blocks from genesis
+func fastSyncEnabled() bool {
+	return os.Getenv("FAST_SYNC") != "0"
+}
+
+// fastSyncCheckpoint returns trusted block given as height:hash in FAST_SYNC_CHECKPOINT. Without it fast sync
+// is not used, as headers alone cannot prove which chain is supported by stake.
+func fastSyncCheckpoint() (int64, []byte, bool) {
+	cp := os.Getenv("FAST_SYNC_CHECKPOINT")
+	if cp == "" {
+		return 0, nil, false
+	}
+	parts := strings.Split(cp, ":")
+	if len(parts) != 2 {
+		logger.GetLogger().Println("FAST_SYNC_CHECKPOINT should be height:hash")
+		return 0, nil, false
+	}
+	height, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		logger.GetLogger().Println("wrong checkpoint height:", err)
+		return 0, nil, false
+	}
+	hash, err := hex.DecodeString(parts[1])
+	if err != nil || len(hash) != common.HashLength {
+		logger.GetLogger().Println("wrong checkpoint hash")
+		return 0, nil, false
+	}
+	return height, hash, true
+}
+
+// shouldFastSync tells if node is fresh, trusts checkpoint and network is far enough to have committed snapshot
+func shouldFastSync(h int64, height int64) bool {
+	cpHeight, _, ok := fastSyncCheckpoint()
+	return fastSyncEnabled() && ok && h == 0 && common.GetBaseHeight() == 0 &&
+		height >= common.SnapshotInterval+common.SnapshotCommitDelay &&
+		cpHeight >= common.SnapshotInterval+common.SnapshotCommitDelay
+}
+
+// startFastSync runs fast sync once, when it fails node syncs all blocks
+func startFastSync() {
+	if fastSyncTried.CompareAndSwap(false, true) {
+		fastSyncRunning.Store(true)
+		go runFastSync()
+	}
+}
+
+// IsFastSyncRunning informs if node waits for state snapshot
+func IsFastSyncRunning() bool {
+	return fastSyncRunning.Load()
+}
+
+func runFastSync() {
+	defer fastSyncRunning.Store(false)
+	logger.GetLogger().Println("fast sync started, looking for snapshots")
+	started := time.Now()
+	lastAsk := time.Time{}
+	lastReport := time.Now()
+	for !services.QUIT.Load() {
+		if common.GetHeight() > 0 {
+			logger.GetLogger().Println("fast sync stopped, blocks are applied")
+			return
+		}
+		fastSyncMutex.Lock()
+		chosen := chosenSnapshot
+		fastSyncMutex.Unlock()
+		if chosen == nil {
+			if time.Since(started) > snapshotOfferTimeout {
+				logger.GetLogger().Println("fast sync stopped, no snapshot matching header chain")
+				return
+			}
+			if time.Since(lastAsk) > 5*time.Second {
+				Send(tcpip.AnyPeer, generateSyncMsgGetSnapshots())
+				lastAsk = time.Now()
+			}
+			chooseSnapshot()
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		if requestChunks() {
+			err := restoreSnapshot(chosen)
+			if err != nil {
+				logger.GetLogger().Println("cannot restore snapshot:", err)
+				return
+			}
+			return
+		}
+		if time.Since(lastReport) > progressInterval {
+			fastSyncMutex.Lock()
+			logger.GetLogger().Printf("fast sync: %d of %d chunks of snapshot at height %d", len(chunksDone), len(chosen.manifest.ChunkHashes), chosen.manifest.Height)
+			fastSyncMutex.Unlock()
+			lastReport = time.Now()
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// verifyOffer checks snapshot against headers downloaded by headers-first sync. Root has to be committed
+// by header linked to trusted checkpoint.
+func verifyOffer(o *snapshotOffer) bool {
+	cpHeight, cpHash, ok := fastSyncCheckpoint()
+	if !ok || o.manifest.Height+common.SnapshotCommitDelay > cpHeight {
+		return false
+	}
+	headersFirstMutex.Lock()
+	tip := headerTip
+	headersFirstMutex.Unlock()
+	if tip < cpHeight {
+		return false
+	}
+	checkpoint, err := blocks.LoadSyncHeader(cpHeight)
+	if err != nil || !bytes.Equal(checkpoint.BlockHash.GetBytes(), cpHash) {
+		return false
+	}
+	header, err := blocks.LoadSyncHeader(o.manifest.Height)
+	if err != nil || !bytes.Equal(header.BlockHash.GetBytes(), o.manifest.BlockHash.GetBytes()) {
+		return false
+	}
+	commit, err := blocks.LoadSyncHeader(o.manifest.Height + common.SnapshotCommitDelay)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(commit.BaseBlock.SnapshotRoot.GetBytes(), o.root.GetBytes())
+}
+
+// chooseSnapshot picks the highest snapshot which header chain commits to
+func chooseSnapshot() {
+	fastSyncMutex.Lock()
+	defer fastSyncMutex.Unlock()
+	var best *snapshotOffer
+	for _, o := range snapshotOffers {
+		if (best == nil || o.manifest.Height > best.manifest.Height) && verifyOffer(o) {
+			best = o
+		}
+	}
+	if best == nil {
+		return
+	}
+	chosenSnapshot = best
+	// chunks stored before restart are not downloaded again
+	for i, h := range best.manifest.ChunkHashes {
+		c, err := blocks.LoadSnapshotChunk(best.manifest.Height, i)
+		if err != nil {
+			continue
+		}
+		ch, err := common.CalcHashFromBytes(c)
+		if err == nil && bytes.Equal(ch.GetBytes(), h.GetBytes()) {
+			chunksDone[i] = true
+		}
+	}
+	logger.GetLogger().Printf("fast sync from snapshot at height %d, %d chunks, %d peers", best.manifest.Height, len(best.manifest.ChunkHashes), len(best.peers))
+}
+
+// requestChunks asks peers for missing chunks, returns true when all chunks are downloaded
+func requestChunks() bool {
+	fastSyncMutex.Lock()
+	defer fastSyncMutex.Unlock()
+	m := chosenSnapshot.manifest
+	if len(chunksDone) == len(m.ChunkHashes) {
+		return true
+	}
+	busy := map[tcpip.NodeID]int{}
+	for i, r := range chunkRequests {
+		if time.Since(r.sent) > chunkRequestTimeout || chunksDone[i] {
+			delete(chunkRequests, i)
+			continue
+		}
+		busy[r.peer]++
+	}
+	for i := range m.ChunkHashes {
+		if _, ok := chunkRequests[i]; ok || chunksDone[i] {
+			continue
+		}
+		for peer := range chosenSnapshot.peers {
+			if busy[peer] >= maxChunkRequestsPerPeer {
+				continue
+			}
+			if Send(peer, generateSyncMsgGetChunk(m.Height, i)) {
+				chunkRequests[i] = syncRequest{peer: peer, sent: time.Now()}
+				busy[peer]++
+			}
+			break
+		}
+	}
+	return false
+}
+
+func restoreSnapshot(o *snapshotOffer) error {
+	m := o.manifest
+	chunks := make([][]byte, len(m.ChunkHashes))
+	for i := range chunks {
+		c, err := blocks.LoadSnapshotChunk(m.Height, i)
+		if err != nil {
+			return err
+		}
+		chunks[i] = c
+	}
+	common.BlockMutex.Lock()
+	defer common.BlockMutex.Unlock()
+	if common.GetHeight() > 0 {
+		return nil
+	}
+	err := blocks.RestoreSnapshot(m, chunks)
+	if err != nil {
+		return err
+	}
+	err = blocks.StoreSnapshotManifest(m)
+	if err != nil {
+		logger.GetLogger().Println(err)
+	}
+	for i := int64(1); i <= m.Height; i++ {
+		err = blocks.RemoveSyncHeader(i)
+		if err != nil {
+			logger.GetLogger().Println(err)
+		}
+	}
+	logger.GetLogger().Printf("state restored from snapshot at height %d, root %x", m.Height, o.root.GetBytes())
+	return nil
+}
+
+// onSnapshots keeps snapshots offered by peer
+func onSnapshots(addr tcpip.NodeID, manifests [][]byte) {
+	fastSyncMutex.Lock()
+	defer fastSyncMutex.Unlock()
+	for _, mb := range manifests {
+		m, err := blocks.SnapshotManifest{}.GetFromBytes(mb)
+		if err != nil {
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			return
+		}
+		_, err = m.Check()
+		if err != nil {
+			logger.GetLogger().Println("wrong snapshot offered:", err)
+			tcpip.ReduceAndCheckIfBanPeer(addr)
+			return
+		}
+		root, err := m.Root()
+		if err != nil {
+			return
+		}
+		key := [common.HashLength]byte{}
+		copy(key[:], root.GetBytes())
+		o, ok := snapshotOffers[key]
+		if !ok {
+			o = &snapshotOffer{manifest: m, root: root, peers: map[tcpip.NodeID]bool{}}
+			snapshotOffers[key] = o
+		}
+		o.peers[addr] = true
+	}
+}
+
+// onChunk stores chunk of chosen snapshot if its hash matches manifest
+func onChunk(addr tcpip.NodeID, height int64, index int, chunk []byte) {
+	fastSyncMutex.Lock()
+	defer fastSyncMutex.Unlock()
+	if chosenSnapshot == nil || chosenSnapshot.manifest.Height != height ||
+		index < 0 || index >= len(chosenSnapshot.manifest.ChunkHashes) || chunksDone[index] {
+		return
+	}
+	delete(chunkRequests, index)
+	h, err := common.CalcHashFromBytes(chunk)
+	if err != nil || !bytes.Equal(h.GetBytes(), chosenSnapshot.manifest.ChunkHashes[index].GetBytes()) {
+		logger.GetLogger().Println("snapshot chunk does not match manifest, from", addr)
+		tcpip.ReduceAndCheckIfBanPeer(addr)
+		delete(chosenSnapshot.peers, addr)
+		return
+	}
+	err = blocks.StoreSnapshotChunk(height, index, chunk)
+	if err != nil {
+		logger.GetLogger().Println(err)
+		return
+	}
+	chunksDone[index] = true
+}
+
+func generateSyncMsgGetSnapshots() []byte {
+	bm := message.BaseMessage{
+		Head:    []byte("gs"),
+		ChainID: common.GetChainID(),
+	}
+	n := message.TransactionsMessage{
+		BaseMessage:       bm,
+		TransactionsBytes: map[[2]byte][][]byte{},
+	}
+	return n.GetBytes()
+}
+
+// generateSyncMsgSnapshots offers snapshots of main chain which roots are already included in blocks
+func generateSyncMsgSnapshots() []byte {
+	bm := message.BaseMessage{
+		Head:    []byte("ss"),
+		ChainID: common.GetChainID(),
+	}
+	n := message.TransactionsMessage{
+		BaseMessage:       bm,
+		TransactionsBytes: map[[2]byte][][]byte{},
+	}
+	h := common.GetHeight()
+	ms := [][]byte{}
+	for _, m := range blocks.LoadSnapshotManifests() {
+		if m.Height+common.SnapshotCommitDelay <= h && blocks.IsOnMainChain(m.BlockHash.GetBytes(), m.Height) {
+			ms = append(ms, m.GetBytes())
+		}
+	}
+	n.TransactionsBytes[[2]byte{'S', 'M'}] = ms
+	return n.GetBytes()
+}
+
+func generateSyncMsgGetChunk(height int64, index int) []byte {
+	bm := message.BaseMessage{
+		Head:    []byte("gc"),
+		ChainID: common.GetChainID(),
+	}
+	n := message.TransactionsMessage{
+		BaseMessage:       bm,
+		TransactionsBytes: map[[2]byte][][]byte{},
+	}
+	n.TransactionsBytes[[2]byte{'S', 'H'}] = [][]byte{common.GetByteInt64(height)}
+	n.TransactionsBytes[[2]byte{'C', 'I'}] = [][]byte{common.GetByteInt64(int64(index))}
+	return n.GetBytes()
+}
+
+func generateSyncMsgChunk(height int64, index int) []byte {
+	_, err := blocks.LoadSnapshotManifest(height)
+	if err != nil {
+		return []byte{}
+	}
+	chunk, err := blocks.LoadSnapshotChunk(height, index)
+	if err != nil {
+		return []byte{}
+	}
+	bm := message.BaseMessage{
+		Head:    []byte("sc"),
+		ChainID: common.GetChainID(),
+	}
+	n := message.TransactionsMessage{
+		BaseMessage:       bm,
+		TransactionsBytes: map[[2]byte][][]byte{},
+	}
+	n.TransactionsBytes[[2]byte{'S', 'H'}] = [][]byte{common.GetByteInt64(height)}
+	n.TransactionsBytes[[2]byte{'C', 'I'}] = [][]byte{common.GetByteInt64(int64(index))}
+	n.TransactionsBytes[[2]byte{'C', 'V'}] = [][]byte{chunk}
+	return n.GetBytes()
+}
+
+// getChunkIndex parses height and index of snapshot chunk
+func getChunkIndex(txn map[[2]byte][][]byte) (int64, int, bool) {
+	sh, ok1 := txn[[2]byte{'S', 'H'}]
+	ci, ok2 := txn[[2]byte{'C', 'I'}]
+	if !ok1 || !ok2 || len(sh) == 0 || len(ci) == 0 || len(sh[0]) != 8 || len(ci[0]) != 8 {
+		return 0, 0, false
+	}
+	return common.GetInt64FromByte(sh[0]), int(common.GetInt64FromByte(ci[0])), true
+}
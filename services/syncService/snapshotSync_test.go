@@ -0,0 +1,27 @@
+package syncServices
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+func TestFastSyncCheckpoint(t *testing.T) {
+	logger.InitLogger()
+	defer logger.CloseLogger()
+
+	hash := strings.Repeat("ab", 32)
+	t.Setenv("FAST_SYNC_CHECKPOINT", "1200:"+hash)
+	height, h, ok := fastSyncCheckpoint()
+	assert.True(t, ok)
+	assert.Equal(t, int64(1200), height)
+	assert.Len(t, h, 32)
+
+	for _, cp := range []string{"", "1200", "x:" + hash, "1200:abcd"} {
+		t.Setenv("FAST_SYNC_CHECKPOINT", cp)
+		_, _, ok = fastSyncCheckpoint()
+		assert.False(t, ok)
+	}
+}
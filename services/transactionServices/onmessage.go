@@ -5,12 +5,13 @@ import (
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/message"
+	"github.com/wonabru/qwid-node/services"
 	"github.com/wonabru/qwid-node/tcpip"
 	"github.com/wonabru/qwid-node/transactionsDefinition"
 	"github.com/wonabru/qwid-node/transactionsPool"
 )
 
-func OnMessage(addr [4]byte, m []byte) {
+func OnMessage(addr tcpip.NodeID, m []byte) {
 
 	//logger.GetLogger().Println("New message nonce from:", addr)
 
@@ -25,7 +26,7 @@ func OnMessage(addr [4]byte, m []byte) {
 	isValid, amsg := message.CheckValidMessage(m)
 	if isValid == false {
 		logger.GetLogger().Println("transaction msg validation fails")
-		tcpip.ReduceAndCheckIfBanIP(addr)
+		tcpip.ReduceAndCheckIfBanPeer(addr)
 		return
 	}
 
@@ -79,7 +80,7 @@ func OnMessage(addr [4]byte, m []byte) {
 				// } else if senderExist && senderAcc.MultiSignNumber > 0 {
 				// 	isAdded = transactionsPool.PoolTxMultiSign.AddTransaction(t, t.Hash)
 				// } else {
-					// Reject non-staking transactions to delegated accounts
+				// Reject non-staking transactions to delegated accounts
 				if n, err := account.IntDelegatedAccountFromAddress(t.TxData.Recipient); err == nil && n > 0 && n < 256 {
 					if t.TxData.Amount > 0 && t.TxData.Amount < common.MinStakingUser && t.GetLockedAmount() == 0 {
 						logger.GetLogger().Println("Rejected: transfer to delegated account below minimum staking amount")
@@ -107,10 +108,11 @@ func OnMessage(addr [4]byte, m []byte) {
 					// }
 					// Always broadcast local transactions (from RPC/wallet with addr 0.0.0.0)
 					// For remote transactions, only broadcast if not syncing
-					isLocalTx := addr == [4]byte{0, 0, 0, 0}
+					isLocalTx := addr == tcpip.AnyPeer
 					if isLocalTx { // || !common.IsSyncing.Load() {
 						BroadcastTxn(addr, m)
 					}
+					services.RequestDevSeal()
 				}
 			}
 		}
@@ -175,7 +177,7 @@ func OnMessage(addr [4]byte, m []byte) {
 			if !Send(addr, transactionMsg.GetBytes()) {
 				logger.GetLogger().Println("could not send transaction in sync")
 			}
-			logger.GetLogger().Println("SENT transaction is sync st to ", addr[:])
+			logger.GetLogger().Println("SENT transaction is sync st to ", addr)
 		}
 	case "bt":
 		txn := amsg.(message.TransactionsMessage).GetTransactionsBytes()
@@ -217,7 +219,7 @@ func OnMessage(addr [4]byte, m []byte) {
 			if !Send(addr, transactionMsg.GetBytes()) {
 				logger.GetLogger().Println("could not send transaction is sync bt - Send failed")
 			} else {
-				logger.GetLogger().Println("SENT transaction is sync bt to ", addr[:], "count:", len(txs))
+				logger.GetLogger().Println("SENT transaction is sync bt to ", addr, "count:", len(txs))
 			}
 		}
 	default:
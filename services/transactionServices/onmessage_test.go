@@ -3,17 +3,18 @@ package transactionServices
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
 	"github.com/wonabru/qwid-node/message"
-	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/tcpip"
 )
 
 func TestOnMessageInvalidMessage(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	addr := [4]byte{192, 168, 1, 1}
+	addr := tcpip.NodeID{192, 168, 1, 1}
 
 	t.Run("empty message", func(t *testing.T) {
 		// Should not panic with empty message
@@ -45,7 +46,7 @@ func TestOnMessageRecovery(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	addr := [4]byte{10, 0, 0, 1}
+	addr := tcpip.NodeID{10, 0, 0, 1}
 
 	t.Run("recovers from malformed data", func(t *testing.T) {
 		// Malformed message should trigger recovery
@@ -131,8 +132,8 @@ func TestAddressHandling(t *testing.T) {
 	logger.InitLogger()
 	defer logger.CloseLogger()
 
-	addresses := [][4]byte{
-		{0, 0, 0, 0},
+	addresses := []tcpip.NodeID{
+		tcpip.AnyPeer,
 		{127, 0, 0, 1},
 		{192, 168, 1, 1},
 		{255, 255, 255, 255},
@@ -73,10 +73,8 @@ func broadcastTransactionsMsgInLoop() {
 			if err == nil {
 				peers := tcpip.GetPeersConnected(tcpip.TransactionTopic)
 				for topicip := range peers {
-					var ip [4]byte
-					copy(ip[:], topicip[2:])
-					if !bytes.Equal(ip[:], tcpip.MyIP[:]) {
-						Send(ip, n.GetBytes())
+					if topicip.ID != tcpip.MyNodeID() {
+						Send(topicip.ID, n.GetBytes())
 					}
 				}
 			}
@@ -86,7 +84,7 @@ func broadcastTransactionsMsgInLoop() {
 	}
 }
 
-func SendTransactionMsg(ip [4]byte, topic [2]byte) bool {
+func SendTransactionMsg(ip tcpip.NodeID, topic [2]byte) bool {
 	isync := common.IsSyncing.Load()
 	if isync == true {
 		return true
@@ -104,7 +102,7 @@ func SendTransactionMsg(ip [4]byte, topic [2]byte) bool {
 	return true
 }
 
-func SendGT(ip [4]byte, txsHashes [][]byte, syncPre string) {
+func SendGT(ip tcpip.NodeID, txsHashes [][]byte, syncPre string) {
 	topic := tcpip.TransactionTopic
 	transactionMsg, err := GenerateTransactionMsgGT(txsHashes, []byte(syncPre), topic)
 	if err != nil {
@@ -115,9 +113,9 @@ func SendGT(ip [4]byte, txsHashes [][]byte, syncPre string) {
 	}
 }
 
-func Send(addr [4]byte, nb []byte) bool {
+func Send(addr tcpip.NodeID, nb []byte) bool {
 
-	nb = append(addr[:], nb...)
+	nb = tcpip.PackPeer(addr, nb)
 	if services.SendMutexTx.TryLock() {
 		defer services.SendMutexTx.Unlock()
 		select {
@@ -130,8 +128,7 @@ func Send(addr [4]byte, nb []byte) bool {
 	return false
 }
 
-func BroadcastTxn(ignoreAddr [4]byte, nb []byte) {
-	var ip [4]byte
+func BroadcastTxn(ignoreAddr tcpip.NodeID, nb []byte) {
 	var peers = tcpip.GetPeersConnected(tcpip.TransactionTopic)
 	num_peers := len(peers)
 	if num_peers == 0 {
@@ -140,8 +137,8 @@ func BroadcastTxn(ignoreAddr [4]byte, nb []byte) {
 	for topicip := range peers {
 		// Send to all peers to ensure transactions reach mining nodes
 		// Previously was randomly selecting ~1 peer which caused transactions to not propagate properly
-		copy(ip[:], topicip[2:])
-		if !bytes.Equal(ip[:], ignoreAddr[:]) && !bytes.Equal(ip[:], tcpip.MyIP[:]) {
+		ip := topicip.ID
+		if ip != ignoreAddr && ip != tcpip.MyNodeID() {
 			//logger.GetLogger().Println("send transactions to ", int(ip[0]), int(ip[1]), int(ip[2]), int(ip[3]))
 			if !Send(ip, nb) {
 				logger.GetLogger().Println("could not broadcast transaction")
@@ -155,11 +152,10 @@ func startPublishingTransactionMsg() {
 	go tcpip.LoopSend(services.SendChanTx, tcpip.TransactionTopic)
 }
 
-func StartSubscribingTransactionMsg(ip [4]byte) {
+func StartSubscribingTransactionMsg(peer tcpip.PeerAddress) {
 	recvChan := make(chan []byte, 100) // Increased buffer size
 	quit := false
-	var ipr [4]byte
-	go tcpip.StartNewConnection(ip, recvChan, tcpip.TransactionTopic)
+	go tcpip.StartNewConnection(peer, recvChan, tcpip.TransactionTopic)
 	for !services.QUIT.Load() && !quit {
 		select {
 		case s := <-recvChan:
@@ -167,12 +163,11 @@ func StartSubscribingTransactionMsg(ip [4]byte) {
 				quit = true
 				break
 			}
-			if len(s) > 4 {
-				copy(ipr[:], s[:4])
-				OnMessage(ipr, s[4:])
+			if ipr, msg, ok := tcpip.UnpackPeer(s); ok {
+				OnMessage(ipr, msg)
 			}
 		case <-tcpip.Quit:
-			logger.GetLogger().Printf("Received quit signal for peer %v", ip)
+			logger.GetLogger().Printf("Received quit signal for peer %v", peer)
 			services.QUIT.Store(true)
 		default:
 			time.Sleep(time.Millisecond * 100) // Reduced sleep time
@@ -0,0 +1,74 @@
+package transactionServices
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+
+	"github.com/wonabru/qwid-node/blocks"
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/tcpip"
+	"github.com/wonabru/qwid-node/transactionsDefinition"
+	"github.com/wonabru/qwid-node/wallet"
+)
+
+var (
+	reportedMutex sync.Mutex
+	reported      = map[string]bool{}
+)
+
+// ReportEquivocation sends evidence to slashing account, fee is paid by wallet of node, which gets part of slashed stake.
+// Every offence is reported once by node.
+func ReportEquivocation(e blocks.Evidence) {
+	offender := e.GetOffender()
+	w := wallet.GetActiveWallet()
+	if bytes.Equal(w.MainAddress.GetBytes(), offender.GetBytes()) {
+		return
+	}
+	key := string(append(offender.GetBytes(), common.GetByteInt64(e.GetHeight())...))
+	reportedMutex.Lock()
+	if reported[key] {
+		reportedMutex.Unlock()
+		return
+	}
+	reported[key] = true
+	reportedMutex.Unlock()
+
+	h := common.GetHeight()
+	tx := transactionsDefinition.Transaction{
+		TxData: transactionsDefinition.TxData{
+			Recipient: common.GetDelegatedAccountAddress(common.SlashingAccountID),
+			Amount:    0,
+			OptData:   e.GetBytes(),
+		},
+		TxParam: transactionsDefinition.TxParam{
+			ChainID:     common.GetChainID(),
+			Sender:      w.MainAddress,
+			SendingTime: common.GetCurrentTimeStampInSecond(),
+			Nonce:       int16(rand.Intn(0xffff)),
+		},
+		Hash:      common.Hash{},
+		Signature: common.Signature{},
+		Height:    h,
+		GasPrice:  1,
+	}
+	tx.GasUsage = tx.GasUsageEstimate()
+	err := tx.CalcHashAndSet()
+	if err != nil {
+		logger.GetLogger().Println("cannot report equivocation:", err)
+		return
+	}
+	err = tx.Sign(w, common.GetNodeSignPrimary(h))
+	if err != nil {
+		logger.GetLogger().Println("cannot report equivocation:", err)
+		return
+	}
+	msg, err := GenerateTransactionMsg([]transactionsDefinition.Transaction{tx}, []byte("tx"), [2]byte{'T', 'T'})
+	if err != nil {
+		logger.GetLogger().Println("cannot report equivocation:", err)
+		return
+	}
+	logger.GetLogger().Println("operator", offender.GetHex(), "equivocated at height", e.GetHeight(), ", evidence sent in transaction", tx.Hash.GetHex())
+	OnMessage(tcpip.AnyPeer, msg.GetBytes())
+}
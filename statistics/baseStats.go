@@ -24,6 +24,10 @@ type Stats struct {
 	Tps                     float32 `json:"tps"`
 	Syncing                 bool    `json:"syncing"`
 	Difficulty              int32   `json:"difficulty"`
+	FinalizedHeight         int64   `json:"finalizedHeight"`
+	SyncTargetHeight        int64   `json:"syncTargetHeight"`
+	SyncHeadersHeight       int64   `json:"syncHeadersHeight"`
+	SyncBlocksWaiting       int     `json:"syncBlocksWaiting"`
 	PriceOracle             float32 `json:"priceOracle"`
 	RandOracle              int64   `json:"randOracle"`
 	db                      *database.BlockchainDB
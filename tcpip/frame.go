@@ -0,0 +1,204 @@
+package tcpip
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+
+	"github.com/wonabru/qwid-node/common"
+)
+
+// frame versions negotiated in handshake
+const (
+	FrameVersionLegacy         byte = 1 // payload terminated by <-END-> marker
+	FrameVersionLengthPrefixed byte = 2 // header with payload length and checksum
+)
+
+// SupportedFrameVersions are offered to peers in handshake, the highest common one is used
+var SupportedFrameVersions = []byte{FrameVersionLegacy, FrameVersionLengthPrefixed}
+
+const (
+	// magic(4) version(1) topic(2) flags(1) length(4) checksum(4)
+	frameHeaderLength             = 16
+	frameAuthenticatedLength      = 8 // magic, version, topic and flags are authenticated by AEAD
+	frameFlagCompressed      byte = 1
+	compressionThreshold          = 4096
+	frameReadTimeout              = 30 * time.Second
+	framePayloadReadTimeout       = 2 * time.Minute
+)
+
+var errFrameTimeout = errors.New("no frame received before timeout")
+
+type frameHeader struct {
+	Version  byte
+	Topic    [2]byte
+	Flags    byte
+	Length   uint32
+	Checksum uint32
+}
+
+func (h frameHeader) Marshal() []byte {
+	b := make([]byte, frameHeaderLength)
+	copy(b[:4], common.MessageInitialization[:])
+	b[4] = h.Version
+	copy(b[5:7], h.Topic[:])
+	b[7] = h.Flags
+	binary.LittleEndian.PutUint32(b[8:12], h.Length)
+	binary.LittleEndian.PutUint32(b[12:16], h.Checksum)
+	return b
+}
+
+func (h *frameHeader) Unmarshal(b []byte) error {
+	if len(b) != frameHeaderLength {
+		return fmt.Errorf("wrong frame header length %v", len(b))
+	}
+	if !bytes.Equal(b[:4], common.MessageInitialization[:]) {
+		return fmt.Errorf("wrong MessageInitialization %v", b[:4])
+	}
+	h.Version = b[4]
+	if h.Version != FrameVersionLengthPrefixed {
+		return fmt.Errorf("unsupported frame version %v", h.Version)
+	}
+	copy(h.Topic[:], b[5:7])
+	h.Flags = b[7]
+	h.Length = binary.LittleEndian.Uint32(b[8:12])
+	h.Checksum = binary.LittleEndian.Uint32(b[12:16])
+	if h.Length > uint32(common.MaxMessageSizeBytes) {
+		return fmt.Errorf("too long frame %v", h.Length)
+	}
+	return nil
+}
+
+// NegotiateFrameVersion returns the highest version supported by both sides
+func NegotiateFrameVersion(offered []byte) (byte, error) {
+	best := byte(0)
+	for _, v := range offered {
+		for _, s := range SupportedFrameVersions {
+			if v == s && v > best {
+				best = v
+			}
+		}
+	}
+	if best == 0 {
+		return 0, fmt.Errorf("no common frame version in %v", offered)
+	}
+	return best, nil
+}
+
+func compressPayload(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressPayload(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(common.MaxMessageSizeBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > int(common.MaxMessageSizeBytes) {
+		return nil, fmt.Errorf("decompressed frame exceeds %v bytes", common.MaxMessageSizeBytes)
+	}
+	return out, nil
+}
+
+// sealLengthPrefixedFrame compresses larger messages, encrypts them and puts header in front.
+// Needs sendMutex held, so frames are written in the order of nonces.
+func (s *secureSession) sealLengthPrefixedFrame(message []byte) ([]byte, error) {
+	h := frameHeader{Version: FrameVersionLengthPrefixed, Topic: s.topic}
+	if len(message) > compressionThreshold {
+		compressed, err := compressPayload(message)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(message) {
+			message = compressed
+			h.Flags |= frameFlagCompressed
+		}
+	}
+	ad := h.Marshal()[:frameAuthenticatedLength]
+	payload := s.sealWithData(message, ad)
+	h.Length = uint32(len(payload))
+	h.Checksum = crc32.ChecksumIEEE(payload)
+	return append(h.Marshal(), payload...), nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// readPayload reads n bytes of frame payload. Length in header is not authenticated until payload is opened,
+// so buffer starts small and grows only with data which really arrived.
+func readPayload(r io.Reader, n uint32) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, min(n, maxHandshakeMessageLen)))
+	_, err := io.CopyN(buf, r, int64(n))
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReceiveFrame reads one length prefixed frame from connection and returns decrypted message.
+// errFrameTimeout is returned when nothing arrived, io.EOF when peer closed connection.
+func ReceiveFrame(topic [2]byte, conn *net.TCPConn) ([]byte, error) {
+	s := getSession(conn)
+	if s == nil {
+		return nil, fmt.Errorf("no secure session with %v", conn.RemoteAddr())
+	}
+	conn.SetReadDeadline(time.Now().Add(frameReadTimeout))
+	hb := make([]byte, frameHeaderLength)
+	n, err := io.ReadFull(conn, hb)
+	if err != nil {
+		if n == 0 && isTimeout(err) {
+			return nil, errFrameTimeout
+		}
+		return nil, err
+	}
+	h := frameHeader{}
+	err = h.Unmarshal(hb)
+	if err != nil {
+		return nil, err
+	}
+	if h.Topic != topic {
+		return nil, fmt.Errorf("frame of topic %v received on topic %v", h.Topic, topic)
+	}
+	conn.SetReadDeadline(time.Now().Add(framePayloadReadTimeout))
+	payload, err := readPayload(conn, h.Length)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != h.Checksum {
+		return nil, fmt.Errorf("wrong frame checksum")
+	}
+	message, err := s.openWithData(payload, hb[:frameAuthenticatedLength])
+	if err != nil {
+		return nil, err
+	}
+	if h.Flags&frameFlagCompressed != 0 {
+		return decompressPayload(message)
+	}
+	return message, nil
+}
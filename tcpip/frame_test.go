@@ -0,0 +1,98 @@
+package tcpip
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+)
+
+func TestNegotiateFrameVersion(t *testing.T) {
+	v, err := NegotiateFrameVersion([]byte{FrameVersionLegacy, FrameVersionLengthPrefixed, 7})
+	assert.NoError(t, err)
+	assert.Equal(t, FrameVersionLengthPrefixed, v)
+
+	v, err = NegotiateFrameVersion([]byte{FrameVersionLegacy})
+	assert.NoError(t, err)
+	assert.Equal(t, FrameVersionLegacy, v)
+
+	_, err = NegotiateFrameVersion([]byte{9})
+	assert.Error(t, err)
+}
+
+func TestFrameHeaderMarshalUnmarshal(t *testing.T) {
+	h := frameHeader{Version: FrameVersionLengthPrefixed, Topic: SyncTopic, Flags: frameFlagCompressed, Length: 1234, Checksum: 99}
+	h2 := frameHeader{}
+	assert.NoError(t, h2.Unmarshal(h.Marshal()))
+	assert.Equal(t, h, h2)
+
+	b := h.Marshal()
+	b[4] = FrameVersionLegacy
+	assert.Error(t, h2.Unmarshal(b))
+
+	h.Length = uint32(common.MaxMessageSizeBytes) + 1
+	assert.Error(t, h2.Unmarshal(h.Marshal()))
+}
+
+func TestLengthPrefixedFrames(t *testing.T) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan *net.TCPConn)
+	go func() {
+		c, _ := listener.AcceptTCP()
+		accepted <- c
+	}()
+	client, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	assert.NoError(t, err)
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	secret := []byte("shared secret established by kem")
+	sender, err := newSessionFromSecret(secret, []byte("transcript"), false, common.Address{})
+	assert.NoError(t, err)
+	receiver, err := newSessionFromSecret(secret, []byte("transcript"), true, common.Address{})
+	assert.NoError(t, err)
+	for _, s := range []*secureSession{sender, receiver} {
+		s.topic = NonceTopic
+		s.frameVersion = FrameVersionLengthPrefixed
+	}
+	setSession(server, sender)
+	setSession(client, receiver)
+	defer removeSession(server)
+	defer removeSession(client)
+
+	// payloads contain old end marker and large one is compressed
+	messages := [][]byte{
+		[]byte("small<-END->payload"),
+		bytes.Repeat([]byte("<-END->"), 10000),
+	}
+	for _, m := range messages {
+		assert.NoError(t, Send(server, m))
+	}
+	for _, m := range messages {
+		got, err := ReceiveFrame(NonceTopic, client)
+		assert.NoError(t, err)
+		assert.Equal(t, m, got)
+	}
+
+	assert.NoError(t, Send(server, []byte("other topic")))
+	_, err = ReceiveFrame(SyncTopic, client)
+	assert.Error(t, err)
+}
+
+func TestReadPayload(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, 100)
+	got, err := readPayload(bytes.NewReader(data), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// header announcing large payload does not allocate it before data arrives
+	got, err = readPayload(bytes.NewReader(data), uint32(common.MaxMessageSizeBytes))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Nil(t, got)
+}
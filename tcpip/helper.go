@@ -1,9 +1,8 @@
 package tcpip
 
 import (
-	"bytes"
 	"context"
-	"fmt"
+	"net/netip"
 	"sync"
 	"time"
 
@@ -11,68 +10,127 @@ import (
 	"github.com/wonabru/qwid-node/logger"
 )
 
-var bannedIP map[[4]byte]int64
+var bannedPeers map[NodeID]int64
+var unreachablePeers map[PeerAddress]int64
 var bannedIPMutex sync.RWMutex
-var whiteListIPs map[[4]byte]bool
+var whiteListIPs map[netip.Addr]bool
+
+// peerHints keeps last address where node was reached
+var peerHints = map[NodeID]PeerAddress{}
+var peerHintsMutex sync.RWMutex
 
 func init() {
-	bannedIP = map[[4]byte]int64{}
-	whiteListIPs = map[[4]byte]bool{}
+	bannedPeers = map[NodeID]int64{}
+	unreachablePeers = map[PeerAddress]int64{}
+	whiteListIPs = map[netip.Addr]bool{}
+}
+
+func AddWhiteListIPs(ip netip.Addr) {
+	whiteListIPs[ip.Unmap()] = true
+}
+
+func setPeerHint(id NodeID, addr PeerAddress) {
+	peerHintsMutex.Lock()
+	defer peerHintsMutex.Unlock()
+	peerHints[id] = addr
+}
+
+// GetPeerHint returns last known address of node
+func GetPeerHint(id NodeID) (PeerAddress, bool) {
+	peerHintsMutex.RLock()
+	defer peerHintsMutex.RUnlock()
+	addr, ok := peerHints[id]
+	return addr, ok
 }
 
-func AddWhiteListIPs(ip [4]byte) {
-	whiteListIPs[ip] = true
+// isWhiteListed checks if node is this node or was reached from whitelisted IP
+func isWhiteListed(id NodeID) bool {
+	if id == AnyPeer || id == MyNodeID() {
+		return true
+	}
+	addr, ok := GetPeerHint(id)
+	return ok && whiteListIPs[addr.IP]
 }
 
-func IsIPBanned(ip [4]byte) bool {
+func IsPeerBanned(id NodeID) bool {
+	if isWhiteListed(id) {
+		return false
+	}
 	bannedIPMutex.RLock()
 	defer bannedIPMutex.RUnlock()
-	for wip, _ := range whiteListIPs {
-		if bytes.Equal(wip[:], ip[:]) {
-			return false
+	if hbanned, ok := bannedPeers[id]; ok {
+		if hbanned > common.GetCurrentTimeStampInSecond() {
+			return true
 		}
 	}
-	if hbanned, ok := bannedIP[ip]; ok {
-		if hbanned > common.GetCurrentTimeStampInSecond() {
+	return false
+}
+
+// IsAddressBanned checks address before connecting, when node ID is not known yet. Address is
+// banned when it could not be reached recently or the last node seen there is banned.
+func IsAddressBanned(addr PeerAddress) bool {
+	if whiteListIPs[addr.IP] {
+		return false
+	}
+	now := common.GetCurrentTimeStampInSecond()
+	bannedIPMutex.RLock()
+	defer bannedIPMutex.RUnlock()
+	if t, ok := unreachablePeers[addr]; ok && t > now {
+		return true
+	}
+	peerHintsMutex.RLock()
+	defer peerHintsMutex.RUnlock()
+	for id, hbanned := range bannedPeers {
+		if hint, ok := peerHints[id]; ok && hbanned > now && hint.AddrPort(SyncTopic) == addr.AddrPort(SyncTopic) {
 			return true
 		}
 	}
 	return false
 }
 
-func BanIP(ip [4]byte) {
+// markUnreachable stops connecting to address for banned time
+func markUnreachable(addr PeerAddress) {
+	if whiteListIPs[addr.IP] {
+		return
+	}
+	bannedIPMutex.Lock()
+	defer bannedIPMutex.Unlock()
+	logger.GetLogger().Println("unreachable ", addr)
+	unreachablePeers[addr] = common.GetCurrentTimeStampInSecond() + common.BannedTimeSeconds
+}
+
+func BanPeer(id NodeID) {
 	// internal IP should not be banned || bytes.Equal(ip[:2], InternalIP[:2])
-	for wip, _ := range whiteListIPs {
-		if bytes.Equal(wip[:], ip[:]) {
-			return
-		}
+	if isWhiteListed(id) {
+		return
 	}
 	bannedIPMutex.Lock()
-	logger.GetLogger().Println("BANNING ", ip)
-	bannedIP[ip] = common.GetCurrentTimeStampInSecond() + common.BannedTimeSeconds
+	logger.GetLogger().Println("BANNING ", id)
+	bannedPeers[id] = common.GetCurrentTimeStampInSecond() + common.BannedTimeSeconds
 	bannedIPMutex.Unlock()
+	penalizePeer(id)
 	if PeersMutex.TryLock() {
 		defer PeersMutex.Unlock()
-		if _, ok := validPeersConnected[ip]; ok {
-			delete(validPeersConnected, ip)
+		if _, ok := validPeersConnected[id]; ok {
+			delete(validPeersConnected, id)
 		}
-		if _, ok := nodePeersConnected[ip]; ok {
-			delete(nodePeersConnected, ip)
+		if _, ok := nodePeersConnected[id]; ok {
+			delete(nodePeersConnected, id)
 		}
 		tcpConns := tcpConnections[NonceTopic]
-		tcpConn, ok := tcpConns[ip]
+		tcpConn, ok := tcpConns[id]
 		if ok {
 			CloseAndRemoveConnection(tcpConn)
 			return
 		}
 		tcpConns = tcpConnections[TransactionTopic]
-		tcpConn, ok = tcpConns[ip]
+		tcpConn, ok = tcpConns[id]
 		if ok {
 			CloseAndRemoveConnection(tcpConn)
 			return
 		}
 		tcpConns = tcpConnections[SyncTopic]
-		tcpConn, ok = tcpConns[ip]
+		tcpConn, ok = tcpConns[id]
 		if ok {
 			CloseAndRemoveConnection(tcpConn)
 			return
@@ -80,7 +138,7 @@ func BanIP(ip [4]byte) {
 	}
 }
 
-func ReduceAndCheckIfBanIP(ip [4]byte) {
+func ReduceAndCheckIfBanPeer(id NodeID) {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer cancel()
 	PeersMutex.Lock()
@@ -88,15 +146,15 @@ func ReduceAndCheckIfBanIP(ip [4]byte) {
 	select {
 	case <-ctx.Done():
 		// Handle timeout
-		logger.GetLogger().Println("ReduceAndCheckIfBanIP: timeout in sending")
+		logger.GetLogger().Println("ReduceAndCheckIfBanPeer: timeout in sending")
 
 	default:
-		if _, ok := validPeersConnected[ip]; ok {
-			ReduceTrustRegisterPeer(ip)
+		if _, ok := validPeersConnected[id]; ok {
+			ReduceTrustRegisterPeer(id)
 		}
-		if _, ok := validPeersConnected[ip]; !ok {
-			logger.GetLogger().Println("not trusted ip", ip)
-			BanIP(ip)
+		if _, ok := validPeersConnected[id]; !ok {
+			logger.GetLogger().Println("not trusted peer", id)
+			BanPeer(id)
 		}
 	}
 }
@@ -107,26 +165,22 @@ func GetConnectedPeersInfo() []map[string]interface{} {
 	defer PeersMutex.RUnlock()
 
 	peers := []map[string]interface{}{}
-	seen := map[[4]byte]bool{}
+	myID := MyNodeID()
 
-	for ip, trust := range nodePeersConnected {
-		if bytes.Equal(ip[:], MyIP[:]) {
-			continue
-		}
-		if seen[ip] {
+	for id, trust := range nodePeersConnected {
+		if id == myID {
 			continue
 		}
-		seen[ip] = true
 
 		validTrust := 0
-		if t, ok := validPeersConnected[ip]; ok {
+		if t, ok := validPeersConnected[id]; ok {
 			validTrust = t
 		}
 
 		// Check which topics this peer is connected on
 		topics := []string{}
 		for topic, conns := range tcpConnections {
-			if _, ok := conns[ip]; ok {
+			if _, ok := conns[id]; ok {
 				switch topic {
 				case TransactionTopic:
 					topics = append(topics, "transactions")
@@ -141,7 +195,8 @@ func GetConnectedPeersInfo() []map[string]interface{} {
 		}
 
 		peers = append(peers, map[string]interface{}{
-			"ip":         formatIP(ip),
+			"id":         id.String(),
+			"ip":         formatHint(id),
 			"trustLevel": trust,
 			"validTrust": validTrust,
 			"isNodePeer": trust > 1,
@@ -160,10 +215,11 @@ func GetBannedPeersInfo() []map[string]interface{} {
 	now := common.GetCurrentTimeStampInSecond()
 	banned := []map[string]interface{}{}
 
-	for ip, expiration := range bannedIP {
+	for id, expiration := range bannedPeers {
 		if expiration > now {
 			banned = append(banned, map[string]interface{}{
-				"ip":            formatIP(ip),
+				"id":            id.String(),
+				"ip":            formatHint(id),
 				"banExpiration": expiration,
 				"remainingTime": expiration - now,
 			})
@@ -182,6 +238,15 @@ func GetWhitelistedIPs() []string {
 	return ips
 }
 
-func formatIP(ip [4]byte) string {
-	return fmt.Sprintf("%d.%d.%d.%d", ip[0], ip[1], ip[2], ip[3])
+func formatIP(ip netip.Addr) string {
+	return ip.String()
+}
+
+// formatHint returns last known IP of node
+func formatHint(id NodeID) string {
+	addr, ok := GetPeerHint(id)
+	if !ok {
+		return ""
+	}
+	return formatIP(addr.IP)
 }
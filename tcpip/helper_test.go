@@ -0,0 +1,43 @@
+package tcpip
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIP(t *testing.T) {
+	ip, err := ParseIP(" ::ffff:10.0.0.1 ")
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), ip)
+	ip, err = ParseIP("2001:db8::2")
+	assert.NoError(t, err)
+	assert.True(t, ip.Is6())
+	_, err = ParseIP("10.0.0")
+	assert.Error(t, err)
+}
+
+func TestSetPorts(t *testing.T) {
+	old := map[[2]byte]int{}
+	for k, v := range Ports {
+		old[k] = v
+	}
+	defer func() {
+		for k, v := range old {
+			Ports[k] = v
+		}
+	}()
+
+	assert.NoError(t, SetPorts("transaction=29023, Sync=26023"))
+	assert.Equal(t, 29023, Ports[TransactionTopic])
+	assert.Equal(t, 26023, Ports[SyncTopic])
+	assert.Equal(t, old[NonceTopic], Ports[NonceTopic])
+	assert.Equal(t, "[2001:db8::1]:26023", PeerAddrPort(netip.MustParseAddr("2001:db8::1"), SyncTopic).String())
+
+	// nothing is changed when any entry is wrong
+	assert.Error(t, SetPorts("nonce=28023,unknown=1"))
+	assert.Error(t, SetPorts("nonce=70000"))
+	assert.Error(t, SetPorts("nonce"))
+	assert.Equal(t, old[NonceTopic], Ports[NonceTopic])
+}
@@ -4,18 +4,23 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/netip"
 	"time"
 
 	"github.com/wonabru/qwid-node/common"
 	"github.com/wonabru/qwid-node/logger"
 )
 
-var ChanPeer = make(chan []byte, 50)
+var ChanPeer = make(chan PeerKey, 50)
+
+// ChanDial receives addresses from address book which should be dialed on all topics
+var ChanDial = make(chan PeerAddress, 50)
 
 func StartNewListener(topic [2]byte) {
 
-	conn, err := Listen([4]byte{0, 0, 0, 0}, Ports[topic])
+	conn, err := Listen(BindIP, Ports[topic])
 	if err != nil {
 		panic(err)
 	}
@@ -48,30 +53,29 @@ func StartNewListener(topic [2]byte) {
 }
 
 type connEntry struct {
-	ip   [4]byte
+	id   NodeID
 	conn *net.TCPConn
 }
 
 func LoopSend(sendChan <-chan []byte, topic [2]byte) {
-	var ipr [4]byte
 	for {
 		select {
 		case s := <-sendChan:
-			if len(s) > 4 {
-				copy(ipr[:], s[:4])
-			} else {
+			ipr, msg, ok := UnpackPeer(s)
+			if !ok {
 				logger.GetLogger().Println("wrong message", topic)
 				continue
 			}
 
 			// Snapshot connections under RLock — do NOT hold the lock during I/O.
 			var targets []connEntry
+			myID := MyNodeID()
 			PeersMutex.RLock()
-			if bytes.Equal(ipr[:], []byte{0, 0, 0, 0}) {
+			if ipr == AnyPeer {
 				for k, tcpConn0 := range tcpConnections[topic] {
-					if _, ok := validPeersConnected[k]; ok && !bytes.Equal(k[:], MyIP[:]) {
+					if _, ok := validPeersConnected[k]; ok && k != myID {
 						targets = append(targets, connEntry{k, tcpConn0})
-					} else if !bytes.Equal(k[:], MyIP[:]) {
+					} else if k != myID {
 						logger.GetLogger().Println("when send to all, ignore connection", k)
 					}
 				}
@@ -85,10 +89,10 @@ func LoopSend(sendChan <-chan []byte, topic [2]byte) {
 			PeersMutex.RUnlock()
 
 			// Send outside the lock — I/O must not hold PeersMutex.
-			var deletedIPs [][]byte
+			var deletedIPs []PeerKey
 			for _, t := range targets {
-				if err := Send(t.conn, s[4:]); err != nil {
-					logger.GetLogger().Printf("LoopSend: error sending to %v: %v", t.ip, err)
+				if err := Send(t.conn, msg); err != nil {
+					logger.GetLogger().Printf("LoopSend: error sending to %v: %v", t.id, err)
 					PeersMutex.Lock()
 					deleted := CloseAndRemoveConnection(t.conn)
 					PeersMutex.Unlock()
@@ -107,36 +111,42 @@ func LoopSend(sendChan <-chan []byte, topic [2]byte) {
 	}
 }
 
-func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
-	ipport := fmt.Sprintf("%d.%d.%d.%d:%d", ip[0], ip[1], ip[2], ip[3], Ports[topic])
-	if bytes.Equal(ip[:], []byte{127, 0, 0, 1}) {
-		ipport = fmt.Sprintf(":%d", Ports[topic])
+// localAddrFor returns BindIP as local address for connections to ip, if both are of the same family
+func localAddrFor(ip netip.Addr) *net.TCPAddr {
+	if !BindIP.IsValid() || BindIP.IsUnspecified() || BindIP.Is4() != ip.Is4() || ip.IsLoopback() {
+		return nil
 	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(BindIP, 0))
+}
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", ipport)
-	if err != nil {
-		logger.GetLogger().Printf("Failed to resolve TCP address for %s: %v", ipport, err)
-		return
+// StartNewConnection dials node at address, authenticates it and passes its messages to receiveChan
+// with node ID in front
+func StartNewConnection(peer PeerAddress, receiveChan chan []byte, topic [2]byte) {
+	ip := peer.IP.Unmap()
+	peer.IP = ip
+	ipport := peer.AddrPort(topic).String()
+	tcpAddr := net.TCPAddrFromAddrPort(peer.AddrPort(topic))
+	if ip.IsLoopback() {
+		ipport = fmt.Sprintf(":%d", Ports[topic])
+		tcpAddr = &net.TCPAddr{Port: Ports[topic]}
 	}
+	laddr := localAddrFor(ip)
 
 	var tcpConn *net.TCPConn
+	var err error
 	maxRetries := 3
 	for i := 0; i < maxRetries; i++ {
-		tcpConn, err = net.DialTCP("tcp", nil, tcpAddr)
+		tcpConn, err = net.DialTCP("tcp", laddr, tcpAddr)
 		if err == nil {
 			break
 		}
 		logger.GetLogger().Printf("Connection attempt %d to %s failed: %v", i+1, ipport, err)
 
 		time.Sleep(time.Second * 2)
-		PeersMutex.Lock()
-		ReduceTrustRegisterPeer(ip)
-		trust, ok := validPeersConnected[ip]
-		PeersMutex.Unlock()
-		if ok && trust <= 0 {
-			BanIP(ip)
-		} else if i == maxRetries-1 {
-			BanIP(ip)
+		// node is not known before handshake, so only address is given up for a while
+		if i == maxRetries-1 {
+			markUnreachable(peer)
+			recordPeerFailure(peer)
 		}
 
 	}
@@ -145,7 +155,29 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 		logger.GetLogger().Printf("Failed to establish connection to %s after %d attempts: %v", ipport, maxRetries, err)
 		return
 	}
-	logger.GetLogger().Printf("Connection successful to %s topic %c%c", ipport, topic[0], topic[1])
+	err = InitiateSecureSession(tcpConn, topic)
+	if err != nil {
+		logger.GetLogger().Printf("Handshake with %s failed: %v", ipport, err)
+		tcpConn.Close()
+		markUnreachable(peer)
+		recordPeerFailure(peer)
+		return
+	}
+	id, _ := GetPeerID(tcpConn)
+	if s := getSession(tcpConn); s != nil && s.listenPorts != (PortSet{}) && s.listenPorts != peer.Ports {
+		// address book keeps node under ports it advertised
+		forgetPeerAddress(peer)
+		peer.Ports = s.listenPorts
+	}
+	setPeerHint(id, peer)
+	if IsPeerBanned(id) {
+		logger.GetLogger().Println("node is BANNED", id, ipport)
+		removeSession(tcpConn)
+		tcpConn.Close()
+		return
+	}
+	recordPeerSeen(peer, id)
+	logger.GetLogger().Printf("Connection successful to %v at %s topic %c%c", id, ipport, topic[0], topic[1])
 
 	// Register the outbound connection for receiving.
 	// If an accepted connection already exists in tcpConnections for this peer+topic,
@@ -153,23 +185,21 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 	// This outbound connection will still be used for the receive loop below.
 	PeersMutex.Lock()
 	if _, ok := tcpConnections[topic]; !ok {
-		tcpConnections[topic] = make(map[[4]byte]*net.TCPConn)
+		tcpConnections[topic] = make(map[NodeID]*net.TCPConn)
 	}
 	// Track whether we stored the outbound connection in tcpConnections.
 	// If an accepted connection already exists, we keep it for sending and
 	// only use this outbound connection for the receive loop.
 	outboundStoredInMap := false
-	if existingConn, exists := tcpConnections[topic][ip]; exists {
+	if existingConn, exists := tcpConnections[topic][id]; exists {
 		_ = existingConn
 	} else {
-		tcpConnections[topic][ip] = tcpConn
+		tcpConnections[topic][id] = tcpConn
 		outboundStoredInMap = true
 	}
-	var topicipBytes [6]byte
-	copy(topicipBytes[:], append(topic[:], ip[:]...))
-	peersConnected[topicipBytes] = topic
-	validPeersConnected[ip] = common.ConnectionMaxTries
-	nodePeersConnected[ip] = common.ConnectionMaxTries
+	peersConnected[PeerKey{topic, id}] = topic
+	validPeersConnected[id] = common.ConnectionMaxTries
+	nodePeersConnected[id] = common.ConnectionMaxTries
 	PeersMutex.Unlock()
 
 	reconnectionTries := 0
@@ -188,11 +218,12 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 			}
 		} else {
 			if tcpConn != nil {
+				removeSession(tcpConn)
 				tcpConn.Close()
 			}
 			PeersMutex.Unlock()
 			// Notify to re-establish the receive connection
-			ChanPeer <- append(topic[:], ip[:]...)
+			ChanPeer <- PeerKey{topic, id}
 		}
 	}
 
@@ -204,7 +235,6 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 		}
 	}()
 
-
 	rTopic := map[[2]byte][]byte{}
 
 	for {
@@ -220,6 +250,26 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 			PeersMutex.Unlock()
 			return
 		default:
+			if s := getSession(tcpConn); s != nil && s.frameVersion >= FrameVersionLengthPrefixed {
+				msg, err := ReceiveFrame(topic, tcpConn)
+				if errors.Is(err, errFrameTimeout) {
+					continue
+				}
+				if err != nil {
+					// stream is out of sync or closed, new connection with new handshake is needed
+					if !errors.Is(err, io.EOF) {
+						logger.GetLogger().Println("error in receiving frame. Closing connection", ip, err)
+						PeersMutex.Lock()
+						ReduceTrustRegisterPeer(id)
+						PeersMutex.Unlock()
+					}
+					receiveChan <- []byte("EXIT")
+					cleanupOutbound()
+					return
+				}
+				receiveChan <- PackPeer(id, msg)
+				continue
+			}
 			r := Receive(topic, tcpConn)
 			if r == nil {
 				continue
@@ -227,8 +277,9 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 			if bytes.Equal(r, []byte("<-ERR->")) {
 				if reconnectionTries > common.ConnectionMaxTries {
 					logger.GetLogger().Println("error in read. Closing connection", ip, string(r))
+					removeSession(tcpConn)
 					tcpConn.Close()
-					tcpConn, err = net.DialTCP("tcp", nil, tcpAddr)
+					tcpConn, err = net.DialTCP("tcp", laddr, tcpAddr)
 					if err != nil {
 						logger.GetLogger().Printf("Connection attempt to %s failed: %v", ipport, err.Error())
 						// Reconnection failed — exit cleanly so subscriber can
@@ -236,6 +287,20 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 						receiveChan <- []byte("EXIT")
 						return
 					}
+					err = InitiateSecureSession(tcpConn, topic)
+					if err != nil {
+						logger.GetLogger().Printf("Handshake with %s failed: %v", ipport, err)
+						tcpConn.Close()
+						receiveChan <- []byte("EXIT")
+						return
+					}
+					if newID, _ := GetPeerID(tcpConn); newID != id {
+						logger.GetLogger().Printf("Other node %v answered at %s", newID, ipport)
+						removeSession(tcpConn)
+						tcpConn.Close()
+						receiveChan <- []byte("EXIT")
+						return
+					}
 					reconnectionTries = 0
 					continue
 				}
@@ -267,11 +332,11 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 			if int32(len(r)) > common.MaxMessageSizeBytes {
 				logger.GetLogger().Println("error: too long message received: ", len(r))
 				PeersMutex.Lock()
-				ReduceTrustRegisterPeer(ip)
+				ReduceTrustRegisterPeer(id)
 				PeersMutex.Unlock()
 				rTopic[topic] = []byte{}
-				if trust, ok := validPeersConnected[ip]; ok && trust <= 0 {
-					BanIP(ip)
+				if trust, ok := validPeersConnected[id]; ok && trust <= 0 {
+					BanPeer(id)
 					receiveChan <- []byte("EXIT")
 					return
 				}
@@ -280,14 +345,24 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 			if bytes.Equal(r[len(r)-7:], []byte("<-END->")) {
 				if len(r) > 4 {
 					if bytes.Equal(r[:4], common.MessageInitialization[:]) {
-						receiveChan <- append(ip[:], r[4:]...)
+						msg, err := decryptFrame(tcpConn, r[4:])
+						if err != nil {
+							// nonces are counters so stream cannot be recovered, new handshake is needed
+							PeersMutex.Lock()
+							ReduceTrustRegisterPeer(id)
+							PeersMutex.Unlock()
+							receiveChan <- []byte("EXIT")
+							cleanupOutbound()
+							return
+						}
+						receiveChan <- PackPeer(id, msg)
 					} else {
 						logger.GetLogger().Println("wrong MessageInitialization", r[:4], "should be", common.MessageInitialization[:])
 						PeersMutex.Lock()
-						ReduceTrustRegisterPeer(ip)
+						ReduceTrustRegisterPeer(id)
 						PeersMutex.Unlock()
-						if trust, ok := validPeersConnected[ip]; ok && trust <= 0 {
-							BanIP(ip)
+						if trust, ok := validPeersConnected[id]; ok && trust <= 0 {
+							BanPeer(id)
 							receiveChan <- []byte("EXIT")
 							return
 						}
@@ -298,34 +373,34 @@ func StartNewConnection(ip [4]byte, receiveChan chan []byte, topic [2]byte) {
 	}
 }
 
-func CloseAndRemoveConnection(tcpConn *net.TCPConn) [][]byte {
+func CloseAndRemoveConnection(tcpConn *net.TCPConn) []PeerKey {
 	if tcpConn == nil {
-		return [][]byte{}
+		return []PeerKey{}
 	}
 
-	topicipBytes := [6]byte{}
-	deletedIP := [][]byte{}
+	deletedIP := []PeerKey{}
 	// Find and remove the connection using pointer comparison
 	for topic, connections := range tcpConnections {
-		for peerIP, conn := range connections {
+		for peerID, conn := range connections {
 			if conn == tcpConn {
-				deletedIP = append(deletedIP, append(topic[:], peerIP[:]...))
+				topicipBytes := PeerKey{topic, peerID}
+				deletedIP = append(deletedIP, topicipBytes)
+				removeSession(tcpConn)
 				tcpConn.Close()
-				copy(topicipBytes[:], append(topic[:], peerIP[:]...))
-				delete(tcpConnections[topic], peerIP)
+				delete(tcpConnections[topic], peerID)
 				delete(peersConnected, topicipBytes)
 				delete(oldPeers, topicipBytes)
 				// If no more topic connections remain for this IP, remove from peer maps
 				hasConnection := false
 				for _, conns := range tcpConnections {
-					if _, ok := conns[peerIP]; ok {
+					if _, ok := conns[peerID]; ok {
 						hasConnection = true
 						break
 					}
 				}
 				if !hasConnection {
-					delete(validPeersConnected, peerIP)
-					delete(nodePeersConnected, peerIP)
+					delete(validPeersConnected, peerID)
+					delete(nodePeersConnected, peerID)
 				}
 				return deletedIP
 			}
@@ -0,0 +1,164 @@
+package tcpip
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/wallet"
+)
+
+// NodeIDLength is the length of node ID put in front of messages passed between services and tcpip
+const NodeIDLength = common.AddressLength
+
+// NodeID identifies node by hash of operator public key which authenticated the connection
+type NodeID [NodeIDLength]byte
+
+// AnyPeer as target sends message to all peers, as source means message created locally
+var AnyPeer = NodeID{}
+
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// NodeIDFromAddress returns node ID of operator address
+func NodeIDFromAddress(a common.Address) NodeID {
+	return NodeID(a.ByteValue)
+}
+
+// NodeIDFromPubKey returns node ID of operator public key, primary tells which key of operator it is
+// as the first byte of signature does in handshake
+func NodeIDFromPubKey(pubKey []byte, primary bool) (NodeID, error) {
+	a, err := common.PubKeyToAddress(pubKey, primary)
+	if err != nil {
+		return NodeID{}, err
+	}
+	return NodeIDFromAddress(a), nil
+}
+
+// operatorKey returns key of active wallet which authenticates connections and whether it is primary one
+func operatorKey() (*wallet.Wallet, common.PubKey, bool) {
+	w := wallet.GetActiveWallet()
+	if w == nil {
+		return nil, common.PubKey{}, false
+	}
+	if common.IsPaused() {
+		return w, w.Account2.PublicKey, false
+	}
+	return w, w.Account1.PublicKey, true
+}
+
+// MyNodeID returns ID of this node, derived from the same key and primary flag which are presented
+// to peers in handshake
+func MyNodeID() NodeID {
+	w, pk, primary := operatorKey()
+	if w == nil {
+		return AnyPeer
+	}
+	id, err := NodeIDFromPubKey(pk.GetBytes(), primary)
+	if err != nil {
+		return AnyPeer
+	}
+	return id
+}
+
+// advertisedTopics are topics which listener ports are exchanged in handshake and peer lists
+var advertisedTopics = [][2]byte{TransactionTopic, NonceTopic, SelfNonceTopic, SyncTopic}
+
+const portSetLength = 8
+
+// PortSet keeps listener ports of node in order of advertisedTopics. Zero means default port.
+type PortSet [4]uint16
+
+// MyPorts returns listener ports of this node
+func MyPorts() PortSet {
+	ps := PortSet{}
+	for i, topic := range advertisedTopics {
+		ps[i] = uint16(Ports[topic])
+	}
+	return ps
+}
+
+func (ps PortSet) Bytes() []byte {
+	b := make([]byte, portSetLength)
+	for i, p := range ps {
+		binary.LittleEndian.PutUint16(b[2*i:], p)
+	}
+	return b
+}
+
+func PortSetFromBytes(b []byte) (PortSet, error) {
+	ps := PortSet{}
+	if len(b) != portSetLength {
+		return ps, fmt.Errorf("wrong length of ports %v", len(b))
+	}
+	for i := range ps {
+		ps[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return ps, nil
+}
+
+// PeerAddress is transport hint where node can be reached, it does not identify node
+type PeerAddress struct {
+	IP    netip.Addr
+	Ports PortSet
+}
+
+// MyPeerAddress returns address advertised to other nodes
+func MyPeerAddress() PeerAddress {
+	return PeerAddress{IP: MyIP, Ports: MyPorts()}
+}
+
+// AddrPort returns address of peer listener on topic
+func (p PeerAddress) AddrPort(topic [2]byte) netip.AddrPort {
+	for i, t := range advertisedTopics {
+		if t == topic && p.Ports[i] != 0 {
+			return netip.AddrPortFrom(p.IP, p.Ports[i])
+		}
+	}
+	return PeerAddrPort(p.IP, topic)
+}
+
+func (p PeerAddress) String() string {
+	return p.AddrPort(SyncTopic).String()
+}
+
+// Bytes encodes address as 4 or 16 bytes IP followed by ports
+func (p PeerAddress) Bytes() []byte {
+	return append(p.IP.AsSlice(), p.Ports.Bytes()...)
+}
+
+// PeerAddressFromBytes decodes address, plain 4 or 16 bytes IP of older nodes gets default ports
+func PeerAddressFromBytes(b []byte) (PeerAddress, error) {
+	p := PeerAddress{}
+	ipLen := len(b)
+	if ipLen == 4+portSetLength || ipLen == 16+portSetLength {
+		ipLen -= portSetLength
+		ps, err := PortSetFromBytes(b[ipLen:])
+		if err != nil {
+			return p, err
+		}
+		p.Ports = ps
+	}
+	ip, ok := netip.AddrFromSlice(b[:ipLen])
+	if !ok {
+		return p, fmt.Errorf("wrong peer address length %v", len(b))
+	}
+	p.IP = ip.Unmap()
+	return p, nil
+}
+
+// PackPeer puts node ID in front of message
+func PackPeer(id NodeID, msg []byte) []byte {
+	return append(id[:], msg...)
+}
+
+// UnpackPeer splits message packed by PackPeer into node ID and payload
+func UnpackPeer(b []byte) (NodeID, []byte, bool) {
+	if len(b) <= NodeIDLength {
+		return NodeID{}, nil, false
+	}
+	return NodeID(b[:NodeIDLength]), b[NodeIDLength:], true
+}
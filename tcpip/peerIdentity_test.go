@@ -0,0 +1,73 @@
+package tcpip
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+)
+
+func TestPackUnpackPeer(t *testing.T) {
+	id := NodeID{1, 2, 3}
+	b := PackPeer(id, []byte("msg"))
+	assert.Equal(t, NodeIDLength+3, len(b))
+	id2, msg, ok := UnpackPeer(b)
+	assert.True(t, ok)
+	assert.Equal(t, id, id2)
+	assert.Equal(t, []byte("msg"), msg)
+
+	_, _, ok = UnpackPeer([]byte("EXIT"))
+	assert.False(t, ok)
+	_, _, ok = UnpackPeer(PackPeer(AnyPeer, nil))
+	assert.False(t, ok)
+}
+
+func TestNodeIDFromPubKey(t *testing.T) {
+	pk := []byte("post quantum public key")
+	for _, primary := range []bool{true, false} {
+		id, err := NodeIDFromPubKey(pk, primary)
+		assert.NoError(t, err)
+		// the same as address which verifyHandshake returns for key flag of signature
+		a, err := common.PubKeyToAddress(pk, primary)
+		assert.NoError(t, err)
+		assert.Equal(t, NodeIDFromAddress(a), id)
+		assert.NotEqual(t, AnyPeer, id)
+	}
+}
+
+func TestPeerAddressBytes(t *testing.T) {
+	for _, s := range []string{"192.168.1.1", "2001:db8::1"} {
+		p := PeerAddress{IP: netip.MustParseAddr(s), Ports: PortSet{29023, 28023, 27023, 26023}}
+		p2, err := PeerAddressFromBytes(p.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, p, p2)
+		assert.Equal(t, uint16(26023), p2.AddrPort(SyncTopic).Port())
+	}
+
+	// older nodes send IP only and default ports are used
+	p, err := PeerAddressFromBytes([]byte{10, 0, 0, 1})
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), p.IP)
+	assert.Equal(t, PeerAddrPort(p.IP, NonceTopic), p.AddrPort(NonceTopic))
+
+	_, err = PeerAddressFromBytes([]byte{10, 0, 0})
+	assert.Error(t, err)
+}
+
+func TestPeerAddressBans(t *testing.T) {
+	p := PeerAddress{IP: netip.MustParseAddr("2001:db8::7")}
+	other := PeerAddress{IP: p.IP, Ports: PortSet{1, 2, 3, 4}}
+	assert.False(t, IsAddressBanned(p))
+	markUnreachable(p)
+	assert.True(t, IsAddressBanned(p))
+	// another node on the same IP is not affected
+	assert.False(t, IsAddressBanned(other))
+
+	id := NodeID{9}
+	setPeerHint(id, other)
+	assert.False(t, IsPeerBanned(id))
+	BanPeer(id)
+	assert.True(t, IsPeerBanned(id))
+	assert.True(t, IsAddressBanned(other))
+}
@@ -0,0 +1,374 @@
+package tcpip
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/database"
+	"github.com/wonabru/qwid-node/logger"
+)
+
+const (
+	peerRecordLength   = NodeIDLength + 8 + 8 + 4 + 4
+	maxStoredPeers     = 1000
+	maxScore           = 100
+	banScorePenalty    = 10
+	backoffBaseSeconds = int64(30)
+	backoffMaxSeconds  = int64(3600)
+	forgetAfterFails   = 10
+	forgetAfterSeconds = int64(7 * 24 * 3600)
+	// MaxPeersInExchange is number of addresses sent in one peer exchange message
+	MaxPeersInExchange = 16
+	// peerExchangeIntervalSeconds is minimal time between peer exchange messages accepted from one node
+	peerExchangeIntervalSeconds = int64(30)
+)
+
+// PeerRecord is entry of address book, node ID is zero until node was reached at address
+type PeerRecord struct {
+	Addr        PeerAddress
+	ID          NodeID
+	LastSeen    int64
+	LastAttempt int64
+	Failures    int32
+	Score       int32
+}
+
+func (r PeerRecord) Bytes() []byte {
+	b := append([]byte{}, r.ID[:]...)
+	b = append(b, common.GetByteInt64(r.LastSeen)...)
+	b = append(b, common.GetByteInt64(r.LastAttempt)...)
+	b = append(b, common.GetByteInt32(r.Failures)...)
+	b = append(b, common.GetByteInt32(r.Score)...)
+	return b
+}
+
+func PeerRecordFromBytes(addr PeerAddress, b []byte) (PeerRecord, error) {
+	if len(b) != peerRecordLength {
+		return PeerRecord{}, fmt.Errorf("wrong length of peer record %v", len(b))
+	}
+	r := PeerRecord{Addr: addr}
+	copy(r.ID[:], b[:NodeIDLength])
+	b = b[NodeIDLength:]
+	r.LastSeen = common.GetInt64FromByte(b[:8])
+	r.LastAttempt = common.GetInt64FromByte(b[8:16])
+	r.Failures = common.GetInt32FromByte(b[16:20])
+	r.Score = common.GetInt32FromByte(b[20:24])
+	return r, nil
+}
+
+// NextAttempt returns time in seconds after which address can be dialed again,
+// waiting time doubles with every failed attempt
+func (r PeerRecord) NextAttempt() int64 {
+	backoff := backoffMaxSeconds
+	if r.Failures < 20 {
+		backoff = min(backoffBaseSeconds<<r.Failures, backoffMaxSeconds)
+	}
+	return r.LastAttempt + backoff
+}
+
+var peerStore = map[PeerAddress]*PeerRecord{}
+var peerStoreMutex sync.Mutex
+
+// lastPeerExchange keeps time of last accepted peer exchange message of node, guarded by peerStoreMutex
+var lastPeerExchange = map[NodeID]int64{}
+
+func peerStoreKey(addr PeerAddress) []byte {
+	return append(common.PeerStoreDBPrefix[:], addr.Bytes()...)
+}
+
+// storePeerRecord should be called with peerStoreMutex locked
+func storePeerRecord(r *PeerRecord) {
+	if database.MainDB == nil {
+		return
+	}
+	err := database.MainDB.Put(peerStoreKey(r.Addr), r.Bytes())
+	if err != nil {
+		logger.GetLogger().Println("cannot store peer", r.Addr, err)
+	}
+}
+
+// deletePeerRecord should be called with peerStoreMutex locked
+func deletePeerRecord(addr PeerAddress) {
+	delete(peerStore, addr)
+	if database.MainDB == nil {
+		return
+	}
+	err := database.MainDB.Delete(peerStoreKey(addr))
+	if err != nil {
+		logger.GetLogger().Println("cannot delete peer", addr, err)
+	}
+}
+
+// isDialable filters out addresses which should never be put into address book
+func isDialable(addr PeerAddress) bool {
+	if !addr.IP.IsValid() || addr.IP.IsUnspecified() || addr.IP.IsMulticast() {
+		return false
+	}
+	return addr != MyPeerAddress()
+}
+
+// LoadPeerStore reads address book from database and adds seed nodes from SEED_PEERS and genesis
+func LoadPeerStore() {
+	peerStoreMutex.Lock()
+	if database.MainDB != nil {
+		keys, err := database.MainDB.LoadAllKeys(common.PeerStoreDBPrefix[:])
+		if err != nil {
+			logger.GetLogger().Println("cannot load peer store", err)
+		}
+		for _, k := range keys {
+			addr, err := PeerAddressFromBytes(k[2:])
+			if err != nil {
+				logger.GetLogger().Println("wrong peer address in store", err)
+				continue
+			}
+			v, err := database.MainDB.Get(k)
+			if err != nil {
+				logger.GetLogger().Println(err)
+				continue
+			}
+			r, err := PeerRecordFromBytes(addr, v)
+			if err != nil {
+				logger.GetLogger().Println(err)
+				continue
+			}
+			peerStore[addr] = &r
+		}
+	}
+	logger.GetLogger().Println("peers loaded from store:", len(peerStore))
+	peerStoreMutex.Unlock()
+
+	AddPeerAddresses(SeedPeerAddresses())
+}
+
+// SeedPeerAddresses returns bootstrap nodes, comma separated IPs in SEED_PEERS and seed_peers of genesis
+func SeedPeerAddresses() []PeerAddress {
+	seeds := append(strings.Split(os.Getenv("SEED_PEERS"), ","), common.SeedPeers...)
+	addrs := []PeerAddress{}
+	for _, s := range seeds {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		ip, err := ParseIP(s)
+		if err != nil {
+			logger.GetLogger().Printf("Warning: Failed to parse seed peer '%s' as an IP address\n", s)
+			continue
+		}
+		addrs = append(addrs, PeerAddress{IP: ip})
+	}
+	return addrs
+}
+
+// evictionCandidate returns the worst entry among addresses never reached or with negative score,
+// addresses of nodes which were reached and behave are never evicted. Should be called with peerStoreMutex locked
+func evictionCandidate() *PeerRecord {
+	var worst *PeerRecord
+	for _, r := range peerStore {
+		if r.LastSeen > 0 && r.Score >= 0 {
+			continue
+		}
+		if worst == nil || r.Score < worst.Score || (r.Score == worst.Score && r.Failures > worst.Failures) {
+			worst = r
+		}
+	}
+	return worst
+}
+
+// makeRoomInPeerStore evicts entry when address book is full. Learned address only replaces addresses
+// which already failed, so flood of unknown addresses cannot push out the others. Should be called
+// with peerStoreMutex locked
+func makeRoomInPeerStore(reached bool) bool {
+	if len(peerStore) < maxStoredPeers {
+		return true
+	}
+	r := evictionCandidate()
+	if r == nil || (!reached && r.Score >= 0 && r.Failures == 0) {
+		return false
+	}
+	deletePeerRecord(r.Addr)
+	return true
+}
+
+// AllowPeerExchange tells whether addresses sent by node are accepted now, one message in
+// peerExchangeIntervalSeconds is accepted from every node
+func AllowPeerExchange(id NodeID) bool {
+	now := common.GetCurrentTimeStampInSecond()
+	peerStoreMutex.Lock()
+	defer peerStoreMutex.Unlock()
+	if now-lastPeerExchange[id] < peerExchangeIntervalSeconds {
+		return false
+	}
+	if len(lastPeerExchange) >= maxStoredPeers {
+		for n, t := range lastPeerExchange {
+			if now-t >= peerExchangeIntervalSeconds {
+				delete(lastPeerExchange, n)
+			}
+		}
+	}
+	lastPeerExchange[id] = now
+	return true
+}
+
+// AddPeerAddresses puts addresses learned from seeds or other nodes into address book
+func AddPeerAddresses(addrs []PeerAddress) {
+	peerStoreMutex.Lock()
+	defer peerStoreMutex.Unlock()
+	for _, addr := range addrs {
+		if _, ok := peerStore[addr]; ok || !isDialable(addr) {
+			continue
+		}
+		if !makeRoomInPeerStore(false) {
+			return
+		}
+		r := &PeerRecord{Addr: addr}
+		peerStore[addr] = r
+		storePeerRecord(r)
+	}
+}
+
+// recordPeerSeen notes successful authenticated connection to node at address
+func recordPeerSeen(addr PeerAddress, id NodeID) {
+	if !isDialable(addr) || id == MyNodeID() {
+		return
+	}
+	peerStoreMutex.Lock()
+	defer peerStoreMutex.Unlock()
+	r, ok := peerStore[addr]
+	if !ok {
+		if !makeRoomInPeerStore(true) {
+			return
+		}
+		r = &PeerRecord{Addr: addr}
+		peerStore[addr] = r
+	}
+	r.ID = id
+	r.LastSeen = common.GetCurrentTimeStampInSecond()
+	r.Failures = 0
+	r.Score = min(r.Score+1, maxScore)
+	storePeerRecord(r)
+}
+
+// recordPeerFailure notes failed dial or handshake, address which failed too often and was not seen
+// for long time is forgotten
+func recordPeerFailure(addr PeerAddress) {
+	peerStoreMutex.Lock()
+	defer peerStoreMutex.Unlock()
+	r, ok := peerStore[addr]
+	if !ok {
+		return
+	}
+	now := common.GetCurrentTimeStampInSecond()
+	r.LastAttempt = now
+	r.Failures++
+	r.Score = max(r.Score-1, -maxScore)
+	if r.Failures >= forgetAfterFails && now-r.LastSeen > forgetAfterSeconds {
+		logger.GetLogger().Println("forget peer", addr)
+		deletePeerRecord(addr)
+		return
+	}
+	storePeerRecord(r)
+}
+
+// forgetPeerAddress removes address from address book, ex. seed without ports when node advertised its ports
+func forgetPeerAddress(addr PeerAddress) {
+	peerStoreMutex.Lock()
+	defer peerStoreMutex.Unlock()
+	if _, ok := peerStore[addr]; ok {
+		deletePeerRecord(addr)
+	}
+}
+
+// penalizePeer lowers score of addresses where banned node was reached
+func penalizePeer(id NodeID) {
+	peerStoreMutex.Lock()
+	defer peerStoreMutex.Unlock()
+	for _, r := range peerStore {
+		if r.ID == id {
+			r.Score = max(r.Score-banScorePenalty, -maxScore)
+			storePeerRecord(r)
+		}
+	}
+}
+
+// GetPeerRecords returns copy of address book sorted by score
+func GetPeerRecords() []PeerRecord {
+	peerStoreMutex.Lock()
+	records := make([]PeerRecord, 0, len(peerStore))
+	for _, r := range peerStore {
+		records = append(records, *r)
+	}
+	peerStoreMutex.Unlock()
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Score != records[j].Score {
+			return records[i].Score > records[j].Score
+		}
+		return records[i].LastSeen > records[j].LastSeen
+	})
+	return records
+}
+
+// PeersToDial returns at most n best scored addresses which backoff time passed and which are not connected,
+// the attempt is noted so the address is not returned again before next backoff time
+func PeersToDial(n int) []PeerAddress {
+	if n <= 0 {
+		return nil
+	}
+	now := common.GetCurrentTimeStampInSecond()
+	connected := connectedNodeIDs()
+	addrs := []PeerAddress{}
+	for _, r := range GetPeerRecords() {
+		if len(addrs) >= n {
+			break
+		}
+		if r.NextAttempt() > now || IsAddressBanned(r.Addr) || IsConnectedTo(SyncTopic, r.Addr) {
+			continue
+		}
+		if _, ok := connected[r.ID]; ok && r.ID != AnyPeer {
+			continue
+		}
+		if r.ID != AnyPeer && IsPeerBanned(r.ID) {
+			continue
+		}
+		addrs = append(addrs, r.Addr)
+	}
+	peerStoreMutex.Lock()
+	for _, addr := range addrs {
+		if r, ok := peerStore[addr]; ok {
+			r.LastAttempt = now
+			storePeerRecord(r)
+		}
+	}
+	peerStoreMutex.Unlock()
+	return addrs
+}
+
+// GetPeerAddressesToShare returns encoded addresses of nodes which were reached recently, for peer exchange
+func GetPeerAddressesToShare() [][]byte {
+	now := common.GetCurrentTimeStampInSecond()
+	addrs := [][]byte{}
+	for _, r := range GetPeerRecords() {
+		if len(addrs) >= MaxPeersInExchange {
+			break
+		}
+		if r.LastSeen == 0 || now-r.LastSeen > forgetAfterSeconds || r.Score < 0 || IsAddressBanned(r.Addr) {
+			continue
+		}
+		addrs = append(addrs, r.Addr.Bytes())
+	}
+	return addrs
+}
+
+func connectedNodeIDs() map[NodeID]struct{} {
+	PeersMutex.RLock()
+	defer PeersMutex.RUnlock()
+	ids := map[NodeID]struct{}{}
+	for _, connections := range tcpConnections {
+		for id := range connections {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,114 @@
+package tcpip
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+)
+
+func TestPeerRecordBytes(t *testing.T) {
+	addr := PeerAddress{IP: netip.MustParseAddr("2001:db8::7"), Ports: PortSet{1, 2, 3, 4}}
+	r := PeerRecord{Addr: addr, ID: NodeID{9}, LastSeen: 100, LastAttempt: 200, Failures: 3, Score: -5}
+	r2, err := PeerRecordFromBytes(addr, r.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, r, r2)
+
+	_, err = PeerRecordFromBytes(addr, []byte{1, 2})
+	assert.Error(t, err)
+}
+
+func TestPeerRecordBackoff(t *testing.T) {
+	r := PeerRecord{LastAttempt: 1000}
+	assert.Equal(t, int64(1000)+backoffBaseSeconds, r.NextAttempt())
+	r.Failures = 2
+	assert.Equal(t, int64(1000)+4*backoffBaseSeconds, r.NextAttempt())
+	r.Failures = 50
+	assert.Equal(t, int64(1000)+backoffMaxSeconds, r.NextAttempt())
+}
+
+func TestPeersToDial(t *testing.T) {
+	a := PeerAddress{IP: netip.MustParseAddr("203.0.113.21")}
+	b := PeerAddress{IP: netip.MustParseAddr("203.0.113.22")}
+	defer forgetPeerAddress(a)
+	defer forgetPeerAddress(b)
+
+	AddPeerAddresses([]PeerAddress{a, b, {}})
+	recordPeerSeen(b, NodeID{5})
+	dial := PeersToDial(1)
+	// better scored address goes first
+	assert.Equal(t, []PeerAddress{b}, dial)
+	assert.Equal(t, []PeerAddress{a}, PeersToDial(2))
+	// attempt was noted, so addresses wait for backoff
+	assert.Empty(t, PeersToDial(2))
+
+	recordPeerFailure(a)
+	for _, r := range GetPeerRecords() {
+		if r.Addr == a {
+			assert.Equal(t, int32(1), r.Failures)
+			assert.Equal(t, int32(-1), r.Score)
+			assert.Greater(t, r.NextAttempt(), common.GetCurrentTimeStampInSecond()+backoffBaseSeconds)
+		}
+	}
+	assert.Contains(t, GetPeerAddressesToShare(), b.Bytes())
+	assert.NotContains(t, GetPeerAddressesToShare(), a.Bytes())
+}
+
+func TestPeerStoreEviction(t *testing.T) {
+	saved := peerStore
+	defer func() { peerStore = saved }()
+	peerStore = map[PeerAddress]*PeerRecord{}
+	for i := 0; i < maxStoredPeers; i++ {
+		addr := PeerAddress{IP: netip.AddrFrom4([4]byte{198, 18, byte(i >> 8), byte(i)})}
+		peerStore[addr] = &PeerRecord{Addr: addr, LastSeen: 1, Score: 1}
+	}
+	learned := PeerAddress{IP: netip.MustParseAddr("203.0.113.40")}
+	reached := PeerAddress{IP: netip.MustParseAddr("203.0.113.41")}
+
+	// nodes reached before are never evicted
+	AddPeerAddresses([]PeerAddress{learned})
+	recordPeerSeen(reached, NodeID{6})
+	assert.Len(t, peerStore, maxStoredPeers)
+	assert.NotContains(t, peerStore, learned)
+	assert.NotContains(t, peerStore, reached)
+
+	// flood of learned addresses does not push out learned ones which were not tried yet
+	unknown := PeerAddress{IP: netip.MustParseAddr("203.0.113.42")}
+	bad := PeerAddress{IP: netip.AddrFrom4([4]byte{198, 18, 0, 1})}
+	peerStore[bad].Score = -3
+	AddPeerAddresses([]PeerAddress{unknown, learned})
+	assert.Contains(t, peerStore, unknown)
+	assert.NotContains(t, peerStore, bad)
+	assert.NotContains(t, peerStore, learned)
+
+	// reached node takes place of address never reached
+	recordPeerSeen(reached, NodeID{6})
+	assert.Contains(t, peerStore, reached)
+	assert.NotContains(t, peerStore, unknown)
+	assert.Len(t, peerStore, maxStoredPeers)
+}
+
+func TestAllowPeerExchange(t *testing.T) {
+	id, other := NodeID{7}, NodeID{8}
+	defer func() {
+		peerStoreMutex.Lock()
+		delete(lastPeerExchange, id)
+		delete(lastPeerExchange, other)
+		peerStoreMutex.Unlock()
+	}()
+	assert.True(t, AllowPeerExchange(id))
+	assert.False(t, AllowPeerExchange(id))
+	assert.True(t, AllowPeerExchange(other))
+}
+
+func TestSeedPeerAddresses(t *testing.T) {
+	t.Setenv("SEED_PEERS", "203.0.113.30, 2001:db8::30,wrong")
+	common.SeedPeers = []string{"198.51.100.30"}
+	defer func() { common.SeedPeers = nil }()
+	assert.Equal(t, []PeerAddress{
+		{IP: netip.MustParseAddr("203.0.113.30")},
+		{IP: netip.MustParseAddr("2001:db8::30")},
+		{IP: netip.MustParseAddr("198.51.100.30")},
+	}, SeedPeerAddresses())
+}
@@ -1,10 +1,10 @@
 package tcpip
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"os"
 	"os/signal"
 	"strconv"
@@ -18,14 +18,20 @@ import (
 	"golang.org/x/exp/rand"
 )
 
+// PeerKey identifies connection with node on topic
+type PeerKey struct {
+	Topic [2]byte
+	ID    NodeID
+}
+
 var (
-	peersConnected      = map[[6]byte][2]byte{}
-	validPeersConnected = map[[4]byte]int{}
-	nodePeersConnected  = map[[4]byte]int{}
-	oldPeers            = map[[6]byte][2]byte{}
+	peersConnected      = map[PeerKey][2]byte{}
+	validPeersConnected = map[NodeID]int{}
+	nodePeersConnected  = map[NodeID]int{}
+	oldPeers            = map[PeerKey][2]byte{}
 	PeersCount          = 0
 	waitChan            = make(chan []byte)
-	tcpConnections      = make(map[[2]byte]map[[4]byte]*net.TCPConn)
+	tcpConnections      = make(map[[2]byte]map[NodeID]*net.TCPConn)
 	PeersMutex          = &sync.RWMutex{}
 	Quit                chan os.Signal
 	TransactionTopic    = [2]byte{'T', 'T'}
@@ -33,29 +39,59 @@ var (
 	SelfNonceTopic      = [2]byte{'S', 'S'}
 	SyncTopic           = [2]byte{'B', 'B'}
 	RPCTopic            = [2]byte{'R', 'P'}
+	EthRPCTopic         = [2]byte{'E', 'R'}
 )
 
+// Ports are default ports of topics, can be changed by NODE_PORTS environment variable
 var Ports = map[[2]byte]int{
 	TransactionTopic: 19023,
 	NonceTopic:       18023,
 	SelfNonceTopic:   17023,
 	SyncTopic:        16023,
 	RPCTopic:         19009,
+	EthRPCTopic:      8545,
 }
 
-var MyIP [4]byte
-var MyIPSelfNonce [4]byte
-var InternalIP [4]byte
+// topicNames are used in NODE_PORTS, ex. "transaction=19023,sync=16023"
+var topicNames = map[string][2]byte{
+	"transaction": TransactionTopic,
+	"nonce":       NonceTopic,
+	"selfnonce":   SelfNonceTopic,
+	"sync":        SyncTopic,
+	"rpc":         RPCTopic,
+	"ethrpc":      EthRPCTopic,
+}
+
+// MyIP is external address advertised to other nodes
+var MyIP netip.Addr
+var MyIPSelfNonce netip.Addr
+var InternalIP netip.Addr
+
+// BindIP is local address listeners are bound to, not valid means all interfaces
+var BindIP netip.Addr
 
 func init() {
 	Quit = make(chan os.Signal)
 	signal.Notify(Quit, syscall.SIGTERM, syscall.SIGINT, os.Interrupt)
 	MyIP = GetIp()
-	copy(InternalIP[:], MyIP[:])
+	InternalIP = MyIP
 
 	logger.GetLogger().Println("Discover MyIP: ", MyIP)
 	for k := range Ports {
-		tcpConnections[k] = map[[4]byte]*net.TCPConn{}
+		tcpConnections[k] = map[NodeID]*net.TCPConn{}
+	}
+	if ports := os.Getenv("NODE_PORTS"); ports != "" {
+		if err := SetPorts(ports); err != nil {
+			logger.GetLogger().Fatalf("Failed to parse NODE_PORTS '%s': %v", ports, err)
+		}
+	}
+	if ips := os.Getenv("NODE_BIND_IP"); ips != "" {
+		ip, err := ParseIP(ips)
+		if err != nil {
+			logger.GetLogger().Fatalf("Failed to parse NODE_BIND_IP '%s' as an IP address", ips)
+		}
+		BindIP = ip
+		logger.GetLogger().Println("Listening on", BindIP)
 	}
 	// Get NODE_IP environment variable
 	ips := os.Getenv("NODE_IP")
@@ -65,46 +101,32 @@ func init() {
 	}
 
 	// Parse the IP address
-	ip := net.ParseIP(ips)
-	if ip == nil {
+	ip, err := ParseIP(ips)
+	if err != nil {
 		logger.GetLogger().Fatalf("Failed to parse NODE_IP '%s' as an IP address", ips)
 	}
-
-	ip4 := ip.To4()
-	if ip4 == nil {
-		logger.GetLogger().Fatalf("Failed to parse NODE_IP '%s' as 4 byte format", ips)
-	}
 	// Assign the parsed IP to tcpip.MyIP
-	MyIP = [4]byte(ip4)
+	MyIP = ip
 
 	// Get NODE_IP_SELF_NONCE environment variable
 	ips = os.Getenv("NODE_IP_SELF_NONCE")
 	if ips == "" {
 		logger.GetLogger().Println("Warning: NODE_IP_SELF_NONCE environment variable is not set")
-		MyIPSelfNonce = [4]byte(MyIP)
+		MyIPSelfNonce = MyIP
 	} else {
 
 		// Parse the IP address
-		ip := net.ParseIP(ips)
-		if ip == nil {
+		ip, err := ParseIP(ips)
+		if err != nil {
 			logger.GetLogger().Fatalf("Failed to parse NODE_IP_SELF_NONCE '%s' as an IP address", ips)
 		}
-
-		ip4 := ip.To4()
-		if ip4 == nil {
-			logger.GetLogger().Fatalf("Failed to parse NODE_IP_SELF_NONCE '%s' as 4 byte format", ips)
-		}
-		// Assign the parsed IP to tcpip.MyIP
-		MyIPSelfNonce = [4]byte(ip4)
+		MyIPSelfNonce = ip
 	}
 
 	AddWhiteListIPs(MyIP)
 	AddWhiteListIPs(MyIPSelfNonce)
-	AddWhiteListIPs([4]byte{0, 0, 0, 0})
 	// Rest of your application logic here...
-	logger.GetLogger().Printf("Successfully set NODE_IP to %d.%d.%d.%d", int(MyIP[0]), int(MyIP[1]), int(MyIP[2]), int(MyIP[3]))
-	validPeersConnected[MyIP] = 100
-	validPeersConnected[MyIPSelfNonce] = 100
+	logger.GetLogger().Printf("Successfully set NODE_IP to %v", MyIP)
 	// Get WHITELIST_IP environment variable
 	ips = os.Getenv("WHITELIST_IP")
 	if ips == "" {
@@ -121,66 +143,129 @@ func init() {
 		ipStr = strings.TrimSpace(ipStr)
 
 		// Parse the IP address
-		ip = net.ParseIP(ipStr)
-		if ip == nil {
+		ip, err = ParseIP(ipStr)
+		if err != nil {
 			logger.GetLogger().Printf("Warning: Failed to parse WHITELIST_IP '%s' as an IP address\n", ipStr)
 			return
 		}
+		AddWhiteListIPs(ip)
+	}
+}
 
-		ip4 = ip.To4()
-		if ip4 == nil {
-			logger.GetLogger().Printf("Warning: failed to parse WHITELIST_IP '%s' as 4 byte format\n", ipStr)
-			return
+// ParseIP parses IPv4 or IPv6 address, IPv4-mapped IPv6 addresses are turned into IPv4
+func ParseIP(s string) (netip.Addr, error) {
+	ip, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return ip.Unmap(), nil
+}
+
+// SetPorts changes ports of topics, ex. "transaction=19023,nonce=18023"
+func SetPorts(s string) error {
+	ports := map[[2]byte]int{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("wrong port entry %v", entry)
 		}
-		AddWhiteListIPs([4]byte(ip4))
+		topic, ok := topicNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return fmt.Errorf("unknown topic %v", name)
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("wrong port %v", value)
+		}
+		ports[topic] = port
+	}
+	for topic, port := range ports {
+		Ports[topic] = port
 	}
+	return nil
 }
 
-func GetIp() [4]byte {
+// PeerAddrPort returns address of peer listener on topic
+func PeerAddrPort(ip netip.Addr, topic [2]byte) netip.AddrPort {
+	return netip.AddrPortFrom(ip, uint16(Ports[topic]))
+}
+
+// ListenAddress returns host:port for listener of topic bound to BindIP
+func ListenAddress(topic [2]byte) string {
+	host := ""
+	if BindIP.IsValid() {
+		host = BindIP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(Ports[topic]))
+}
+
+// addrFromConn returns IP of remote side of connection
+func addrFromConn(tcpConn *net.TCPConn) (netip.Addr, error) {
+	raddr, ok := tcpConn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("wrong remote address %v", tcpConn.RemoteAddr())
+	}
+	return raddr.AddrPort().Addr().Unmap(), nil
+}
+
+func GetIp() netip.Addr {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		logger.GetLogger().Println("Can not obtain net interface")
-		return [4]byte{}
+		return netip.Addr{}
 	}
-	ipInternal := [4]byte{}
-	zeros := [4]byte{}
+	// public IPv4 is preferred, then public IPv6 and private addresses at the end
+	var ipPublic6, ipInternal netip.Addr
 	for _, i := range ifaces {
 		addrs, err := i.Addrs()
 		if err != nil {
 			logger.GetLogger().Println("Can not get net addresses")
-			return [4]byte{}
+			return netip.Addr{}
 		}
 		for _, addr := range addrs {
-			var ip net.IP
+			var ipn net.IP
 			switch v := addr.(type) {
 			case *net.IPNet:
-				ip = v.IP
+				ipn = v.IP
 			case *net.IPAddr:
-				ip = v.IP
+				ipn = v.IP
 			}
-			ip4 := ip.To4()
-			if ip4 == nil {
+			ip, ok := netip.AddrFromSlice(ipn)
+			if !ok {
 				continue
 			}
-			if ip.IsLoopback() {
+			ip = ip.Unmap()
+			if ip.IsLoopback() || !ip.IsGlobalUnicast() {
 				continue
 			}
 			if !ip.IsPrivate() {
-				return [4]byte(ip.To4())
-			} else if bytes.Equal(ipInternal[:], zeros[:]) {
-				ipInternal = [4]byte(ip.To4())
+				if ip.Is4() {
+					return ip
+				}
+				if !ipPublic6.IsValid() {
+					ipPublic6 = ip
+				}
+			} else if !ipInternal.IsValid() {
+				ipInternal = ip
 			}
 		}
 	}
+	if ipPublic6.IsValid() {
+		return ipPublic6
+	}
 	return ipInternal
 }
-func Listen(ip [4]byte, port int) (*net.TCPListener, error) {
-	ipport := fmt.Sprintf("%d.%d.%d.%d:%d", ip[0], ip[1], ip[2], ip[3], port)
+
+// Listen binds to ip and port, not valid ip means all interfaces, IPv4 and IPv6
+func Listen(ip netip.Addr, port int) (*net.TCPListener, error) {
 	protocol := "tcp"
-	addr, err := net.ResolveTCPAddr(protocol, ipport)
-	if err != nil {
-		logger.GetLogger().Println("Wrong Address", err)
-		return nil, err
+	addr := &net.TCPAddr{Port: port}
+	if ip.IsValid() {
+		addr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port)))
 	}
 	conn, err := net.ListenTCP(protocol, addr)
 	if err != nil {
@@ -196,18 +281,47 @@ func Accept(topic [2]byte, conn *net.TCPListener) (*net.TCPConn, error) {
 		return nil, fmt.Errorf("error accepting connection: %w", err)
 	}
 
+	tcpConn.SetKeepAlive(true)
+	// handshake runs aside, so slow peers do not block accepting other connections
+	go secureAndRegisterPeer(topic, tcpConn)
+	return tcpConn, nil
+}
+
+// secureAndRegisterPeer establishes encrypted session with accepted connection and registers it for sending
+func secureAndRegisterPeer(topic [2]byte, tcpConn *net.TCPConn) {
+	err := AcceptSecureSession(tcpConn, topic)
+	if err != nil {
+		logger.GetLogger().Printf("handshake with %v failed: %v", tcpConn.RemoteAddr(), err)
+		tcpConn.Close()
+		return
+	}
 	if !RegisterPeer(topic, tcpConn) {
+		logger.GetLogger().Println("registration failed for connection", tcpConn.RemoteAddr())
+		removeSession(tcpConn)
 		tcpConn.Close()
-		return nil, fmt.Errorf("registration failed for connection")
 	}
-	tcpConn.SetKeepAlive(true)
-	return tcpConn, nil
 }
 
+// Send encrypts message with session key established in handshake and writes it to connection
 func Send(conn *net.TCPConn, message []byte) error {
-
-	message = append(common.MessageInitialization[:], message...)
-	message = append(message, []byte("<-END->")...)
+	s := getSession(conn)
+	if s == nil {
+		err := fmt.Errorf("no secure session with %v", conn.RemoteAddr())
+		logger.GetLogger().Println(err)
+		return err
+	}
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if s.frameVersion >= FrameVersionLengthPrefixed {
+		var err error
+		message, err = s.sealLengthPrefixedFrame(message)
+		if err != nil {
+			logger.GetLogger().Println(err)
+			return err
+		}
+	} else {
+		message = s.sealFrame(message)
+	}
 
 	// Set write deadline to 2 seconds
 	conn.SetWriteDeadline(time.Now().Add(4 * time.Second))
@@ -246,44 +360,43 @@ func Receive(topic [2]byte, conn *net.TCPConn) []byte {
 	return buf[:n]
 }
 
-// ValidRegisterPeer Confirm that ip is valid node
-func ValidRegisterPeer(ip [4]byte) {
+// ValidRegisterPeer Confirm that node is valid
+func ValidRegisterPeer(id NodeID) {
 	PeersMutex.Lock()
 	defer PeersMutex.Unlock()
-	if n, ok := validPeersConnected[ip]; ok {
+	if n, ok := validPeersConnected[id]; ok {
 		if n < 3 {
-			validPeersConnected[ip]++
+			validPeersConnected[id]++
 		}
 		return
 	}
-	validPeersConnected[ip] = common.ConnectionMaxTries
+	validPeersConnected[id] = common.ConnectionMaxTries
 
 }
 
-// NodeRegisterPeer Confirm that ip is valid node IP
-func NodeRegisterPeer(ip [4]byte) {
+// NodeRegisterPeer Confirm that node is valid mining node
+func NodeRegisterPeer(id NodeID) {
 	PeersMutex.Lock()
 	defer PeersMutex.Unlock()
-	if _, ok := nodePeersConnected[ip]; ok {
-		validPeersConnected[ip] = common.ConnectionMaxTries
+	if _, ok := nodePeersConnected[id]; ok {
+		validPeersConnected[id] = common.ConnectionMaxTries
 		return
 	}
-	nodePeersConnected[ip] = common.ConnectionMaxTries
+	nodePeersConnected[id] = common.ConnectionMaxTries
 }
 
 // ReduceTrustRegisterPeer limit connections attempts needs to be peer lock
-func ReduceTrustRegisterPeer(ip [4]byte) {
-	// || bytes.Equal(ip[:2], InternalIP[:2])
-	if bytes.Equal(ip[:], MyIP[:]) || bytes.Equal(ip[:], []byte{0, 0, 0, 0}) {
+func ReduceTrustRegisterPeer(id NodeID) {
+	if id == AnyPeer || id == MyNodeID() {
 		return
 	}
-	if _, ok := validPeersConnected[ip]; !ok {
+	if _, ok := validPeersConnected[id]; !ok {
 		return
 	}
 
-	validPeersConnected[ip]--
-	if validPeersConnected[ip] <= 0 {
-		delete(validPeersConnected, ip)
+	validPeersConnected[id]--
+	if validPeersConnected[id] <= 0 {
+		delete(validPeersConnected, id)
 	}
 }
 
@@ -293,58 +406,58 @@ func FullyDeleteConnection(tcpConn *net.TCPConn) {
 	CloseAndRemoveConnection(tcpConn)
 }
 
-// RegisterPeer registers a new peer connection
+// RegisterPeer registers a new peer connection under node ID authenticated in handshake
 func RegisterPeer(topic [2]byte, tcpConn *net.TCPConn) bool {
 
-	raddr := tcpConn.RemoteAddr().String()
-	ra := strings.Split(raddr, ":")
-	ips := strings.Split(ra[0], ".")
-	var ip [4]byte
-	for i := 0; i < 4; i++ {
-		num, err := strconv.Atoi(ips[i])
-		if err != nil {
-			fmt.Println("Invalid IP address segment:", ips[i])
-			return false
-		}
-		ip[i] = byte(num)
+	s := getSession(tcpConn)
+	if s == nil {
+		logger.GetLogger().Println("no secure session with", tcpConn.RemoteAddr())
+		return false
 	}
-	if IsIPBanned(ip) {
-		logger.GetLogger().Println("IP is BANNED", ip)
+	id := NodeIDFromAddress(s.peer)
+	ip, err := addrFromConn(tcpConn)
+	if err != nil {
+		logger.GetLogger().Println(err)
 		return false
 	}
-	var topicipBytes [6]byte
-	copy(topicipBytes[:], append(topic[:], ip[:]...))
-
+	hint := PeerAddress{IP: ip, Ports: s.listenPorts}
+	setPeerHint(id, hint)
+	if IsPeerBanned(id) {
+		logger.GetLogger().Println("node is BANNED", id, ip)
+		return false
+	}
+	recordPeerSeen(hint, id)
 	PeersMutex.Lock()
 	defer PeersMutex.Unlock()
 
 	// Initialize the map for the topic if it doesn't exist
 	if _, ok := tcpConnections[topic]; !ok {
-		tcpConnections[topic] = make(map[[4]byte]*net.TCPConn)
+		tcpConnections[topic] = make(map[NodeID]*net.TCPConn)
 	}
 
 	// Check if we already have a connection for this peer
-	if oldConn, ok := tcpConnections[topic][ip]; ok {
+	if oldConn, ok := tcpConnections[topic][id]; ok {
 		// Close the old connection before replacing it, so the other node's
 		// outbound receive loop gets a clean EOF instead of lingering and
 		// triggering repeated reconnections.
+		removeSession(oldConn)
 		oldConn.Close()
 	}
 
 	// Register the accepted connection for sending
-	tcpConnections[topic][ip] = tcpConn
-	peersConnected[topicipBytes] = topic
-	validPeersConnected[ip] = common.ConnectionMaxTries
-	nodePeersConnected[ip] = common.ConnectionMaxTries
+	tcpConnections[topic][id] = tcpConn
+	peersConnected[PeerKey{topic, id}] = topic
+	validPeersConnected[id] = common.ConnectionMaxTries
+	nodePeersConnected[id] = common.ConnectionMaxTries
 
 	return true
 }
 
-func GetPeersConnected(topic [2]byte) map[[6]byte][2]byte {
+func GetPeersConnected(topic [2]byte) map[PeerKey][2]byte {
 	PeersMutex.RLock()
 	defer PeersMutex.RUnlock()
 
-	copyOfPeers := make(map[[6]byte][2]byte, len(peersConnected))
+	copyOfPeers := make(map[PeerKey][2]byte, len(peersConnected))
 	for key, value := range peersConnected {
 		if value == topic {
 			copyOfPeers[key] = value
@@ -354,28 +467,44 @@ func GetPeersConnected(topic [2]byte) map[[6]byte][2]byte {
 	return copyOfPeers
 }
 
-func GetIPsConnected() [][]byte {
+// IsConnectedTo checks if any node connected on topic was reached at address
+func IsConnectedTo(topic [2]byte, addr PeerAddress) bool {
+	PeersMutex.RLock()
+	defer PeersMutex.RUnlock()
+	for id := range tcpConnections[topic] {
+		if hint, ok := GetPeerHint(id); ok && hint.AddrPort(topic) == addr.AddrPort(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPeerAddressesConnected returns address of one random connected node, encoded for peer list
+func GetPeerAddressesConnected() [][]byte {
 	if PeersMutex.TryLock() {
 		defer PeersMutex.Unlock()
-		// Only return IPs that have at least one active TCP connection
-		uniqueIPs := make(map[[4]byte]struct{})
+		// Only return nodes that have at least one active TCP connection
+		myID := MyNodeID()
+		uniqueIDs := make(map[NodeID]struct{})
 		for _, connections := range tcpConnections {
-			for ip := range connections {
-				if bytes.Equal(ip[:], MyIP[:]) {
+			for id := range connections {
+				if id == myID {
 					continue
 				}
-				uniqueIPs[ip] = struct{}{}
+				uniqueIDs[id] = struct{}{}
 			}
 		}
-		var ips [][]byte
-		for ip := range uniqueIPs {
-			ips = append(ips, ip[:])
+		var addrs [][]byte
+		for id := range uniqueIDs {
+			if hint, ok := GetPeerHint(id); ok {
+				addrs = append(addrs, hint.Bytes())
+			}
 		}
-		PeersCount = len(ips)
+		PeersCount = len(uniqueIDs)
 		// return one random peer only
-		if PeersCount > 0 {
-			rn := rand.Intn(PeersCount)
-			return [][]byte{ips[rn]}
+		if len(addrs) > 0 {
+			rn := rand.Intn(len(addrs))
+			return [][]byte{addrs[rn]}
 		} else {
 			return [][]byte{}
 		}
@@ -386,29 +515,30 @@ func GetIPsConnected() [][]byte {
 func GetPeersCount() int {
 	PeersMutex.RLock()
 	defer PeersMutex.RUnlock()
-	uniqueIPs := make(map[[4]byte]struct{})
+	myID := MyNodeID()
+	uniqueIDs := make(map[NodeID]struct{})
 	for _, connections := range tcpConnections {
-		for ip := range connections {
-			if !bytes.Equal(ip[:], MyIP[:]) {
-				uniqueIPs[ip] = struct{}{}
+		for id := range connections {
+			if id != myID {
+				uniqueIDs[id] = struct{}{}
 			}
 		}
 	}
-	return len(uniqueIPs)
+	return len(uniqueIDs)
 }
 
-func LookUpForNewPeersToConnect(chanPeer chan []byte) {
+// LookUpForNewPeersToConnect notifies about newly connected peers and, when there are too few of them,
+// passes addresses from address book to chanDial respecting backoff of each address
+func LookUpForNewPeersToConnect(chanPeer chan PeerKey, chanDial chan PeerAddress) {
 	for {
-		var newPeers [][]byte
+		var newPeers []PeerKey
 
 		PeersMutex.Lock()
 		for topicip, topic := range peersConnected {
 			_, ok := oldPeers[topicip]
 			if ok == false {
 				oldPeers[topicip] = topic
-				peerCopy := make([]byte, len(topicip))
-				copy(peerCopy, topicip[:])
-				newPeers = append(newPeers, peerCopy)
+				newPeers = append(newPeers, topicip)
 			}
 		}
 		for topicip := range oldPeers {
@@ -424,6 +554,13 @@ func LookUpForNewPeersToConnect(chanPeer chan []byte) {
 			chanPeer <- peer
 		}
 
+		for _, addr := range PeersToDial(common.MaxPeersConnected - GetPeersCount()) {
+			select {
+			case chanDial <- addr:
+			default:
+			}
+		}
+
 		time.Sleep(time.Second * 1)
 	}
 }
@@ -0,0 +1,427 @@
+package tcpip
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wonabru/qwid-node/common"
+	"github.com/wonabru/qwid-node/crypto"
+	"github.com/wonabru/qwid-node/crypto/oqs"
+	"github.com/wonabru/qwid-node/logger"
+	"github.com/wonabru/qwid-node/wallet"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	handshakeTimeout       = 10 * time.Second
+	maxHandshakeMessageLen = 1 << 20
+	handshakeProtocol      = "QWID-P2P-KEM-v1"
+)
+
+// secureSession keeps AEAD state of one encrypted connection. Nonces are counters, so
+// frames have to be opened in the same order as they were sealed.
+type secureSession struct {
+	sendAEAD  cipher.AEAD
+	recvAEAD  cipher.AEAD
+	sendNonce uint64
+	recvNonce uint64
+	sendMutex sync.Mutex
+	recvMutex sync.Mutex
+	peer      common.Address
+	topic     [2]byte
+	// frameVersion is negotiated in handshake and decides how frames are delimited
+	frameVersion byte
+	// listenPorts are advertised by peer in handshake, zeros for older nodes
+	listenPorts PortSet
+}
+
+var (
+	secureSessions      = map[*net.TCPConn]*secureSession{}
+	secureSessionsMutex sync.RWMutex
+)
+
+func getSession(conn *net.TCPConn) *secureSession {
+	secureSessionsMutex.RLock()
+	defer secureSessionsMutex.RUnlock()
+	return secureSessions[conn]
+}
+
+func setSession(conn *net.TCPConn, s *secureSession) {
+	secureSessionsMutex.Lock()
+	defer secureSessionsMutex.Unlock()
+	secureSessions[conn] = s
+}
+
+func removeSession(conn *net.TCPConn) {
+	secureSessionsMutex.Lock()
+	defer secureSessionsMutex.Unlock()
+	delete(secureSessions, conn)
+}
+
+// GetPeerAddress returns address of operator key which authenticated the connection
+func GetPeerAddress(conn *net.TCPConn) (common.Address, bool) {
+	s := getSession(conn)
+	if s == nil {
+		return common.Address{}, false
+	}
+	return s.peer, true
+}
+
+// GetPeerID returns node ID of peer which authenticated the connection
+func GetPeerID(conn *net.TCPConn) (NodeID, bool) {
+	a, ok := GetPeerAddress(conn)
+	if !ok {
+		return NodeID{}, false
+	}
+	return NodeIDFromAddress(a), true
+}
+
+func aeadNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[chacha20poly1305.NonceSize-8:], counter)
+	return nonce
+}
+
+func (s *secureSession) seal(plaintext []byte) []byte {
+	return s.sealWithData(plaintext, nil)
+}
+
+// sealWithData encrypts plaintext and authenticates it together with additional data
+func (s *secureSession) sealWithData(plaintext, additionalData []byte) []byte {
+	ct := s.sendAEAD.Seal(nil, aeadNonce(s.sendNonce), plaintext, additionalData)
+	s.sendNonce++
+	return ct
+}
+
+func (s *secureSession) open(ciphertext []byte) ([]byte, error) {
+	return s.openWithData(ciphertext, nil)
+}
+
+func (s *secureSession) openWithData(ciphertext, additionalData []byte) ([]byte, error) {
+	s.recvMutex.Lock()
+	defer s.recvMutex.Unlock()
+	pt, err := s.recvAEAD.Open(nil, aeadNonce(s.recvNonce), ciphertext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	s.recvNonce++
+	return pt, nil
+}
+
+// handshakePrefix binds signatures to protocol, chain and topic, so they cannot be replayed elsewhere
+func handshakePrefix(topic [2]byte) []byte {
+	prefix := append([]byte(handshakeProtocol), common.GetByteInt16(common.GetChainID())...)
+	return append(prefix, topic[:]...)
+}
+
+// newSessionFromSecret derives directional keys from KEM shared secret and handshake transcript
+func newSessionFromSecret(sharedSecret []byte, transcript []byte, initiator bool, peer common.Address) (*secureSession, error) {
+	kdf := hkdf.New(sha256.New, sharedSecret, crypto.Keccak256(transcript), []byte(handshakeProtocol))
+	keys := make([]byte, 2*chacha20poly1305.KeySize)
+	_, err := io.ReadFull(kdf, keys)
+	if err != nil {
+		return nil, err
+	}
+	initiatorAEAD, err := chacha20poly1305.New(keys[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, err
+	}
+	responderAEAD, err := chacha20poly1305.New(keys[chacha20poly1305.KeySize:])
+	if err != nil {
+		return nil, err
+	}
+	s := &secureSession{peer: peer}
+	if initiator {
+		s.sendAEAD, s.recvAEAD = initiatorAEAD, responderAEAD
+	} else {
+		s.sendAEAD, s.recvAEAD = responderAEAD, initiatorAEAD
+	}
+	return s, nil
+}
+
+func writeHandshakeMessage(conn net.Conn, msg []byte) error {
+	_, err := conn.Write(common.BytesToLenAndBytes(msg))
+	return err
+}
+
+func readHandshakeMessage(conn net.Conn) ([]byte, error) {
+	lb := make([]byte, 4)
+	_, err := io.ReadFull(conn, lb)
+	if err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lb)
+	if n > maxHandshakeMessageLen {
+		return nil, fmt.Errorf("too long handshake message %v", n)
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(conn, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// signHandshake signs data with operator key of active wallet. Returns public key and signature.
+func signHandshake(data []byte) ([]byte, []byte, error) {
+	w, pk, primary := operatorKey()
+	if w == nil {
+		return nil, nil, fmt.Errorf("no active wallet to authenticate connection")
+	}
+	sig, err := w.Sign(data, primary)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pk.GetBytes(), sig.GetBytes(), nil
+}
+
+// verifyHandshake checks peer signature and returns address of peer operator key
+func verifyHandshake(data, pubKey, sig []byte) (common.Address, error) {
+	if len(sig) < 2 {
+		return common.Address{}, fmt.Errorf("empty handshake signature")
+	}
+	if !wallet.Verify(data, sig, pubKey, common.SigName(), common.SigName2(), common.IsPaused(), common.IsPaused2()) {
+		return common.Address{}, fmt.Errorf("wrong handshake signature")
+	}
+	return common.PubKeyToAddress(pubKey, sig[0] == 0)
+}
+
+// splitHandshakeFields reads n length prefixed fields from handshake message
+func splitHandshakeFields(msg []byte, n int) ([][]byte, error) {
+	fields := make([][]byte, n)
+	var err error
+	for i := 0; i < n; i++ {
+		fields[i], msg, err = common.BytesWithLenToBytes(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(msg) > 0 {
+		return nil, fmt.Errorf("unexpected data at the end of handshake message")
+	}
+	return fields, nil
+}
+
+// clientHello is handshake message of initiator
+type clientHello struct {
+	kemPubKey []byte
+	pubKey    []byte
+	sig       []byte
+	// offered are frame versions supported by initiator, legacy initiators do not offer any
+	offered []byte
+	ports   []byte
+	legacy  bool
+	// withPorts is false for initiators which do not advertise listener ports
+	withPorts bool
+}
+
+// parseClientHello reads initiator handshake message. Initiators of older nodes send only KEM key,
+// public key and signature, they are served with legacy framing. Initiators which offer frame
+// versions but do not advertise ports get reply without ports.
+func parseClientHello(hello []byte) (clientHello, error) {
+	fields, err := splitHandshakeFields(hello, 5)
+	if err == nil {
+		return clientHello{kemPubKey: fields[0], pubKey: fields[1], sig: fields[2], offered: fields[3], ports: fields[4], withPorts: true}, nil
+	}
+	fields, err = splitHandshakeFields(hello, 4)
+	if err == nil {
+		return clientHello{kemPubKey: fields[0], pubKey: fields[1], sig: fields[2], offered: fields[3]}, nil
+	}
+	fields, err = splitHandshakeFields(hello, 3)
+	if err != nil {
+		return clientHello{}, err
+	}
+	return clientHello{kemPubKey: fields[0], pubKey: fields[1], sig: fields[2], offered: []byte{FrameVersionLegacy}, legacy: true}, nil
+}
+
+// signedData returns data which initiator signed with its operator key
+func (h clientHello) signedData(prefix []byte) []byte {
+	if h.legacy {
+		return bytes.Join([][]byte{prefix, h.kemPubKey}, nil)
+	}
+	if !h.withPorts {
+		return bytes.Join([][]byte{prefix, h.kemPubKey, h.offered}, nil)
+	}
+	return bytes.Join([][]byte{prefix, h.kemPubKey, h.offered, h.ports}, nil)
+}
+
+// replyFields returns transcript signed by responder and fields of its reply following signature.
+// Legacy initiators get neither frame version nor ports, initiators without ports get no ports.
+func (h clientHello) replyFields(prefix, hello, ciphertext []byte, version byte, ports []byte) ([]byte, [][]byte) {
+	if h.legacy {
+		return bytes.Join([][]byte{prefix, hello, ciphertext}, nil), nil
+	}
+	if !h.withPorts {
+		return bytes.Join([][]byte{prefix, hello, ciphertext, {version}}, nil), [][]byte{{version}}
+	}
+	return bytes.Join([][]byte{prefix, hello, ciphertext, {version}, ports}, nil), [][]byte{{version}, ports}
+}
+
+// InitiateSecureSession runs handshake on outbound connection. Initiator sends ephemeral KEM public key,
+// supported frame versions and its listener ports signed by its operator key, responder encapsulates
+// shared secret to it, chooses frame version and signs the whole transcript together with its ports.
+// Operator keys give node IDs of both sides.
+func InitiateSecureSession(conn *net.TCPConn, topic [2]byte) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var kem oqs.KeyEncapsulation
+	defer kem.Clean()
+	err := kem.Init(common.P2PKEMName, nil)
+	if err != nil {
+		return err
+	}
+	kemPubKey, err := kem.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	prefix := handshakePrefix(topic)
+	ports := MyPorts().Bytes()
+	pubKey, sig, err := signHandshake(bytes.Join([][]byte{prefix, kemPubKey, SupportedFrameVersions, ports}, nil))
+	if err != nil {
+		return err
+	}
+	hello := append(common.BytesToLenAndBytes(kemPubKey), common.BytesToLenAndBytes(pubKey)...)
+	hello = append(hello, common.BytesToLenAndBytes(sig)...)
+	hello = append(hello, common.BytesToLenAndBytes(SupportedFrameVersions)...)
+	hello = append(hello, common.BytesToLenAndBytes(ports)...)
+	err = writeHandshakeMessage(conn, hello)
+	if err != nil {
+		return err
+	}
+
+	reply, err := readHandshakeMessage(conn)
+	if err != nil {
+		return err
+	}
+	fields, err := splitHandshakeFields(reply, 5)
+	if err != nil {
+		return err
+	}
+	ciphertext, peerPubKey, peerSig, version, peerPorts := fields[0], fields[1], fields[2], fields[3], fields[4]
+	if len(version) != 1 || !bytes.Contains(SupportedFrameVersions, version) {
+		return fmt.Errorf("peer chose unsupported frame version %v", version)
+	}
+	listenPorts, err := PortSetFromBytes(peerPorts)
+	if err != nil {
+		return err
+	}
+	transcript := bytes.Join([][]byte{prefix, hello, ciphertext, version, peerPorts}, nil)
+	peer, err := verifyHandshake(transcript, peerPubKey, peerSig)
+	if err != nil {
+		return err
+	}
+	sharedSecret, err := kem.DecapSecret(ciphertext)
+	if err != nil {
+		return err
+	}
+	s, err := newSessionFromSecret(sharedSecret, transcript, true, peer)
+	if err != nil {
+		return err
+	}
+	s.topic = topic
+	s.frameVersion = version[0]
+	s.listenPorts = listenPorts
+	setSession(conn, s)
+	return nil
+}
+
+// AcceptSecureSession runs responder side of handshake on accepted connection. Handshake is
+// required on every connection, so nodes which send plain messages cannot connect. Initiators
+// which do not offer frame versions are served with legacy framing.
+func AcceptSecureSession(conn *net.TCPConn, topic [2]byte) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hello, err := readHandshakeMessage(conn)
+	if err != nil {
+		return err
+	}
+	ch, err := parseClientHello(hello)
+	if err != nil {
+		return err
+	}
+	version, err := NegotiateFrameVersion(ch.offered)
+	if err != nil {
+		return err
+	}
+	listenPorts := PortSet{}
+	if ch.withPorts {
+		listenPorts, err = PortSetFromBytes(ch.ports)
+		if err != nil {
+			return err
+		}
+	}
+	prefix := handshakePrefix(topic)
+	peer, err := verifyHandshake(ch.signedData(prefix), ch.pubKey, ch.sig)
+	if err != nil {
+		return err
+	}
+
+	var kem oqs.KeyEncapsulation
+	defer kem.Clean()
+	err = kem.Init(common.P2PKEMName, nil)
+	if err != nil {
+		return err
+	}
+	ciphertext, sharedSecret, err := kem.EncapSecret(ch.kemPubKey)
+	if err != nil {
+		return err
+	}
+	transcript, extra := ch.replyFields(prefix, hello, ciphertext, version, MyPorts().Bytes())
+	pubKey, sig, err := signHandshake(transcript)
+	if err != nil {
+		return err
+	}
+	reply := append(common.BytesToLenAndBytes(ciphertext), common.BytesToLenAndBytes(pubKey)...)
+	reply = append(reply, common.BytesToLenAndBytes(sig)...)
+	for _, f := range extra {
+		reply = append(reply, common.BytesToLenAndBytes(f)...)
+	}
+	err = writeHandshakeMessage(conn, reply)
+	if err != nil {
+		return err
+	}
+	s, err := newSessionFromSecret(sharedSecret, transcript, false, peer)
+	if err != nil {
+		return err
+	}
+	s.topic = topic
+	s.frameVersion = version
+	s.listenPorts = listenPorts
+	setSession(conn, s)
+	return nil
+}
+
+// sealFrame encrypts message and wraps it into MessageInitialization and end marker. Needs sendMutex held,
+// so frames are written in the order of nonces.
+func (s *secureSession) sealFrame(message []byte) []byte {
+	frame := append(common.MessageInitialization[:], s.seal(message)...)
+	return append(frame, []byte("<-END->")...)
+}
+
+// decryptFrame opens frame received on connection. Frame has MessageInitialization stripped
+// and ends with end marker, which is kept in returned message.
+func decryptFrame(conn *net.TCPConn, frame []byte) ([]byte, error) {
+	s := getSession(conn)
+	if s == nil {
+		return nil, fmt.Errorf("no secure session with %v", conn.RemoteAddr())
+	}
+	if !bytes.HasSuffix(frame, []byte("<-END->")) {
+		return nil, fmt.Errorf("frame without end marker")
+	}
+	pt, err := s.open(frame[:len(frame)-7])
+	if err != nil {
+		logger.GetLogger().Println("cannot decrypt frame from", conn.RemoteAddr(), err)
+		return nil, err
+	}
+	return append(pt, []byte("<-END->")...), nil
+}
@@ -0,0 +1,99 @@
+package tcpip
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+)
+
+func TestSecureSessionSealOpen(t *testing.T) {
+	secret := []byte("shared secret established by kem")
+	transcript := []byte("handshake transcript")
+	initiator, err := newSessionFromSecret(secret, transcript, true, common.Address{})
+	assert.NoError(t, err)
+	responder, err := newSessionFromSecret(secret, transcript, false, common.Address{})
+	assert.NoError(t, err)
+
+	for _, msg := range []string{"first", "second", ""} {
+		frame := initiator.sealFrame([]byte(msg))
+		assert.Equal(t, common.MessageInitialization[:], frame[:4])
+		if msg != "" {
+			assert.NotContains(t, string(frame), msg)
+		}
+		pt, err := responder.open(frame[4 : len(frame)-7])
+		assert.NoError(t, err)
+		assert.Equal(t, msg, string(pt))
+	}
+
+	// replayed frame uses old nonce
+	ct := responder.seal([]byte("reply"))
+	pt, err := initiator.open(ct)
+	assert.NoError(t, err)
+	assert.Equal(t, "reply", string(pt))
+	_, err = initiator.open(ct)
+	assert.Error(t, err)
+
+	// tampered frame
+	ct = responder.seal([]byte("reply2"))
+	ct[0] ^= 1
+	_, err = initiator.open(ct)
+	assert.Error(t, err)
+
+	// different transcript gives different keys
+	other, err := newSessionFromSecret(secret, []byte("other transcript"), false, common.Address{})
+	assert.NoError(t, err)
+	_, err = initiator.open(other.seal([]byte("x")))
+	assert.Error(t, err)
+}
+
+func TestSplitHandshakeFields(t *testing.T) {
+	msg := append(common.BytesToLenAndBytes([]byte{1, 2}), common.BytesToLenAndBytes([]byte{3})...)
+	fields, err := splitHandshakeFields(msg, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{1, 2}, {3}}, fields)
+
+	_, err = splitHandshakeFields(msg, 3)
+	assert.Error(t, err)
+	_, err = splitHandshakeFields(append(msg, 0), 2)
+	assert.Error(t, err)
+}
+
+func TestParseClientHello(t *testing.T) {
+	prefix := []byte("prefix")
+	legacy := append(common.BytesToLenAndBytes([]byte{1}), common.BytesToLenAndBytes([]byte{2})...)
+	legacy = append(legacy, common.BytesToLenAndBytes([]byte{3})...)
+	ch, err := parseClientHello(legacy)
+	assert.NoError(t, err)
+	assert.True(t, ch.legacy)
+	assert.Equal(t, []byte{FrameVersionLegacy}, ch.offered)
+	assert.Equal(t, []byte("prefix\x01"), ch.signedData(prefix))
+	transcript, extra := ch.replyFields(prefix, legacy, []byte{9}, FrameVersionLegacy, []byte{7})
+	assert.Equal(t, bytes.Join([][]byte{prefix, legacy, {9}}, nil), transcript)
+	assert.Empty(t, extra)
+
+	hello := append(legacy, common.BytesToLenAndBytes(SupportedFrameVersions)...)
+	hello = append(hello, common.BytesToLenAndBytes([]byte{5})...)
+	ch, err = parseClientHello(hello)
+	assert.NoError(t, err)
+	assert.False(t, ch.legacy)
+	assert.Equal(t, SupportedFrameVersions, ch.offered)
+	assert.Equal(t, bytes.Join([][]byte{prefix, {1}, SupportedFrameVersions, {5}}, nil), ch.signedData(prefix))
+	_, extra = ch.replyFields(prefix, hello, []byte{9}, FrameVersionLengthPrefixed, []byte{7})
+	assert.Equal(t, [][]byte{{FrameVersionLengthPrefixed}, {7}}, extra)
+
+	// versions without ports
+	noPorts := append(legacy, common.BytesToLenAndBytes(SupportedFrameVersions)...)
+	ch, err = parseClientHello(noPorts)
+	assert.NoError(t, err)
+	assert.False(t, ch.legacy)
+	assert.False(t, ch.withPorts)
+	assert.Equal(t, bytes.Join([][]byte{prefix, {1}, SupportedFrameVersions}, nil), ch.signedData(prefix))
+	transcript, extra = ch.replyFields(prefix, noPorts, []byte{9}, FrameVersionLengthPrefixed, []byte{7})
+	assert.Equal(t, bytes.Join([][]byte{prefix, noPorts, {9}, {FrameVersionLengthPrefixed}}, nil), transcript)
+	assert.Equal(t, [][]byte{{FrameVersionLengthPrefixed}}, extra)
+
+	_, err = parseClientHello(common.BytesToLenAndBytes([]byte{1}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,117 @@
+package transactionsPool
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/wonabru/qwid-node/common"
+)
+
+// MerkleProof proves that transaction is included in block with given RootMerkleTree.
+// Siblings are hashes of neighbouring nodes from leaf up to root, Index is position of transaction in block,
+// its bits say on which side sibling is.
+type MerkleProof struct {
+	Height   int64         `json:"height"`
+	TxHash   common.Hash   `json:"tx_hash"`
+	Index    int64         `json:"index"`
+	Siblings []common.Hash `json:"siblings"`
+	Root     common.Hash   `json:"root"`
+}
+
+// BlockMerkleLeaves returns leaves of merkle tree of block, tree of block starts with as many empty leaves
+// as there are transactions, then hashes of transactions follow
+func BlockMerkleLeaves(txHashes [][]byte) [][]byte {
+	leaves := make([][]byte, len(txHashes))
+	return append(leaves, txHashes...)
+}
+
+// NewMerkleProof makes proof for txHash in tree built from txHashes, the same way as NewMerkleTree does
+func NewMerkleProof(txHashes [][]byte, txHash []byte) (MerkleProof, error) {
+	index := -1
+	for i, h := range txHashes {
+		if bytes.Equal(h, txHash) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return MerkleProof{}, fmt.Errorf("tx hash not found")
+	}
+	level := [][]byte{}
+	for _, h := range txHashes {
+		leaf, err := common.CalcHashToByte(h)
+		if err != nil {
+			return MerkleProof{}, err
+		}
+		level = append(level, leaf)
+	}
+	proof := MerkleProof{TxHash: common.GetHashFromBytes(txHash), Index: int64(index), Siblings: []common.Hash{}}
+	i := index
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		proof.Siblings = append(proof.Siblings, common.GetHashFromBytes(level[i^1]))
+		next := [][]byte{}
+		for j := 0; j < len(level); j += 2 {
+			h, err := common.CalcHashToByte(append(append([]byte{}, level[j]...), level[j+1]...))
+			if err != nil {
+				return MerkleProof{}, err
+			}
+			next = append(next, h)
+		}
+		level = next
+		i /= 2
+	}
+	proof.Root = common.GetHashFromBytes(level[0])
+	return proof, nil
+}
+
+// LoadMerkleProof makes proof of transaction included in block at height from hashes stored in db
+func LoadMerkleProof(height int64, txHash []byte) (MerkleProof, error) {
+	hashes, err := LoadTxHashes(height)
+	if err != nil {
+		return MerkleProof{}, err
+	}
+	proof, err := NewMerkleProof(BlockMerkleLeaves(hashes), txHash)
+	if err != nil {
+		return MerkleProof{}, err
+	}
+	root, err := LoadHashMerkleTreeByHeight(height)
+	if err != nil {
+		return MerkleProof{}, err
+	}
+	if !bytes.Equal(root, proof.Root.GetBytes()) {
+		return MerkleProof{}, fmt.Errorf("stored merkle root of block %d does not match transactions hashes", height)
+	}
+	proof.Height = height
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that transaction with txHash at index is in tree with root, it does not need node
+func VerifyMerkleProof(txHash []byte, index int64, siblings []common.Hash, root []byte) bool {
+	if index < 0 || (len(siblings) < 64 && index >= int64(1)<<len(siblings)) {
+		return false
+	}
+	h, err := common.CalcHashToByte(txHash)
+	if err != nil {
+		return false
+	}
+	for _, s := range siblings {
+		if index%2 == 0 {
+			h, err = common.CalcHashToByte(append(h, s.GetBytes()...))
+		} else {
+			h, err = common.CalcHashToByte(append(s.GetBytes(), h...))
+		}
+		if err != nil {
+			return false
+		}
+		index /= 2
+	}
+	return bytes.Equal(h, root)
+}
+
+// Verify checks proof against RootMerkleTree taken from trusted block header
+func (p MerkleProof) Verify(root []byte) bool {
+	return bytes.Equal(p.Root.GetBytes(), root) && VerifyMerkleProof(p.TxHash.GetBytes(), p.Index, p.Siblings, root)
+}
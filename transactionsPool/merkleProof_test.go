@@ -0,0 +1,34 @@
+package transactionsPool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonabru/qwid-node/common"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 7; n++ {
+		txs := [][]byte{}
+		for i := 0; i < n; i++ {
+			h, err := common.CalcHashToByte([]byte{byte(i)})
+			assert.NoError(t, err)
+			txs = append(txs, h)
+		}
+		leaves := BlockMerkleLeaves(txs)
+		nodes, err := NewMerkleTree(leaves)
+		assert.NoError(t, err)
+		root := nodes[0].Data
+		for _, tx := range txs {
+			proof, err := NewMerkleProof(leaves, tx)
+			assert.NoError(t, err)
+			assert.Equal(t, root, proof.Root.GetBytes())
+			assert.True(t, proof.Verify(root))
+
+			proof.Index ^= 1
+			assert.False(t, proof.Verify(root))
+		}
+	}
+	_, err := NewMerkleProof(BlockMerkleLeaves([][]byte{{1}}), []byte{2})
+	assert.Error(t, err)
+}
@@ -380,7 +380,11 @@ func LastHeightStoredInMerleTrie() (int64, error) {
 		return -1, fmt.Errorf("database is nil")
 	}
 
-	i := int64(0)
+	// trees up to base height are not stored when node started from snapshot
+	i := common.GetBaseHeight()
+	if i > 0 {
+		i++
+	}
 	for {
 		ib := common.GetByteInt64(i)
 		prefix := append(common.RootHashMerkleTreeDBPrefix[:], ib...)
@@ -254,6 +254,31 @@ func (w *Wallet) AddNewEncryptionToActiveWallet(sigName string, primary bool) er
 	return nil
 }
 
+// NewEphemeralWallet generates wallet kept only in memory with random password, it is never stored
+func NewEphemeralWallet(walletNumber uint8, sigName, sigName2 string) (*Wallet, error) {
+	password := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, password); err != nil {
+		return nil, err
+	}
+	w := EmptyWallet(walletNumber, sigName, sigName2)
+	w.SetPassword(fmt.Sprintf("%x", password))
+	w.Iv = GenerateNewIv()
+	acc1, err := GenerateNewAccount(w, sigName)
+	if err != nil {
+		return nil, err
+	}
+	w.Account1 = acc1
+	w.MainAddress = acc1.Address
+	acc2, err := GenerateNewAccount(w, sigName2)
+	if err != nil {
+		return nil, err
+	}
+	w.Account2 = acc2
+	w.Accounts[sigName] = acc1
+	w.Accounts[sigName2] = acc2
+	return &w, nil
+}
+
 func GenerateNewIv() []byte {
 	iv := make([]byte, aes.BlockSize)
 	if _, err := io.ReadFull(rand.Reader, iv); err != nil {